@@ -0,0 +1,61 @@
+package influxdbv2
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// The influx client builds the URL of every API request under "/api/v2/" of
+// the server, with no option to change it. api_path moves those requests
+// under another path, for gateways that route the API elsewhere or for a
+// server serving it under a new version's path: a transport rewrites the
+// path of each request as it is sent. /ping, /health and /ready, which
+// InfluxDB serves outside the API, are still requested at the root of the
+// server.
+
+// defaultAPIPath is the path the influx client sends API requests under.
+const defaultAPIPath = "/api/v2"
+
+// parseAPIPath validates api_path and returns it without a trailing slash.
+func parseAPIPath(raw string) (string, error) {
+	if raw == "" {
+		return defaultAPIPath, nil
+	}
+	u, err := url.Parse(raw)
+	switch {
+	case err != nil:
+		return "", fmt.Errorf("invalid api_path: %w", err)
+	case u.Scheme != "" || u.Host != "" || u.RawQuery != "" || u.Fragment != "" || strings.Contains(raw, "?"):
+		return "", fmt.Errorf("invalid api_path %q: expected a path only, with no scheme, host, query or fragment", raw)
+	case !strings.HasPrefix(u.Path, "/"):
+		return "", fmt.Errorf("invalid api_path %q: expected an absolute path, such as %q", raw, defaultAPIPath)
+	}
+	for _, segment := range strings.Split(strings.Trim(u.Path, "/"), "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return "", fmt.Errorf("invalid api_path %q: expected no empty, \".\" or \"..\" segments", raw)
+		}
+	}
+	return strings.TrimSuffix(u.Path, "/"), nil
+}
+
+// apiPathTransport sends the requests the client makes under defaultAPIPath
+// under path instead.
+type apiPathTransport struct {
+	wrappedTransport
+	path string
+}
+
+func (t *apiPathTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rest := strings.TrimPrefix(req.URL.Path, defaultAPIPath+"/")
+	if rest == req.URL.Path {
+		return t.base.RoundTrip(req)
+	}
+	req = req.Clone(req.Context())
+	req.URL.Path = t.path + "/" + rest
+	if req.URL.RawPath != "" {
+		req.URL.RawPath = t.path + "/" + strings.TrimPrefix(req.URL.RawPath, defaultAPIPath+"/")
+	}
+	return t.base.RoundTrip(req)
+}
@@ -0,0 +1,118 @@
+package influxdbv2
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/database/dbplugin/v5"
+	dbtesting "github.com/hashicorp/vault/sdk/database/dbplugin/v5/testing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInitialize_APIPath(t *testing.T) {
+	const token = "root-token"
+	srv := newFakeInfluxServer(t, token)
+	srv.addUserAuthorization(srv.orgID("vault"), srv.addUser("other"), "other")
+	srv.authorizationPageSize = 1
+
+	// The gateway only serves the API under /influx/v3, and the probes at
+	// the root.
+	var lock sync.Mutex
+	var paths []string
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		paths = append(paths, r.URL.Path)
+		lock.Unlock()
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/influx/v3/"):
+			r.URL.Path = "/api/v2/" + strings.TrimPrefix(r.URL.Path, "/influx/v3/")
+		case r.URL.Path != "/ping" && r.URL.Path != "/health":
+			http.NotFound(w, r)
+			return
+		}
+		srv.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer gateway.Close()
+	u, _ := url.Parse(gateway.URL)
+	params := makeConfig(srv.connectionParams(token), "host", u.Hostname(), "port", u.Port())
+
+	t.Run("default", func(t *testing.T) {
+		_, err := new().Initialize(context.Background(), dbplugin.InitializeRequest{
+			Config:           params,
+			VerifyConnection: true,
+		})
+		require.Error(t, err)
+	})
+
+	t.Run("override", func(t *testing.T) {
+		lock.Lock()
+		paths = nil
+		lock.Unlock()
+
+		db := new()
+		dbtesting.AssertInitialize(t, db, dbplugin.InitializeRequest{
+			Config:           makeConfig(params, "api_path", "/influx/v3/"),
+			VerifyConnection: true,
+		})
+		defer dbtesting.AssertClose(t, db)
+
+		resp := dbtesting.AssertNewUser(t, db, dbplugin.NewUserRequest{
+			UsernameConfig: dbplugin.UsernameMetadata{DisplayName: "test", RoleName: "test"},
+			Statements:     dbplugin.Statements{Commands: []string{`{"preset": "read", "bucket": "vault"}`}},
+			Password:       "nuozxby98523u89bdfnkjl",
+			Expiration:     time.Now().Add(time.Minute),
+		})
+		require.Len(t, srv.userAuthorizations(resp.Username), 1)
+		dbtesting.AssertDeleteUser(t, db, dbplugin.DeleteUserRequest{Username: resp.Username})
+		require.Empty(t, srv.userAuthorizations(resp.Username))
+
+		lock.Lock()
+		defer lock.Unlock()
+		for _, path := range paths {
+			require.False(t, strings.HasPrefix(path, "/api/v2/"), path)
+		}
+
+		b, err := db.SanitizedConfig()
+		require.NoError(t, err)
+		var config sanitizedConfig
+		require.NoError(t, json.Unmarshal(b, &config))
+		require.Equal(t, "/influx/v3", config.APIPath)
+	})
+}
+
+func TestParseAPIPath(t *testing.T) {
+	valid := map[string]string{
+		"":                   defaultAPIPath,
+		"/api/v2":            "/api/v2",
+		"/api/v3/":           "/api/v3",
+		"/gateway/influxdb/": "/gateway/influxdb",
+	}
+	for raw, expected := range valid {
+		path, err := parseAPIPath(raw)
+		require.NoError(t, err, raw)
+		require.Equal(t, expected, path, raw)
+	}
+
+	invalid := []string{
+		"api/v2",
+		"/",
+		"/api//v2",
+		"/api/../v2",
+		"/api/./v2",
+		"/api/v2?org=vault",
+		"/api/v2#top",
+		"https://influxdb/api/v2",
+		"//influxdb/api/v2",
+	}
+	for _, raw := range invalid {
+		_, err := parseAPIPath(raw)
+		require.Error(t, err, raw)
+	}
+}
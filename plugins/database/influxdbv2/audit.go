@@ -0,0 +1,149 @@
+package influxdbv2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/influxdata/influxdb-client-go/v2/domain"
+)
+
+// The database plugin SDK has no event or audit facility for plugins, so
+// credential operations are recorded on a dedicated "audit" log channel: one
+// event per NewUser, UpdateUser, DeleteUser and ImportCredential, whether it
+// succeeds or not. Events never carry the token, the credential's password,
+// or any other secret: errors are redacted before being recorded.
+
+const auditLoggerName = "audit"
+
+const (
+	auditOutcomeSuccess = "success"
+	auditOutcomeFailure = "failure"
+	// auditOutcomeRetained is the outcome of a DeleteUser that left a
+	// non-revocable credential in place.
+	auditOutcomeRetained = "retained"
+)
+
+// auditEvent describes a credential operation. Fields that aren't known,
+// e.g. because the operation failed before they were, are left empty.
+type auditEvent struct {
+	operation       string
+	role            string
+	username        string
+	authorizationID string
+	organization    string
+	// permissions summarizes the permissions granted, see
+	// summarizePermissions.
+	permissions []string
+	// changes lists what UpdateUser changed.
+	changes []string
+	// retained is set when DeleteUser left a non-revocable credential in
+	// place.
+	retained bool
+	// start is when the operation started, for its duration metric.
+	start time.Time
+}
+
+// auditEventFor returns the event of an operation on an existing credential,
+// identified by the authorization recorded in the expiry schedule, if any. It
+// must be called with the lock held.
+func (i *influxdbConnectionProducer) auditEventFor(operation, username string) auditEvent {
+	event := auditEvent{operation: operation, username: username, start: time.Now()}
+	if ids := i.expiries.entries[username].authorizationIDs; len(ids) > 0 {
+		event.authorizationID = strings.Join(ids, ",")
+	}
+	return event
+}
+
+// auditErrorKinds names the sentinels errors are classified by, in order of
+// precedence.
+var auditErrorKinds = []struct {
+	name string
+	err  error
+}{
+	{"not_initialized", ErrNotInitialized},
+	{"unreachable", ErrUnreachable},
+	{"auth_failed", ErrAuthFailed},
+	{"insufficient_permissions", ErrInsufficientPermissions},
+	{"invalid_organization_id", ErrInvalidOrganizationID},
+	{"organization_not_found", ErrOrganizationNotFound},
+	{"bucket_not_found", ErrBucketNotFound},
+	{"authorizations_not_visible", ErrAuthorizationsNotVisible},
+	{"credential_not_found", ErrCredentialNotFound},
+	{"revocation_failed", ErrRevocationFailed},
+	{"credential_not_revocable", ErrCredentialNotRevocable},
+	{"credential_unusable", ErrCredentialUnusable},
+	{"canceled", context.Canceled},
+	{"deadline_exceeded", context.DeadlineExceeded},
+}
+
+// auditErrorKind classifies err for an audit event.
+func auditErrorKind(err error) string {
+	for _, kind := range auditErrorKinds {
+		if errors.Is(err, kind.err) {
+			return kind.name
+		}
+	}
+	return "error"
+}
+
+// summarizePermissions describes permissions as "<action>:<resource type>",
+// followed by the ID or name of the resource if it is scoped to one.
+func summarizePermissions(permissions []domain.Permission) []string {
+	summary := make([]string, len(permissions))
+	for idx, permission := range permissions {
+		summary[idx] = fmt.Sprintf("%s:%s", permission.Action, permission.Resource.Type)
+		switch {
+		case permission.Resource.Id != nil:
+			summary[idx] += "/" + *permission.Resource.Id
+		case permission.Resource.Name != nil:
+			summary[idx] += "/" + *permission.Resource.Name
+		}
+	}
+	return summary
+}
+
+// audit records event with the outcome of the operation, err. Passwords the
+// operation handled are redacted along with the configured secrets. It must
+// be called with the lock held.
+func (i *influxdbConnectionProducer) audit(event auditEvent, err error, passwords ...string) {
+	recordOperation(event, err)
+
+	secrets := i.secretValues()
+	for _, password := range passwords {
+		if password != "" {
+			secrets[password] = i.redactionMarker("password", password)
+		}
+	}
+
+	args := []interface{}{
+		"operation", event.operation,
+		"role", redactString(event.role, secrets),
+		"username", redactString(event.username, secrets),
+		"authorization_id", event.authorizationID,
+		"organization", redactString(event.organization, secrets),
+		"session", i.SessionName,
+	}
+	if event.permissions != nil {
+		args = append(args, "permissions", event.permissions)
+	}
+	if event.changes != nil {
+		args = append(args, "changes", event.changes)
+	}
+	logger := i.logger.Named(auditLoggerName)
+	if err == nil {
+		outcome := auditOutcomeSuccess
+		if event.retained {
+			outcome = auditOutcomeRetained
+		}
+		logger.Info("credential operation", append(args, "outcome", outcome)...)
+		return
+	}
+	logger.Warn("credential operation", append(args,
+		"outcome", auditOutcomeFailure,
+		"error_kind", auditErrorKind(err),
+		"error", redactString(err.Error(), secrets),
+	)...)
+}
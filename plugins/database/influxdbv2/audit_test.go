@@ -0,0 +1,124 @@
+package influxdbv2
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/sdk/database/dbplugin/v5"
+	dbtesting "github.com/hashicorp/vault/sdk/database/dbplugin/v5/testing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInfluxdb_AuditEvents(t *testing.T) {
+	const token = "root-token-that-must-not-leak"
+	const password = "password-that-must-not-leak"
+	srv := newFakeInfluxServer(t, token)
+	srv.addBucket(srv.orgID("vault"), "telegraf")
+
+	var buf bytes.Buffer
+	db := new()
+	db.logger = log.New(&log.LoggerOptions{Output: &buf, JSONFormat: true})
+	dbtesting.AssertInitialize(t, db, dbplugin.InitializeRequest{
+		Config: srv.connectionParams(token),
+	})
+	defer dbtesting.AssertClose(t, db)
+
+	// events returns the audit events logged since the last call.
+	events := func(t *testing.T) []map[string]interface{} {
+		t.Helper()
+		defer buf.Reset()
+		require.NotContains(t, buf.String(), token)
+		require.NotContains(t, buf.String(), password)
+		var res []map[string]interface{}
+		for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+			var entry map[string]interface{}
+			require.NoError(t, json.Unmarshal([]byte(line), &entry), line)
+			if entry["@module"] == auditLoggerName {
+				res = append(res, entry)
+			}
+		}
+		return res
+	}
+	newUser := func(statement string) (dbplugin.NewUserResponse, error) {
+		return db.NewUser(context.Background(), dbplugin.NewUserRequest{
+			UsernameConfig: dbplugin.UsernameMetadata{DisplayName: "token", RoleName: "metrics"},
+			Statements:     dbplugin.Statements{Commands: []string{statement}},
+			Password:       password,
+			Expiration:     time.Now().Add(time.Hour),
+		})
+	}
+
+	buf.Reset()
+	resp, err := newUser(`{"preset": "read", "bucket": "telegraf"}`)
+	require.NoError(t, err)
+	auths := srv.userAuthorizations(resp.Username)
+	require.Len(t, auths, 1)
+	logged := events(t)
+	require.Len(t, logged, 1)
+	require.Equal(t, "NewUser", logged[0]["operation"])
+	require.Equal(t, "metrics", logged[0]["role"])
+	require.Equal(t, resp.Username, logged[0]["username"])
+	require.Equal(t, *auths[0].Id, logged[0]["authorization_id"])
+	require.Equal(t, "vault", logged[0]["organization"])
+	require.Equal(t, []interface{}{"read:buckets/" + *(*auths[0].Permissions)[0].Resource.Id}, logged[0]["permissions"])
+	require.Equal(t, auditOutcomeSuccess, logged[0]["outcome"])
+	require.NotContains(t, logged[0], "error")
+
+	// Failures are recorded with their classification.
+	_, err = newUser(`{"preset": "read", "bucket": "missing"}`)
+	require.Error(t, err)
+	logged = events(t)
+	require.Len(t, logged, 1)
+	require.Equal(t, auditOutcomeFailure, logged[0]["outcome"])
+	require.Equal(t, "bucket_not_found", logged[0]["error_kind"])
+	require.Contains(t, logged[0]["error"], "missing")
+
+	// Secrets echoed back by the server are redacted.
+	srv.handle("POST /api/v2/users/{id}/password", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		writeError(w, http.StatusUnprocessableEntity, "invalid", "rejected "+string(body)+" set with "+r.Header.Get("Authorization"))
+	})
+	_, err = newUser(`{"preset": "read", "bucket": "telegraf"}`)
+	require.Error(t, err)
+	logged = events(t)
+	require.Len(t, logged, 1)
+	require.Equal(t, auditOutcomeFailure, logged[0]["outcome"])
+	require.Equal(t, "error", logged[0]["error_kind"])
+	require.Contains(t, logged[0]["error"], "[password]")
+	require.Contains(t, logged[0]["error"], "[token]")
+
+	_, err = db.UpdateUser(context.Background(), dbplugin.UpdateUserRequest{
+		Username: resp.Username,
+		Password: &dbplugin.ChangePassword{NewPassword: password},
+	})
+	require.Error(t, err)
+	logged = events(t)
+	require.Len(t, logged, 1)
+	require.Equal(t, "UpdateUser", logged[0]["operation"])
+	require.Equal(t, resp.Username, logged[0]["username"])
+	require.Equal(t, []interface{}{"password"}, logged[0]["changes"])
+	require.Equal(t, auditOutcomeFailure, logged[0]["outcome"])
+
+	dbtesting.AssertDeleteUser(t, db, dbplugin.DeleteUserRequest{Username: resp.Username})
+	logged = events(t)
+	require.Len(t, logged, 1)
+	require.Equal(t, "DeleteUser", logged[0]["operation"])
+	require.Equal(t, *auths[0].Id, logged[0]["authorization_id"])
+	require.Equal(t, auditOutcomeSuccess, logged[0]["outcome"])
+
+	srv.handle("GET /api/v2/users", func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusForbidden, "forbidden", "cannot list users")
+	})
+	_, err = db.DeleteUser(context.Background(), dbplugin.DeleteUserRequest{Username: resp.Username})
+	require.Error(t, err)
+	logged = events(t)
+	require.Len(t, logged, 1)
+	require.Equal(t, auditOutcomeFailure, logged[0]["outcome"])
+}
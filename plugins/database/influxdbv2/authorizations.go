@@ -0,0 +1,117 @@
+package influxdbv2
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+
+	"github.com/influxdata/influxdb-client-go/v2"
+	"github.com/influxdata/influxdb-client-go/v2/domain"
+)
+
+// The client's GetAuthorizations only returns the first page of a paginated
+// listing, which on large instances may not include the configured token, so
+// authorizations are listed by following the "next" link of every page
+// instead, up to max_authorization_pages pages.
+
+// defaultMaxAuthorizationPages is the number of pages of authorizations
+// listed when max_authorization_pages is not configured.
+const defaultMaxAuthorizationPages = 100
+
+// errTooManyAuthorizationPages is returned by listAuthorizations when the
+// listing has more than the pages it may read.
+var errTooManyAuthorizationPages = errors.New("too many pages of authorizations")
+
+// maxAuthorizationPages returns the number of pages of authorizations listed
+// before giving up.
+func (i *influxdbConnectionProducer) maxAuthorizationPages() int {
+	if i.MaxAuthorizationPages == 0 {
+		return defaultMaxAuthorizationPages
+	}
+	return i.MaxAuthorizationPages
+}
+
+// listAuthorizations returns every authorization visible to the client,
+// following the pages of the listing. It fails rather than return a partial
+// listing if there are more than maxPages pages, or if ctx is done before the
+// last page is read.
+func listAuthorizations(ctx context.Context, cli influxdb2.Client, maxPages int) ([]domain.Authorization, error) {
+	service := cli.HTTPService()
+	next := service.ServerAPIURL() + "authorizations"
+	seen := make(map[string]bool)
+
+	var res []domain.Authorization
+	for pages := 0; next != ""; pages++ {
+		if pages == maxPages {
+			return nil, fmt.Errorf("%w: listing stopped after %d pages, raise max_authorization_pages", errTooManyAuthorizationPages, maxPages)
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		seen[next] = true
+
+		var page domain.Authorizations
+		err := retry(ctx, func(int) error {
+			page = domain.Authorizations{}
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, next, nil)
+			if err != nil {
+				return err
+			}
+			if err := service.DoHTTPRequest(req, nil, func(resp *http.Response) error {
+				defer resp.Body.Close()
+				return json.NewDecoder(resp.Body).Decode(&page)
+			}); err != nil {
+				return err
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		if page.Authorizations != nil {
+			res = append(res, *page.Authorizations...)
+		}
+
+		next = ""
+		if page.Links != nil && page.Links.Next != nil && *page.Links.Next != "" {
+			link, err := resolveLink(service.ServerAPIURL(), string(*page.Links.Next))
+			if err != nil {
+				return nil, fmt.Errorf("invalid link to the next page of authorizations: %w", err)
+			}
+			// A server repeating a page would otherwise be listed until
+			// maxPages.
+			if !seen[link] {
+				next = link
+			}
+		}
+	}
+	return res, nil
+}
+
+// resolveLink returns the URL of a link returned by the server, which is
+// given relative to its root, e.g. "/api/v2/authorizations?offset=20". Links
+// are resolved against apiURL so that a path prefix of the configured URL,
+// e.g. one routed by a reverse proxy, is kept. Only the path and query of the
+// link are used, so that the token is never sent to another host, and of the
+// path only its last segment, since with api_path set the server may give
+// links under another path than apiURL's.
+func resolveLink(apiURL, link string) (string, error) {
+	base, err := url.Parse(apiURL)
+	if err != nil {
+		return "", err
+	}
+	ref, err := url.Parse(link)
+	if err != nil {
+		return "", err
+	}
+	last := path.Base(ref.Path)
+	if last == "." || last == "/" {
+		return "", fmt.Errorf("no path in link %q", link)
+	}
+	ref = &url.URL{Path: last, RawQuery: ref.RawQuery}
+	return base.ResolveReference(ref).String(), nil
+}
@@ -0,0 +1,121 @@
+package influxdbv2
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/database/dbplugin/v5"
+	dbtesting "github.com/hashicorp/vault/sdk/database/dbplugin/v5/testing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInitialize_PaginatedAuthorizations(t *testing.T) {
+	const token = "paged-token"
+	srv := newFakeInfluxServer(t, "root-token")
+	srv.addAuthorization("other-token", "")
+	srv.addAuthorization(token, "", operatorPermissions()...)
+	srv.Lock()
+	srv.authorizationPageSize = 2
+	srv.Unlock()
+
+	db := new()
+	dbtesting.AssertInitialize(t, db, dbplugin.InitializeRequest{
+		Config:           srv.connectionParams(token),
+		VerifyConnection: true,
+	})
+	defer dbtesting.AssertClose(t, db)
+	require.True(t, db.accessVerified)
+
+	cli, err := db.getConnection(context.Background())
+	require.NoError(t, err)
+	calls := srv.callCount("GET /api/v2/authorizations")
+	authorizations, err := listAuthorizations(context.Background(), cli, 2)
+	require.NoError(t, err)
+	require.Len(t, authorizations, 3)
+	require.Equal(t, calls+2, srv.callCount("GET /api/v2/authorizations"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = listAuthorizations(ctx, cli, 2)
+	require.ErrorIs(t, err, context.Canceled)
+
+	_, err = new().Initialize(context.Background(), dbplugin.InitializeRequest{
+		Config:           makeConfig(srv.connectionParams(token), "max_authorization_pages", 1),
+		VerifyConnection: true,
+	})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "raise max_authorization_pages")
+
+	_, err = new().Initialize(context.Background(), dbplugin.InitializeRequest{
+		Config: makeConfig(srv.connectionParams(token), "max_authorization_pages", -1),
+	})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "max_authorization_pages cannot be negative")
+}
+
+func TestListAuthorizations_RepeatedPage(t *testing.T) {
+	const token = "root-token"
+	srv := newFakeInfluxServer(t, token)
+	srv.handle("GET /api/v2/authorizations", func(w http.ResponseWriter, r *http.Request) {
+		srv.Lock()
+		res := srv.authorizations
+		srv.Unlock()
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"authorizations": res,
+			"links":          map[string]string{"self": "/api/v2/authorizations", "next": "/api/v2/authorizations"},
+		})
+	})
+
+	db := new()
+	dbtesting.AssertInitialize(t, db, dbplugin.InitializeRequest{
+		Config:           srv.connectionParams(token),
+		VerifyConnection: true,
+	})
+	defer dbtesting.AssertClose(t, db)
+	require.True(t, db.accessVerified)
+
+	cli, err := db.getConnection(context.Background())
+	require.NoError(t, err)
+	calls := srv.callCount("GET /api/v2/authorizations")
+	authorizations, err := listAuthorizations(context.Background(), cli, 10)
+	require.NoError(t, err)
+	require.Len(t, authorizations, 1)
+	require.Equal(t, calls+1, srv.callCount("GET /api/v2/authorizations"))
+}
+
+func TestResolveLink(t *testing.T) {
+	tests := map[string]struct {
+		apiURL   string
+		link     string
+		expected string
+	}{
+		"root": {
+			apiURL:   "http://influxdb:8086/api/v2/",
+			link:     "/api/v2/authorizations?offset=20&limit=20",
+			expected: "http://influxdb:8086/api/v2/authorizations?offset=20&limit=20",
+		},
+		"path prefix": {
+			apiURL:   "https://proxy/influxdb/api/v2/",
+			link:     "/api/v2/authorizations?offset=20",
+			expected: "https://proxy/influxdb/api/v2/authorizations?offset=20",
+		},
+		"api_path": {
+			apiURL:   "https://gateway/api/v2/",
+			link:     "/influx/v3/authorizations?offset=20",
+			expected: "https://gateway/api/v2/authorizations?offset=20",
+		},
+		"other host": {
+			apiURL:   "https://influxdb/api/v2/",
+			link:     "https://attacker/api/v2/authorizations?offset=20",
+			expected: "https://influxdb/api/v2/authorizations?offset=20",
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			link, err := resolveLink(test.apiURL, test.link)
+			require.NoError(t, err)
+			require.Equal(t, test.expected, link)
+		})
+	}
+}
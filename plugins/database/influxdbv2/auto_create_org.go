@@ -0,0 +1,58 @@
+package influxdbv2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/influxdata/influxdb-client-go/v2"
+	"github.com/influxdata/influxdb-client-go/v2/domain"
+)
+
+// With auto_create_org set, Initialize creates the configured organization if
+// it doesn't exist, for fully automated bootstraps. Only operator tokens can
+// create organizations: an all-access token only holds orgs:write on its own
+// organization.
+
+// errAutoCreateOrgDenied explains why the organization wasn't created.
+var errAutoCreateOrgDenied = errors.New("auto_create_org requires an operator token, the configured token cannot create organizations")
+
+// ensureOrganization resolves the configured organization, creating it if it
+// doesn't exist. It must be called with the lock held.
+func (i *influxdbConnectionProducer) ensureOrganization(ctx context.Context, cli influxdb2.Client) (*domain.Organization, error) {
+	org, err := i.resolveOrganization(ctx, cli, i.Organization)
+	if !errors.Is(err, ErrOrganizationNotFound) {
+		return org, err
+	}
+
+	if i.accessVerified && !grantsUnscoped(i.grantedPermissions, requiredPermission{Action: domain.PermissionActionWrite, ResourceType: domain.ResourceTypeOrgs}) {
+		return nil, withKind(ErrInsufficientPermissions, fmt.Errorf("%w, it is missing permissions: orgs:write on every organization", errAutoCreateOrgDenied))
+	}
+
+	// Creates are not retried, see the retry policy.
+	org, err = cli.OrganizationsAPI().CreateOrganizationWithName(ctx, i.Organization)
+	if err != nil {
+		if kind := statusKind(err); kind != nil {
+			return nil, withKind(kind, fmt.Errorf("%w: %s", errAutoCreateOrgDenied, err))
+		}
+		return nil, fmt.Errorf("failed to create organization %q: %w", i.Organization, err)
+	}
+	i.logger.Info("created the configured organization, as auto_create_org is set", "organization", org.Name, "organization_id", stringValue(org.Id))
+	if i.orgCache == nil {
+		i.orgCache = make(map[string]*domain.Organization)
+	}
+	i.orgCache[i.Organization] = org
+	return org, nil
+}
+
+// grantsUnscoped reports whether permissions include p on every resource of
+// its type, rather than on a single one or those of a single organization.
+func grantsUnscoped(permissions []domain.Permission, p requiredPermission) bool {
+	for _, permission := range permissions {
+		if permission.Action == p.Action && permission.Resource.Type == p.ResourceType &&
+			permission.Resource.Id == nil && permission.Resource.OrgID == nil {
+			return true
+		}
+	}
+	return false
+}
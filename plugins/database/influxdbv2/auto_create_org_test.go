@@ -0,0 +1,105 @@
+package influxdbv2
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/database/dbplugin/v5"
+	dbtesting "github.com/hashicorp/vault/sdk/database/dbplugin/v5/testing"
+	"github.com/influxdata/influxdb-client-go/v2/domain"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInitialize_AutoCreateOrg(t *testing.T) {
+	const token = "root-token"
+
+	t.Run("creates missing organization", func(t *testing.T) {
+		srv := newFakeInfluxServer(t, token)
+		config := makeConfig(srv.connectionParams(token), "organization", "bootstrap", "auto_create_org", true)
+
+		db := new()
+		dbtesting.AssertInitialize(t, db, dbplugin.InitializeRequest{Config: config, VerifyConnection: true})
+		defer dbtesting.AssertClose(t, db)
+		require.Equal(t, 1, srv.callCount("POST /api/v2/orgs"))
+		orgID := srv.orgID("bootstrap")
+		require.NotEmpty(t, orgID)
+
+		org, err := db.resolveDefaultOrganization(context.Background(), db.client)
+		require.NoError(t, err)
+		require.Equal(t, orgID, *org.Id)
+
+		// An organization that exists is used as is.
+		dbtesting.AssertInitialize(t, db, dbplugin.InitializeRequest{Config: config})
+		require.Equal(t, 1, srv.callCount("POST /api/v2/orgs"))
+	})
+
+	t.Run("without verify_connection", func(t *testing.T) {
+		srv := newFakeInfluxServer(t, token)
+
+		db := new()
+		dbtesting.AssertInitialize(t, db, dbplugin.InitializeRequest{
+			Config: makeConfig(srv.connectionParams(token), "organization", "bootstrap", "auto_create_org", true),
+		})
+		defer dbtesting.AssertClose(t, db)
+		require.Equal(t, 1, srv.callCount("POST /api/v2/orgs"))
+		require.NotEmpty(t, srv.orgID("bootstrap"))
+	})
+
+	t.Run("off by default", func(t *testing.T) {
+		srv := newFakeInfluxServer(t, token)
+
+		db := new()
+		dbtesting.AssertInitialize(t, db, dbplugin.InitializeRequest{
+			Config:           makeConfig(srv.connectionParams(token), "organization", "bootstrap"),
+			VerifyConnection: true,
+		})
+		defer dbtesting.AssertClose(t, db)
+		require.Zero(t, srv.callCount("POST /api/v2/orgs"))
+	})
+
+	t.Run("token not an operator", func(t *testing.T) {
+		srv := newFakeInfluxServer(t, token)
+		orgID := srv.orgID("vault")
+		srv.setPermissions(token,
+			permission(domain.PermissionActionWrite, domain.ResourceTypeAuthorizations, orgID),
+			permission(domain.PermissionActionRead, domain.ResourceTypeAuthorizations, orgID),
+			permission(domain.PermissionActionWrite, domain.ResourceTypeOrgs, orgID),
+			permission(domain.PermissionActionRead, domain.ResourceTypeOrgs, orgID),
+			permission(domain.PermissionActionWrite, domain.ResourceTypeUsers, orgID),
+		)
+
+		_, err := new().Initialize(context.Background(), dbplugin.InitializeRequest{
+			Config:           makeConfig(srv.connectionParams(token), "organization", "bootstrap", "auto_create_org", true),
+			VerifyConnection: true,
+		})
+		require.ErrorIs(t, err, ErrInsufficientPermissions)
+		require.Contains(t, err.Error(), "auto_create_org requires an operator token")
+		require.Zero(t, srv.callCount("POST /api/v2/orgs"))
+	})
+
+	t.Run("server denies the creation", func(t *testing.T) {
+		srv := newFakeInfluxServer(t, token)
+		srv.handle("POST /api/v2/orgs", func(w http.ResponseWriter, r *http.Request) {
+			writeError(w, http.StatusForbidden, "forbidden", "insufficient permissions")
+		})
+
+		_, err := new().Initialize(context.Background(), dbplugin.InitializeRequest{
+			Config:           makeConfig(srv.connectionParams(token), "organization", "bootstrap", "auto_create_org", true, "skip_access_check", true),
+			VerifyConnection: true,
+		})
+		require.ErrorIs(t, err, ErrInsufficientPermissions)
+		require.Contains(t, err.Error(), "auto_create_org requires an operator token")
+		require.Empty(t, srv.orgID("bootstrap"))
+	})
+
+	t.Run("requires organization", func(t *testing.T) {
+		srv := newFakeInfluxServer(t, token)
+		config := makeConfig(srv.connectionParams(token), "auto_create_org", true)
+		delete(config, "organization")
+
+		_, err := new().Initialize(context.Background(), dbplugin.InitializeRequest{Config: config})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "auto_create_org requires organization")
+	})
+}
@@ -0,0 +1,122 @@
+package influxdbv2
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/influxdata/influxdb-client-go/v2/domain"
+)
+
+// Capabilities are the credential operations the configured token can
+// perform, as derived from the permissions verified by the access check.
+type Capabilities struct {
+	// Verified is set once the token's permissions have been checked. Until
+	// then, and when skip_access_check is set, nothing is known and every
+	// capability is reported as missing, but none is enforced.
+	Verified bool `json:"verified"`
+
+	CreateTokens bool `json:"create_tokens"`
+	DeleteTokens bool `json:"delete_tokens"`
+	CreateUsers  bool `json:"create_users"`
+	CreateDBRPs  bool `json:"create_dbrps"`
+}
+
+// capability is an operation and the permissions it takes.
+type capability struct {
+	name     string
+	required []requiredPermission
+}
+
+var (
+	capabilityCreateTokens = capability{
+		name: "create tokens",
+		required: []requiredPermission{
+			{Action: domain.PermissionActionWrite, ResourceType: domain.ResourceTypeAuthorizations},
+		},
+	}
+	capabilityDeleteTokens = capability{
+		name: "delete tokens",
+		required: []requiredPermission{
+			{Action: domain.PermissionActionRead, ResourceType: domain.ResourceTypeAuthorizations},
+			{Action: domain.PermissionActionWrite, ResourceType: domain.ResourceTypeAuthorizations},
+		},
+	}
+	// Creating a user includes adding it to its organization.
+	capabilityCreateUsers = capability{
+		name: "create users",
+		required: []requiredPermission{
+			{Action: domain.PermissionActionWrite, ResourceType: domain.ResourceTypeUsers},
+			{Action: domain.PermissionActionWrite, ResourceType: domain.ResourceTypeOrgs},
+		},
+	}
+	// A token can only grant what it holds, so issuing credentials that
+	// manage DBRP mappings takes the same permission.
+	capabilityCreateDBRPs = capability{
+		name: "create DBRPs",
+		required: []requiredPermission{
+			{Action: domain.PermissionActionWrite, ResourceType: domain.ResourceTypeDbrp},
+		},
+	}
+)
+
+// Capabilities returns the operations the configured token can perform, as
+// verified when the connection was established.
+func (i *InfluxdbV2) Capabilities() (Capabilities, error) {
+	i.Lock()
+	defer i.Unlock()
+
+	if !i.Initialized {
+		return Capabilities{}, ErrNotInitialized
+	}
+	return i.capabilities(), nil
+}
+
+// capabilities returns the capabilities of the token. It must be called with
+// the lock held.
+func (i *influxdbConnectionProducer) capabilities() Capabilities {
+	if !i.accessVerified {
+		return Capabilities{}
+	}
+	has := func(c capability) bool {
+		return len(missingPermissions(i.grantedPermissions, c.required)) == 0
+	}
+	return Capabilities{
+		Verified:     true,
+		CreateTokens: has(capabilityCreateTokens),
+		DeleteTokens: has(capabilityDeleteTokens),
+		CreateUsers:  has(capabilityCreateUsers),
+		CreateDBRPs:  has(capabilityCreateDBRPs),
+	}
+}
+
+// requireCapabilities fails with ErrInsufficientPermissions, naming the
+// missing permissions, if the token lacks one of the given capabilities.
+// Nothing is enforced until the token's permissions have been verified. It
+// must be called with the lock held.
+func (i *influxdbConnectionProducer) requireCapabilities(capabilities ...capability) error {
+	if !i.accessVerified {
+		return nil
+	}
+	for _, c := range capabilities {
+		missing := missingPermissions(i.grantedPermissions, c.required)
+		if len(missing) == 0 {
+			continue
+		}
+		names := make([]string, len(missing))
+		for idx, permission := range missing {
+			names[idx] = permission.String()
+		}
+		return withKind(ErrInsufficientPermissions, fmt.Errorf("the configured token cannot %s, it is missing permissions: %s", c.name, strings.Join(names, ", ")))
+	}
+	return nil
+}
+
+// grantsDBRPWrite reports whether permissions include writing DBRP mappings.
+func grantsDBRPWrite(permissions []domain.Permission) bool {
+	for _, permission := range permissions {
+		if permission.Action == domain.PermissionActionWrite && permission.Resource.Type == domain.ResourceTypeDbrp {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,120 @@
+package influxdbv2
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/database/dbplugin/v5"
+	dbtesting "github.com/hashicorp/vault/sdk/database/dbplugin/v5/testing"
+	"github.com/influxdata/influxdb-client-go/v2/domain"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInfluxdb_Capabilities(t *testing.T) {
+	const token = "root-token"
+	srv := newFakeInfluxServer(t, token)
+
+	_, err := new().Capabilities()
+	require.True(t, errors.Is(err, ErrNotInitialized), err)
+
+	newUser := func(db *InfluxdbV2, permissions string) error {
+		_, err := db.NewUser(context.Background(), dbplugin.NewUserRequest{
+			UsernameConfig: dbplugin.UsernameMetadata{DisplayName: "token", RoleName: "test"},
+			Statements:     dbplugin.Statements{Commands: []string{`{"permissions": [` + permissions + `]}`}},
+			Password:       "nuozxby98523u89bdfnkjl",
+			Expiration:     time.Now().Add(time.Minute),
+		})
+		return err
+	}
+	without := func(action domain.PermissionAction, resourceType domain.ResourceType) []domain.Permission {
+		var permissions []domain.Permission
+		for _, p := range operatorPermissions() {
+			if p.Action != action || p.Resource.Type != resourceType {
+				permissions = append(permissions, p)
+			}
+		}
+		return permissions
+	}
+	const readBuckets = `{"action": "read", "resource": {"type": "buckets"}}`
+	const writeDBRPs = `{"action": "write", "resource": {"type": "dbrp"}}`
+
+	tests := map[string]struct {
+		permissions  []domain.Permission
+		config       []interface{}
+		expected     Capabilities
+		statement    string
+		expectedErr  string
+		expectedUser bool
+	}{
+		"operator token": {
+			permissions:  operatorPermissions(),
+			expected:     Capabilities{Verified: true, CreateTokens: true, DeleteTokens: true, CreateUsers: true},
+			statement:    readBuckets,
+			expectedUser: true,
+		},
+		"dbrp credential without dbrp permission": {
+			permissions: operatorPermissions(),
+			expected:    Capabilities{Verified: true, CreateTokens: true, DeleteTokens: true, CreateUsers: true},
+			statement:   writeDBRPs,
+			expectedErr: "cannot create DBRPs, it is missing permissions: dbrp:write",
+		},
+		"dbrp credential with dbrp permission": {
+			permissions: append(operatorPermissions(),
+				permission(domain.PermissionActionWrite, domain.ResourceTypeDbrp, "")),
+			expected:     Capabilities{Verified: true, CreateTokens: true, DeleteTokens: true, CreateUsers: true, CreateDBRPs: true},
+			statement:    writeDBRPs,
+			expectedUser: true,
+		},
+		"token that cannot create tokens": {
+			permissions: without(domain.PermissionActionWrite, domain.ResourceTypeAuthorizations),
+			config:      []interface{}{"root_profile", "user-manager"},
+			expected:    Capabilities{Verified: true, CreateUsers: true},
+			statement:   readBuckets,
+			expectedErr: "cannot create tokens, it is missing permissions: authorizations:write",
+		},
+		"token that cannot create users": {
+			permissions: without(domain.PermissionActionWrite, domain.ResourceTypeUsers),
+			config:      []interface{}{"required_permissions", "users:read"},
+			expected:    Capabilities{Verified: true, CreateTokens: true, DeleteTokens: true},
+			statement:   readBuckets,
+			expectedErr: "cannot create users, it is missing permissions: users:write",
+		},
+		"unverified access": {
+			permissions:  operatorPermissions(),
+			config:       []interface{}{"skip_access_check", true},
+			expected:     Capabilities{},
+			statement:    readBuckets,
+			expectedUser: true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			srv.setPermissions(token, test.permissions...)
+
+			db := new()
+			dbtesting.AssertInitialize(t, db, dbplugin.InitializeRequest{
+				Config:           makeConfig(srv.connectionParams(token), test.config...),
+				VerifyConnection: true,
+			})
+			defer dbtesting.AssertClose(t, db)
+
+			capabilities, err := db.Capabilities()
+			require.NoError(t, err)
+			require.Equal(t, test.expected, capabilities)
+
+			created := srv.callCount("POST /api/v2/users")
+			err = newUser(db, test.statement)
+			if test.expectedErr == "" {
+				require.NoError(t, err)
+			} else {
+				require.Error(t, err)
+				require.True(t, errors.Is(err, ErrInsufficientPermissions), err)
+				require.Contains(t, err.Error(), test.expectedErr)
+			}
+			require.Equal(t, test.expectedUser, srv.callCount("POST /api/v2/users") > created)
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package influxdbv2
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/go-secure-stdlib/strutil"
+)
+
+// require_server_eku and max_chain_depth constrain the server certificate
+// beyond what the standard verification checks, for security policies that
+// require it. They are checked by a VerifyPeerCertificate callback, which
+// only runs once the chain has been verified, so they add to the standard
+// verification rather than replace it.
+
+// extKeyUsages are the extended key usages require_server_eku accepts, by
+// name.
+var extKeyUsages = map[string]x509.ExtKeyUsage{
+	"serverAuth":      x509.ExtKeyUsageServerAuth,
+	"clientAuth":      x509.ExtKeyUsageClientAuth,
+	"codeSigning":     x509.ExtKeyUsageCodeSigning,
+	"emailProtection": x509.ExtKeyUsageEmailProtection,
+	"timeStamping":    x509.ExtKeyUsageTimeStamping,
+	"ocspSigning":     x509.ExtKeyUsageOCSPSigning,
+}
+
+// certificateConstraints are the constraints the server certificate must
+// satisfy on top of the standard verification.
+type certificateConstraints struct {
+	// extKeyUsages must all be listed by the server certificate. A
+	// certificate valid for any usage doesn't satisfy them.
+	extKeyUsages []x509.ExtKeyUsage
+	// maxChainDepth bounds the number of CA certificates above the server
+	// certificate in its verified chain, its root included. Zero means no
+	// bound.
+	maxChainDepth int
+}
+
+// parseExtKeyUsages parses require_server_eku entries. Entries may also be
+// given as a single comma-separated string.
+func parseExtKeyUsages(raw []string) ([]x509.ExtKeyUsage, error) {
+	var usages []x509.ExtKeyUsage
+	for _, name := range strutil.ParseStringSlice(strings.Join(raw, ","), ",") {
+		usage, ok := extKeyUsages[name]
+		if !ok {
+			known := make([]string, 0, len(extKeyUsages))
+			for k := range extKeyUsages {
+				known = append(known, k)
+			}
+			sort.Strings(known)
+			return nil, fmt.Errorf("unknown extended key usage %q, expected one of %s", name, strings.Join(known, ", "))
+		}
+		usages = append(usages, usage)
+	}
+	return usages, nil
+}
+
+// constraintError is the error of a server certificate violating a
+// constraint, so that it is counted as a TLS failure, see
+// connectionErrorCause.
+type constraintError struct {
+	msg string
+}
+
+func (e *constraintError) Error() string {
+	return e.msg
+}
+
+// enabled reports whether there is any constraint to check.
+func (c certificateConstraints) enabled() bool {
+	return len(c.extKeyUsages) > 0 || c.maxChainDepth > 0
+}
+
+// verifyPeerCertificate is a tls.Config.VerifyPeerCertificate callback
+// checking the constraints on the chains verified by the standard
+// verification. The server is accepted if one of them satisfies the
+// constraints.
+func (c certificateConstraints) verifyPeerCertificate(_ [][]byte, verifiedChains [][]*x509.Certificate) error {
+	if len(verifiedChains) == 0 {
+		return &constraintError{"the server certificate constraints cannot be checked, the certificate chain wasn't verified"}
+	}
+
+	leaf := verifiedChains[0][0]
+	for _, usage := range c.extKeyUsages {
+		if !hasExtKeyUsage(leaf, usage) {
+			return &constraintError{fmt.Sprintf("the server certificate for %q doesn't have the extended key usage %s required by require_server_eku", leaf.Subject.CommonName, extKeyUsageName(usage))}
+		}
+	}
+
+	if c.maxChainDepth > 0 {
+		shortest := len(verifiedChains[0]) - 1
+		for _, chain := range verifiedChains[1:] {
+			if depth := len(chain) - 1; depth < shortest {
+				shortest = depth
+			}
+		}
+		if shortest > c.maxChainDepth {
+			return &constraintError{fmt.Sprintf("the server certificate for %q is %d CA certificates away from its root, more than max_chain_depth allows (%d)", leaf.Subject.CommonName, shortest, c.maxChainDepth)}
+		}
+	}
+	return nil
+}
+
+// apply adds the constraints to tlsConfig, if there are any.
+func (c certificateConstraints) apply(tlsConfig *tls.Config) {
+	if c.enabled() {
+		tlsConfig.VerifyPeerCertificate = c.verifyPeerCertificate
+	}
+}
+
+func hasExtKeyUsage(cert *x509.Certificate, usage x509.ExtKeyUsage) bool {
+	for _, u := range cert.ExtKeyUsage {
+		if u == usage {
+			return true
+		}
+	}
+	return false
+}
+
+func extKeyUsageName(usage x509.ExtKeyUsage) string {
+	for name, u := range extKeyUsages {
+		if u == usage {
+			return name
+		}
+	}
+	return fmt.Sprintf("%d", usage)
+}
@@ -0,0 +1,151 @@
+package influxdbv2
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/database/dbplugin/v5"
+	"github.com/stretchr/testify/require"
+)
+
+// newChainedTLSServer returns a TLS server presenting a certificate issued by
+// issuer with the given usage, along with the chain up to, but excluding,
+// the root.
+func newChainedTLSServer(t *testing.T, issuer *testCA, usage x509.ExtKeyUsage, intermediates ...*testCA) *httptest.Server {
+	t.Helper()
+	certPEM, keyPEM := issuer.issue(t, "127.0.0.1", usage)
+	for _, ca := range intermediates {
+		certPEM += ca.pem
+	}
+	cert, err := tls.X509KeyPair([]byte(certPEM), []byte(keyPEM))
+	require.NoError(t, err)
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	srv.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestTransport_CertificateConstraints(t *testing.T) {
+	root := newTestCA(t, "root", nil)
+	intermediate := newTestCA(t, "intermediate", root)
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(caFile, []byte(root.pem), 0o600))
+
+	serverAuth := newChainedTLSServer(t, intermediate, x509.ExtKeyUsageServerAuth, intermediate)
+	anyUsage := newChainedTLSServer(t, intermediate, x509.ExtKeyUsageAny, intermediate)
+	direct := newChainedTLSServer(t, root, x509.ExtKeyUsageServerAuth)
+	untrusted := newChainedTLSServer(t, newTestCA(t, "other root", nil), x509.ExtKeyUsageServerAuth)
+
+	tests := map[string]struct {
+		srv       *httptest.Server
+		config    []interface{}
+		expectErr string
+	}{
+		"required usage present": {
+			srv:    serverAuth,
+			config: []interface{}{"require_server_eku", "serverAuth"},
+		},
+		"required usage missing": {
+			srv:       serverAuth,
+			config:    []interface{}{"require_server_eku", "serverAuth,clientAuth"},
+			expectErr: "doesn't have the extended key usage clientAuth required by require_server_eku",
+		},
+		"any usage doesn't count": {
+			srv:       anyUsage,
+			config:    []interface{}{"require_server_eku", []string{"serverAuth"}},
+			expectErr: "doesn't have the extended key usage serverAuth required by require_server_eku",
+		},
+		"chain within depth": {
+			srv:    serverAuth,
+			config: []interface{}{"max_chain_depth", 2},
+		},
+		"chain too deep": {
+			srv:       serverAuth,
+			config:    []interface{}{"max_chain_depth", 1},
+			expectErr: "is 2 CA certificates away from its root, more than max_chain_depth allows (1)",
+		},
+		"direct issuance within depth": {
+			srv:    direct,
+			config: []interface{}{"max_chain_depth", 1, "require_server_eku", "serverAuth"},
+		},
+		"standard verification still applies": {
+			srv:       untrusted,
+			config:    []interface{}{"max_chain_depth", 5},
+			expectErr: "certificate signed by unknown authority",
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			u, err := url.Parse(test.srv.URL)
+			require.NoError(t, err)
+			db := new()
+			_, err = db.Initialize(context.Background(), dbplugin.InitializeRequest{
+				Config: makeConfig(map[string]interface{}{
+					"host":        u.Hostname(),
+					"port":        u.Port(),
+					"token":       "token",
+					"tls":         true,
+					"tls_ca_file": caFile,
+				}, test.config...),
+			})
+			require.NoError(t, err)
+			transport, err := db.newTransport()
+			require.NoError(t, err)
+
+			resp, err := (&http.Client{Transport: transport}).Get(test.srv.URL + "/ping")
+			if test.expectErr == "" {
+				require.NoError(t, err)
+				resp.Body.Close()
+				return
+			}
+			require.Error(t, err)
+			require.Contains(t, err.Error(), test.expectErr)
+			if test.srv != untrusted {
+				require.Equal(t, causeTLS, connectionErrorCause(err))
+			}
+		})
+	}
+}
+
+func TestInitialize_InvalidCertificateConstraints(t *testing.T) {
+	tests := map[string]struct {
+		config    []interface{}
+		expectErr string
+	}{
+		"unknown usage": {
+			config:    []interface{}{"require_server_eku", "serverAuth,webAuth"},
+			expectErr: `unknown extended key usage "webAuth"`,
+		},
+		"negative depth": {
+			config:    []interface{}{"max_chain_depth", -1},
+			expectErr: "max_chain_depth cannot be negative",
+		},
+		"with insecure_tls": {
+			config:    []interface{}{"max_chain_depth", 2, "insecure_tls", true},
+			expectErr: "cannot be combined with insecure_tls",
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := new().Initialize(context.Background(), dbplugin.InitializeRequest{
+				Config: makeConfig(map[string]interface{}{
+					"host":  "127.0.0.1",
+					"token": "token",
+					"tls":   true,
+				}, test.config...),
+			})
+			require.Error(t, err)
+			require.Contains(t, err.Error(), test.expectErr)
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package influxdbv2
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/influxdata/influxdb-client-go/v2"
+	"github.com/influxdata/influxdb-client-go/v2/domain"
+)
+
+// On InfluxDB Cloud, tokens are scoped to a single organization and typically
+// can't list every authorization or organization. With cloud set, the access
+// check, which scans authorizations for the token's permissions, is skipped as
+// with skip_access_check, and without organization_id the organization is
+// derived from the token's own authorization rather than by listing
+// organizations. Organizations can't be given by name, since resolving a name
+// takes listing them.
+
+// tokenOrganization returns the organization of the configured token's own
+// authorization, failing with a request to set organization_id if the token
+// can't see it.
+func (i *influxdbConnectionProducer) tokenOrganization(ctx context.Context, cli influxdb2.Client) (*domain.Organization, error) {
+	if i.orgByID != nil {
+		return i.orgByID, nil
+	}
+	authorization, err := findAuthorizationByToken(ctx, cli, i.Token, i.maxAuthorizationPages())
+	if err != nil {
+		return nil, withKind(ErrOrganizationNotFound, fmt.Errorf("unable to derive the organization from the token's authorization, set organization_id: %w", classify(err)))
+	}
+	orgID := stringValue(authorization.OrgID)
+	if orgID == "" {
+		return nil, withKind(ErrOrganizationNotFound, fmt.Errorf("the token's authorization isn't scoped to an organization, set organization_id"))
+	}
+
+	var org *domain.Organization
+	err = retry(ctx, func(int) error {
+		var err error
+		org, err = cli.OrganizationsAPI().FindOrganizationByID(ctx, orgID)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up the organization of the token: %w", classify(err))
+	}
+	i.logger.Info("no organization configured, using the organization of the token", "organization", org.Name, "organization_id", orgID)
+	i.orgByID = org
+	return org, nil
+}
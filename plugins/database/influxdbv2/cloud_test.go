@@ -0,0 +1,128 @@
+package influxdbv2
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/database/dbplugin/v5"
+	dbtesting "github.com/hashicorp/vault/sdk/database/dbplugin/v5/testing"
+	"github.com/stretchr/testify/require"
+)
+
+// newCloudServer returns a fake server behaving like InfluxDB Cloud for an
+// organization-scoped token: organizations can't be listed, and neither can
+// authorizations if listAuthorizations is false.
+func newCloudServer(t *testing.T, token string, listAuthorizations bool) *fakeInfluxServer {
+	srv := newFakeInfluxServer(t, token)
+	orgID := srv.orgID("vault")
+	srv.Lock()
+	srv.authorizations[0].OrgID = &orgID
+	srv.Unlock()
+	// A second organization makes guessing from a listing impossible.
+	srv.addOrg("other")
+
+	forbidden := func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusForbidden, "forbidden", "token is scoped to an organization")
+	}
+	srv.handle("GET /api/v2/orgs", forbidden)
+	if !listAuthorizations {
+		srv.handle("GET /api/v2/authorizations", forbidden)
+	}
+	return srv
+}
+
+func cloudParams(srv *fakeInfluxServer, token string, kv ...interface{}) map[string]interface{} {
+	config := makeConfig(srv.connectionParams(token), append([]interface{}{"cloud", true}, kv...)...)
+	delete(config, "organization")
+	return config
+}
+
+func TestInitialize_Cloud(t *testing.T) {
+	const token = "cloud-token"
+
+	newUser := func(t *testing.T, db *InfluxdbV2) {
+		t.Helper()
+		dbtesting.AssertNewUser(t, db, dbplugin.NewUserRequest{
+			UsernameConfig: dbplugin.UsernameMetadata{
+				DisplayName: "test",
+				RoleName:    "test",
+			},
+			Statements: dbplugin.Statements{
+				Commands: []string{`{"permissions": [{"action": "read", "resource": {"type": "buckets"}}]}`},
+			},
+			Password:   "nuozxby98523u89bdfnkjl",
+			Expiration: time.Now().Add(time.Hour),
+		})
+	}
+
+	t.Run("organization derived from the token", func(t *testing.T) {
+		srv := newCloudServer(t, token, true)
+		db := new()
+		dbtesting.AssertInitialize(t, db, dbplugin.InitializeRequest{
+			Config:           cloudParams(srv, token),
+			VerifyConnection: true,
+		})
+		defer dbtesting.AssertClose(t, db)
+		require.Equal(t, srv.orgID("vault"), *db.orgByID.Id)
+
+		newUser(t, db)
+		capabilities, err := db.Capabilities()
+		require.NoError(t, err)
+		require.False(t, capabilities.Verified)
+	})
+
+	t.Run("organization_id required when not derivable", func(t *testing.T) {
+		srv := newCloudServer(t, token, false)
+		_, err := new().Initialize(context.Background(), dbplugin.InitializeRequest{
+			Config:           cloudParams(srv, token),
+			VerifyConnection: true,
+		})
+		require.Error(t, err)
+		require.True(t, errors.Is(err, ErrOrganizationNotFound), err.Error())
+		require.Contains(t, err.Error(), "set organization_id")
+	})
+
+	t.Run("organization_id", func(t *testing.T) {
+		srv := newCloudServer(t, token, false)
+		db := new()
+		dbtesting.AssertInitialize(t, db, dbplugin.InitializeRequest{
+			Config:           cloudParams(srv, token, "organization_id", srv.orgID("vault")),
+			VerifyConnection: true,
+		})
+		defer dbtesting.AssertClose(t, db)
+		newUser(t, db)
+	})
+
+	t.Run("diagnose", func(t *testing.T) {
+		srv := newCloudServer(t, token, false)
+		db := new()
+		dbtesting.AssertInitialize(t, db, dbplugin.InitializeRequest{
+			Config: cloudParams(srv, token, "organization_id", srv.orgID("vault")),
+		})
+		defer dbtesting.AssertClose(t, db)
+
+		d := db.Diagnose(context.Background())
+		require.True(t, d.Passed(), "%#v", d)
+		require.Equal(t, DiagnosticSkipped, d.Steps[2].Result)
+		require.Contains(t, d.Steps[2].Message, "cloud is set")
+	})
+
+	t.Run("without cloud", func(t *testing.T) {
+		srv := newCloudServer(t, token, true)
+		_, err := new().Initialize(context.Background(), dbplugin.InitializeRequest{
+			Config:           makeConfig(cloudParams(srv, token), "cloud", false),
+			VerifyConnection: true,
+		})
+		require.Error(t, err)
+	})
+
+	t.Run("organization by name", func(t *testing.T) {
+		srv := newCloudServer(t, token, true)
+		err := ValidateConfig(makeConfig(cloudParams(srv, token), "organization", "vault"))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "organization cannot be set with cloud")
+	})
+}
@@ -0,0 +1,73 @@
+package influxdbv2
+
+import (
+	"io"
+	"net/http"
+	"sync"
+
+	"golang.org/x/time/rate"
+)
+
+// limitTransport bounds the number of requests in flight to the server at
+// max_concurrent_operations. Requests over the limit wait for a slot until
+// their context is done. A slot is held until the response body is closed,
+// so reading a response counts towards the limit too. The slots are shared by
+// every client built for the same configuration.
+type limitTransport struct {
+	wrappedTransport
+	slots chan struct{}
+}
+
+func (t *limitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	select {
+	case t.slots <- struct{}{}:
+	case <-req.Context().Done():
+		return nil, req.Context().Err()
+	}
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		<-t.slots
+		return nil, err
+	}
+	resp.Body = &releasingBody{ReadCloser: resp.Body, release: func() { <-t.slots }}
+	return resp, nil
+}
+
+// releasingBody gives back a request slot the first time it is closed.
+type releasingBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
+
+// rateLimitTransport rate limits the requests sent to the server at
+// requests_per_second, letting burst requests through at once. Requests over
+// the limit wait for their turn, failing right away if it wouldn't come
+// before their context's deadline. The limiter is shared by every client
+// built for the same configuration.
+type rateLimitTransport struct {
+	wrappedTransport
+	limiter *rate.Limiter
+}
+
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.limiter.Wait(req.Context()); err != nil {
+		return nil, err
+	}
+	return t.base.RoundTrip(req)
+}
+
+// burst returns the number of requests let through at once by the rate
+// limiter. It defaults to a single request.
+func (i *influxdbConnectionProducer) burst() int {
+	if i.Burst == 0 {
+		return 1
+	}
+	return i.Burst
+}
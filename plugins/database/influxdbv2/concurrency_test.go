@@ -0,0 +1,253 @@
+package influxdbv2
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/database/dbplugin/v5"
+	dbtesting "github.com/hashicorp/vault/sdk/database/dbplugin/v5/testing"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/time/rate"
+)
+
+func TestLimitTransport_CapsConcurrency(t *testing.T) {
+	const limit = 2
+	var inFlight, maxInFlight int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			max := atomic.LoadInt32(&maxInFlight)
+			if n <= max || atomic.CompareAndSwapInt32(&maxInFlight, max, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+	}))
+	defer srv.Close()
+
+	client := &http.Client{Transport: &limitTransport{
+		wrappedTransport: wrappedTransport{http.DefaultTransport},
+		slots:            make(chan struct{}, limit),
+	}}
+
+	var wg sync.WaitGroup
+	for n := 0; n < 10; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := client.Get(srv.URL)
+			require.NoError(t, err)
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}()
+	}
+	wg.Wait()
+
+	require.Equal(t, int32(limit), atomic.LoadInt32(&maxInFlight))
+}
+
+func TestLimitTransport_QueueingIsContextBounded(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	transport := &limitTransport{
+		wrappedTransport: wrappedTransport{http.DefaultTransport},
+		slots:            make(chan struct{}, 1),
+	}
+	client := &http.Client{Transport: transport}
+
+	// Hold the only slot by leaving the body open.
+	held, err := client.Get(srv.URL)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+	require.NoError(t, err)
+	_, err = client.Do(req)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// Closing the body frees the slot, even when closed twice.
+	held.Body.Close()
+	held.Body.Close()
+	resp, err := client.Get(srv.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Empty(t, transport.slots)
+}
+
+func TestInitialize_MaxConcurrentOperations(t *testing.T) {
+	const token = "root-token"
+	srv := newFakeInfluxServer(t, token)
+
+	db := new()
+	dbtesting.AssertInitialize(t, db, dbplugin.InitializeRequest{
+		Config:           makeConfig(srv.connectionParams(token), "max_concurrent_operations", 3),
+		VerifyConnection: true,
+	})
+	defer dbtesting.AssertClose(t, db)
+
+	cli, err := db.getConnection(context.Background())
+	require.NoError(t, err)
+	limit, ok := cli.Options().HTTPClient().Transport.(*sessionTransport).base.(*limitTransport)
+	require.True(t, ok)
+	require.Equal(t, 3, cap(limit.slots))
+	require.Empty(t, limit.slots)
+
+	_, err = new().Initialize(context.Background(), dbplugin.InitializeRequest{
+		Config: makeConfig(srv.connectionParams(token), "max_concurrent_operations", -1),
+	})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "max_concurrent_operations cannot be negative")
+}
+
+func TestRateLimitTransport_ShapesBursts(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	const perSecond, burst = 20, 2
+	client := &http.Client{Transport: &rateLimitTransport{
+		wrappedTransport: wrappedTransport{http.DefaultTransport},
+		limiter:          rate.NewLimiter(perSecond, burst),
+	}}
+
+	get := func() {
+		resp, err := client.Get(srv.URL)
+		require.NoError(t, err)
+		resp.Body.Close()
+	}
+
+	// The burst goes through at once, the rest at the configured rate.
+	start := time.Now()
+	for n := 0; n < burst; n++ {
+		get()
+	}
+	require.Less(t, int64(time.Since(start)), int64(25*time.Millisecond))
+
+	var wg sync.WaitGroup
+	for n := 0; n < 4; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			get()
+		}()
+	}
+	wg.Wait()
+	require.GreaterOrEqual(t, int64(time.Since(start)), int64(190*time.Millisecond))
+}
+
+func TestRateLimitTransport_WaitIsContextBounded(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	client := &http.Client{Transport: &rateLimitTransport{
+		wrappedTransport: wrappedTransport{http.DefaultTransport},
+		limiter:          rate.NewLimiter(rate.Every(time.Hour), 1),
+	}}
+	resp, err := client.Get(srv.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+	require.NoError(t, err)
+	start := time.Now()
+	_, err = client.Do(req)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "exceed context deadline")
+	require.Less(t, int64(time.Since(start)), int64(500*time.Millisecond))
+}
+
+func TestInitialize_RequestsPerSecond(t *testing.T) {
+	const token = "root-token"
+	srv := newFakeInfluxServer(t, token)
+
+	db := new()
+	dbtesting.AssertInitialize(t, db, dbplugin.InitializeRequest{
+		Config:           makeConfig(srv.connectionParams(token), "requests_per_second", 50, "burst", 5, "max_concurrent_operations", 2),
+		VerifyConnection: true,
+	})
+	defer dbtesting.AssertClose(t, db)
+
+	cli, err := db.getConnection(context.Background())
+	require.NoError(t, err)
+	limited, ok := cli.Options().HTTPClient().Transport.(*sessionTransport).base.(*rateLimitTransport)
+	require.True(t, ok)
+	require.Equal(t, rate.Limit(50), limited.limiter.Limit())
+	require.Equal(t, 5, limited.limiter.Burst())
+	_, ok = limited.base.(*limitTransport)
+	require.True(t, ok)
+
+	tests := map[string]struct {
+		config   []interface{}
+		expected string
+	}{
+		"negative rate":      {[]interface{}{"requests_per_second", -1}, "requests_per_second cannot be negative"},
+		"negative burst":     {[]interface{}{"requests_per_second", 1, "burst", -1}, "burst cannot be negative"},
+		"burst without rate": {[]interface{}{"burst", 5}, "burst requires requests_per_second"},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := new().Initialize(context.Background(), dbplugin.InitializeRequest{
+				Config: makeConfig(srv.connectionParams(token), test.config...),
+			})
+			require.Error(t, err)
+			require.Contains(t, err.Error(), test.expected)
+		})
+	}
+}
+
+func TestInfluxdb_MaxConcurrentOperations_CredentialOperations(t *testing.T) {
+	const token = "root-token"
+	srv := newFakeInfluxServer(t, token)
+	var inFlight, maxInFlight int32
+	handler := srv.Config.Handler
+	srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			max := atomic.LoadInt32(&maxInFlight)
+			if n <= max || atomic.CompareAndSwapInt32(&maxInFlight, max, n) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+		handler.ServeHTTP(w, r)
+	})
+
+	db := new()
+	dbtesting.AssertInitialize(t, db, dbplugin.InitializeRequest{
+		Config:           makeConfig(srv.connectionParams(token), "max_concurrent_operations", 1),
+		VerifyConnection: true,
+	})
+	defer dbtesting.AssertClose(t, db)
+
+	// Every request of every operation gives its slot back, so operations
+	// issued at once all complete, one request at a time.
+	var wg sync.WaitGroup
+	for n := 0; n < 4; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := db.NewUser(context.Background(), dbplugin.NewUserRequest{
+				UsernameConfig: dbplugin.UsernameMetadata{DisplayName: "test", RoleName: "test"},
+				Statements:     dbplugin.Statements{Commands: []string{`{"permissions": [{"action": "read", "resource": {"type": "buckets"}}]}`}},
+				Password:       "nuozxby98523u89bdfnkjl",
+				Expiration:     time.Now().Add(time.Minute),
+			})
+			require.NoError(t, err)
+			_, err = db.DeleteUser(context.Background(), dbplugin.DeleteUserRequest{Username: resp.Username})
+			require.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, int32(1), atomic.LoadInt32(&maxInFlight))
+}
@@ -2,45 +2,390 @@ package influxdbv2
 
 import (
 	"context"
-	"crypto/tls"
 	"errors"
 	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
 	"sync"
 	"time"
 
+	log "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/go-multierror"
 	"github.com/hashicorp/go-secure-stdlib/parseutil"
-	"github.com/hashicorp/go-secure-stdlib/tlsutil"
+	"github.com/hashicorp/go-secure-stdlib/strutil"
 	"github.com/hashicorp/vault/sdk/database/dbplugin/v5"
-	"github.com/hashicorp/vault/sdk/database/helper/connutil"
 	"github.com/hashicorp/vault/sdk/helper/certutil"
 	"github.com/influxdata/influxdb-client-go/v2"
+	"github.com/influxdata/influxdb-client-go/v2/domain"
 	"github.com/mitchellh/mapstructure"
+	"golang.org/x/time/rate"
 )
 
+const (
+	// Upper bounds of the client retry settings, well past any useful value.
+	maxClientRetryExponentialBase = 10
+	maxClientMaxRetries           = 100
+
+	// defaultExpirySkew is the expiry_skew used when it isn't configured.
+	defaultExpirySkew = 60 * time.Second
+
+	// defaultResolutionRetryWindow is the resolution_retry_window used when
+	// it isn't configured.
+	defaultResolutionRetryWindow = 2 * time.Second
+
+	// maxRootRotationGrace bounds root_rotation_grace, so that a rotated
+	// token can't outlive its rotation for long.
+	maxRootRotationGrace = 24 * time.Hour
+)
+
+// nonConnectionFields are the config fields that neither shape the client nor
+// decide whether a token is accepted for it. Initialize keeps the existing
+// client when only these change; every other field, including any it doesn't
+// know, is connection-relevant and makes it build a new one.
+var nonConnectionFields = map[string]struct{}{
+	"organization":                   {},
+	"organization_id":                {},
+	"resolution_organization":        {},
+	"default_bucket":                 {},
+	"default_bucket_organization":    {},
+	"case_insensitive_names":         {},
+	"session_name":                   {},
+	"origin_cluster":                 {},
+	"origin_mount":                   {},
+	"username_template":              {},
+	"redaction_marker":               {},
+	"metrics_listen_addr":            {},
+	"metrics_listen_allow_remote":    {},
+	"skip_token_format_check":        {},
+	"lazy_connect":                   {},
+	"prewarm":                        {},
+	"warm_connections":               {},
+	"auto_create_org":                {},
+	"verify_endpoint":                {},
+	"verify_write_capability":        {},
+	"verify_created_credential":      {},
+	"strict_timeouts":                {},
+	"expiry_skew":                    {},
+	"resolution_retry_window":        {},
+	"resolution_retry_on":            {},
+	"revocation_attempts":            {},
+	"root_rotation_grace":            {},
+	"import_allowed_permissions":     {},
+	"max_permissions":                {},
+	"max_permissions_size":           {},
+	"forbidden_unscoped_permissions": {},
+}
+
+// connectionFields returns the connection-relevant entries of config.
+func connectionFields(config map[string]interface{}) map[string]interface{} {
+	fields := make(map[string]interface{}, len(config))
+	for k, v := range config {
+		if _, ok := nonConnectionFields[k]; !ok {
+			fields[k] = v
+		}
+	}
+	return fields
+}
+
+// newInfluxClient creates the influx clients; tests replace it to observe
+// them.
+var newInfluxClient = influxdb2.NewClientWithOptions
+
 // influxdbConnectionProducer implements ConnectionProducer and provides an
 // interface for influxdb databases to make connections.
 type influxdbConnectionProducer struct {
 	Host              string      `json:"host" structs:"host" mapstructure:"host"`
+	URL               string      `json:"url" structs:"url" mapstructure:"url"`
+	Scheme            string      `json:"scheme" structs:"scheme" mapstructure:"scheme"`
 	Token             string      `json:"token" structs:"token" mapstructure:"token"`
 	Port              string      `json:"port" structs:"port" mapstructure:"port"` // default to 8086
 	TLS               bool        `json:"tls" structs:"tls" mapstructure:"tls"`
 	InsecureTLS       bool        `json:"insecure_tls" structs:"insecure_tls" mapstructure:"insecure_tls"`
+	RequireTLS        bool        `json:"require_tls" structs:"require_tls" mapstructure:"require_tls"`
+	RequireServerEKU  []string    `json:"require_server_eku" structs:"require_server_eku" mapstructure:"require_server_eku"`
+	MaxChainDepth     int         `json:"max_chain_depth" structs:"max_chain_depth" mapstructure:"max_chain_depth"`
 	ConnectTimeoutRaw interface{} `json:"connect_timeout" structs:"connect_timeout" mapstructure:"connect_timeout"`
 	TLSMinVersion     string      `json:"tls_min_version" structs:"tls_min_version" mapstructure:"tls_min_version"`
 	PemBundle         string      `json:"pem_bundle" structs:"pem_bundle" mapstructure:"pem_bundle"`
 	PemJSON           string      `json:"pem_json" structs:"pem_json" mapstructure:"pem_json"`
+	PKIResponse       string      `json:"pki_response" structs:"pki_response" mapstructure:"pki_response"`
+	StrictTLS         bool        `json:"strict_tls" structs:"strict_tls" mapstructure:"strict_tls"`
+	TLSCertFile       string      `json:"tls_cert_file" structs:"tls_cert_file" mapstructure:"tls_cert_file"`
+	TLSKeyFile        string      `json:"tls_key_file" structs:"tls_key_file" mapstructure:"tls_key_file"`
+	TLSCAFile         string      `json:"tls_ca_file" structs:"tls_ca_file" mapstructure:"tls_ca_file"`
 	DefaultBucket     string      `json:"default_bucket" structs:"default_bucket" mapstructure:"default_bucket"`
 	Organization      string      `json:"organization" structs:"organization" mapstructure:"organization"`
 
-	connectTimeout time.Duration
-	certificate    string
-	privateKey     string
-	issuingCA      string
-	rawConfig      map[string]interface{}
+	// DefaultBucketOrganization is the organization DefaultBucket is looked
+	// up in, for every credential, see default_bucket.go.
+	DefaultBucketOrganization string `json:"default_bucket_organization" structs:"default_bucket_organization" mapstructure:"default_bucket_organization"`
+
+	// ResolutionOrganization is the organization in which buckets named
+	// without an organization are looked up, instead of the credential's own
+	// organization. Credentials are still created in, and scoped to, their
+	// own organization.
+	ResolutionOrganization string `json:"resolution_organization" structs:"resolution_organization" mapstructure:"resolution_organization"`
+
+	// SkipTokenFormatCheck turns off the warning logged when Token doesn't
+	// look like a token generated by InfluxDB v2.
+	SkipTokenFormatCheck bool `json:"skip_token_format_check" structs:"skip_token_format_check" mapstructure:"skip_token_format_check"`
+
+	// OrganizationID selects the default organization by ID instead of by
+	// name. It is mutually exclusive with Organization.
+	OrganizationID string `json:"organization_id" structs:"organization_id" mapstructure:"organization_id"`
+
+	// Endpoints lists the nodes of a clustered deployment to distribute
+	// connections across, according to EndpointPolicy. It replaces Host.
+	Endpoints      []string `json:"endpoints" structs:"endpoints" mapstructure:"endpoints"`
+	EndpointPolicy string   `json:"endpoint_policy" structs:"endpoint_policy" mapstructure:"endpoint_policy"`
+
+	// VerifyEndpoint, such as a read replica, receives the ping and health
+	// probes instead of the endpoints credential operations go to.
+	VerifyEndpoint string `json:"verify_endpoint" structs:"verify_endpoint" mapstructure:"verify_endpoint"`
+
+	// APIPath is the path API requests are sent under instead of the
+	// client's "/api/v2", see api_path.go.
+	APIPath string `json:"api_path" structs:"api_path" mapstructure:"api_path"`
+
+	// MaxConnectionLifetimeRaw bounds how long a client is reused before it
+	// is rebuilt, re-running the ping and access check. Unset means no limit.
+	MaxConnectionLifetimeRaw interface{} `json:"max_connection_lifetime" structs:"max_connection_lifetime" mapstructure:"max_connection_lifetime"`
+
+	// LazyConnect builds the client without checking reachability or the
+	// token's permissions, leaving the first operation to surface problems.
+	// It has no effect when the connection is verified during Initialize.
+	LazyConnect bool `json:"lazy_connect" structs:"lazy_connect" mapstructure:"lazy_connect"`
+
+	// SkipAccessCheck trusts the token to hold the permissions the plugin
+	// needs instead of checking them, for tokens that can't read the
+	// authorizations they are checked against.
+	SkipAccessCheck bool `json:"skip_access_check" structs:"skip_access_check" mapstructure:"skip_access_check"`
+
+	// RequireHealthy rejects a node that answers pings but reports itself
+	// unhealthy whenever a client is created, not only on verification.
+	RequireHealthy bool `json:"require_healthy" structs:"require_healthy" mapstructure:"require_healthy"`
+
+	// VerifyWriteCapability extends verify_connection with an end-to-end
+	// check that the token can create write tokens for DefaultBucket.
+	VerifyWriteCapability bool `json:"verify_write_capability" structs:"verify_write_capability" mapstructure:"verify_write_capability"`
+
+	// Prewarm resolves the organization and default bucket and runs the
+	// access check right after Initialize, so that the first credential
+	// operation after a reload doesn't pay for them.
+	Prewarm bool `json:"prewarm" structs:"prewarm" mapstructure:"prewarm"`
+
+	// WarmConnections is the number of idle connections to open right after
+	// Initialize, see warm_connections.go.
+	WarmConnections int `json:"warm_connections" structs:"warm_connections" mapstructure:"warm_connections"`
+
+	// AutoCreateOrg makes Initialize create Organization if it doesn't
+	// exist, see auto_create_org.go.
+	AutoCreateOrg bool `json:"auto_create_org" structs:"auto_create_org" mapstructure:"auto_create_org"`
+
+	// CaseInsensitiveNames matches organization and bucket names without
+	// regard to case during resolution.
+	CaseInsensitiveNames bool `json:"case_insensitive_names" structs:"case_insensitive_names" mapstructure:"case_insensitive_names"`
+
+	// SessionName identifies the mount in the server's request log and in the
+	// descriptions of the authorizations it creates.
+	SessionName string `json:"session_name" structs:"session_name" mapstructure:"session_name"`
+
+	// OriginCluster and OriginMount identify the Vault cluster and mount in
+	// the descriptions of the authorizations the mount creates, which the
+	// plugin can't learn from Vault, see credentialMetadata.
+	OriginCluster string `json:"origin_cluster" structs:"origin_cluster" mapstructure:"origin_cluster"`
+	OriginMount   string `json:"origin_mount" structs:"origin_mount" mapstructure:"origin_mount"`
+
+	// RedactionMarker selects how secrets are replaced in errors and logs,
+	// see redaction.go.
+	RedactionMarker string `json:"redaction_marker" structs:"redaction_marker" mapstructure:"redaction_marker"`
+
+	// MetricsListenAddr serves Prometheus metrics from the plugin process,
+	// on a loopback address unless MetricsListenAllowRemote is set, see
+	// prometheus.go.
+	MetricsListenAddr        string `json:"metrics_listen_addr" structs:"metrics_listen_addr" mapstructure:"metrics_listen_addr"`
+	MetricsListenAllowRemote bool   `json:"metrics_listen_allow_remote" structs:"metrics_listen_allow_remote" mapstructure:"metrics_listen_allow_remote"`
+
+	// Transport tuning, see newTransport for the order these are applied in.
+	HTTPProxy                string      `json:"http_proxy" structs:"http_proxy" mapstructure:"http_proxy"`
+	SOCKS5Proxy              string      `json:"socks5_proxy" structs:"socks5_proxy" mapstructure:"socks5_proxy"`
+	DNSResolver              string      `json:"dns_resolver" structs:"dns_resolver" mapstructure:"dns_resolver"`
+	DisableHTTP2             bool        `json:"disable_http2" structs:"disable_http2" mapstructure:"disable_http2"`
+	FollowRedirects          bool        `json:"follow_redirects" structs:"follow_redirects" mapstructure:"follow_redirects"`
+	MaxIdleConnections       int         `json:"max_idle_connections" structs:"max_idle_connections" mapstructure:"max_idle_connections"`
+	IdleConnectionTimeoutRaw interface{} `json:"idle_connection_timeout" structs:"idle_connection_timeout" mapstructure:"idle_connection_timeout"`
+	ResponseHeaderTimeoutRaw interface{} `json:"response_header_timeout" structs:"response_header_timeout" mapstructure:"response_header_timeout"`
+
+	// RequestTimeoutRaw and PingTimeoutRaw bound whole requests and the
+	// reachability check. StrictTimeouts rejects combinations of timeouts
+	// that can never take effect instead of logging them, see timeouts.go.
+	RequestTimeoutRaw interface{} `json:"request_timeout" structs:"request_timeout" mapstructure:"request_timeout"`
+	PingTimeoutRaw    interface{} `json:"ping_timeout" structs:"ping_timeout" mapstructure:"ping_timeout"`
+	StrictTimeouts    bool        `json:"strict_timeouts" structs:"strict_timeouts" mapstructure:"strict_timeouts"`
+
+	// Retry tuning of the influx client, which applies to its write API. Unset
+	// fields keep the client's defaults.
+	ClientRetryExponentialBase *int        `json:"client_retry_exponential_base" structs:"client_retry_exponential_base" mapstructure:"client_retry_exponential_base"`
+	ClientMaxRetries           *int        `json:"client_max_retries" structs:"client_max_retries" mapstructure:"client_max_retries"`
+	ClientMaxRetryTimeRaw      interface{} `json:"client_max_retry_time" structs:"client_max_retry_time" mapstructure:"client_max_retry_time"`
+
+	// ExpirySkewRaw is the grace period applied when deciding whether the
+	// expiry embedded in a credential's description has passed.
+	ExpirySkewRaw interface{} `json:"expiry_skew" structs:"expiry_skew" mapstructure:"expiry_skew"`
+
+	// ResolutionRetryWindowRaw is how long an organization or bucket that
+	// fails to resolve is looked up again, on the errors ResolutionRetryOn
+	// lists, see awaitResolution.
+	ResolutionRetryWindowRaw interface{} `json:"resolution_retry_window" structs:"resolution_retry_window" mapstructure:"resolution_retry_window"`
+	ResolutionRetryOn        []string    `json:"resolution_retry_on" structs:"resolution_retry_on" mapstructure:"resolution_retry_on"`
+
+	// VerifyCreatedCredential makes NewUser check that the token it created
+	// is usable before returning it, see verifyCredential.
+	VerifyCreatedCredential bool `json:"verify_created_credential" structs:"verify_created_credential" mapstructure:"verify_created_credential"`
+
+	// RootRotationGraceRaw is how long RotateRootToken leaves the old token
+	// usable, see rotate.go. Zero revokes it immediately.
+	RootRotationGraceRaw interface{} `json:"root_rotation_grace" structs:"root_rotation_grace" mapstructure:"root_rotation_grace"`
+
+	// MaxAuthorizationPages bounds the number of pages read when listing
+	// authorizations, see authorizations.go. Zero means
+	// defaultMaxAuthorizationPages.
+	MaxAuthorizationPages int `json:"max_authorization_pages" structs:"max_authorization_pages" mapstructure:"max_authorization_pages"`
+
+	// MaxConcurrentOperations bounds the number of requests in flight to the
+	// server, queuing the rest, see limitTransport. Credential operations
+	// hold the lock throughout and send their requests one at a time, so it
+	// only caps the requests sent in parallel, such as warm_connections'.
+	// Zero means no limit.
+	MaxConcurrentOperations int `json:"max_concurrent_operations" structs:"max_concurrent_operations" mapstructure:"max_concurrent_operations"`
+
+	// RequestsPerSecond rate limits the requests sent to the server, letting
+	// Burst of them through at once, see rateLimitTransport. Zero means no
+	// limit.
+	RequestsPerSecond float64 `json:"requests_per_second" structs:"requests_per_second" mapstructure:"requests_per_second"`
+	Burst             int     `json:"burst" structs:"burst" mapstructure:"burst"`
+
+	// RevocationAttempts is the number of attempts at each step of deleting a
+	// credential when the server fails transiently. Zero means retryAttempts.
+	RevocationAttempts int `json:"revocation_attempts" structs:"revocation_attempts" mapstructure:"revocation_attempts"`
+
+	// Cloud adapts the plugin to InfluxDB Cloud's organization-scoped tokens,
+	// see cloud.go.
+	Cloud bool `json:"cloud" structs:"cloud" mapstructure:"cloud"`
+
+	// RootProfile selects the permissions the token must hold by the purpose
+	// of the mount, see root_profiles.go.
+	RootProfile string `json:"root_profile" structs:"root_profile" mapstructure:"root_profile"`
+
+	// RequiredPermissions lists the permissions the token must hold under the
+	// custom root profile, as "<resource type>:<action>" entries.
+	RequiredPermissions []string `json:"required_permissions" structs:"required_permissions" mapstructure:"required_permissions"`
+
+	// MaxPermissions caps the number of permissions a credential may hold.
+	// Zero means no cap.
+	MaxPermissions int `json:"max_permissions" structs:"max_permissions" mapstructure:"max_permissions"`
+
+	// MaxPermissionsSize caps the size, in bytes, of the permissions of a
+	// credential as sent to create its authorization. Zero means
+	// defaultMaxPermissionsSize.
+	MaxPermissionsSize int `json:"max_permissions_size" structs:"max_permissions_size" mapstructure:"max_permissions_size"`
+
+	// ForbiddenUnscopedPermissions lists permissions, in the same format as
+	// RequiredPermissions, that credentials may only hold on a single
+	// resource, see checkPermissionLimits.
+	ForbiddenUnscopedPermissions []string `json:"forbidden_unscoped_permissions" structs:"forbidden_unscoped_permissions" mapstructure:"forbidden_unscoped_permissions"`
+
+	// ImportAllowedPermissions limits the permissions of authorizations
+	// ImportCredential adopts, in the same format as RequiredPermissions.
+	ImportAllowedPermissions []string `json:"import_allowed_permissions" structs:"import_allowed_permissions" mapstructure:"import_allowed_permissions"`
+
+	connectTimeout        time.Duration
+	idleConnectionTimeout time.Duration
+	responseHeaderTimeout time.Duration
+	requestTimeout        time.Duration
+	pingTimeout           time.Duration
+	clientMaxRetryTime    time.Duration
+	maxConnectionLifetime time.Duration
+	proxyURL              *url.URL
+	socks5URL             *url.URL
+	serverScheme          string // see the endpoint precedence in endpoints.go
+	operationSlots        chan struct{}
+	rateLimiter           *rate.Limiter
+	metricsAddr           string // the metrics endpoint held, see serveMetrics
+	expirySkew            time.Duration
+	resolutionRetryWindow time.Duration
+	resolutionRetryOn     map[string]bool
+	rootRotationGrace     time.Duration
+	rootProfile           string
+	requiredPermissions   []requiredPermission
+	importAllowed         []requiredPermission
+	forbiddenUnscoped     []requiredPermission
+
+	// endpoints holds the normalized "host:port" of each node. The index of
+	// the last node that answered, and for round_robin the node the next
+	// connection starts at, survive client rebuilds.
+	endpoints       []string
+	healthyEndpoint int
+	nextEndpoint    int
+	verifyEndpoint  string // normalized VerifyEndpoint, see probeClient
+	apiPath         string // normalized APIPath, see api_path.go
+	certificate     string
+	privateKey      string
+	issuingCA       string
+	caChain         []string
+	certConstraints certificateConstraints // see certificate_constraints.go
+	rawConfig       map[string]interface{} // redacted, see redactConfig
+
+	// certFiles serves the certificates of tls_cert_file, tls_key_file and
+	// tls_ca_file, if any is set.
+	certFiles *fileCertificates
+
+	// orgAccess caches the IDs of organizations in which the token has been
+	// confirmed to hold write access to authorizations.
+	orgAccess map[string]struct{}
+	// orgCache and bucketCache cache the results of name resolution, keyed
+	// by organization name and by "<org ID>/<bucket name>" respectively.
+	orgCache    map[string]*domain.Organization
+	bucketCache map[string]*domain.Bucket
+	// bucketListings caches the buckets of each organization, keyed by its
+	// ID, so that every bucket name of a request is resolved from a single
+	// listing, see orgBuckets.
+	bucketListings map[string]bucketListing
+	// orgByID caches the organization selected by organization_id, or the
+	// only accessible one if no organization is configured.
+	orgByID *domain.Organization
+
+	// grantedPermissions are the permissions of the token found by the last
+	// access check, which accessVerified tells apart from none having run.
+	// They back the capabilities, see capabilities.go.
+	grantedPermissions []domain.Permission
+	accessVerified     bool
+
+	// expiries is the schedule enforced by EnforceExpiry, see expiry.go.
+	expiries expirySchedule
+
+	// serverVersion is the version the server last reported, see
+	// version.go.
+	serverVersion string
+
+	// connectionConfig holds the connection-relevant fields client was
+	// built for, see nonConnectionFields.
+	connectionConfig map[string]interface{}
+
+	// retirement revokes the root tokens retired by rotation once their grace
+	// period is over, see rotate.go.
+	retirement rootRetirement
+
+	logger log.Logger
 
 	Initialized bool
 	Type        string
 	client      influxdb2.Client
+	// clientCreated is when client was created, see max_connection_lifetime.
+	clientCreated time.Time
 	sync.Mutex
 }
 
@@ -48,42 +393,443 @@ func (i *influxdbConnectionProducer) Initialize(ctx context.Context, req dbplugi
 	i.Lock()
 	defer i.Unlock()
 
-	i.rawConfig = req.Config
+	i.resetCaches()
+	i.expiries = expirySchedule{}
 
-	err := mapstructure.WeakDecode(req.Config, i)
-	if err != nil {
+	if err := i.applyConfig(req.Config); err != nil {
+		return dbplugin.InitializeResponse{}, err
+	}
+	if err := i.serveMetrics(); err != nil {
 		return dbplugin.InitializeResponse{}, err
 	}
 
-	if i.ConnectTimeoutRaw == nil {
-		i.ConnectTimeoutRaw = "5s"
+	// The client, and what was learned while building it, is only kept when
+	// no connection-relevant field changed.
+	connectionConfig := connectionFields(req.Config)
+	if i.client == nil || !reflect.DeepEqual(connectionConfig, i.connectionConfig) {
+		if i.client != nil {
+			if err := closeClient(i.client); err != nil {
+				i.logger.Warn("failed to close connection replaced by the new config", "error", err)
+			}
+			i.client = nil
+		}
+		i.serverVersion = ""
+		i.grantedPermissions, i.accessVerified = nil, false
+	}
+	i.connectionConfig = connectionConfig
+
+	// Set initialized to true at this point since all fields are set,
+	// and the connection can be established at a later time.
+	i.Initialized = true
+
+	if req.VerifyConnection {
+		if err := i.verifyConnection(ctx); err != nil {
+			// Neither keep nor leak a client that failed verification.
+			if i.client != nil {
+				if closeErr := closeClient(i.client); closeErr != nil {
+					i.logger.Warn("failed to close connection that failed verification", "error", closeErr)
+				}
+				i.client = nil
+			}
+			return dbplugin.InitializeResponse{}, fmt.Errorf("error verifying connection: %w", err)
+		}
+	} else if i.AutoCreateOrg {
+		conn, err := i.connection(true)
+		if err != nil {
+			return dbplugin.InitializeResponse{}, err
+		}
+		if _, err := i.ensureOrganization(ctx, conn.(influxdb2.Client)); err != nil {
+			return dbplugin.InitializeResponse{}, err
+		}
+	}
+
+	if i.Prewarm {
+		i.prewarm(ctx)
+	}
+	if i.WarmConnections > 0 {
+		i.warmConnections(ctx)
+	}
+
+	resp := dbplugin.InitializeResponse{
+		Config: req.Config,
+	}
+
+	return resp, nil
+}
+
+// ValidateConfig runs every check Initialize applies to the fields of config,
+// without contacting the server or initializing a connection, so that a
+// config can be linted before it is applied to a mount. Files referenced by
+// config, such as tls_ca_file, are read.
+func ValidateConfig(config map[string]interface{}) error {
+	i := &influxdbConnectionProducer{logger: log.NewNullLogger()}
+	return i.applyConfig(config)
+}
+
+// applyConfig decodes and validates config onto the connection fields,
+// applying defaults. It doesn't touch the network, which is left to
+// Initialize, and must be called with the lock held.
+func (i *influxdbConnectionProducer) applyConfig(config map[string]interface{}) error {
+	err := mapstructure.WeakDecode(config, i)
+	if err != nil {
+		return err
+	}
+	// Credentials embedded in url are dropped before anything is kept, and
+	// the config is then rejected, see stripURLCredentials.
+	var urlCredentials bool
+	i.URL, urlCredentials = stripURLCredentials(i.URL)
+	if urlCredentials {
+		stripped := make(map[string]interface{}, len(config))
+		for k, v := range config {
+			stripped[k] = v
+		}
+		stripped["url"] = i.URL
+		config = stripped
+	}
+
+	if i.MetricsListenAddr != "" {
+		if err := validateMetricsListenAddr(i.MetricsListenAddr, i.MetricsListenAllowRemote); err != nil {
+			return err
+		}
+	} else if i.MetricsListenAllowRemote {
+		return fmt.Errorf("metrics_listen_allow_remote requires metrics_listen_addr")
+	}
+
+	switch i.RedactionMarker {
+	case "":
+		i.RedactionMarker = redactionMarkerFixed
+	case redactionMarkerFixed, redactionMarkerHash:
+	default:
+		return fmt.Errorf("invalid redaction_marker %q, expected %q or %q", i.RedactionMarker, redactionMarkerFixed, redactionMarkerHash)
+	}
+
+	// Only keep a redacted copy of the config around; the secrets it holds
+	// are available on the decoded fields where they're needed.
+	i.rawConfig = redactConfig(config, i.secretValues())
+	if urlCredentials {
+		return fmt.Errorf("url %q embeds credentials, which are never used nor stored: remove them from url and set token instead", i.URL)
+	}
+
+	// Only a port given in this config overrides the scheme's standard port
+	// for url, see the endpoint precedence in endpoints.go.
+	var explicitPort string
+	if _, ok := config["port"]; ok {
+		explicitPort = i.Port
 	}
 	if i.Port == "" {
-		i.Port = "8086"
+		i.Port = influxdbDefaultPort
+	}
+	// Unset and zero timeouts both take their default, see timeouts.go.
+	if i.connectTimeout, err = parseTimeout("connect_timeout", i.ConnectTimeoutRaw, defaultConnectTimeout); err != nil {
+		return err
+	}
+	if i.idleConnectionTimeout, err = parseTimeout("idle_connection_timeout", i.IdleConnectionTimeoutRaw, 0); err != nil {
+		return err
+	}
+	if i.responseHeaderTimeout, err = parseTimeout("response_header_timeout", i.ResponseHeaderTimeoutRaw, 0); err != nil {
+		return err
+	}
+	if i.requestTimeout, err = parseTimeout("request_timeout", i.RequestTimeoutRaw, 0); err != nil {
+		return err
+	}
+	if i.pingTimeout, err = parseTimeout("ping_timeout", i.PingTimeoutRaw, 0); err != nil {
+		return err
+	}
+	for _, conflict := range i.timeoutConflicts() {
+		if i.StrictTimeouts {
+			return fmt.Errorf("inconsistent timeouts: %s", conflict)
+		}
+		i.logger.Warn("inconsistent timeouts, the shorter one always applies first", "conflict", conflict)
+	}
+	i.expirySkew = defaultExpirySkew
+	if i.ExpirySkewRaw != nil {
+		i.expirySkew, err = parseutil.ParseDurationSecond(i.ExpirySkewRaw)
+		if err != nil {
+			return fmt.Errorf("invalid expiry_skew: %w", err)
+		}
+		if i.expirySkew < 0 {
+			return fmt.Errorf("expiry_skew cannot be negative")
+		}
+	}
+	i.resolutionRetryWindow = defaultResolutionRetryWindow
+	if i.ResolutionRetryWindowRaw != nil {
+		i.resolutionRetryWindow, err = parseutil.ParseDurationSecond(i.ResolutionRetryWindowRaw)
+		if err != nil {
+			return fmt.Errorf("invalid resolution_retry_window: %w", err)
+		}
+		if i.resolutionRetryWindow < 0 {
+			return fmt.Errorf("resolution_retry_window cannot be negative")
+		}
+	}
+	i.resolutionRetryOn, err = parseResolutionRetryOn(i.ResolutionRetryOn, i.Cloud)
+	if err != nil {
+		return err
+	}
+	i.rootRotationGrace = 0
+	if i.RootRotationGraceRaw != nil {
+		i.rootRotationGrace, err = parseutil.ParseDurationSecond(i.RootRotationGraceRaw)
+		if err != nil {
+			return fmt.Errorf("invalid root_rotation_grace: %w", err)
+		}
+		if i.rootRotationGrace < 0 || i.rootRotationGrace > maxRootRotationGrace {
+			return fmt.Errorf("root_rotation_grace must be between 0 and %s", maxRootRotationGrace)
+		}
+	}
+	i.maxConnectionLifetime = 0
+	if i.MaxConnectionLifetimeRaw != nil {
+		i.maxConnectionLifetime, err = parseutil.ParseDurationSecond(i.MaxConnectionLifetimeRaw)
+		if err != nil {
+			return fmt.Errorf("invalid max_connection_lifetime: %w", err)
+		}
+		if i.maxConnectionLifetime < 0 {
+			return fmt.Errorf("max_connection_lifetime cannot be negative")
+		}
+	}
+	if i.MaxAuthorizationPages < 0 {
+		return fmt.Errorf("max_authorization_pages cannot be negative")
+	}
+	if i.MaxConcurrentOperations < 0 {
+		return fmt.Errorf("max_concurrent_operations cannot be negative")
+	}
+	i.operationSlots = nil
+	if i.MaxConcurrentOperations > 0 {
+		i.operationSlots = make(chan struct{}, i.MaxConcurrentOperations)
+	}
+	if i.RequestsPerSecond < 0 {
+		return fmt.Errorf("requests_per_second cannot be negative")
+	}
+	if i.Burst < 0 {
+		return fmt.Errorf("burst cannot be negative")
+	}
+	if i.Burst > 0 && i.RequestsPerSecond == 0 {
+		return fmt.Errorf("burst requires requests_per_second")
+	}
+	i.rateLimiter = nil
+	if i.RequestsPerSecond > 0 {
+		i.rateLimiter = rate.NewLimiter(rate.Limit(i.RequestsPerSecond), i.burst())
+	}
+	if i.RevocationAttempts < 0 || i.RevocationAttempts > maxRevocationAttempts {
+		return fmt.Errorf("revocation_attempts must be between 0 and %d", maxRevocationAttempts)
+	}
+	if i.MaxIdleConnections < 0 {
+		return fmt.Errorf("max_idle_connections cannot be negative")
+	}
+	if i.WarmConnections < 0 {
+		return fmt.Errorf("warm_connections cannot be negative")
+	}
+	if i.MaxIdleConnections > 0 && i.WarmConnections > i.MaxIdleConnections {
+		return fmt.Errorf("warm_connections cannot exceed max_idle_connections (%d), the connections over it would be closed right away", i.MaxIdleConnections)
+	}
+	if i.MaxConcurrentOperations > 0 && i.WarmConnections > i.MaxConcurrentOperations {
+		return fmt.Errorf("warm_connections cannot exceed max_concurrent_operations (%d), the connections over it could never be opened", i.MaxConcurrentOperations)
+	}
+	i.proxyURL = nil
+	if i.HTTPProxy != "" {
+		i.proxyURL, err = url.Parse(i.HTTPProxy)
+		if err != nil {
+			return fmt.Errorf("invalid http_proxy: %w", err)
+		}
+		if i.proxyURL.Host == "" {
+			return fmt.Errorf("invalid http_proxy: missing host")
+		}
+	}
+	i.socks5URL = nil
+	if i.SOCKS5Proxy != "" {
+		if i.HTTPProxy != "" {
+			return fmt.Errorf("http_proxy and socks5_proxy cannot both be set")
+		}
+		i.socks5URL, err = parseSOCKS5Proxy(i.SOCKS5Proxy)
+		if err != nil {
+			return fmt.Errorf("invalid socks5_proxy: %w", err)
+		}
+	}
+	if i.DNSResolver != "" {
+		if _, _, err := net.SplitHostPort(i.DNSResolver); err != nil {
+			return fmt.Errorf("invalid dns_resolver, expected host:port: %w", err)
+		}
+	}
+
+	if i.ClientRetryExponentialBase != nil && (*i.ClientRetryExponentialBase < 2 || *i.ClientRetryExponentialBase > maxClientRetryExponentialBase) {
+		return fmt.Errorf("client_retry_exponential_base must be between 2 and %d", maxClientRetryExponentialBase)
+	}
+	if i.ClientMaxRetries != nil && (*i.ClientMaxRetries < 0 || *i.ClientMaxRetries > maxClientMaxRetries) {
+		return fmt.Errorf("client_max_retries must be between 0 and %d", maxClientMaxRetries)
+	}
+	i.clientMaxRetryTime = 0
+	if i.ClientMaxRetryTimeRaw != nil {
+		i.clientMaxRetryTime, err = parseutil.ParseDurationSecond(i.ClientMaxRetryTimeRaw)
+		if err != nil {
+			return fmt.Errorf("invalid client_max_retry_time: %w", err)
+		}
+		if i.clientMaxRetryTime < time.Millisecond {
+			return fmt.Errorf("client_max_retry_time must be at least 1ms")
+		}
+	}
+
+	i.Organization = strings.TrimSpace(i.Organization)
+	i.OrganizationID = strings.TrimSpace(i.OrganizationID)
+	if i.Organization != "" && i.OrganizationID != "" {
+		return fmt.Errorf("organization and organization_id cannot both be set")
 	}
-	i.connectTimeout, err = parseutil.ParseDurationSecond(i.ConnectTimeoutRaw)
+	if i.Cloud && i.Organization != "" {
+		return fmt.Errorf("organization cannot be set with cloud, since resolving it takes listing organizations: set organization_id, or neither to use the organization of the token")
+	}
+	if i.OrganizationID != "" && !isOrganizationID(i.OrganizationID) {
+		return withKind(ErrInvalidOrganizationID, fmt.Errorf("invalid organization_id %q, expected 16 hexadecimal characters", i.OrganizationID))
+	}
+	if isOrganizationID(i.Organization) {
+		// Most likely an ID pasted into the name field.
+		i.logger.Warn("organization looks like an organization ID, treating it as organization_id; set organization_id instead", "organization", i.Organization)
+		i.OrganizationID = i.Organization
+		i.Organization = ""
+	}
+
+	if i.AutoCreateOrg && i.Organization == "" {
+		return fmt.Errorf("auto_create_org requires organization, the name of the organization to create")
+	}
+
+	i.ResolutionOrganization = strings.TrimSpace(i.ResolutionOrganization)
+	if i.ResolutionOrganization != "" && i.ResolutionOrganization == i.Organization {
+		i.logger.Warn("resolution_organization is the same as organization and has no effect", "resolution_organization", i.ResolutionOrganization)
+	}
+
+	i.DefaultBucketOrganization = strings.TrimSpace(i.DefaultBucketOrganization)
+	if i.DefaultBucketOrganization != "" && i.DefaultBucket == "" {
+		return fmt.Errorf("default_bucket_organization requires default_bucket")
+	}
+
+	if i.VerifyWriteCapability && i.DefaultBucket == "" {
+		return fmt.Errorf("verify_write_capability requires default_bucket")
+	}
+
+	if i.SessionName == "" {
+		i.SessionName = defaultSessionName
+	}
+	if err := validateSessionName(i.SessionName); err != nil {
+		return err
+	}
+	if err := validateOrigin("origin_cluster", i.OriginCluster); err != nil {
+		return err
+	}
+	if err := validateOrigin("origin_mount", i.OriginMount); err != nil {
+		return err
+	}
+
+	i.rootProfile, i.requiredPermissions, err = profilePermissions(strings.TrimSpace(i.RootProfile), i.RequiredPermissions)
 	if err != nil {
-		return dbplugin.InitializeResponse{}, fmt.Errorf("invalid connect_timeout: %w", err)
+		return err
+	}
+	if i.MaxPermissions < 0 {
+		return fmt.Errorf("max_permissions cannot be negative")
+	}
+	if i.MaxPermissionsSize < 0 {
+		return fmt.Errorf("max_permissions_size cannot be negative")
+	}
+	i.forbiddenUnscoped = nil
+	if len(i.ForbiddenUnscopedPermissions) > 0 {
+		i.forbiddenUnscoped, err = parseRequiredPermissions(i.ForbiddenUnscopedPermissions)
+		if err != nil {
+			return fmt.Errorf("invalid forbidden_unscoped_permissions: %w", err)
+		}
+	}
+	i.importAllowed = defaultImportAllowedPermissions
+	if len(i.ImportAllowedPermissions) > 0 {
+		i.importAllowed, err = parseRequiredPermissions(i.ImportAllowedPermissions)
+		if err != nil {
+			return fmt.Errorf("invalid import_allowed_permissions: %w", err)
+		}
 	}
 
 	switch {
-	case len(i.Host) == 0:
-		return dbplugin.InitializeResponse{}, fmt.Errorf("host cannot be empty")
+	case i.URL != "" && (len(i.Host) != 0 || len(i.Endpoints) != 0):
+		return fmt.Errorf("url cannot be combined with host or endpoints")
+	case i.URL == "" && len(i.Host) == 0 && len(i.Endpoints) == 0:
+		return ErrHostEmpty
+	case len(i.Host) != 0 && len(i.Endpoints) != 0:
+		return fmt.Errorf("host and endpoints cannot both be set")
 	case len(i.Token) == 0:
-		return dbplugin.InitializeResponse{}, fmt.Errorf("token cannot be empty")
+		return ErrTokenEmpty
+	}
+	if !i.SkipTokenFormatCheck {
+		if problem := tokenFormatProblem(i.Token); problem != "" {
+			i.logger.Warn("this doesn't look like a valid InfluxDB v2 token, authentication will likely fail", "reason", problem)
+		}
+	}
+
+	switch i.EndpointPolicy {
+	case "":
+		i.EndpointPolicy = endpointPolicyFirstAvailable
+	case endpointPolicyFirstAvailable, endpointPolicyRoundRobin:
+	default:
+		return fmt.Errorf("invalid endpoint_policy %q, expected %q or %q", i.EndpointPolicy, endpointPolicyFirstAvailable, endpointPolicyRoundRobin)
+	}
+	i.serverScheme = ""
+	switch {
+	case i.URL != "":
+		var endpoint string
+		i.serverScheme, endpoint, err = parseServerURL(i.URL, explicitPort)
+		if err != nil {
+			return err
+		}
+		i.endpoints = []string{endpoint}
+	case len(i.Endpoints) > 0:
+		i.endpoints, err = parseEndpoints(i.Endpoints, i.Port)
+		if err != nil {
+			return fmt.Errorf("invalid endpoints: %w", err)
+		}
+	default:
+		endpoint, err := parseHost(i.Host, explicitPort, i.Port)
+		if err != nil {
+			return err
+		}
+		i.endpoints = []string{endpoint}
+	}
+	i.healthyEndpoint = 0
+	i.nextEndpoint = 0
+	i.verifyEndpoint = ""
+	if i.VerifyEndpoint != "" {
+		// Without a port, the verify endpoint uses that of the endpoints.
+		_, port, _ := net.SplitHostPort(i.endpoints[0])
+		i.verifyEndpoint, err = parseHost(i.VerifyEndpoint, "", port)
+		if err != nil {
+			return fmt.Errorf("invalid verify_endpoint: %w", err)
+		}
+	}
+	i.apiPath, err = parseAPIPath(i.APIPath)
+	if err != nil {
+		return err
 	}
 
 	var certBundle *certutil.CertBundle
 	var parsedCertBundle *certutil.ParsedCertBundle
+	i.caChain = nil
 	switch {
+	case len(i.PKIResponse) != 0 && (len(i.PemJSON) != 0 || len(i.PemBundle) != 0):
+		return fmt.Errorf("pki_response cannot be combined with pem_bundle or pem_json")
+
+	case len(i.PKIResponse) != 0:
+		parsedCertBundle, err = parsePKIResponse(i.PKIResponse)
+		if err != nil {
+			return err
+		}
+		certBundle, err = parsedCertBundle.ToCertBundle()
+		if err != nil {
+			return fmt.Errorf("Error marshaling PEM information: %w", err)
+		}
+		i.certificate = certBundle.Certificate
+		i.privateKey = certBundle.PrivateKey
+		i.issuingCA = certBundle.IssuingCA
+		i.caChain = certBundle.CAChain
+		i.TLS = true
+
 	case len(i.PemJSON) != 0:
 		parsedCertBundle, err = certutil.ParsePKIJSON([]byte(i.PemJSON))
 		if err != nil {
-			return dbplugin.InitializeResponse{}, fmt.Errorf("could not parse given JSON; it must be in the format of the output of the PKI backend certificate issuing command: %w", err)
+			return fmt.Errorf("could not parse given JSON; it must be in the format of the output of the PKI backend certificate issuing command: %w", err)
 		}
 		certBundle, err = parsedCertBundle.ToCertBundle()
 		if err != nil {
-			return dbplugin.InitializeResponse{}, fmt.Errorf("Error marshaling PEM information: %w", err)
+			return fmt.Errorf("Error marshaling PEM information: %w", err)
 		}
 		i.certificate = certBundle.Certificate
 		i.privateKey = certBundle.PrivateKey
@@ -93,11 +839,11 @@ func (i *influxdbConnectionProducer) Initialize(ctx context.Context, req dbplugi
 	case len(i.PemBundle) != 0:
 		parsedCertBundle, err = certutil.ParsePEMBundle(i.PemBundle)
 		if err != nil {
-			return dbplugin.InitializeResponse{}, fmt.Errorf("Error parsing the given PEM information: %w", err)
+			return fmt.Errorf("Error parsing the given PEM information: %w", err)
 		}
 		certBundle, err = parsedCertBundle.ToCertBundle()
 		if err != nil {
-			return dbplugin.InitializeResponse{}, fmt.Errorf("Error marshaling PEM information: %w", err)
+			return fmt.Errorf("Error marshaling PEM information: %w", err)
 		}
 		i.certificate = certBundle.Certificate
 		i.privateKey = certBundle.PrivateKey
@@ -105,40 +851,185 @@ func (i *influxdbConnectionProducer) Initialize(ctx context.Context, req dbplugi
 		i.TLS = true
 	}
 
-	// Set initialized to true at this point since all fields are set,
-	// and the connection can be established at a later time.
-	i.Initialized = true
+	i.certFiles = nil
+	if i.TLSCertFile != "" || i.TLSKeyFile != "" || i.TLSCAFile != "" {
+		if len(i.PemJSON) != 0 || len(i.PemBundle) != 0 || len(i.PKIResponse) != 0 {
+			return fmt.Errorf("tls_cert_file, tls_key_file and tls_ca_file cannot be combined with pem_bundle, pem_json or pki_response")
+		}
+		i.certFiles, err = newFileCertificates(i.TLSCertFile, i.TLSKeyFile, i.TLSCAFile)
+		if err != nil {
+			return err
+		}
+		i.TLS = true
+	}
 
-	if req.VerifyConnection {
-		if _, err := i.Connection(ctx); err != nil {
-			return dbplugin.InitializeResponse{}, fmt.Errorf("error verifying connection: %w", err)
+	// The certificate options above may have enabled TLS, so the scheme is
+	// only derived from it now.
+	switch {
+	case i.serverScheme != "":
+	case i.Scheme != "":
+		i.serverScheme, err = parseScheme(i.Scheme)
+		if err != nil {
+			return err
+		}
+	case i.TLS:
+		i.serverScheme = schemeHTTPS
+	default:
+		i.serverScheme = schemeHTTP
+	}
+	if i.TLS && i.serverScheme == schemeHTTP {
+		i.logger.Warn("tls is set but the scheme is http, the TLS settings are ignored")
+	}
+	// require_tls applies to the resolved scheme, whichever of url, scheme
+	// and tls decided it.
+	if i.RequireTLS {
+		if i.serverScheme != schemeHTTPS {
+			return fmt.Errorf("require_tls is set but the connection would use %s://: use an https url, scheme https, or tls", i.serverScheme)
+		}
+		if i.InsecureTLS {
+			return fmt.Errorf("require_tls is set, so insecure_tls cannot be")
 		}
 	}
 
-	resp := dbplugin.InitializeResponse{
-		Config: req.Config,
+	i.certConstraints = certificateConstraints{maxChainDepth: i.MaxChainDepth}
+	i.certConstraints.extKeyUsages, err = parseExtKeyUsages(i.RequireServerEKU)
+	if err != nil {
+		return fmt.Errorf("invalid require_server_eku: %w", err)
+	}
+	if i.MaxChainDepth < 0 {
+		return fmt.Errorf("max_chain_depth cannot be negative")
+	}
+	if i.InsecureTLS && i.certConstraints.enabled() {
+		return fmt.Errorf("require_server_eku and max_chain_depth cannot be combined with insecure_tls, which skips the verification they add to")
 	}
 
-	return resp, nil
+	// insecure_tls disables verification altogether, so a CA provided along
+	// with it is never consulted.
+	if i.InsecureTLS && (bundleHasCA(parsedCertBundle) || i.TLSCAFile != "") {
+		source := i.certificateSource()
+		if i.TLSCAFile != "" {
+			source = "tls_ca_file"
+		}
+		if i.StrictTLS {
+			return fmt.Errorf("insecure_tls cannot be combined with a CA certificate in %s when strict_tls is set", source)
+		}
+		i.logger.Warn("insecure_tls is set: the CA certificate is ignored and the server certificate will NOT be verified", "ca_source", source)
+	}
+
+	return nil
+}
+
+// verifyConnection connects to the server and checks everything
+// verify_connection covers. The connection is kept as the cached client.
+func (i *influxdbConnectionProducer) verifyConnection(ctx context.Context) error {
+	conn, err := i.connection(true)
+	if err != nil {
+		return err
+	}
+	cli := conn.(influxdb2.Client)
+	probe, release, err := i.probeClient(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	if i.verifyEndpoint != "" {
+		if err := i.ping(ctx, probe); err != nil {
+			return withKind(ErrUnreachable, fmt.Errorf("verify_endpoint %s is unreachable: %w", i.verifyEndpoint, err))
+		}
+	}
+	health, err := healthStatus(ctx, probe)
+	if err != nil {
+		return err
+	}
+	if health.Condition != ProbeHealthy {
+		return fmt.Errorf("server is unhealthy: %s", health.Message)
+	}
+	i.setServerVersion(health.Version)
+	if i.AutoCreateOrg {
+		if _, err := i.ensureOrganization(ctx, cli); err != nil {
+			return err
+		}
+	}
+	if i.Organization == "" && i.OrganizationID == "" {
+		if _, err := i.resolveDefaultOrganization(ctx, cli); err != nil {
+			return err
+		}
+	}
+	if i.OrganizationID != "" {
+		if _, err := i.resolveDefaultOrganization(ctx, cli); err != nil {
+			if errors.Is(err, ErrOrganizationNotFound) {
+				err = fmt.Errorf("no organization with organization_id %q exists on the server: %w", i.OrganizationID, err)
+			}
+			return err
+		}
+	}
+	if i.ResolutionOrganization != "" {
+		if _, err := i.resolveOrganization(ctx, cli, i.ResolutionOrganization); err != nil {
+			return fmt.Errorf("invalid resolution_organization: %w", err)
+		}
+	}
+	if err := i.checkDefaultBucketAmbiguity(ctx, cli); err != nil {
+		return err
+	}
+	if i.VerifyWriteCapability {
+		if err := i.verifyWriteCapability(ctx, cli); err != nil {
+			return err
+		}
+	}
+	// Best effort: EnforceExpiry retries if this fails.
+	if err := i.loadExpirySchedule(ctx, cli); err != nil {
+		i.logger.Warn("unable to rebuild the expiry schedule", "error", err)
+	}
+	// Best effort too, this picks up the retirements of a previous process.
+	if err := i.revokeRetiredRootTokens(ctx, cli); err != nil {
+		i.logger.Warn("unable to revoke retired root tokens", "error", err)
+	}
+	return nil
+}
+
+// bundleHasCA reports whether the parsed pem_bundle or pem_json contains a CA
+// certificate.
+func bundleHasCA(bundle *certutil.ParsedCertBundle) bool {
+	if bundle == nil {
+		return false
+	}
+	return len(bundle.CAChain) > 0 || (bundle.Certificate != nil && bundle.Certificate.IsCA)
 }
 
 func (i *influxdbConnectionProducer) Connection(_ context.Context) (interface{}, error) {
+	return i.connection(!i.LazyConnect)
+}
+
+// connection returns the cached client, creating it if needed. When validate
+// is false the client is built without checking that the server is reachable
+// or that the token holds the required permissions.
+func (i *influxdbConnectionProducer) connection(validate bool) (interface{}, error) {
 	if !i.Initialized {
-		return nil, connutil.ErrNotInitialized
+		return nil, ErrNotInitialized
 	}
 
-	// If we already have a DB, return it
+	// If we already have a DB, return it, unless it has outlived
+	// max_connection_lifetime or the CA file has changed since
 	if i.client != nil {
-		return i.client, nil
+		expired := i.maxConnectionLifetime > 0 && time.Since(i.clientCreated) >= i.maxConnectionLifetime
+		if !expired && (i.certFiles == nil || !i.certFiles.caChanged()) {
+			return i.client, nil
+		}
+		if err := closeClient(i.client); err != nil {
+			i.logger.Warn("failed to close expired connection", "error", err)
+		}
+		i.client = nil
 	}
 
-	cli, err := i.createClient()
+	cli, err := i.createClient(i.Token, validate)
 	if err != nil {
 		return nil, err
 	}
 
 	//  Store the session in backend for reuse
 	i.client = cli
+	i.clientCreated = time.Now()
+	promClientsCreated.Inc()
 
 	return cli, nil
 }
@@ -148,123 +1039,399 @@ func (i *influxdbConnectionProducer) Close() error {
 	i.Lock()
 	defer i.Unlock()
 
+	var err error
 	if i.client != nil {
-		i.client.Close()
+		err = closeClient(i.client)
 	}
 
+	// The producer stays usable even if closing failed.
 	i.client = nil
+	i.resetCaches()
+	i.retirement.stop()
+	i.stopServingMetrics()
 
+	if err != nil {
+		return fmt.Errorf("failed to close connection: %w", err)
+	}
 	return nil
 }
 
-func (i *influxdbConnectionProducer) createClient() (influxdb2.Client, error) {
-	var cli influxdb2.Client
-	if i.TLS {
-		tlsConfig := &tls.Config{}
-		if len(i.certificate) > 0 || len(i.issuingCA) > 0 {
-			if len(i.certificate) > 0 && len(i.privateKey) == 0 {
-				return nil, fmt.Errorf("found certificate for TLS authentication but no private key")
-			}
+// closeClient closes cli and releases its idle connections, which the client
+// doesn't do for a transport it didn't create itself. The client's Close
+// doesn't return errors, so a panic while closing is reported as one instead
+// of taking the plugin down.
+func closeClient(cli influxdb2.Client) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic while closing client: %v", r)
+		}
+	}()
+	cli.Close()
+	cli.Options().HTTPClient().CloseIdleConnections()
+	return nil
+}
 
-			certBundle := &certutil.CertBundle{}
-			if len(i.certificate) > 0 {
-				certBundle.Certificate = i.certificate
-				certBundle.PrivateKey = i.privateKey
-			}
-			if len(i.issuingCA) > 0 {
-				certBundle.IssuingCA = i.issuingCA
-			}
+// resetCaches drops everything cached about the server.
+func (i *influxdbConnectionProducer) resetCaches() {
+	i.orgAccess = nil
+	i.orgCache = nil
+	i.bucketCache = nil
+	i.bucketListings = nil
+	i.orgByID = nil
+}
 
-			parsedCertBundle, err := certBundle.ToParsedCertBundle()
-			if err != nil {
-				return nil, fmt.Errorf("failed to parse certificate bundle: %w", err)
-			}
+// prewarm populates the caches used by credential operations. It is best
+// effort: failures are logged and left for the first real operation to
+// surface.
+func (i *influxdbConnectionProducer) prewarm(ctx context.Context) {
+	conn, err := i.Connection(ctx)
+	if err != nil {
+		i.logger.Warn("prewarm: unable to get connection", "error", err)
+		return
+	}
+	cli := conn.(influxdb2.Client)
 
-			tlsConfig, err = parsedCertBundle.GetTLSConfig(certutil.TLSClient)
-			if err != nil || tlsConfig == nil {
-				return nil, fmt.Errorf("failed to get TLS configuration: tlsConfig:%#v err:%w", tlsConfig, err)
-			}
+	org, err := i.resolveDefaultOrganization(ctx, cli)
+	if err != nil {
+		i.logger.Warn("prewarm: unable to resolve organization", "organization", i.Organization, "organization_id", i.OrganizationID, "error", err)
+		return
+	}
+	if err := i.checkOrgAccess(ctx, cli, *org.Id, org.Name); err != nil {
+		i.logger.Warn("prewarm: access check failed", "organization", org.Name, "error", err)
+	}
+	if i.DefaultBucket != "" {
+		if _, err := i.resolveDefaultBucket(ctx, cli, org); err != nil {
+			i.logger.Warn("prewarm: unable to resolve default bucket", "bucket", i.DefaultBucket, "error", err)
 		}
+	}
+}
 
-		tlsConfig.InsecureSkipVerify = i.InsecureTLS
-
-		if i.TLSMinVersion != "" {
-			var ok bool
-			tlsConfig.MinVersion, ok = tlsutil.TLSLookup[i.TLSMinVersion]
-			if !ok {
-				return nil, fmt.Errorf("invalid 'tls_min_version' in config")
-			}
-		} else {
-			// MinVersion was not being set earlier. Reset it to
-			// zero to gracefully handle upgrades.
-			tlsConfig.MinVersion = 0
-		}
+// createClient connects to the first available endpoint using the given token
+// and validates the token's permissions. When validate is false, neither check
+// is made and the client targets the first endpoint in policy order.
+func (i *influxdbConnectionProducer) createClient(token string, validate bool) (influxdb2.Client, error) {
+	options, err := i.clientOptions()
+	if err != nil {
+		return nil, err
+	}
 
-		options := influxdb2.Options{}
-		options.SetTLSConfig(tlsConfig)
+	if !validate {
+		idx := i.endpointOrder()[0]
+		return newInfluxClient(i.scheme()+"://"+i.endpoints[idx], token, options), nil
+	}
 
-		cli = influxdb2.NewClientWithOptions(fmt.Sprintf("http://%s:%s", i.Host, i.Port), i.Token, &options)
-	} else {
-		cli = influxdb2.NewClient(fmt.Sprintf("http://%s:%s", i.Host, i.Port), i.Token)
+	// Checking server status, trying each endpoint in turn
+	var cli influxdb2.Client
+	var pingErrs *multierror.Error
+	for _, idx := range i.endpointOrder() {
+		c := newInfluxClient(i.scheme()+"://"+i.endpoints[idx], token, options)
+		err = i.ping(context.Background(), c)
+		if err == nil && i.RequireHealthy {
+			err = requireHealthy(context.Background(), c)
+		}
+		if err == nil {
+			i.healthyEndpoint = idx
+			cli = c
+			break
+		}
+		recordConnectionError(err)
+		if closeErr := closeClient(c); closeErr != nil {
+			i.logger.Warn("failed to close connection to unreachable endpoint", "error", closeErr)
+		}
+		pingErrs = multierror.Append(pingErrs, fmt.Errorf("endpoint %s: %w", i.endpoints[idx], err))
+	}
+	if cli == nil {
+		if len(i.endpoints) == 1 {
+			return nil, withKind(ErrUnreachable, fmt.Errorf("error checking cluster status: %w", err))
+		}
+		return nil, withKind(ErrUnreachable, fmt.Errorf("error checking cluster status: %w", pingErrs))
 	}
 
-	// Checking server status
-	_, err := cli.Ping(context.Background())
-	if err != nil {
-		return nil, fmt.Errorf("error checking cluster status: %w", err)
+	if i.SkipAccessCheck || i.Cloud {
+		return cli, nil
 	}
 
 	// verifying infos about the connection
-	isSufficientAccess, err := isTokenSufficientAccess(context.Background(), cli, i.Token)
+	permissions, err := checkTokenAccess(context.Background(), cli, token, i.requiredPermissions, i.maxAuthorizationPages())
 	if err != nil {
+		recordConnectionError(err)
+		if closeErr := closeClient(cli); closeErr != nil {
+			i.logger.Warn("failed to close connection that failed the access check", "error", closeErr)
+		}
 		return nil, fmt.Errorf("error getting if provided username is admin: %w", err)
 	}
-	if !isSufficientAccess {
-		return nil, fmt.Errorf("the provided user is missing permissions on the influxDB server")
-	}
+	i.grantedPermissions, i.accessVerified = permissions, true
 
 	return cli, nil
 }
 
+// ping checks that the server behind cli is reachable, bounding each attempt
+// by ping_timeout.
+func (i *influxdbConnectionProducer) ping(ctx context.Context, cli influxdb2.Client) error {
+	return retry(ctx, func(int) error {
+		pingCtx := ctx
+		if i.pingTimeout > 0 {
+			var cancel context.CancelFunc
+			pingCtx, cancel = context.WithTimeout(ctx, i.pingTimeout)
+			defer cancel()
+		}
+		_, err := cli.Ping(pingCtx)
+		return err
+	})
+}
+
+// requireHealthy fails with ErrUnhealthy, including the reported message, if
+// the server behind cli doesn't report itself healthy.
+func requireHealthy(ctx context.Context, cli influxdb2.Client) error {
+	var health ProbeStatus
+	err := retry(ctx, func(int) error {
+		var err error
+		health, err = healthStatus(ctx, cli)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("unable to check server health: %w", err)
+	}
+	if health.Condition != ProbeHealthy {
+		return withKind(ErrUnhealthy, fmt.Errorf("server is unhealthy: %s", health.Message))
+	}
+	return nil
+}
+
+// clientOptions returns the influx client options for a new client, including
+// an HTTP client on a freshly built transport.
+func (i *influxdbConnectionProducer) clientOptions() (*influxdb2.Options, error) {
+	transport, err := i.newTransport()
+	if err != nil {
+		return nil, err
+	}
+
+	var base http.RoundTripper = &metricsTransport{wrappedTransport: wrappedTransport{transport}}
+	if i.apiPath != defaultAPIPath {
+		base = &apiPathTransport{wrappedTransport: wrappedTransport{base}, path: i.apiPath}
+	}
+	if i.operationSlots != nil {
+		base = &limitTransport{wrappedTransport: wrappedTransport{base}, slots: i.operationSlots}
+	}
+	// Requests wait for the rate limiter before taking a slot, so that a
+	// waiting request doesn't hold one.
+	if i.rateLimiter != nil {
+		base = &rateLimitTransport{wrappedTransport: wrappedTransport{base}, limiter: i.rateLimiter}
+	}
+	client := &http.Client{
+		Timeout: i.effectiveRequestTimeout(),
+		Transport: &sessionTransport{
+			wrappedTransport: wrappedTransport{base},
+			name:             i.SessionName,
+		},
+	}
+	if !i.FollowRedirects {
+		client.CheckRedirect = rejectRedirect
+	}
+	options := influxdb2.DefaultOptions()
+	options.SetHTTPClient(client)
+	if i.ClientRetryExponentialBase != nil {
+		options.SetExponentialBase(uint(*i.ClientRetryExponentialBase))
+	}
+	if i.ClientMaxRetries != nil {
+		options.SetMaxRetries(uint(*i.ClientMaxRetries))
+	}
+	if i.clientMaxRetryTime > 0 {
+		options.SetMaxRetryTime(uint(i.clientMaxRetryTime.Milliseconds()))
+	}
+	return options, nil
+}
+
 func (i *influxdbConnectionProducer) secretValues() map[string]string {
+	socks5Password := socks5Password(i.SOCKS5Proxy)
 	return map[string]string{
-		i.Token:     "[token]",
-		i.PemBundle: "[pem_bundle]",
-		i.PemJSON:   "[pem_json]",
+		i.Token:        i.redactionMarker("token", i.Token),
+		i.PemBundle:    i.redactionMarker("pem_bundle", i.PemBundle),
+		i.PemJSON:      i.redactionMarker("pem_json", i.PemJSON),
+		i.PKIResponse:  i.redactionMarker("pki_response", i.PKIResponse),
+		socks5Password: i.redactionMarker("socks5_password", socks5Password),
 	}
 }
 
-func isTokenSufficientAccess(ctx context.Context, cli influxdb2.Client, token string) (bool, error) {
-	authorizations, err := cli.AuthorizationsAPI().GetAuthorizations(ctx)
+// redactConfig returns a copy of config in which every occurrence of a
+// secret in a string value is replaced by its redaction marker.
+func redactConfig(config map[string]interface{}, secrets map[string]string) map[string]interface{} {
+	redacted := make(map[string]interface{}, len(config))
+	for k, v := range config {
+		if str, ok := v.(string); ok {
+			v = redactString(str, secrets)
+		}
+		redacted[k] = v
+	}
+	return redacted
+}
+
+// redactString replaces every occurrence of a secret in s by its redaction
+// marker.
+func redactString(s string, secrets map[string]string) string {
+	for secret, marker := range secrets {
+		if secret != "" {
+			s = strings.ReplaceAll(s, secret, marker)
+		}
+	}
+	return s
+}
+
+// scheme returns the URL scheme used to reach the endpoints.
+func (i *influxdbConnectionProducer) scheme() string {
+	if i.serverScheme == "" {
+		return schemeHTTP
+	}
+	return i.serverScheme
+}
+
+// tokenPermissions returns the permissions of every authorization matching
+// the given token, listing at most maxPages pages of authorizations.
+func tokenPermissions(ctx context.Context, cli influxdb2.Client, token string, maxPages int) ([]domain.Permission, error) {
+	authorizations, err := listAuthorizations(ctx, cli, maxPages)
+	if errors.Is(err, errTooManyAuthorizationPages) {
+		return nil, err
+	}
 	if err != nil {
-		return false, errors.New("cannot access authorizations API to check token")
-	}
-	hasUserRead := false
-	hasUserWrite := false
-	hasOrganizationsRead := false
-	hasOrganizationsWrite := false
-	for _, authorization := range *authorizations {
-		if *authorization.Token == token {
-			for _, permission := range *authorization.Permissions {
-				if permission.Action == "read" && permission.Resource.Type == "users" {
-					hasUserRead = true
-				}
-				if permission.Action == "write" && permission.Resource.Type == "users" {
-					hasUserWrite = true
-				}
-			}
-			for _, permission := range *authorization.Permissions {
-				if permission.Action == "read" && permission.Resource.Type == "orgs" {
-					hasOrganizationsRead = true
-				}
-				if permission.Action == "write" && permission.Resource.Type == "orgs" {
-					hasOrganizationsWrite = true
-				}
+		accessErr := errors.New("cannot access authorizations API to check token")
+		if kind := statusKind(err); kind != nil {
+			return nil, withKind(kind, accessErr)
+		}
+		return nil, accessErr
+	}
+	// Every token can at least see itself when it may read authorizations,
+	// so an empty list means the check can't be made rather than that the
+	// token has no permissions.
+	if len(authorizations) == 0 {
+		return nil, withKind(ErrAuthorizationsNotVisible, errors.New("the token cannot see any authorizations, not even its own, so its permissions cannot be checked; "+
+			"use a token that can read authorizations or set skip_access_check"))
+	}
+	var permissions []domain.Permission
+	for _, authorization := range authorizations {
+		if authorization.Token != nil && *authorization.Token == token && authorization.Permissions != nil {
+			permissions = append(permissions, *authorization.Permissions...)
+		}
+	}
+	return permissions, nil
+}
+
+// requiredPermission is an action on a resource type that the configured
+// token must be granted for the connection to be considered usable.
+type requiredPermission struct {
+	Action       domain.PermissionAction
+	ResourceType domain.ResourceType
+}
+
+func (p requiredPermission) String() string {
+	return fmt.Sprintf("%s:%s", p.ResourceType, p.Action)
+}
+
+// parseRequiredPermissions parses "<resource type>:<action>" entries, e.g.
+// "users:read". Entries may also be given as a single comma-separated string.
+func parseRequiredPermissions(raw []string) ([]requiredPermission, error) {
+	var required []requiredPermission
+	for _, entry := range strutil.ParseStringSlice(strings.Join(raw, ","), ",") {
+		pieces := strings.Split(strings.TrimSpace(entry), ":")
+		if len(pieces) != 2 || pieces[0] == "" {
+			return nil, fmt.Errorf("invalid required permission %q, expected <resource type>:<action>", entry)
+		}
+		action := domain.PermissionAction(pieces[1])
+		if action != domain.PermissionActionRead && action != domain.PermissionActionWrite {
+			return nil, fmt.Errorf("invalid action in required permission %q, expected read or write", entry)
+		}
+		required = append(required, requiredPermission{
+			Action:       action,
+			ResourceType: domain.ResourceType(pieces[0]),
+		})
+	}
+	return required, nil
+}
+
+// missingPermissions returns the required permissions that are not granted.
+func missingPermissions(granted []domain.Permission, required []requiredPermission) []requiredPermission {
+	var missing []requiredPermission
+	for _, req := range required {
+		found := false
+		for _, permission := range granted {
+			if permission.Action == req.Action && permission.Resource.Type == req.ResourceType {
+				found = true
+				break
 			}
 		}
+		if !found {
+			missing = append(missing, req)
+		}
+	}
+	return missing
+}
+
+// checkTokenAccess fails with ErrInsufficientPermissions if token lacks some
+// of the required permissions. It returns the permissions the token holds,
+// which are only meaningful when it doesn't fail.
+func checkTokenAccess(ctx context.Context, cli influxdb2.Client, token string, required []requiredPermission, maxPages int) ([]domain.Permission, error) {
+	permissions, err := tokenPermissions(ctx, cli, token, maxPages)
+	if err != nil {
+		return nil, err
+	}
+	return permissions, checkRequiredPermissions(permissions, required)
+}
+
+// checkRequiredPermissions fails with ErrInsufficientPermissions, naming them,
+// if some of the required permissions are not granted.
+func checkRequiredPermissions(granted []domain.Permission, required []requiredPermission) error {
+	missing := missingPermissions(granted, required)
+	if len(missing) == 0 {
+		return nil
+	}
+	return withKind(ErrInsufficientPermissions, &missingPermissionsError{missing: missing})
+}
+
+// missingPermissionsError names the required permissions a token lacks.
+type missingPermissionsError struct {
+	missing []requiredPermission
+}
+
+func (e *missingPermissionsError) Error() string {
+	return "the provided token is missing required permissions in influxdb: " + e.names()
+}
+
+func (e *missingPermissionsError) names() string {
+	names := make([]string, len(e.missing))
+	for idx, permission := range e.missing {
+		names[idx] = permission.String()
+	}
+	return strings.Join(names, ", ")
+}
+
+// checkOrgAccess verifies that the token can create authorizations in the
+// given organization. The check is evaluated lazily, the first time a
+// credential targets the organization, and successful results are cached
+// until the connection is closed or re-initialized. Failures are not cached so
+// that a permission granted later is picked up without a reload.
+// skip_access_check turns the check off.
+func (i *influxdbConnectionProducer) checkOrgAccess(ctx context.Context, cli influxdb2.Client, orgID, orgName string) error {
+	if _, ok := i.orgAccess[orgID]; ok || i.SkipAccessCheck || i.Cloud {
+		return nil
+	}
+
+	permissions, err := tokenPermissions(ctx, cli, i.Token, i.maxAuthorizationPages())
+	if err != nil {
+		return err
 	}
-	if hasUserRead && hasUserWrite && hasOrganizationsRead && hasOrganizationsWrite {
-		return true, nil
+	for _, permission := range permissions {
+		if permission.Action != domain.PermissionActionWrite || permission.Resource.Type != domain.ResourceTypeAuthorizations {
+			continue
+		}
+		if permission.Resource.Id != nil {
+			continue
+		}
+		if permission.Resource.OrgID == nil || *permission.Resource.OrgID == orgID {
+			if i.orgAccess == nil {
+				i.orgAccess = make(map[string]struct{})
+			}
+			i.orgAccess[orgID] = struct{}{}
+			return nil
+		}
 	}
-	return false, fmt.Errorf("the provided token does not have sufficient permissions in influxdb hasUserRead: %t, hasUserWrite: %t, hasOrganizationsRead: %t, hasOrganizationsWrite: %t", hasUserRead, hasUserWrite, hasOrganizationsRead, hasOrganizationsWrite)
+	return withKind(ErrInsufficientPermissions, fmt.Errorf("the provided token does not have write access to authorizations in organization %q", orgName))
 }
@@ -0,0 +1,621 @@
+package influxdbv2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/database/dbplugin/v5"
+	dbtesting "github.com/hashicorp/vault/sdk/database/dbplugin/v5/testing"
+	"github.com/influxdata/influxdb-client-go/v2"
+	"github.com/influxdata/influxdb-client-go/v2/domain"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMissingPermissions(t *testing.T) {
+	usersRead := permission(domain.PermissionActionRead, domain.ResourceTypeUsers, "")
+	usersWrite := permission(domain.PermissionActionWrite, domain.ResourceTypeUsers, "")
+	orgsRead := permission(domain.PermissionActionRead, domain.ResourceTypeOrgs, "")
+	orgsWrite := permission(domain.PermissionActionWrite, domain.ResourceTypeOrgs, "")
+
+	readOnly, err := parseRequiredPermissions([]string{"users:read", "orgs:read"})
+	require.NoError(t, err)
+
+	type testCase struct {
+		granted  []domain.Permission
+		required []requiredPermission
+		expected []string
+	}
+
+	tests := map[string]testCase{
+		"all granted": {
+			granted:  []domain.Permission{usersRead, usersWrite, orgsRead, orgsWrite},
+			required: rootProfiles[rootProfileUserManager],
+		},
+		"none granted": {
+			required: rootProfiles[rootProfileUserManager],
+			expected: []string{"users:read", "users:write", "orgs:read", "orgs:write"},
+		},
+		"missing one": {
+			granted:  []domain.Permission{usersRead, usersWrite, orgsRead},
+			required: rootProfiles[rootProfileUserManager],
+			expected: []string{"orgs:write"},
+		},
+		"missing writes": {
+			granted:  []domain.Permission{usersRead, orgsRead},
+			required: rootProfiles[rootProfileUserManager],
+			expected: []string{"users:write", "orgs:write"},
+		},
+		"read-only subset satisfied": {
+			granted:  []domain.Permission{usersRead, orgsRead},
+			required: readOnly,
+		},
+		"read-only subset missing one": {
+			granted:  []domain.Permission{usersRead, usersWrite},
+			required: readOnly,
+			expected: []string{"orgs:read"},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			var actual []string
+			for _, p := range missingPermissions(test.granted, test.required) {
+				actual = append(actual, p.String())
+			}
+			require.Equal(t, test.expected, actual)
+		})
+	}
+}
+
+func TestParseRequiredPermissions(t *testing.T) {
+	required, err := parseRequiredPermissions([]string{"users:read, orgs:read"})
+	require.NoError(t, err)
+	require.Equal(t, []requiredPermission{
+		{Action: domain.PermissionActionRead, ResourceType: domain.ResourceTypeUsers},
+		{Action: domain.PermissionActionRead, ResourceType: domain.ResourceTypeOrgs},
+	}, required)
+
+	for _, invalid := range []string{"users", "users:delete", ":read", "users:read:write"} {
+		_, err := parseRequiredPermissions([]string{invalid})
+		require.Error(t, err, invalid)
+	}
+}
+
+func TestInitialize_RequiredPermissions(t *testing.T) {
+	const token = "read-only"
+	srv := newFakeInfluxServer(t, token)
+	srv.setPermissions(token,
+		permission(domain.PermissionActionRead, domain.ResourceTypeUsers, ""),
+		permission(domain.PermissionActionRead, domain.ResourceTypeOrgs, ""),
+		permission(domain.PermissionActionRead, domain.ResourceTypeAuthorizations, ""),
+	)
+
+	db := new()
+	_, err := db.Initialize(context.Background(), dbplugin.InitializeRequest{
+		Config:           makeConfig(srv.connectionParams(token), "root_profile", "user-manager"),
+		VerifyConnection: true,
+	})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "missing required permissions in influxdb: users:write, orgs:write")
+
+	db = new()
+	_, err = db.Initialize(context.Background(), dbplugin.InitializeRequest{
+		Config:           makeConfig(srv.connectionParams(token), "required_permissions", "users:read,orgs:read"),
+		VerifyConnection: true,
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+}
+
+func TestInitialize_Prewarm(t *testing.T) {
+	const token = "root-token"
+	srv := newFakeInfluxServer(t, token)
+
+	db := new()
+	dbtesting.AssertInitialize(t, db, dbplugin.InitializeRequest{
+		Config: makeConfig(srv.connectionParams(token), "prewarm", true, "default_bucket", "vault"),
+	})
+	defer dbtesting.AssertClose(t, db)
+
+	orgID := srv.orgID("vault")
+	require.Contains(t, db.orgAccess, orgID)
+	require.Contains(t, db.orgCache, "vault")
+	require.Contains(t, db.bucketCache, orgID+"/vault")
+
+	// The first credential doesn't need to resolve anything again.
+	orgCalls := srv.callCount("GET /api/v2/orgs")
+	authCalls := srv.callCount("GET /api/v2/authorizations")
+	newTestCredential(t, db, "test")
+	require.Equal(t, orgCalls, srv.callCount("GET /api/v2/orgs"))
+	require.Equal(t, authCalls, srv.callCount("GET /api/v2/authorizations"))
+}
+
+func TestInitialize_PrewarmFailureIsNotFatal(t *testing.T) {
+	const token = "root-token"
+	srv := newFakeInfluxServer(t, token)
+
+	db := new()
+	dbtesting.AssertInitialize(t, db, dbplugin.InitializeRequest{
+		Config: makeConfig(srv.connectionParams(token), "prewarm", true, "organization", "missing"),
+	})
+	defer dbtesting.AssertClose(t, db)
+	require.Empty(t, db.orgCache)
+
+	// Without prewarm nothing is resolved up front.
+	db = new()
+	dbtesting.AssertInitialize(t, db, dbplugin.InitializeRequest{
+		Config: srv.connectionParams(token),
+	})
+	defer dbtesting.AssertClose(t, db)
+	require.Empty(t, db.orgCache)
+	require.Nil(t, db.client)
+}
+
+func TestInitialize_RawConfigIsRedacted(t *testing.T) {
+	config := map[string]interface{}{
+		"host":         "influx.example.com",
+		"token":        "super-secret-token",
+		"organization": "vault",
+		"port":         8086,
+	}
+
+	db := new()
+	resp, err := db.Initialize(context.Background(), dbplugin.InitializeRequest{Config: config})
+	require.NoError(t, err)
+
+	require.Equal(t, "[token]", db.rawConfig["token"])
+	require.Equal(t, "influx.example.com", db.rawConfig["host"])
+	require.Equal(t, 8086, db.rawConfig["port"])
+	require.NotContains(t, fmt.Sprintf("%v", db.rawConfig), "super-secret-token")
+
+	// The secret is still used for connecting, and Vault still gets the
+	// config it sent back.
+	require.Equal(t, "super-secret-token", db.Token)
+	require.Equal(t, "super-secret-token", resp.Config["token"])
+}
+
+func TestInitialize_ClientRetryOptions(t *testing.T) {
+	const token = "root-token"
+	srv := newFakeInfluxServer(t, token)
+
+	db := new()
+	dbtesting.AssertInitialize(t, db, dbplugin.InitializeRequest{
+		Config: makeConfig(srv.connectionParams(token),
+			"client_retry_exponential_base", 3,
+			"client_max_retries", "0",
+			"client_max_retry_time", "90s",
+		),
+		VerifyConnection: true,
+	})
+	defer dbtesting.AssertClose(t, db)
+
+	cli, err := db.getConnection(context.Background())
+	require.NoError(t, err)
+	require.EqualValues(t, 3, cli.Options().ExponentialBase())
+	require.EqualValues(t, 0, cli.Options().MaxRetries())
+	require.EqualValues(t, 90000, cli.Options().MaxRetryTime())
+
+	// Unset fields keep the client's defaults.
+	other := new()
+	dbtesting.AssertInitialize(t, other, dbplugin.InitializeRequest{
+		Config:           srv.connectionParams(token),
+		VerifyConnection: true,
+	})
+	defer dbtesting.AssertClose(t, other)
+	cli, err = other.getConnection(context.Background())
+	require.NoError(t, err)
+	require.EqualValues(t, 2, cli.Options().ExponentialBase())
+	require.EqualValues(t, 5, cli.Options().MaxRetries())
+
+	for field, value := range map[string]interface{}{
+		"client_retry_exponential_base": 1,
+		"client_max_retries":            -1,
+		"client_max_retry_time":         "0s",
+	} {
+		_, err := new().Initialize(context.Background(), dbplugin.InitializeRequest{
+			Config: makeConfig(srv.connectionParams(token), field, value),
+		})
+		require.Error(t, err, field)
+		require.Contains(t, err.Error(), field)
+	}
+}
+
+func TestInitialize_LazyConnect(t *testing.T) {
+	host, port, err := net.SplitHostPort(unusedEndpoint(t))
+	require.NoError(t, err)
+	config := map[string]interface{}{
+		"host":         host,
+		"port":         port,
+		"token":        "token",
+		"organization": "vault",
+	}
+
+	// By default the unreachable server is reported when connecting.
+	db := new()
+	dbtesting.AssertInitialize(t, db, dbplugin.InitializeRequest{Config: config})
+	_, err = db.Connection(context.Background())
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "error checking cluster status")
+	dbtesting.AssertClose(t, db)
+
+	// Lazily, the client is built and the first operation fails instead.
+	db = new()
+	dbtesting.AssertInitialize(t, db, dbplugin.InitializeRequest{Config: makeConfig(config, "lazy_connect", true)})
+	_, err = db.Connection(context.Background())
+	require.NoError(t, err)
+	_, err = db.DeleteUser(context.Background(), dbplugin.DeleteUserRequest{Username: "missing"})
+	require.Error(t, err)
+	dbtesting.AssertClose(t, db)
+
+	// Verifying the connection still checks it eagerly.
+	_, err = new().Initialize(context.Background(), dbplugin.InitializeRequest{
+		Config:           makeConfig(config, "lazy_connect", true),
+		VerifyConnection: true,
+	})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "error verifying connection")
+}
+
+func TestConnection_NoVisibleAuthorizations(t *testing.T) {
+	const token = "root-token"
+
+	for name, body := range map[string]string{
+		"nil":   `{"authorizations": null}`,
+		"empty": `{"authorizations": []}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			srv := newFakeInfluxServer(t, token)
+			srv.handle("GET /api/v2/authorizations", func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(body))
+			})
+
+			_, err := new().Initialize(context.Background(), dbplugin.InitializeRequest{
+				Config:           srv.connectionParams(token),
+				VerifyConnection: true,
+			})
+			require.Error(t, err)
+			require.True(t, errors.Is(err, ErrAuthorizationsNotVisible), err.Error())
+			require.False(t, errors.Is(err, ErrInsufficientPermissions))
+			require.Contains(t, err.Error(), "skip_access_check")
+
+			db := new()
+			dbtesting.AssertInitialize(t, db, dbplugin.InitializeRequest{
+				Config:           makeConfig(srv.connectionParams(token), "skip_access_check", true),
+				VerifyConnection: true,
+			})
+			defer dbtesting.AssertClose(t, db)
+		})
+	}
+}
+
+func TestConnection_MaxConnectionLifetime(t *testing.T) {
+	const token = "root-token"
+	srv := newFakeInfluxServer(t, token)
+
+	db := new()
+	dbtesting.AssertInitialize(t, db, dbplugin.InitializeRequest{
+		Config:           srv.connectionParams(token),
+		VerifyConnection: true,
+	})
+	defer dbtesting.AssertClose(t, db)
+
+	// Without a limit the client is reused.
+	first, err := db.getConnection(context.Background())
+	require.NoError(t, err)
+	time.Sleep(10 * time.Millisecond)
+	cli, err := db.getConnection(context.Background())
+	require.NoError(t, err)
+	require.Same(t, first, cli)
+
+	db = new()
+	dbtesting.AssertInitialize(t, db, dbplugin.InitializeRequest{
+		Config:           makeConfig(srv.connectionParams(token), "max_connection_lifetime", "5ms"),
+		VerifyConnection: true,
+	})
+	defer dbtesting.AssertClose(t, db)
+
+	first, err = db.getConnection(context.Background())
+	require.NoError(t, err)
+	pings := srv.callCount("GET /ping")
+	time.Sleep(10 * time.Millisecond)
+	cli, err = db.getConnection(context.Background())
+	require.NoError(t, err)
+	require.NotSame(t, first, cli)
+	require.Equal(t, pings+1, srv.callCount("GET /ping"))
+
+	_, err = new().Initialize(context.Background(), dbplugin.InitializeRequest{
+		Config: makeConfig(srv.connectionParams(token), "max_connection_lifetime", "-5s"),
+	})
+	require.Error(t, err)
+}
+
+// failingCloseClient is a client whose Close fails.
+type failingCloseClient struct {
+	influxdb2.Client
+}
+
+func (failingCloseClient) Close() {
+	panic("close failed")
+}
+
+func TestClose_Failure(t *testing.T) {
+	const token = "root-token"
+	srv := newFakeInfluxServer(t, token)
+
+	db := new()
+	dbtesting.AssertInitialize(t, db, dbplugin.InitializeRequest{
+		Config: srv.connectionParams(token),
+	})
+	db.client = failingCloseClient{}
+	db.orgCache = map[string]*domain.Organization{"vault": {}}
+
+	err := db.Close()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "close failed")
+	require.Nil(t, db.client)
+	require.Nil(t, db.orgCache)
+
+	// The producer is still usable.
+	_, err = db.getConnection(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+}
+
+// closeRecordingClient records whether Close was called.
+type closeRecordingClient struct {
+	influxdb2.Client
+	closed *int
+}
+
+func (c closeRecordingClient) Close() {
+	*c.closed++
+	c.Client.Close()
+}
+
+func TestInitialize_FailedVerificationClosesClient(t *testing.T) {
+	const token = "root-token"
+
+	type testCase struct {
+		setup  func(srv *fakeInfluxServer)
+		config []interface{}
+	}
+
+	tests := map[string]testCase{
+		"unhealthy": {
+			setup: func(srv *fakeInfluxServer) {
+				srv.handle("GET /health", func(w http.ResponseWriter, r *http.Request) {
+					writeJSON(w, http.StatusServiceUnavailable, map[string]interface{}{"name": "influxdb", "status": "fail", "message": "down"})
+				})
+			},
+		},
+		"missing permissions": {
+			setup: func(srv *fakeInfluxServer) {
+				srv.setPermissions(token)
+			},
+		},
+		"unknown organization_id": {
+			config: []interface{}{"organization", "", "organization_id", "0123456789abcdef"},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			srv := newFakeInfluxServer(t, token)
+			if test.setup != nil {
+				test.setup(srv)
+			}
+
+			var created, closed int
+			newInfluxClient = func(serverURL, authToken string, options *influxdb2.Options) influxdb2.Client {
+				created++
+				return closeRecordingClient{Client: influxdb2.NewClientWithOptions(serverURL, authToken, options), closed: &closed}
+			}
+			defer func() { newInfluxClient = influxdb2.NewClientWithOptions }()
+
+			db := new()
+			_, err := db.Initialize(context.Background(), dbplugin.InitializeRequest{
+				Config:           makeConfig(srv.connectionParams(token), test.config...),
+				VerifyConnection: true,
+			})
+			require.Error(t, err)
+			require.Equal(t, 1, created)
+			require.Equal(t, 1, closed)
+			require.Nil(t, db.client)
+		})
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	base := map[string]interface{}{
+		"host":  "influx.example.com",
+		"token": "root-token",
+	}
+
+	type testCase struct {
+		config    map[string]interface{}
+		expectErr string
+	}
+
+	tests := map[string]testCase{
+		"host": {
+			config: base,
+		},
+		"url": {
+			config: map[string]interface{}{"url": "https://influx.example.com/", "token": "root-token"},
+		},
+		"endpoints": {
+			config: map[string]interface{}{"endpoints": []string{"a.example.com", "b.example.com:9999"}, "token": "root-token", "endpoint_policy": "round_robin"},
+		},
+		"everything": {
+			config: makeConfig(base,
+				"port", "8443",
+				"tls", true,
+				"tls_min_version", "tls12",
+				"organization_id", "0123456789abcdef",
+				"connect_timeout", "2s",
+				"request_timeout", "30s",
+				"ping_timeout", "1s",
+				"expiry_skew", "1m",
+				"max_connection_lifetime", "1h",
+				"socks5_proxy", "proxy.example.com:1080",
+				"required_permissions", []string{"users:read", "orgs:read"},
+				"redaction_marker", "hash",
+			),
+		},
+		"no host": {
+			config:    map[string]interface{}{"token": "root-token"},
+			expectErr: ErrHostEmpty.Error(),
+		},
+		"no token": {
+			config:    map[string]interface{}{"host": "influx.example.com"},
+			expectErr: ErrTokenEmpty.Error(),
+		},
+		"url and host": {
+			config:    makeConfig(base, "url", "http://influx.example.com"),
+			expectErr: "url cannot be combined with host or endpoints",
+		},
+		"host and endpoints": {
+			config:    makeConfig(base, "endpoints", []string{"b.example.com"}),
+			expectErr: "host and endpoints cannot both be set",
+		},
+		"invalid url": {
+			config:    map[string]interface{}{"url": "ftp://influx.example.com", "token": "root-token"},
+			expectErr: "invalid url",
+		},
+		"invalid scheme": {
+			config:    makeConfig(base, "scheme", "gopher"),
+			expectErr: "invalid scheme",
+		},
+		"invalid endpoint_policy": {
+			config:    makeConfig(base, "endpoint_policy", "random"),
+			expectErr: "invalid endpoint_policy",
+		},
+		"invalid connect_timeout": {
+			config:    makeConfig(base, "connect_timeout", "soon"),
+			expectErr: "invalid connect_timeout",
+		},
+		"negative request_timeout": {
+			config:    makeConfig(base, "request_timeout", "-1s"),
+			expectErr: "request_timeout cannot be negative",
+		},
+		"strict timeouts": {
+			config:    makeConfig(base, "ping_timeout", "10s", "request_timeout", "5s", "strict_timeouts", true),
+			expectErr: "inconsistent timeouts",
+		},
+		"pki_response with pem_bundle": {
+			config:    makeConfig(base, "pki_response", "{}", "pem_bundle", "bundle"),
+			expectErr: "pki_response cannot be combined with pem_bundle or pem_json",
+		},
+		"tls_cert_file without tls_key_file": {
+			config:    makeConfig(base, "tls_cert_file", "/nonexistent/cert.pem"),
+			expectErr: "must be set together",
+		},
+		"http_proxy with socks5_proxy": {
+			config:    makeConfig(base, "http_proxy", "http://proxy.example.com:3128", "socks5_proxy", "proxy.example.com:1080"),
+			expectErr: "http_proxy and socks5_proxy cannot both be set",
+		},
+		"organization with organization_id": {
+			config:    makeConfig(base, "organization", "vault", "organization_id", "0123456789abcdef"),
+			expectErr: "cannot both be set",
+		},
+		"invalid organization_id": {
+			config:    makeConfig(base, "organization_id", "vault"),
+			expectErr: "invalid organization_id",
+		},
+		"verify_write_capability without default_bucket": {
+			config:    makeConfig(base, "verify_write_capability", true),
+			expectErr: "verify_write_capability requires default_bucket",
+		},
+		"invalid session_name": {
+			config:    makeConfig(base, "session_name", "not valid"),
+			expectErr: "session_name",
+		},
+		"invalid required_permissions": {
+			config:    makeConfig(base, "required_permissions", []string{"users"}),
+			expectErr: "invalid required_permissions",
+		},
+		"invalid redaction_marker": {
+			config:    makeConfig(base, "redaction_marker", "last4"),
+			expectErr: "invalid redaction_marker",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := ValidateConfig(test.config)
+
+			// Initialize without verification reaches the same verdict.
+			db := new()
+			_, initErr := db.Initialize(context.Background(), dbplugin.InitializeRequest{Config: test.config})
+
+			if test.expectErr != "" {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), test.expectErr)
+				require.EqualError(t, initErr, err.Error())
+				require.False(t, db.Initialized)
+				return
+			}
+			require.NoError(t, err)
+			require.NoError(t, initErr)
+			require.True(t, db.Initialized)
+			dbtesting.AssertClose(t, db)
+		})
+	}
+}
+
+func TestInitialize_ReusesConnection(t *testing.T) {
+	const token = "root-token"
+	srv := newFakeInfluxServer(t, token)
+	srv.addBucket(srv.orgID("vault"), "telegraf")
+	other := srv.addOrg("other")
+
+	db := new()
+	initialize := func(kv ...interface{}) {
+		t.Helper()
+		dbtesting.AssertInitialize(t, db, dbplugin.InitializeRequest{
+			Config:           makeConfig(srv.connectionParams(token), kv...),
+			VerifyConnection: true,
+		})
+	}
+	defer dbtesting.AssertClose(t, db)
+
+	initialize("default_bucket", "vault")
+	cli := db.client
+	pings := srv.callCount("GET /ping")
+	capabilities, err := db.Capabilities()
+	require.NoError(t, err)
+	require.True(t, capabilities.Verified)
+
+	// Only non-connection fields change: the client and what the access
+	// check found are kept, and the server isn't pinged again.
+	initialize("default_bucket", "telegraf", "session_name", "other", "expiry_skew", "10s")
+	require.Same(t, cli, db.client)
+	require.Equal(t, pings, srv.callCount("GET /ping"))
+	require.Equal(t, "telegraf", db.DefaultBucket)
+	capabilities, err = db.Capabilities()
+	require.NoError(t, err)
+	require.True(t, capabilities.Verified)
+
+	initialize("organization", "", "organization_id", other)
+	require.Same(t, cli, db.client)
+	require.Equal(t, pings, srv.callCount("GET /ping"))
+	require.Equal(t, other, *db.orgByID.Id)
+
+	// A connection-relevant field changes: the client is rebuilt.
+	initialize("organization", "", "organization_id", other, "request_timeout", "30s")
+	require.NotSame(t, cli, db.client)
+	require.Greater(t, srv.callCount("GET /ping"), pings)
+	cli, pings = db.client, srv.callCount("GET /ping")
+
+	// So is it after Close.
+	require.NoError(t, db.Close())
+	initialize("organization", "", "organization_id", other, "request_timeout", "30s")
+	require.NotSame(t, cli, db.client)
+	require.Greater(t, srv.callCount("GET /ping"), pings)
+}
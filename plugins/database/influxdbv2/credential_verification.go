@@ -0,0 +1,86 @@
+package influxdbv2
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/influxdata/influxdb-client-go/v2"
+	"github.com/influxdata/influxdb-client-go/v2/domain"
+)
+
+// verifyCredential proves that the token of a newly created authorization is
+// usable by performing, as the token, a read its permissions allow, through a
+// throwaway client to the endpoint cli is connected to. A token that only
+// holds write permissions reads its own user instead, which any valid token
+// may.
+func (i *influxdbConnectionProducer) verifyCredential(ctx context.Context, cli influxdb2.Client, created *domain.Authorization) error {
+	options, err := i.clientOptions()
+	if err != nil {
+		return err
+	}
+	probe := newInfluxClient(cli.ServerURL(), stringValue(created.Token), options)
+	defer func() {
+		if err := closeClient(probe); err != nil {
+			i.logger.Warn("failed to close the connection used to verify a credential", "error", err)
+		}
+	}()
+
+	var permissions []domain.Permission
+	if created.Permissions != nil {
+		permissions = *created.Permissions
+	}
+	read := credentialRead(probe, permissions)
+	err = retry(ctx, func(int) error {
+		return read(ctx)
+	})
+	if err != nil {
+		return withKind(ErrCredentialUnusable, fmt.Errorf("the created token failed verification: %w", classify(err)))
+	}
+	return nil
+}
+
+// credentialRead returns a read allowed by permissions, preferring those
+// scoped to a single resource.
+func credentialRead(cli influxdb2.Client, permissions []domain.Permission) func(context.Context) error {
+	for _, permission := range permissions {
+		if permission.Action != domain.PermissionActionRead {
+			continue
+		}
+		resource := permission.Resource
+		switch resource.Type {
+		case domain.ResourceTypeBuckets:
+			if resource.Id != nil {
+				return func(ctx context.Context) error {
+					_, err := cli.BucketsAPI().FindBucketByID(ctx, *resource.Id)
+					return err
+				}
+			}
+			if resource.OrgID != nil {
+				return func(ctx context.Context) error {
+					_, err := cli.BucketsAPI().FindBucketsByOrgID(ctx, *resource.OrgID)
+					return err
+				}
+			}
+		case domain.ResourceTypeOrgs:
+			id := resource.Id
+			if id == nil {
+				id = resource.OrgID
+			}
+			if id != nil {
+				return func(ctx context.Context) error {
+					_, err := cli.OrganizationsAPI().FindOrganizationByID(ctx, *id)
+					return err
+				}
+			}
+		case domain.ResourceTypeAuthorizations:
+			return func(ctx context.Context) error {
+				_, err := cli.AuthorizationsAPI().GetAuthorizations(ctx)
+				return err
+			}
+		}
+	}
+	return func(ctx context.Context) error {
+		_, err := cli.UsersAPI().Me(ctx)
+		return err
+	}
+}
@@ -0,0 +1,94 @@
+package influxdbv2
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/database/dbplugin/v5"
+	dbtesting "github.com/hashicorp/vault/sdk/database/dbplugin/v5/testing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInfluxdb_NewUser_VerifyCreatedCredential(t *testing.T) {
+	const token = "root-token"
+	srv := newFakeInfluxServer(t, token)
+	srv.addBucket(srv.orgID("vault"), "telegraf")
+
+	db := new()
+	dbtesting.AssertInitialize(t, db, dbplugin.InitializeRequest{
+		Config: makeConfig(srv.connectionParams(token), "verify_created_credential", true),
+	})
+	defer dbtesting.AssertClose(t, db)
+
+	newUser := func(command string) (dbplugin.NewUserResponse, error) {
+		return db.NewUser(context.Background(), dbplugin.NewUserRequest{
+			UsernameConfig: dbplugin.UsernameMetadata{
+				DisplayName: "test",
+				RoleName:    "test",
+			},
+			Statements: dbplugin.Statements{
+				Commands: []string{command},
+			},
+			Password:   "nuozxby98523u89bdfnkjl",
+			Expiration: time.Now().Add(time.Hour),
+		})
+	}
+
+	tests := map[string]struct {
+		command string
+		read    string
+	}{
+		"bucket":        {`{"preset": "read", "bucket": "telegraf"}`, "GET /api/v2/buckets/{id}"},
+		"organization":  {`{"permissions": [{"action": "read", "resource": {"type": "buckets"}}]}`, "GET /api/v2/buckets"},
+		"write only":    {`{"preset": "write", "bucket": "telegraf"}`, "GET /api/v2/me"},
+		"organizations": {`{"permissions": [{"action": "read", "resource": {"type": "orgs"}}]}`, "GET /api/v2/orgs/{id}"},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			var readAs []string
+			srv.handle(test.read, func(w http.ResponseWriter, r *http.Request) {
+				srv.Lock()
+				readAs = append(readAs, strings.TrimPrefix(r.Header.Get("Authorization"), "Token "))
+				srv.Unlock()
+				srv.serveDefault(w, r, test.read, r.URL.Path, strings.Split(strings.TrimPrefix(r.URL.Path, "/api/v2/"), "/"))
+			})
+			defer srv.unhandle(test.read)
+
+			resp, err := newUser(test.command)
+			require.NoError(t, err)
+			authorizations := srv.userAuthorizations(resp.Username)
+			require.Len(t, authorizations, 1)
+			srv.Lock()
+			defer srv.Unlock()
+			require.Contains(t, readAs, *authorizations[0].Token)
+		})
+	}
+
+	t.Run("unusable credential is revoked", func(t *testing.T) {
+		srv.handle("GET /api/v2/buckets/{id}", func(w http.ResponseWriter, r *http.Request) {
+			writeError(w, http.StatusUnauthorized, "unauthorized", "unauthorized access")
+		})
+		defer srv.unhandle("GET /api/v2/buckets/{id}")
+
+		srv.Lock()
+		users, authorizations := len(srv.users), len(srv.authorizations)
+		srv.Unlock()
+		_, err := newUser(`{"preset": "read", "bucket": "telegraf"}`)
+		require.Error(t, err)
+		require.True(t, errors.Is(err, ErrCredentialUnusable), err.Error())
+		require.True(t, errors.Is(err, ErrAuthFailed), err.Error())
+
+		srv.Lock()
+		require.Len(t, srv.users, users)
+		require.Len(t, srv.authorizations, authorizations)
+		srv.Unlock()
+		db.Lock()
+		entries := len(db.expiries.entries)
+		db.Unlock()
+		require.Equal(t, len(tests), entries)
+	})
+}
@@ -0,0 +1,207 @@
+package influxdbv2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/influxdata/influxdb-client-go/v2"
+	"github.com/influxdata/influxdb-client-go/v2/domain"
+)
+
+// CredentialPermission is one permission granted to a credential.
+type CredentialPermission struct {
+	Action       string `json:"action"`
+	ResourceType string `json:"resource_type"`
+	ResourceID   string `json:"resource_id,omitempty"`
+	ResourceName string `json:"resource_name,omitempty"`
+	OrgID        string `json:"org_id,omitempty"`
+	Org          string `json:"org,omitempty"`
+}
+
+// CredentialAuthorization describes an authorization backing a credential.
+type CredentialAuthorization struct {
+	ID          string                 `json:"id"`
+	Username    string                 `json:"username,omitempty"`
+	Role        string                 `json:"role,omitempty"`
+	OrgID       string                 `json:"org_id,omitempty"`
+	Status      string                 `json:"status,omitempty"`
+	Permissions []CredentialPermission `json:"permissions"`
+
+	// Expires is the expiry recorded when the credential was created, if
+	// any. Expired means it has passed by more than expiry_skew.
+	Expires *time.Time `json:"expires,omitempty"`
+	Expired bool       `json:"expired"`
+
+	// Created, Node, Cluster and Mount record when and where the plugin
+	// issued the credential, if it recorded them. OriginTruncated is set
+	// when Node, Cluster or Mount were cut short to fit the description.
+	Created         *time.Time `json:"created,omitempty"`
+	Node            string     `json:"node,omitempty"`
+	Cluster         string     `json:"cluster,omitempty"`
+	Mount           string     `json:"mount,omitempty"`
+	OriginTruncated bool       `json:"origin_truncated,omitempty"`
+}
+
+// CredentialPermissions returns the authorizations, and so the effective
+// permissions, of a credential identified either by its username or by the
+// ID of its authorization. It returns an error matching ErrCredentialNotFound
+// if the credential no longer exists, e.g. because it was revoked. Nothing is
+// modified on the server.
+func (i *InfluxdbV2) CredentialPermissions(ctx context.Context, usernameOrID string) ([]CredentialAuthorization, error) {
+	if usernameOrID == "" {
+		return nil, fmt.Errorf("username or authorization ID cannot be empty")
+	}
+
+	i.Lock()
+	defer i.Unlock()
+
+	if err := i.requireInitialized("CredentialPermissions"); err != nil {
+		return nil, err
+	}
+	cli, err := i.getConnection(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get connection: %w", err)
+	}
+
+	authorizations, err := findCredentialAuthorizations(ctx, cli, usernameOrID, i.maxAuthorizationPages())
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up credential %q: %w", usernameOrID, classify(err))
+	}
+	if len(authorizations) == 0 {
+		return nil, withKind(ErrCredentialNotFound, fmt.Errorf("no credential found for %q, it may have been revoked", usernameOrID))
+	}
+
+	now := time.Now()
+	res := make([]CredentialAuthorization, len(authorizations))
+	for idx, authorization := range authorizations {
+		res[idx] = credentialAuthorization(authorization)
+		if metadata, ok := parseDescription(stringValue(authorization.Description)); ok && !metadata.Expires.IsZero() {
+			expires := metadata.Expires
+			res[idx].Expires = &expires
+			res[idx].Expired = metadata.expiredAt(now, i.expirySkew)
+		}
+	}
+	return res, nil
+}
+
+// findCredentialAuthorizations returns the authorizations of a credential. An
+// ID-shaped argument is first looked up as an authorization ID. Otherwise the
+// plugin's authorizations are matched on the username in their description,
+// falling back to the user's authorizations for credentials created before
+// descriptions carried it.
+func findCredentialAuthorizations(ctx context.Context, cli influxdb2.Client, usernameOrID string, maxPages int) ([]domain.Authorization, error) {
+	if isOrganizationID(usernameOrID) {
+		authorization, err := findAuthorizationByID(ctx, cli, usernameOrID)
+		if err != nil {
+			return nil, err
+		}
+		if authorization != nil {
+			return []domain.Authorization{*authorization}, nil
+		}
+	}
+
+	managed, err := listManagedAuthorizations(ctx, cli, maxPages)
+	if err != nil {
+		return nil, err
+	}
+	var res []domain.Authorization
+	for _, authorization := range managed {
+		if authorization.metadata.Username == usernameOrID {
+			res = append(res, authorization.Authorization)
+		}
+	}
+	if len(res) > 0 {
+		return res, nil
+	}
+
+	user, err := findUserByName(ctx, cli, usernameOrID)
+	if errors.Is(err, ErrCredentialNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var authorizations *[]domain.Authorization
+	err = retry(ctx, func(int) error {
+		var err error
+		authorizations, err = cli.AuthorizationsAPI().FindAuthorizationsByUserID(ctx, *user.Id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return *authorizations, nil
+}
+
+// findAuthorizationByID returns the authorization with the given ID, or nil if
+// there is none.
+func findAuthorizationByID(ctx context.Context, cli influxdb2.Client, id string) (*domain.Authorization, error) {
+	var authorization *domain.Authorization
+	err := retry(ctx, func(int) error {
+		response, err := domain.NewClientWithResponses(cli.HTTPService()).GetAuthorizationsIDWithResponse(ctx, id, &domain.GetAuthorizationsIDParams{})
+		if err != nil {
+			return err
+		}
+		if response.StatusCode() == http.StatusNotFound {
+			authorization = nil
+			return nil
+		}
+		if response.JSONDefault != nil {
+			return domain.ErrorToHTTPError(response.JSONDefault, response.StatusCode())
+		}
+		authorization = response.JSON200
+		return nil
+	})
+	return authorization, err
+}
+
+func credentialAuthorization(authorization domain.Authorization) CredentialAuthorization {
+	res := CredentialAuthorization{
+		ID:          stringValue(authorization.Id),
+		OrgID:       stringValue(authorization.OrgID),
+		Permissions: []CredentialPermission{},
+	}
+	if authorization.Status != nil {
+		res.Status = string(*authorization.Status)
+	}
+	if authorization.Description != nil {
+		if metadata, ok := parseDescription(*authorization.Description); ok {
+			res.Username = metadata.Username
+			res.Role = metadata.Role
+			if !metadata.Created.IsZero() {
+				created := metadata.Created
+				res.Created = &created
+			}
+			res.Node = metadata.Node
+			res.Cluster = metadata.Cluster
+			res.Mount = metadata.Mount
+			res.OriginTruncated = metadata.Truncated
+		}
+	}
+	if res.Username == "" {
+		res.Username = stringValue(authorization.User)
+	}
+	if authorization.Permissions != nil {
+		for _, permission := range *authorization.Permissions {
+			res.Permissions = append(res.Permissions, CredentialPermission{
+				Action:       string(permission.Action),
+				ResourceType: string(permission.Resource.Type),
+				ResourceID:   stringValue(permission.Resource.Id),
+				ResourceName: stringValue(permission.Resource.Name),
+				OrgID:        stringValue(permission.Resource.OrgID),
+				Org:          stringValue(permission.Resource.Org),
+			})
+		}
+	}
+	return res
+}
+
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
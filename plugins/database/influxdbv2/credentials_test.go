@@ -0,0 +1,85 @@
+package influxdbv2
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/database/dbplugin/v5"
+	dbtesting "github.com/hashicorp/vault/sdk/database/dbplugin/v5/testing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInfluxdb_CredentialPermissions(t *testing.T) {
+	const token = "root-token"
+	srv := newFakeInfluxServer(t, token)
+	orgID := srv.orgID("vault")
+	bucketID := srv.addBucket(orgID, "telegraf")
+
+	db := new()
+	dbtesting.AssertInitialize(t, db, dbplugin.InitializeRequest{
+		Config: makeConfig(srv.connectionParams(token), "origin_cluster", "vault-cluster-1", "origin_mount", "database/"),
+	})
+	defer dbtesting.AssertClose(t, db)
+
+	expiration := time.Now().Add(1 * time.Minute)
+	resp := dbtesting.AssertNewUser(t, db, dbplugin.NewUserRequest{
+		UsernameConfig: dbplugin.UsernameMetadata{
+			DisplayName: "test",
+			RoleName:    "test",
+		},
+		Statements: dbplugin.Statements{
+			Commands: []string{`{"permissions": [{"action": "write", "resource": {"type": "buckets", "name": "telegraf"}}]}`},
+		},
+		Password:   "nuozxby98523u89bdfnkjl",
+		Expiration: expiration,
+	})
+	auths := srv.userAuthorizations(resp.Username)
+	require.Len(t, auths, 1)
+
+	expires := time.Unix(expiration.Unix(), 0)
+	expected := []CredentialAuthorization{
+		{
+			ID:       *auths[0].Id,
+			Username: resp.Username,
+			Role:     "test",
+			OrgID:    orgID,
+			Status:   "active",
+			Expires:  &expires,
+			Node:     originNode,
+			Cluster:  "vault-cluster-1",
+			Mount:    "database/",
+			Permissions: []CredentialPermission{
+				{
+					Action:       "write",
+					ResourceType: "buckets",
+					ResourceID:   bucketID,
+					ResourceName: "telegraf",
+					OrgID:        orgID,
+				},
+			},
+		},
+	}
+
+	byUsername, err := db.CredentialPermissions(context.Background(), resp.Username)
+	require.NoError(t, err)
+	require.Len(t, byUsername, 1)
+	require.NotNil(t, byUsername[0].Created)
+	require.WithinDuration(t, time.Now(), *byUsername[0].Created, time.Minute)
+	expected[0].Created = byUsername[0].Created
+	require.Equal(t, expected, byUsername)
+
+	byID, err := db.CredentialPermissions(context.Background(), *auths[0].Id)
+	require.NoError(t, err)
+	require.Equal(t, expected, byID)
+
+	dbtesting.AssertDeleteUser(t, db, dbplugin.DeleteUserRequest{Username: resp.Username})
+
+	for _, id := range []string{resp.Username, *auths[0].Id} {
+		_, err = db.CredentialPermissions(context.Background(), id)
+		require.Error(t, err)
+		require.True(t, errors.Is(err, ErrCredentialNotFound), "unexpected error: %s", err)
+		require.Contains(t, err.Error(), "may have been revoked")
+	}
+}
@@ -0,0 +1,91 @@
+package influxdbv2
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/influxdata/influxdb-client-go/v2"
+	"github.com/influxdata/influxdb-client-go/v2/domain"
+)
+
+// By default, default_bucket is looked up in the organization bucket names of
+// a credential are looked up in. Bucket names are only unique within an
+// organization, so on a mount whose token spans organizations the same name
+// may designate a different bucket for every credential's organization.
+// default_bucket_organization pins it to the bucket of one organization, and
+// verifying the connection fails if it is needed but not set.
+
+// resolveDefaultBucketOrganization returns the organization default_bucket is
+// looked up in for a credential of org.
+func (i *influxdbConnectionProducer) resolveDefaultBucketOrganization(ctx context.Context, cli influxdb2.Client, org *domain.Organization) (*domain.Organization, error) {
+	if i.DefaultBucketOrganization == "" {
+		return i.resolveBucketOrganization(ctx, cli, org)
+	}
+	bucketOrg, err := i.resolveOrganization(ctx, cli, i.DefaultBucketOrganization)
+	if err != nil {
+		return nil, fmt.Errorf("unable to resolve default_bucket_organization: %w", err)
+	}
+	return bucketOrg, nil
+}
+
+// resolveDefaultBucket resolves default_bucket for a credential of org.
+func (i *influxdbConnectionProducer) resolveDefaultBucket(ctx context.Context, cli influxdb2.Client, org *domain.Organization) (*domain.Bucket, error) {
+	bucketOrg, err := i.resolveDefaultBucketOrganization(ctx, cli, org)
+	if err != nil {
+		return nil, err
+	}
+	return i.resolveBucket(ctx, cli, *bucketOrg.Id, i.DefaultBucket)
+}
+
+// checkDefaultBucketAmbiguity fails if default_bucket names buckets in
+// several of the organizations the token can access and nothing selects one
+// of them: neither default_bucket_organization nor resolution_organization.
+// A mount whose token only sees one organization never fails the check.
+func (i *influxdbConnectionProducer) checkDefaultBucketAmbiguity(ctx context.Context, cli influxdb2.Client) error {
+	if i.DefaultBucket == "" || i.DefaultBucketOrganization != "" || i.ResolutionOrganization != "" || i.Cloud {
+		return nil
+	}
+
+	name := i.DefaultBucket
+	var buckets []domain.Bucket
+	err := retry(ctx, func(int) error {
+		response, err := domain.NewClientWithResponses(cli.HTTPService()).GetBucketsWithResponse(ctx, &domain.GetBucketsParams{Name: &name})
+		if err != nil {
+			return err
+		}
+		if response.JSONDefault != nil {
+			return domain.ErrorToHTTPError(response.JSONDefault, response.StatusCode())
+		}
+		buckets = nil
+		if response.JSON200 != nil && response.JSON200.Buckets != nil {
+			buckets = *response.JSON200.Buckets
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("unable to look up default_bucket %q: %w", name, classify(err))
+	}
+
+	orgIDs := make(map[string]struct{})
+	for _, bucket := range buckets {
+		if bucket.Name == name {
+			orgIDs[stringValue(bucket.OrgID)] = struct{}{}
+		}
+	}
+	if len(orgIDs) < 2 {
+		return nil
+	}
+	orgs := make([]string, 0, len(orgIDs))
+	for id := range orgIDs {
+		org, err := cli.OrganizationsAPI().FindOrganizationByID(ctx, id)
+		if err != nil {
+			orgs = append(orgs, id)
+			continue
+		}
+		orgs = append(orgs, fmt.Sprintf("%q (%s)", org.Name, id))
+	}
+	sort.Strings(orgs)
+	return fmt.Errorf("default_bucket %q is ambiguous, the token can access a bucket of that name in %d organizations: %s; set default_bucket_organization to the organization of the bucket to use", name, len(orgs), strings.Join(orgs, ", "))
+}
@@ -0,0 +1,116 @@
+package influxdbv2
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/database/dbplugin/v5"
+	dbtesting "github.com/hashicorp/vault/sdk/database/dbplugin/v5/testing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInitialize_DefaultBucketAcrossOrganizations(t *testing.T) {
+	const token = "root-token"
+	srv := newFakeInfluxServer(t, token)
+	vaultOrgID := srv.orgID("vault")
+	otherOrgID := srv.addOrg("other")
+	vaultTelegrafID := srv.addBucket(vaultOrgID, "telegraf")
+	otherTelegrafID := srv.addBucket(otherOrgID, "telegraf")
+	srv.addBucket(otherOrgID, "metrics")
+
+	newUser := func(t *testing.T, db *InfluxdbV2, statement string) (string, string) {
+		t.Helper()
+		resp := dbtesting.AssertNewUser(t, db, dbplugin.NewUserRequest{
+			UsernameConfig: dbplugin.UsernameMetadata{DisplayName: "test", RoleName: "test"},
+			Statements:     dbplugin.Statements{Commands: []string{statement}},
+			Password:       "nuozxby98523u89bdfnkjl",
+			Expiration:     time.Now().Add(time.Minute),
+		})
+		auths := srv.userAuthorizations(resp.Username)
+		require.Len(t, auths, 1)
+		permissions := *auths[0].Permissions
+		require.Len(t, permissions, 1)
+		return *permissions[0].Resource.Id, *permissions[0].Resource.OrgID
+	}
+
+	t.Run("ambiguous", func(t *testing.T) {
+		_, err := new().Initialize(context.Background(), dbplugin.InitializeRequest{
+			Config:           makeConfig(srv.connectionParams(token), "default_bucket", "telegraf"),
+			VerifyConnection: true,
+		})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), `default_bucket "telegraf" is ambiguous`)
+		require.Contains(t, err.Error(), `"vault" (`+vaultOrgID+`)`)
+		require.Contains(t, err.Error(), `"other" (`+otherOrgID+`)`)
+		require.Contains(t, err.Error(), "set default_bucket_organization")
+	})
+
+	t.Run("unambiguous", func(t *testing.T) {
+		db := new()
+		dbtesting.AssertInitialize(t, db, dbplugin.InitializeRequest{
+			Config:           makeConfig(srv.connectionParams(token), "organization", "other", "default_bucket", "metrics"),
+			VerifyConnection: true,
+		})
+		defer dbtesting.AssertClose(t, db)
+	})
+
+	t.Run("qualified", func(t *testing.T) {
+		db := new()
+		dbtesting.AssertInitialize(t, db, dbplugin.InitializeRequest{
+			Config:           makeConfig(srv.connectionParams(token), "default_bucket", "telegraf", "default_bucket_organization", "other"),
+			VerifyConnection: true,
+		})
+		defer dbtesting.AssertClose(t, db)
+
+		// The bucket of default_bucket_organization is granted, whatever the
+		// organization of the credential.
+		bucketID, orgID := newUser(t, db, `{"preset": "read"}`)
+		require.Equal(t, otherTelegrafID, bucketID)
+		require.Equal(t, otherOrgID, orgID)
+
+		// A bucket named by the statement is still looked up in the
+		// credential's organization.
+		bucketID, orgID = newUser(t, db, `{"preset": "read", "bucket": "telegraf"}`)
+		require.Equal(t, vaultTelegrafID, bucketID)
+		require.Equal(t, vaultOrgID, orgID)
+	})
+
+	t.Run("resolution_organization selects the bucket", func(t *testing.T) {
+		db := new()
+		dbtesting.AssertInitialize(t, db, dbplugin.InitializeRequest{
+			Config:           makeConfig(srv.connectionParams(token), "default_bucket", "telegraf", "resolution_organization", "other"),
+			VerifyConnection: true,
+		})
+		defer dbtesting.AssertClose(t, db)
+	})
+
+	t.Run("single organization", func(t *testing.T) {
+		srv := newFakeInfluxServer(t, token)
+		telegrafID := srv.addBucket(srv.orgID("vault"), "telegraf")
+
+		db := new()
+		dbtesting.AssertInitialize(t, db, dbplugin.InitializeRequest{
+			Config:           makeConfig(srv.connectionParams(token), "default_bucket", "telegraf"),
+			VerifyConnection: true,
+		})
+		defer dbtesting.AssertClose(t, db)
+		resp := dbtesting.AssertNewUser(t, db, dbplugin.NewUserRequest{
+			UsernameConfig: dbplugin.UsernameMetadata{DisplayName: "test", RoleName: "test"},
+			Statements:     dbplugin.Statements{Commands: []string{`{"preset": "read"}`}},
+			Password:       "nuozxby98523u89bdfnkjl",
+			Expiration:     time.Now().Add(time.Minute),
+		})
+		auths := srv.userAuthorizations(resp.Username)
+		require.Len(t, auths, 1)
+		require.Equal(t, telegrafID, *(*auths[0].Permissions)[0].Resource.Id)
+	})
+
+	t.Run("requires default_bucket", func(t *testing.T) {
+		_, err := new().Initialize(context.Background(), dbplugin.InitializeRequest{
+			Config: makeConfig(srv.connectionParams(token), "default_bucket_organization", "other"),
+		})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "default_bucket_organization requires default_bucket")
+	})
+}
@@ -0,0 +1,202 @@
+package influxdbv2
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/influxdata/influxdb-client-go/v2"
+)
+
+// Names of the diagnostic steps, in the order they run.
+const (
+	DiagnosticPing          = "ping"
+	DiagnosticHealth        = "health"
+	DiagnosticAccess        = "access"
+	DiagnosticOrganization  = "organization"
+	DiagnosticDefaultBucket = "default_bucket"
+)
+
+// DiagnosticResult is the outcome of a diagnostic step.
+type DiagnosticResult string
+
+const (
+	DiagnosticPassed  DiagnosticResult = "passed"
+	DiagnosticFailed  DiagnosticResult = "failed"
+	DiagnosticSkipped DiagnosticResult = "skipped"
+)
+
+// DiagnosticStep reports a single diagnostic step. Message explains a failed
+// or skipped step, or adds detail to a passed one. Hint suggests how to fix a
+// failed step, when its failure is a known one. Secrets are redacted from
+// both.
+type DiagnosticStep struct {
+	Name     string
+	Result   DiagnosticResult
+	Message  string
+	Hint     string
+	Duration time.Duration
+}
+
+// Diagnostics is the report returned by Diagnose.
+type Diagnostics struct {
+	// URL is the configured url, stripped of any credentials, or else the
+	// first endpoint the connection targets.
+	URL   string
+	Steps []DiagnosticStep
+}
+
+// Passed reports whether no step failed.
+func (d Diagnostics) Passed() bool {
+	for _, step := range d.Steps {
+		if step.Result == DiagnosticFailed {
+			return false
+		}
+	}
+	return true
+}
+
+// Diagnose runs every connection check in turn and reports the outcome of
+// each, rather than stopping at the first failure. Steps that depend on one
+// that failed are reported as skipped. It uses a client of its own, leaving
+// the cached connection and endpoint selection alone, and can be called
+// before Initialize has succeeded.
+func (i *InfluxdbV2) Diagnose(ctx context.Context) Diagnostics {
+	i.Lock()
+	defer i.Unlock()
+
+	d := &diagnosis{secrets: i.secretValues()}
+	d.report.URL = i.URL
+	if d.report.URL == "" && len(i.endpoints) > 0 {
+		d.report.URL = i.scheme() + "://" + i.endpoints[0]
+	}
+	if !i.Initialized || len(i.endpoints) == 0 {
+		d.fail(DiagnosticPing, time.Now(), ErrNotInitialized)
+		d.skipFrom(DiagnosticHealth, "the connection could not be established")
+		return d.report
+	}
+
+	start := time.Now()
+	cli, err := i.diagnosticClient(ctx)
+	if err != nil {
+		d.fail(DiagnosticPing, start, err)
+		d.skipFrom(DiagnosticHealth, "the connection could not be established")
+		return d.report
+	}
+	defer func() { _ = closeClient(cli) }()
+	d.pass(DiagnosticPing, start, cli.ServerURL())
+
+	start = time.Now()
+	health, err := healthStatus(ctx, cli)
+	switch {
+	case err != nil:
+		d.fail(DiagnosticHealth, start, err)
+	case health.Condition != ProbeHealthy:
+		d.fail(DiagnosticHealth, start, fmt.Errorf("server is unhealthy: %s", health.Message))
+	default:
+		d.pass(DiagnosticHealth, start, health.Version)
+	}
+
+	start = time.Now()
+	if i.SkipAccessCheck {
+		d.skip(DiagnosticAccess, "skip_access_check is set")
+	} else if i.Cloud {
+		// As in Initialize: Cloud tokens often can't list authorizations.
+		d.skip(DiagnosticAccess, "cloud is set, so the token's permissions aren't listed")
+	} else if _, err := checkTokenAccess(ctx, cli, i.Token, i.requiredPermissions, i.maxAuthorizationPages()); err != nil {
+		d.fail(DiagnosticAccess, start, err)
+	} else {
+		d.pass(DiagnosticAccess, start, "")
+	}
+
+	start = time.Now()
+	org, err := i.resolveDefaultOrganization(ctx, cli)
+	if err == nil {
+		err = i.checkOrgAccess(ctx, cli, *org.Id, org.Name)
+	}
+	if err != nil {
+		d.fail(DiagnosticOrganization, start, err)
+		d.skip(DiagnosticDefaultBucket, "the organization could not be resolved")
+		return d.report
+	}
+	d.pass(DiagnosticOrganization, start, *org.Id)
+
+	if i.DefaultBucket == "" {
+		d.skip(DiagnosticDefaultBucket, "no default_bucket configured")
+		return d.report
+	}
+	start = time.Now()
+	bucket, err := i.resolveDefaultBucket(ctx, cli, org)
+	if err != nil {
+		d.fail(DiagnosticDefaultBucket, start, err)
+	} else {
+		d.pass(DiagnosticDefaultBucket, start, *bucket.Id)
+	}
+	return d.report
+}
+
+// diagnosticClient returns a client for the first reachable endpoint, in
+// configured order.
+func (i *influxdbConnectionProducer) diagnosticClient(ctx context.Context) (influxdb2.Client, error) {
+	options, err := i.clientOptions()
+	if err != nil {
+		return nil, err
+	}
+	var failures []string
+	for _, endpoint := range i.endpoints {
+		cli := newInfluxClient(i.scheme()+"://"+endpoint, i.Token, options)
+		err := i.ping(ctx, cli)
+		if err == nil {
+			return cli, nil
+		}
+		_ = closeClient(cli)
+		failures = append(failures, fmt.Sprintf("endpoint %s: %s", endpoint, err))
+	}
+	return nil, withKind(ErrUnreachable, fmt.Errorf("no endpoint is reachable: %s", strings.Join(failures, "; ")))
+}
+
+// diagnosis accumulates the steps of a Diagnose report.
+type diagnosis struct {
+	report  Diagnostics
+	secrets map[string]string
+}
+
+func (d *diagnosis) add(name string, result DiagnosticResult, start time.Time, message string) *DiagnosticStep {
+	step := DiagnosticStep{
+		Name:    name,
+		Result:  result,
+		Message: redactString(message, d.secrets),
+	}
+	if !start.IsZero() {
+		step.Duration = time.Since(start)
+	}
+	d.report.Steps = append(d.report.Steps, step)
+	return &d.report.Steps[len(d.report.Steps)-1]
+}
+
+func (d *diagnosis) pass(name string, start time.Time, message string) {
+	d.add(name, DiagnosticPassed, start, message)
+}
+
+func (d *diagnosis) fail(name string, start time.Time, err error) {
+	step := d.add(name, DiagnosticFailed, start, err.Error())
+	step.Hint = redactString(remediationHint(name, err), d.secrets)
+}
+
+func (d *diagnosis) skip(name, reason string) {
+	d.add(name, DiagnosticSkipped, time.Time{}, reason)
+}
+
+// skipFrom marks every step from name onwards as skipped.
+func (d *diagnosis) skipFrom(name, reason string) {
+	steps := []string{DiagnosticPing, DiagnosticHealth, DiagnosticAccess, DiagnosticOrganization, DiagnosticDefaultBucket}
+	for idx, step := range steps {
+		if step == name {
+			for _, skipped := range steps[idx:] {
+				d.skip(skipped, reason)
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package influxdbv2
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/database/dbplugin/v5"
+	dbtesting "github.com/hashicorp/vault/sdk/database/dbplugin/v5/testing"
+	ihttp "github.com/influxdata/influxdb-client-go/v2/api/http"
+	"github.com/influxdata/influxdb-client-go/v2/domain"
+	"github.com/stretchr/testify/require"
+)
+
+func diagnosticResults(d Diagnostics) map[string]DiagnosticResult {
+	results := make(map[string]DiagnosticResult, len(d.Steps))
+	for _, step := range d.Steps {
+		results[step.Name] = step.Result
+	}
+	return results
+}
+
+func TestInfluxdb_Diagnose(t *testing.T) {
+	const token = "root-token"
+	srv := newFakeInfluxServer(t, token)
+	orgID := srv.orgID("vault")
+	bucketID := srv.addBucket(orgID, "telegraf")
+
+	// Before Initialize nothing can be checked, but nothing panics either.
+	d := new().Diagnose(context.Background())
+	require.False(t, d.Passed())
+	require.Len(t, d.Steps, 5)
+	require.Equal(t, DiagnosticFailed, d.Steps[0].Result)
+	require.Contains(t, d.Steps[0].Message, ErrNotInitialized.Error())
+
+	db := new()
+	dbtesting.AssertInitialize(t, db, dbplugin.InitializeRequest{
+		Config: makeConfig(srv.connectionParams(token), "default_bucket", "telegraf"),
+	})
+	defer dbtesting.AssertClose(t, db)
+
+	d = db.Diagnose(context.Background())
+	require.True(t, d.Passed(), "%#v", d)
+	require.Equal(t, []string{DiagnosticPing, DiagnosticHealth, DiagnosticAccess, DiagnosticOrganization, DiagnosticDefaultBucket},
+		[]string{d.Steps[0].Name, d.Steps[1].Name, d.Steps[2].Name, d.Steps[3].Name, d.Steps[4].Name})
+	require.Equal(t, orgID, d.Steps[3].Message)
+	require.Equal(t, bucketID, d.Steps[4].Message)
+
+	// Every failure is reported at once, with secrets redacted.
+	srv.handle("GET /health", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]interface{}{"name": "influxdb", "status": "fail", "message": "rejected " + token})
+	})
+	db = new()
+	dbtesting.AssertInitialize(t, db, dbplugin.InitializeRequest{
+		Config: makeConfig(srv.connectionParams(token), "organization", "missing", "default_bucket", "telegraf"),
+	})
+	defer dbtesting.AssertClose(t, db)
+
+	d = db.Diagnose(context.Background())
+	require.False(t, d.Passed())
+	require.Equal(t, map[string]DiagnosticResult{
+		DiagnosticPing:          DiagnosticPassed,
+		DiagnosticHealth:        DiagnosticFailed,
+		DiagnosticAccess:        DiagnosticPassed,
+		DiagnosticOrganization:  DiagnosticFailed,
+		DiagnosticDefaultBucket: DiagnosticSkipped,
+	}, diagnosticResults(d))
+	require.Equal(t, "server is unhealthy: rejected [token]", d.Steps[1].Message)
+	require.Contains(t, d.Steps[3].Message, "organization 'missing' not found")
+}
+
+func TestInfluxdb_DiagnoseUnreachable(t *testing.T) {
+	const token = "root-token"
+	srv := newFakeInfluxServer(t, token)
+	srv.handle("GET /ping", func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusBadGateway, "unavailable", "down")
+	})
+
+	db := new()
+	dbtesting.AssertInitialize(t, db, dbplugin.InitializeRequest{
+		Config: makeConfig(srv.connectionParams(token), "lazy_connect", true),
+	})
+	defer dbtesting.AssertClose(t, db)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	d := db.Diagnose(ctx)
+	require.Equal(t, map[string]DiagnosticResult{
+		DiagnosticPing:          DiagnosticFailed,
+		DiagnosticHealth:        DiagnosticSkipped,
+		DiagnosticAccess:        DiagnosticSkipped,
+		DiagnosticOrganization:  DiagnosticSkipped,
+		DiagnosticDefaultBucket: DiagnosticSkipped,
+	}, diagnosticResults(d))
+}
+
+func TestInfluxdb_DiagnoseHints(t *testing.T) {
+	const token = "root-token"
+
+	diagnose := func(t *testing.T, srv *fakeInfluxServer, kv ...interface{}) Diagnostics {
+		t.Helper()
+		db := new()
+		dbtesting.AssertInitialize(t, db, dbplugin.InitializeRequest{
+			Config: makeConfig(srv.connectionParams(token), kv...),
+		})
+		t.Cleanup(func() { dbtesting.AssertClose(t, db) })
+		return db.Diagnose(context.Background())
+	}
+	step := func(t *testing.T, d Diagnostics, name string) DiagnosticStep {
+		t.Helper()
+		for _, step := range d.Steps {
+			if step.Name == name {
+				return step
+			}
+		}
+		t.Fatalf("no %s step in %#v", name, d)
+		return DiagnosticStep{}
+	}
+
+	t.Run("not initialized", func(t *testing.T) {
+		d := new().Diagnose(context.Background())
+		require.Equal(t, hintNotInitialized, step(t, d, DiagnosticPing).Hint)
+	})
+
+	t.Run("passed steps have no hint", func(t *testing.T) {
+		srv := newFakeInfluxServer(t, token)
+		for _, step := range diagnose(t, srv).Steps {
+			require.Empty(t, step.Hint, step.Name)
+		}
+	})
+
+	t.Run("unhealthy", func(t *testing.T) {
+		srv := newFakeInfluxServer(t, token)
+		srv.handle("GET /health", func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(w, http.StatusServiceUnavailable, map[string]interface{}{"name": "influxdb", "status": "fail", "message": "rejected " + token})
+		})
+		require.Equal(t, hintUnhealthy, step(t, diagnose(t, srv), DiagnosticHealth).Hint)
+	})
+
+	t.Run("missing permissions", func(t *testing.T) {
+		srv := newFakeInfluxServer(t, token)
+		orgID := srv.orgID("vault")
+		srv.setPermissions(token,
+			permission(domain.PermissionActionRead, domain.ResourceTypeAuthorizations, orgID),
+			permission(domain.PermissionActionRead, domain.ResourceTypeOrgs, orgID),
+			permission(domain.PermissionActionRead, domain.ResourceTypeUsers, orgID),
+			permission(domain.PermissionActionWrite, domain.ResourceTypeUsers, orgID),
+		)
+		d := diagnose(t, srv, "skip_access_check", true)
+		require.Equal(t, DiagnosticSkipped, step(t, d, DiagnosticAccess).Result)
+
+		d = diagnose(t, srv)
+		hint := step(t, d, DiagnosticAccess).Hint
+		require.Contains(t, hint, "the token lacks authorizations:write")
+		require.Contains(t, hint, "grant them to the token in the InfluxDB UI")
+	})
+
+	t.Run("organization not found", func(t *testing.T) {
+		srv := newFakeInfluxServer(t, token)
+		require.Equal(t, hintOrgNotFound, step(t, diagnose(t, srv, "organization", "missing"), DiagnosticOrganization).Hint)
+	})
+
+	t.Run("bucket not found", func(t *testing.T) {
+		srv := newFakeInfluxServer(t, token)
+		require.Equal(t, hintBucketNotFound, step(t, diagnose(t, srv, "default_bucket", "missing"), DiagnosticDefaultBucket).Hint)
+	})
+
+	t.Run("redacted", func(t *testing.T) {
+		// A hint naming a configured secret, however unlikely, is redacted
+		// like the message.
+		d := &diagnosis{secrets: map[string]string{"InfluxDB": "[token]"}}
+		d.fail(DiagnosticHealth, time.Now(), ErrUnhealthy)
+		require.NotContains(t, d.report.Steps[0].Hint, "InfluxDB")
+		require.Contains(t, d.report.Steps[0].Hint, "[token]")
+	})
+}
+
+func TestRemediationHint(t *testing.T) {
+	for name, tc := range map[string]struct {
+		step string
+		err  error
+		hint string
+	}{
+		"dns":                  {DiagnosticPing, &net.DNSError{Err: "no such host", Name: "influxdb.invalid", IsNotFound: true}, hintDNS},
+		"refused":              {DiagnosticPing, &net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}, hintRefused},
+		"timeout":              {DiagnosticPing, fmt.Errorf("ping: %w", context.DeadlineExceeded), hintTimeout},
+		"tls":                  {DiagnosticPing, x509.UnknownAuthorityError{}, hintTLS},
+		"certificate policy":   {DiagnosticPing, &constraintError{"max_chain_depth"}, hintTLS},
+		"rate limited":         {DiagnosticAccess, &ihttp.Error{StatusCode: http.StatusTooManyRequests}, hintRateLimit},
+		"unauthorized":         {DiagnosticAccess, classify(&ihttp.Error{StatusCode: http.StatusUnauthorized}), hintAuthFailed},
+		"forbidden":            {DiagnosticOrganization, classify(&ihttp.Error{StatusCode: http.StatusForbidden}), hintPermissions},
+		"missing permissions":  {DiagnosticAccess, checkRequiredPermissions(nil, []requiredPermission{{ResourceType: domain.ResourceTypeAuthorizations, Action: domain.PermissionActionWrite}}), "the token lacks authorizations:write: " + hintGrant},
+		"authorizations":       {DiagnosticAccess, withKind(ErrAuthorizationsNotVisible, errors.New("hidden")), hintNoAuthorizations},
+		"invalid organization": {DiagnosticOrganization, withKind(ErrInvalidOrganizationID, errors.New("invalid")), hintInvalidOrgID},
+		"unreachable":          {DiagnosticPing, withKind(ErrUnreachable, errors.New("no endpoint is reachable")), hintUnreachable},
+		"unknown":              {DiagnosticOrganization, errors.New("unexpected"), ""},
+	} {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, tc.hint, remediationHint(tc.step, tc.err))
+		})
+	}
+}
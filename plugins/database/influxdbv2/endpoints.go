@@ -0,0 +1,197 @@
+package influxdbv2
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	// endpointPolicyFirstAvailable tries the last healthy endpoint first and
+	// then the remaining endpoints in configured order.
+	endpointPolicyFirstAvailable = "first_available"
+
+	// endpointPolicyRoundRobin starts each new connection at the endpoint
+	// after the one the previous connection started at.
+	endpointPolicyRoundRobin = "round_robin"
+)
+
+// Endpoint precedence
+//
+// The scheme and address of the endpoints are derived in a fixed order:
+//
+//  1. url, if set, wins: it gives the scheme and the single endpoint, and
+//     cannot be combined with host or endpoints. Without a port in the URL,
+//     port is used if explicitly set, or else the standard port of the
+//     scheme, 80 or 443, as for any URL.
+//  2. Otherwise scheme, if set, selects http or https regardless of tls.
+//  3. Otherwise tls selects https, and its absence http.
+//  4. host or endpoints entries without a port use port, which defaults to
+//     8086, InfluxDB's port for both schemes. A port included in host is used
+//     instead, unless port is explicitly set to a different one.
+//
+// tls decides whether the TLS settings apply, not the scheme; they are
+// ignored, with a warning, if the scheme ends up being http.
+
+const (
+	schemeHTTP  = "http"
+	schemeHTTPS = "https"
+
+	// influxdbDefaultPort is InfluxDB's default listen port.
+	influxdbDefaultPort = "8086"
+)
+
+// standardPorts are the ports a URL without one implies.
+var standardPorts = map[string]string{
+	schemeHTTP:  "80",
+	schemeHTTPS: "443",
+}
+
+// parseScheme validates an explicitly configured scheme.
+func parseScheme(raw string) (string, error) {
+	scheme := strings.ToLower(strings.TrimSpace(raw))
+	if scheme != schemeHTTP && scheme != schemeHTTPS {
+		return "", fmt.Errorf("invalid scheme %q, expected %q or %q", raw, schemeHTTP, schemeHTTPS)
+	}
+	return scheme, nil
+}
+
+// parseServerURL splits url into its scheme and "host:port" endpoint,
+// falling back to port, if not empty, and then to the scheme's standard port
+// when the URL has none.
+func parseServerURL(raw, port string) (scheme, endpoint string, err error) {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return "", "", fmt.Errorf("invalid url: %w", err)
+	}
+	scheme, err = parseScheme(u.Scheme)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid url: %w", err)
+	}
+	switch {
+	case u.Hostname() == "":
+		return "", "", fmt.Errorf("invalid url: missing host")
+	case (u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.Fragment != "":
+		return "", "", fmt.Errorf("invalid url: expected no path, query or fragment")
+	}
+	if u.Port() != "" {
+		port = u.Port()
+	} else if port == "" {
+		port = standardPorts[scheme]
+	}
+	if n, err := strconv.Atoi(port); err != nil || n <= 0 || n > 65535 {
+		return "", "", fmt.Errorf("invalid url: invalid port %q", port)
+	}
+	return scheme, net.JoinHostPort(u.Hostname(), port), nil
+}
+
+// urlCredentialParams are the query parameters InfluxDB, including its v1
+// compatibility API, reads credentials from.
+var urlCredentialParams = []string{"token", "authorization", "u", "p", "password"}
+
+// userinfoRegex matches the user info of a URL that doesn't parse.
+var userinfoRegex = regexp.MustCompile(`^([a-zA-Z][a-zA-Z0-9+.-]*://)[^/?#]*@`)
+
+// stripURLCredentials removes the user info and credential query parameters
+// from a URL, reporting whether there were any. A URL that doesn't parse is
+// still stripped of its user info, since the parse error quotes it.
+func stripURLCredentials(raw string) (stripped string, found bool) {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		stripped = userinfoRegex.ReplaceAllString(raw, "$1")
+		return stripped, stripped != raw
+	}
+	if u.User != nil {
+		u.User = nil
+		found = true
+	}
+	query := u.Query()
+	for _, param := range urlCredentialParams {
+		for key := range query {
+			if strings.EqualFold(key, param) {
+				query.Del(key)
+				found = true
+			}
+		}
+	}
+	if !found {
+		return raw, false
+	}
+	u.RawQuery = query.Encode()
+	return u.String(), true
+}
+
+// parseHost normalizes host to "host:port". A port included in host, such
+// as "influx.example.com:8086" or "[::1]:8086", is used unless explicitPort,
+// the port explicitly configured if any, differs from it. Otherwise
+// defaultPort is used.
+func parseHost(raw, explicitPort, defaultPort string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	host, port, err := net.SplitHostPort(raw)
+	switch {
+	case err != nil:
+		// No port given, or a bare IPv6 address.
+		host, port = strings.Trim(raw, "[]"), defaultPort
+	case explicitPort != "" && explicitPort != port:
+		return "", fmt.Errorf("host %q includes port %s, which conflicts with port %s; remove one of them", raw, port, explicitPort)
+	}
+	if n, err := strconv.Atoi(port); host == "" || strings.Contains(host, "/") || err != nil || n <= 0 || n > 65535 {
+		return "", fmt.Errorf("invalid host %q, expected host or host:port; use url for a full URL", raw)
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// parseEndpoints validates the configured endpoints and normalizes each one
+// to "host:port", using defaultPort when an entry has no port.
+func parseEndpoints(raw []string, defaultPort string) ([]string, error) {
+	endpoints := make([]string, 0, len(raw))
+	seen := make(map[string]struct{}, len(raw))
+	for _, entry := range raw {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			return nil, fmt.Errorf("endpoints cannot contain an empty entry")
+		}
+		host, port, err := net.SplitHostPort(entry)
+		if err != nil {
+			// No port given, or a bare IPv6 address.
+			host, port = strings.Trim(entry, "[]"), defaultPort
+		}
+		if n, err := strconv.Atoi(port); host == "" || strings.Contains(host, "/") || err != nil || n <= 0 || n > 65535 {
+			return nil, fmt.Errorf("invalid endpoint %q, expected host or host:port", entry)
+		}
+		endpoint := net.JoinHostPort(host, port)
+		if _, ok := seen[endpoint]; ok {
+			return nil, fmt.Errorf("duplicate endpoint %q", entry)
+		}
+		seen[endpoint] = struct{}{}
+		endpoints = append(endpoints, endpoint)
+	}
+	return endpoints, nil
+}
+
+// endpointOrder returns the order in which endpoints should be tried for a
+// new connection according to the configured policy. It must be called with
+// the lock held.
+func (i *influxdbConnectionProducer) endpointOrder() []int {
+	n := len(i.endpoints)
+	order := make([]int, 0, n)
+	if i.EndpointPolicy == endpointPolicyRoundRobin {
+		start := i.nextEndpoint
+		i.nextEndpoint = (i.nextEndpoint + 1) % n
+		for idx := 0; idx < n; idx++ {
+			order = append(order, (start+idx)%n)
+		}
+		return order
+	}
+
+	order = append(order, i.healthyEndpoint)
+	for idx := 0; idx < n; idx++ {
+		if idx != i.healthyEndpoint {
+			order = append(order, idx)
+		}
+	}
+	return order
+}
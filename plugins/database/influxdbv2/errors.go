@@ -0,0 +1,102 @@
+package influxdbv2
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/hashicorp/vault/sdk/database/helper/connutil"
+	ihttp "github.com/influxdata/influxdb-client-go/v2/api/http"
+)
+
+// Errors returned, possibly wrapped, by the plugin. Use errors.Is to test for
+// them rather than matching on error messages.
+var (
+	// ErrNotInitialized is returned when the plugin is used before Initialize.
+	ErrNotInitialized = connutil.ErrNotInitialized
+
+	ErrTokenEmpty = errors.New("token cannot be empty")
+	ErrHostEmpty  = errors.New("host cannot be empty")
+
+	// ErrUnreachable is returned when no endpoint answers a ping.
+	ErrUnreachable = errors.New("influxdb is unreachable")
+
+	// ErrUnhealthy is returned, along with ErrUnreachable, when the only
+	// endpoints answering a ping report themselves unhealthy and
+	// require_healthy is set.
+	ErrUnhealthy = errors.New("influxdb is unhealthy")
+
+	// ErrAuthFailed is returned when the server rejects the token.
+	ErrAuthFailed = errors.New("authentication failed")
+
+	// ErrInsufficientPermissions is returned when the token is valid but not
+	// allowed to do what the plugin needs.
+	ErrInsufficientPermissions = errors.New("insufficient permissions")
+
+	// ErrInvalidOrganizationID is returned when organization_id isn't shaped
+	// like an InfluxDB ID, as opposed to ErrOrganizationNotFound when it is
+	// but no such organization exists.
+	ErrInvalidOrganizationID = errors.New("invalid organization ID")
+
+	ErrOrganizationNotFound = errors.New("organization not found")
+	ErrBucketNotFound       = errors.New("bucket not found")
+
+	// ErrAuthorizationsNotVisible is returned when the token can't see any
+	// authorization, not even its own, so its permissions can't be checked.
+	ErrAuthorizationsNotVisible = errors.New("no authorizations visible to the token")
+
+	// ErrCredentialNotFound is returned when a credential no longer exists.
+	ErrCredentialNotFound = errors.New("credential not found")
+
+	// ErrRevocationFailed is returned when a credential couldn't be deleted,
+	// even after retrying, so it may still be usable.
+	ErrRevocationFailed = errors.New("revocation failed")
+
+	// ErrCredentialNotRevocable is returned when revoking a credential that
+	// was marked non-revocable when it was created or adopted.
+	ErrCredentialNotRevocable = errors.New("credential is not revocable")
+
+	// ErrCredentialUnusable is returned when a credential failed
+	// verify_created_credential, and was revoked rather than handed out.
+	ErrCredentialUnusable = errors.New("credential unusable")
+)
+
+// kindError tags an error with one of the sentinels above without changing
+// its message.
+type kindError struct {
+	kind error
+	err  error
+}
+
+func (e *kindError) Error() string { return e.err.Error() }
+
+func (e *kindError) Unwrap() error { return e.err }
+
+func (e *kindError) Is(target error) bool { return target == e.kind }
+
+func withKind(kind, err error) error {
+	return &kindError{kind: kind, err: err}
+}
+
+// statusKind returns the sentinel matching the HTTP status of a server error,
+// or nil if there is none.
+func statusKind(err error) error {
+	var httpErr *ihttp.Error
+	if !errors.As(err, &httpErr) {
+		return nil
+	}
+	switch httpErr.StatusCode {
+	case http.StatusUnauthorized:
+		return ErrAuthFailed
+	case http.StatusForbidden:
+		return ErrInsufficientPermissions
+	}
+	return nil
+}
+
+// classify tags err with the sentinel matching its HTTP status, if any.
+func classify(err error) error {
+	if kind := statusKind(err); kind != nil {
+		return withKind(kind, err)
+	}
+	return err
+}
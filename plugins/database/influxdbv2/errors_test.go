@@ -0,0 +1,138 @@
+package influxdbv2
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/database/dbplugin/v5"
+	dbtesting "github.com/hashicorp/vault/sdk/database/dbplugin/v5/testing"
+	"github.com/influxdata/influxdb-client-go/v2/domain"
+	"github.com/stretchr/testify/require"
+)
+
+func TestErrors_Is(t *testing.T) {
+	const token = "root-token"
+
+	newUser := func(db *InfluxdbV2, statement string) error {
+		_, err := db.NewUser(context.Background(), dbplugin.NewUserRequest{
+			UsernameConfig: dbplugin.UsernameMetadata{
+				DisplayName: "test",
+				RoleName:    "test",
+			},
+			Statements: dbplugin.Statements{
+				Commands: []string{statement},
+			},
+			Password:   "nuozxby98523u89bdfnkjl",
+			Expiration: time.Now().Add(1 * time.Minute),
+		})
+		return err
+	}
+
+	type testCase struct {
+		run    func(t *testing.T, srv *fakeInfluxServer) error
+		expect error
+	}
+
+	tests := map[string]testCase{
+		"not initialized": {
+			run: func(t *testing.T, srv *fakeInfluxServer) error {
+				_, err := new().getConnection(context.Background())
+				return err
+			},
+			expect: ErrNotInitialized,
+		},
+		"token empty": {
+			run: func(t *testing.T, srv *fakeInfluxServer) error {
+				_, err := new().Initialize(context.Background(), dbplugin.InitializeRequest{
+					Config: makeConfig(srv.connectionParams(token), "token", ""),
+				})
+				return err
+			},
+			expect: ErrTokenEmpty,
+		},
+		"host empty": {
+			run: func(t *testing.T, srv *fakeInfluxServer) error {
+				_, err := new().Initialize(context.Background(), dbplugin.InitializeRequest{
+					Config: makeConfig(srv.connectionParams(token), "host", ""),
+				})
+				return err
+			},
+			expect: ErrHostEmpty,
+		},
+		"unreachable": {
+			run: func(t *testing.T, srv *fakeInfluxServer) error {
+				closed := httptest.NewServer(nil)
+				closed.Close()
+				u, _ := url.Parse(closed.URL)
+				_, err := new().Initialize(context.Background(), dbplugin.InitializeRequest{
+					Config:           makeConfig(srv.connectionParams(token), "port", u.Port()),
+					VerifyConnection: true,
+				})
+				return err
+			},
+			expect: ErrUnreachable,
+		},
+		"auth failed": {
+			run: func(t *testing.T, srv *fakeInfluxServer) error {
+				_, err := new().Initialize(context.Background(), dbplugin.InitializeRequest{
+					Config:           makeConfig(srv.connectionParams("wrong-token")),
+					VerifyConnection: true,
+				})
+				return err
+			},
+			expect: ErrAuthFailed,
+		},
+		"insufficient permissions": {
+			run: func(t *testing.T, srv *fakeInfluxServer) error {
+				srv.setPermissions(token, permission(domain.PermissionActionRead, domain.ResourceTypeAuthorizations, ""))
+				_, err := new().Initialize(context.Background(), dbplugin.InitializeRequest{
+					Config:           makeConfig(srv.connectionParams(token)),
+					VerifyConnection: true,
+				})
+				return err
+			},
+			expect: ErrInsufficientPermissions,
+		},
+		"organization not found": {
+			run: func(t *testing.T, srv *fakeInfluxServer) error {
+				db := new()
+				dbtesting.AssertInitialize(t, db, dbplugin.InitializeRequest{
+					Config: makeConfig(srv.connectionParams(token)),
+				})
+				defer dbtesting.AssertClose(t, db)
+				return newUser(db, `{"organization": "missing"}`)
+			},
+			expect: ErrOrganizationNotFound,
+		},
+		"bucket not found": {
+			run: func(t *testing.T, srv *fakeInfluxServer) error {
+				db := new()
+				dbtesting.AssertInitialize(t, db, dbplugin.InitializeRequest{
+					Config: makeConfig(srv.connectionParams(token)),
+				})
+				defer dbtesting.AssertClose(t, db)
+				return newUser(db, `{"permissions": [{"action": "read", "resource": {"type": "buckets", "name": "missing"}}]}`)
+			},
+			expect: ErrBucketNotFound,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			srv := newFakeInfluxServer(t, token)
+			err := test.run(t, srv)
+			require.Error(t, err)
+			require.True(t, errors.Is(err, test.expect), "expected %q to match %q", err, test.expect)
+		})
+	}
+}
+
+func TestErrors_MessageUnchanged(t *testing.T) {
+	err := withKind(ErrBucketNotFound, errors.New("bucket 'b' not found"))
+	require.Equal(t, "bucket 'b' not found", err.Error())
+	require.False(t, errors.Is(err, ErrOrganizationNotFound))
+}
@@ -0,0 +1,207 @@
+package influxdbv2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/influxdata/influxdb-client-go/v2"
+	"github.com/influxdata/influxdb-client-go/v2/domain"
+)
+
+// InfluxDB tokens never expire on their own, so a credential whose revocation
+// Vault missed would stay usable forever. The plugin keeps a schedule of the
+// expiry of every credential the mount issued, which EnforceExpiry revokes
+// once due. The schedule is only held in memory, but the expiry is also
+// embedded in the description of the credential's authorization, so it is
+// rebuilt from the server after a restart or reload.
+
+// scheduledExpiry is the schedule entry of a credential.
+type scheduledExpiry struct {
+	expires          time.Time
+	authorizationIDs []string
+}
+
+// expirySchedule maps the usernames of credentials to their expiry. loaded is
+// set once it has been rebuilt from the server.
+type expirySchedule struct {
+	loaded  bool
+	entries map[string]scheduledExpiry
+}
+
+// scheduleExpiry records the expiry of a credential. A zero expiry removes it
+// from the schedule. It must be called with the lock held.
+func (i *influxdbConnectionProducer) scheduleExpiry(username string, expires time.Time, authorizationID string) {
+	if expires.IsZero() {
+		i.unscheduleExpiry(username)
+		return
+	}
+	if i.expiries.entries == nil {
+		i.expiries.entries = make(map[string]scheduledExpiry)
+	}
+	entry := i.expiries.entries[username]
+	entry.expires = expires
+	if authorizationID != "" {
+		entry.authorizationIDs = append(entry.authorizationIDs, authorizationID)
+	}
+	i.expiries.entries[username] = entry
+}
+
+// unscheduleExpiry removes a credential from the schedule. It must be called
+// with the lock held.
+func (i *influxdbConnectionProducer) unscheduleExpiry(username string) {
+	delete(i.expiries.entries, username)
+}
+
+// loadExpirySchedule rebuilds the schedule from the descriptions of the
+// authorizations created by this mount, identified by its session name and,
+// when recorded, its origin. It must be called with the lock held.
+func (i *influxdbConnectionProducer) loadExpirySchedule(ctx context.Context, cli influxdb2.Client) error {
+	authorizations, err := listManagedAuthorizations(ctx, cli, i.maxAuthorizationPages())
+	if err != nil {
+		return classify(err)
+	}
+	i.expiries = expirySchedule{}
+	for _, authorization := range authorizations {
+		if authorization.metadata.Session != i.SessionName || authorization.metadata.Expires.IsZero() || authorization.metadata.NonRevocable || i.fromOtherOrigin(authorization.metadata) {
+			continue
+		}
+		i.scheduleExpiry(authorization.metadata.Username, authorization.metadata.Expires, stringValue(authorization.Id))
+	}
+	i.expiries.loaded = true
+	return nil
+}
+
+// EnforceExpiryResponse summarizes the outcome of EnforceExpiry.
+type EnforceExpiryResponse struct {
+	// Revoked lists the usernames of the credentials that were revoked.
+	Revoked []string
+
+	// Failed maps the usernames of credentials that could not be revoked to
+	// the error encountered. They stay scheduled and are retried on the next
+	// call.
+	Failed map[string]error
+}
+
+// EnforceExpiry revokes every credential issued by the mount whose expiry has
+// passed by more than expiry_skew, the same way DeleteUser does, along with
+// the root tokens retired by rotation whose grace period is over. It is meant
+// to be called periodically, by Vault or a scheduler, as a backstop for
+// revocations Vault missed. The first call after Initialize rebuilds the
+// schedule from the server. Enforcement stops early, reporting what was done
+// so far, if ctx is done.
+func (i *InfluxdbV2) EnforceExpiry(ctx context.Context) (EnforceExpiryResponse, error) {
+	i.Lock()
+	defer i.Unlock()
+
+	if err := i.requireInitialized("EnforceExpiry"); err != nil {
+		return EnforceExpiryResponse{}, err
+	}
+	cli, err := i.getConnection(ctx)
+	if err != nil {
+		return EnforceExpiryResponse{}, fmt.Errorf("unable to get connection: %w", err)
+	}
+	if !i.expiries.loaded {
+		if err := i.loadExpirySchedule(ctx, cli); err != nil {
+			return EnforceExpiryResponse{}, fmt.Errorf("failed to rebuild the expiry schedule: %w", err)
+		}
+	}
+
+	now := time.Now()
+	var due []string
+	for username, entry := range i.expiries.entries {
+		metadata := credentialMetadata{Expires: entry.expires}
+		if metadata.expiredAt(now, i.expirySkew) {
+			due = append(due, username)
+		}
+	}
+	sort.Strings(due)
+
+	resp := EnforceExpiryResponse{
+		Failed: map[string]error{},
+	}
+	for _, username := range due {
+		if err := ctx.Err(); err != nil {
+			return resp, err
+		}
+		if err := revokeExpired(ctx, cli, username, i.expiries.entries[username].authorizationIDs, i.revocationAttempts()); err != nil {
+			resp.Failed[username] = err
+			continue
+		}
+		i.unscheduleExpiry(username)
+		resp.Revoked = append(resp.Revoked, username)
+	}
+	// Best effort: root tokens are not credentials, and are reported apart.
+	if err := i.revokeRetiredRootTokens(ctx, cli); err != nil {
+		i.logger.Warn("unable to revoke retired root tokens", "error", err)
+	}
+	return resp, nil
+}
+
+// revokeExpired deletes the user of an expired credential along with its
+// authorizations. If the user is already gone, any authorization it left
+// behind is deleted by ID.
+func revokeExpired(ctx context.Context, cli influxdb2.Client, username string, authorizationIDs []string, attempts int) error {
+	_, err := findUserByName(ctx, cli, username)
+	if !errors.Is(err, ErrCredentialNotFound) {
+		return deleteUser(ctx, cli, username, attempts)
+	}
+	for _, id := range authorizationIDs {
+		id := id
+		err := retryN(ctx, attempts, func(int) error {
+			err := cli.AuthorizationsAPI().DeleteAuthorization(ctx, &domain.Authorization{Id: &id})
+			if isNotFound(err) {
+				return nil
+			}
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// updateExpiry records a new expiry for a credential, both in the schedule
+// and in the descriptions of its authorizations, so that a renewed
+// credential isn't revoked at its original expiry, even after a restart. It
+// must be called with the lock held.
+func (i *influxdbConnectionProducer) updateExpiry(ctx context.Context, cli influxdb2.Client, username string, expires time.Time) error {
+	authorizations, err := listManagedAuthorizations(ctx, cli, i.maxAuthorizationPages())
+	if err != nil {
+		return err
+	}
+	i.unscheduleExpiry(username)
+	for _, authorization := range authorizations {
+		if authorization.metadata.Username != username {
+			continue
+		}
+		metadata := authorization.metadata
+		metadata.Expires = expires
+		err := setAuthorizationDescription(ctx, cli, *authorization.Id, metadata.description())
+		if err != nil {
+			return err
+		}
+		if metadata.Session == i.SessionName && !metadata.NonRevocable && !i.fromOtherOrigin(metadata) {
+			i.scheduleExpiry(username, expires, *authorization.Id)
+		}
+	}
+	return nil
+}
+
+// setAuthorizationDescription replaces the description of an authorization.
+func setAuthorizationDescription(ctx context.Context, cli influxdb2.Client, id, description string) error {
+	return retry(ctx, func(int) error {
+		response, err := domain.NewClientWithResponses(cli.HTTPService()).PatchAuthorizationsIDWithResponse(ctx, id, &domain.PatchAuthorizationsIDParams{},
+			domain.PatchAuthorizationsIDJSONRequestBody{Description: &description})
+		if err != nil {
+			return err
+		}
+		if response.JSONDefault != nil {
+			return domain.ErrorToHTTPError(response.JSONDefault, response.StatusCode())
+		}
+		return nil
+	})
+}
@@ -0,0 +1,147 @@
+package influxdbv2
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/database/dbplugin/v5"
+	dbtesting "github.com/hashicorp/vault/sdk/database/dbplugin/v5/testing"
+	"github.com/stretchr/testify/require"
+)
+
+func newExpiringUser(t *testing.T, db dbplugin.Database, expiration time.Time) string {
+	t.Helper()
+	resp := dbtesting.AssertNewUser(t, db, dbplugin.NewUserRequest{
+		UsernameConfig: dbplugin.UsernameMetadata{
+			DisplayName: "test",
+			RoleName:    "test",
+		},
+		Statements: dbplugin.Statements{
+			Commands: []string{`{"preset": "read", "bucket": "telegraf"}`},
+		},
+		Password:   "nuozxby98523u89bdfnkjl",
+		Expiration: expiration,
+	})
+	return resp.Username
+}
+
+func TestInfluxdb_EnforceExpiry(t *testing.T) {
+	const token = "root-token"
+	srv := newFakeInfluxServer(t, token)
+	srv.addBucket(srv.orgID("vault"), "telegraf")
+
+	db := new()
+	dbtesting.AssertInitialize(t, db, dbplugin.InitializeRequest{
+		Config: srv.connectionParams(token),
+	})
+	defer dbtesting.AssertClose(t, db)
+
+	now := time.Now()
+	expired := newExpiringUser(t, db, now.Add(-2*time.Minute))
+	withinSkew := newExpiringUser(t, db, now.Add(-10*time.Second))
+	live := newExpiringUser(t, db, now.Add(time.Hour))
+	renewed := newExpiringUser(t, db, now.Add(-2*time.Minute))
+
+	// Renewing moves the expiry, including in the description.
+	dbtesting.AssertUpdateUser(t, db, dbplugin.UpdateUserRequest{
+		Username: renewed,
+		Expiration: &dbplugin.ChangeExpiration{
+			NewExpiration: now.Add(time.Hour),
+		},
+	})
+
+	// Another mount's credential is left alone.
+	other := new()
+	dbtesting.AssertInitialize(t, other, dbplugin.InitializeRequest{
+		Config: makeConfig(srv.connectionParams(token), "session_name", "other-mount"),
+	})
+	defer dbtesting.AssertClose(t, other)
+	otherExpired := newExpiringUser(t, other, now.Add(-2*time.Minute))
+
+	resp, err := db.EnforceExpiry(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, []string{expired}, resp.Revoked)
+	require.Empty(t, resp.Failed)
+	require.Empty(t, srv.userAuthorizations(expired))
+	for _, username := range []string{withinSkew, live, renewed, otherExpired} {
+		require.Len(t, srv.userAuthorizations(username), 1, username)
+	}
+
+	// A reloaded mount rebuilds its schedule from the descriptions.
+	reloaded := new()
+	dbtesting.AssertInitialize(t, reloaded, dbplugin.InitializeRequest{
+		Config: makeConfig(srv.connectionParams(token), "expiry_skew", "0s"),
+	})
+	defer dbtesting.AssertClose(t, reloaded)
+	resp, err = reloaded.EnforceExpiry(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, []string{withinSkew}, resp.Revoked)
+	require.Len(t, srv.userAuthorizations(renewed), 1)
+	require.Len(t, srv.userAuthorizations(otherExpired), 1)
+
+	// Nothing is left to revoke.
+	resp, err = reloaded.EnforceExpiry(context.Background())
+	require.NoError(t, err)
+	require.Empty(t, resp.Revoked)
+}
+
+func TestInfluxdb_EnforceExpiryOrigin(t *testing.T) {
+	const token = "root-token"
+	srv := newFakeInfluxServer(t, token)
+	srv.addBucket(srv.orgID("vault"), "telegraf")
+
+	// Mounts sharing a session name still tell their credentials apart by
+	// their origin_mount, once the schedule is rebuilt from the server.
+	expired := time.Now().Add(-2 * time.Minute)
+	usernames := map[string]string{}
+	for _, mount := range []string{"database/", "influx/"} {
+		db := new()
+		dbtesting.AssertInitialize(t, db, dbplugin.InitializeRequest{
+			Config: makeConfig(srv.connectionParams(token), "origin_mount", mount),
+		})
+		usernames[mount] = newExpiringUser(t, db, expired)
+		dbtesting.AssertClose(t, db)
+	}
+
+	reloaded := new()
+	dbtesting.AssertInitialize(t, reloaded, dbplugin.InitializeRequest{
+		Config: makeConfig(srv.connectionParams(token), "origin_mount", "database/"),
+	})
+	defer dbtesting.AssertClose(t, reloaded)
+	resp, err := reloaded.EnforceExpiry(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, []string{usernames["database/"]}, resp.Revoked)
+	require.Len(t, srv.userAuthorizations(usernames["influx/"]), 1)
+
+	err = ValidateConfig(makeConfig(srv.connectionParams(token), "origin_mount", strings.Repeat("m", maxOriginLength+1)))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "origin_mount cannot be longer than")
+	err = ValidateConfig(makeConfig(srv.connectionParams(token), "origin_cluster", "a\nb"))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "origin_cluster cannot contain control characters")
+}
+
+func TestInfluxdb_EnforceExpiryContext(t *testing.T) {
+	const token = "root-token"
+	srv := newFakeInfluxServer(t, token)
+	srv.addBucket(srv.orgID("vault"), "telegraf")
+
+	db := new()
+	dbtesting.AssertInitialize(t, db, dbplugin.InitializeRequest{
+		Config:           srv.connectionParams(token),
+		VerifyConnection: true,
+	})
+	defer dbtesting.AssertClose(t, db)
+	require.True(t, db.expiries.loaded)
+
+	expired := newExpiringUser(t, db, time.Now().Add(-2*time.Minute))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	resp, err := db.EnforceExpiry(ctx)
+	require.ErrorIs(t, err, context.Canceled)
+	require.Empty(t, resp.Revoked)
+	require.Len(t, srv.userAuthorizations(expired), 1)
+}
@@ -0,0 +1,508 @@
+package influxdbv2
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/influxdata/influxdb-client-go/v2/domain"
+)
+
+// fakeInfluxServer is a minimal in-memory stand-in for the InfluxDB v2 HTTP
+// API. It implements just enough of the users, organizations, buckets and
+// authorizations endpoints to exercise the plugin without a docker container.
+type fakeInfluxServer struct {
+	*httptest.Server
+
+	sync.Mutex
+	nextID         int
+	orgs           []domain.Organization
+	buckets        []domain.Bucket
+	users          []domain.User
+	passwords      map[string]string
+	members        map[string][]string
+	authorizations []domain.Authorization
+
+	// calls counts requests by "METHOD /path".
+	calls map[string]int
+
+	// handlers overrides the default behavior for a "METHOD /path" key.
+	handlers map[string]http.HandlerFunc
+
+	// lostResponses counts, by "METHOD /path", requests that are applied
+	// but answered with an error, see loseResponses.
+	lostResponses map[string]int
+
+	// authorizationPageSize, if set, paginates the listing of
+	// authorizations, linking every page but the last to the next one.
+	authorizationPageSize int
+}
+
+func newFakeInfluxServer(t testing.TB, token string) *fakeInfluxServer {
+	t.Helper()
+
+	f := &fakeInfluxServer{
+		passwords: map[string]string{},
+		members:   map[string][]string{},
+		calls:     map[string]int{},
+		handlers:  map[string]http.HandlerFunc{},
+
+		lostResponses: map[string]int{},
+	}
+	org := f.addOrg("vault")
+	f.addBucket(org, "vault")
+	f.addAuthorization(token, "", operatorPermissions()...)
+
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serveHTTP))
+	t.Cleanup(f.Close)
+	return f
+}
+
+// connectionParams returns an Initialize config pointing at the fake server.
+// The fake server is consistent, so missing names aren't looked up again.
+func (f *fakeInfluxServer) connectionParams(token string) map[string]interface{} {
+	u, _ := url.Parse(f.URL)
+	return map[string]interface{}{
+		"host":                    u.Hostname(),
+		"port":                    u.Port(),
+		"token":                   token,
+		"organization":            "vault",
+		"resolution_retry_window": "0s",
+	}
+}
+
+func (f *fakeInfluxServer) id() string {
+	f.nextID++
+	return fmt.Sprintf("%016x", 0xa000000000000000+uint64(f.nextID))
+}
+
+func (f *fakeInfluxServer) addOrg(name string) string {
+	id := f.id()
+	f.orgs = append(f.orgs, domain.Organization{Id: &id, Name: name})
+	return id
+}
+
+func (f *fakeInfluxServer) addBucket(orgID, name string) string {
+	id := f.id()
+	f.buckets = append(f.buckets, domain.Bucket{Id: &id, Name: name, OrgID: &orgID})
+	return id
+}
+
+func (f *fakeInfluxServer) addAuthorization(token, orgID string, permissions ...domain.Permission) string {
+	id := f.id()
+	auth := domain.Authorization{
+		Id:          &id,
+		Token:       &token,
+		Permissions: &permissions,
+	}
+	if orgID != "" {
+		auth.OrgID = &orgID
+	}
+	f.authorizations = append(f.authorizations, auth)
+	return id
+}
+
+// addUser adds a user created outside of the plugin.
+func (f *fakeInfluxServer) addUser(name string) string {
+	f.Lock()
+	defer f.Unlock()
+	id := f.id()
+	f.users = append(f.users, domain.User{Id: &id, Name: name})
+	return id
+}
+
+// addUserAuthorization adds an authorization owned by a user, as created
+// outside of the plugin.
+func (f *fakeInfluxServer) addUserAuthorization(orgID, userID, description string, permissions ...domain.Permission) string {
+	f.Lock()
+	defer f.Unlock()
+	id := f.addAuthorization("token-"+f.id(), orgID, permissions...)
+	auth := &f.authorizations[len(f.authorizations)-1]
+	auth.UserID = &userID
+	auth.Description = &description
+	return id
+}
+
+// setPermissions replaces the permissions of the authorization for token.
+func (f *fakeInfluxServer) setPermissions(token string, permissions ...domain.Permission) {
+	f.Lock()
+	defer f.Unlock()
+	for idx := range f.authorizations {
+		if *f.authorizations[idx].Token == token {
+			f.authorizations[idx].Permissions = &permissions
+		}
+	}
+}
+
+func (f *fakeInfluxServer) orgID(name string) string {
+	f.Lock()
+	defer f.Unlock()
+	for _, org := range f.orgs {
+		if org.Name == name {
+			return *org.Id
+		}
+	}
+	return ""
+}
+
+func (f *fakeInfluxServer) callCount(key string) int {
+	f.Lock()
+	defer f.Unlock()
+	return f.calls[key]
+}
+
+func (f *fakeInfluxServer) handle(key string, h http.HandlerFunc) {
+	f.Lock()
+	defer f.Unlock()
+	f.handlers[key] = h
+}
+
+// unhandle restores the default behavior for key.
+func (f *fakeInfluxServer) unhandle(key string) {
+	f.Lock()
+	defer f.Unlock()
+	delete(f.handlers, key)
+}
+
+// loseResponses makes the next n requests for key take effect but answer
+// with a 503, as if the response had been lost on the way back.
+func (f *fakeInfluxServer) loseResponses(key string, n int) {
+	f.Lock()
+	defer f.Unlock()
+	f.lostResponses[key] = n
+}
+
+// userAuthorizations returns the authorizations owned by the named user.
+func (f *fakeInfluxServer) userAuthorizations(username string) []domain.Authorization {
+	f.Lock()
+	defer f.Unlock()
+	var userID string
+	for _, u := range f.users {
+		if u.Name == username {
+			userID = *u.Id
+		}
+	}
+	var res []domain.Authorization
+	for _, a := range f.authorizations {
+		if userID != "" && a.UserID != nil && *a.UserID == userID {
+			res = append(res, a)
+		}
+	}
+	return res
+}
+
+// authenticated reports whether r carries the token of an existing
+// authorization. It must be called with the lock held.
+func (f *fakeInfluxServer) authenticated(r *http.Request) bool {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Token ")
+	for _, a := range f.authorizations {
+		if a.Token != nil && *a.Token == token {
+			return true
+		}
+	}
+	return false
+}
+
+func operatorPermissions() []domain.Permission {
+	var permissions []domain.Permission
+	for _, resourceType := range []domain.ResourceType{
+		domain.ResourceTypeAuthorizations,
+		domain.ResourceTypeBuckets,
+		domain.ResourceTypeOrgs,
+		domain.ResourceTypeUsers,
+	} {
+		for _, action := range []domain.PermissionAction{domain.PermissionActionRead, domain.PermissionActionWrite} {
+			permissions = append(permissions, domain.Permission{
+				Action:   action,
+				Resource: domain.Resource{Type: resourceType},
+			})
+		}
+	}
+	return permissions
+}
+
+func permission(action domain.PermissionAction, resourceType domain.ResourceType, orgID string) domain.Permission {
+	p := domain.Permission{
+		Action:   action,
+		Resource: domain.Resource{Type: resourceType},
+	}
+	if orgID != "" {
+		p.Resource.OrgID = &orgID
+	}
+	return p
+}
+
+// page applies the limit and offset query parameters of r to a list of n items.
+func page(r *http.Request, n int) (int, int) {
+	start, end := 0, n
+	if v, err := strconv.Atoi(r.URL.Query().Get("offset")); err == nil {
+		start = v
+	}
+	if v, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && start+v < end {
+		end = start + v
+	}
+	if start > end {
+		start = end
+	}
+	return start, end
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, domain.Error{Code: domain.ErrorCode(code), Message: message})
+}
+
+func (f *fakeInfluxServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	path := r.URL.Path
+	// Collapse resource IDs so that "DELETE /api/v2/users/{id}" can be
+	// counted and overridden independently of the ID.
+	pieces := strings.Split(strings.TrimPrefix(path, "/api/v2/"), "/")
+	key := r.Method + " " + path
+	if strings.HasPrefix(path, "/api/v2/") && len(pieces) > 1 {
+		pieces[1] = "{id}"
+		key = r.Method + " /api/v2/" + strings.Join(pieces, "/")
+	}
+	f.calls[key]++
+	h, ok := f.handlers[key]
+	lose := f.lostResponses[key] > 0
+	if lose {
+		f.lostResponses[key]--
+	}
+	f.Unlock()
+	if ok {
+		h(w, r)
+		return
+	}
+	if lose {
+		// Apply the request, then fail as if the response never arrived.
+		f.serveDefault(httptest.NewRecorder(), r, key, path, pieces)
+		writeError(w, http.StatusServiceUnavailable, "unavailable", "response lost")
+		return
+	}
+	f.serveDefault(w, r, key, path, pieces)
+}
+
+func (f *fakeInfluxServer) serveDefault(w http.ResponseWriter, r *http.Request, key, path string, pieces []string) {
+	f.Lock()
+	defer f.Unlock()
+	if strings.HasPrefix(path, "/api/v2/") && !f.authenticated(r) {
+		writeError(w, http.StatusUnauthorized, "unauthorized", "unauthorized access")
+		return
+	}
+	id := ""
+	if len(pieces) > 1 {
+		id = strings.Split(strings.TrimPrefix(path, "/api/v2/"), "/")[1]
+	}
+
+	switch key {
+	case "GET /ping":
+		w.WriteHeader(http.StatusNoContent)
+	case "GET /health":
+		writeJSON(w, http.StatusOK, map[string]interface{}{"name": "influxdb", "status": "pass", "message": "ready for queries and writes", "version": "2.1.1"})
+	case "GET /ready":
+		writeJSON(w, http.StatusOK, map[string]interface{}{"status": "ready", "started": "2022-01-01T00:00:00Z", "up": "1h0m0s"})
+
+	case "GET /api/v2/authorizations":
+		q := r.URL.Query()
+		res := []domain.Authorization{}
+		for _, a := range f.authorizations {
+			if v := q.Get("userID"); v != "" && (a.UserID == nil || *a.UserID != v) {
+				continue
+			}
+			if v := q.Get("orgID"); v != "" && (a.OrgID == nil || *a.OrgID != v) {
+				continue
+			}
+			res = append(res, a)
+		}
+		var links *domain.Links
+		if f.authorizationPageSize > 0 {
+			offset, _ := strconv.Atoi(q.Get("offset"))
+			end := offset + f.authorizationPageSize
+			links = &domain.Links{Self: domain.Link(r.URL.RequestURI())}
+			if end < len(res) {
+				next := domain.Link(fmt.Sprintf("/api/v2/authorizations?offset=%d", end))
+				links.Next = &next
+			} else {
+				end = len(res)
+			}
+			if offset > end {
+				offset = end
+			}
+			res = res[offset:end]
+		}
+		writeJSON(w, http.StatusOK, domain.Authorizations{Authorizations: &res, Links: links})
+	case "POST /api/v2/authorizations":
+		var req domain.AuthorizationPostRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid", err.Error())
+			return
+		}
+		authID := f.id()
+		token := "token-" + authID
+		auth := domain.Authorization{
+			AuthorizationUpdateRequest: req.AuthorizationUpdateRequest,
+			Id:                         &authID,
+			Token:                      &token,
+			OrgID:                      req.OrgID,
+			UserID:                     req.UserID,
+			Permissions:                req.Permissions,
+		}
+		f.authorizations = append(f.authorizations, auth)
+		writeJSON(w, http.StatusCreated, auth)
+	case "GET /api/v2/authorizations/{id}":
+		for _, a := range f.authorizations {
+			if *a.Id == id {
+				writeJSON(w, http.StatusOK, a)
+				return
+			}
+		}
+		writeError(w, http.StatusNotFound, "not found", "authorization not found")
+	case "PATCH /api/v2/authorizations/{id}":
+		var req domain.AuthorizationUpdateRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid", err.Error())
+			return
+		}
+		for idx, a := range f.authorizations {
+			if *a.Id == id {
+				if req.Description != nil {
+					f.authorizations[idx].Description = req.Description
+				}
+				if req.Status != nil {
+					f.authorizations[idx].Status = req.Status
+				}
+				writeJSON(w, http.StatusOK, f.authorizations[idx])
+				return
+			}
+		}
+		writeError(w, http.StatusNotFound, "not found", "authorization not found")
+	case "DELETE /api/v2/authorizations/{id}":
+		for idx, a := range f.authorizations {
+			if *a.Id == id {
+				f.authorizations = append(f.authorizations[:idx], f.authorizations[idx+1:]...)
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+		writeError(w, http.StatusNotFound, "not found", "authorization not found")
+
+	case "GET /api/v2/orgs":
+		name := r.URL.Query().Get("org")
+		res := []domain.Organization{}
+		for _, org := range f.orgs {
+			if name == "" || org.Name == name {
+				res = append(res, org)
+			}
+		}
+		start, end := page(r, len(res))
+		res = res[start:end]
+		writeJSON(w, http.StatusOK, domain.Organizations{Orgs: &res})
+	case "POST /api/v2/orgs":
+		var req domain.Organization
+		json.NewDecoder(r.Body).Decode(&req)
+		orgID := f.id()
+		f.orgs = append(f.orgs, domain.Organization{Id: &orgID, Name: req.Name})
+		writeJSON(w, http.StatusCreated, f.orgs[len(f.orgs)-1])
+	case "GET /api/v2/orgs/{id}":
+		for _, org := range f.orgs {
+			if *org.Id == id {
+				writeJSON(w, http.StatusOK, org)
+				return
+			}
+		}
+		writeError(w, http.StatusNotFound, "not found", "organization not found")
+	case "POST /api/v2/orgs/{id}/members":
+		var req domain.AddResourceMemberRequestBody
+		json.NewDecoder(r.Body).Decode(&req)
+		f.members[id] = append(f.members[id], req.Id)
+		writeJSON(w, http.StatusCreated, domain.ResourceMember{UserResponse: domain.UserResponse{Id: &req.Id}})
+
+	case "GET /api/v2/buckets":
+		q := r.URL.Query()
+		res := []domain.Bucket{}
+		for _, b := range f.buckets {
+			if v := q.Get("orgID"); v != "" && *b.OrgID != v {
+				continue
+			}
+			if v := q.Get("name"); v != "" && b.Name != v {
+				continue
+			}
+			res = append(res, b)
+		}
+		start, end := page(r, len(res))
+		res = res[start:end]
+		writeJSON(w, http.StatusOK, domain.Buckets{Buckets: &res})
+	case "GET /api/v2/buckets/{id}":
+		for _, b := range f.buckets {
+			if *b.Id == id {
+				writeJSON(w, http.StatusOK, b)
+				return
+			}
+		}
+		writeError(w, http.StatusNotFound, "not found", "bucket not found")
+
+	case "GET /api/v2/users":
+		res := []domain.UserResponse{}
+		for _, u := range f.users {
+			res = append(res, domain.UserResponse{Id: u.Id, Name: u.Name})
+		}
+		writeJSON(w, http.StatusOK, domain.Users{Users: &res})
+	case "POST /api/v2/users":
+		var req domain.User
+		json.NewDecoder(r.Body).Decode(&req)
+		userID := f.id()
+		f.users = append(f.users, domain.User{Id: &userID, Name: req.Name})
+		writeJSON(w, http.StatusCreated, domain.UserResponse{Id: &userID, Name: req.Name})
+	case "GET /api/v2/users/{id}":
+		for _, u := range f.users {
+			if *u.Id == id {
+				writeJSON(w, http.StatusOK, domain.UserResponse{Id: u.Id, Name: u.Name})
+				return
+			}
+		}
+		writeError(w, http.StatusNotFound, "not found", "user not found")
+	case "GET /api/v2/me":
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Token ")
+		for _, a := range f.authorizations {
+			if *a.Token != token || a.UserID == nil {
+				continue
+			}
+			for _, u := range f.users {
+				if *u.Id == *a.UserID {
+					writeJSON(w, http.StatusOK, domain.UserResponse{Id: u.Id, Name: u.Name})
+					return
+				}
+			}
+		}
+		writeError(w, http.StatusNotFound, "not found", "user not found")
+	case "POST /api/v2/users/{id}/password":
+		var req domain.PasswordResetBody
+		json.NewDecoder(r.Body).Decode(&req)
+		f.passwords[id] = req.Password
+		w.WriteHeader(http.StatusNoContent)
+	case "DELETE /api/v2/users/{id}":
+		for idx, u := range f.users {
+			if *u.Id == id {
+				f.users = append(f.users[:idx], f.users[idx+1:]...)
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+		writeError(w, http.StatusNotFound, "not found", "user not found")
+
+	default:
+		writeError(w, http.StatusNotFound, "not found", "path not found: "+key)
+	}
+}
@@ -0,0 +1,183 @@
+package influxdbv2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/influxdata/influxdb-client-go/v2"
+	"github.com/influxdata/influxdb-client-go/v2/domain"
+)
+
+// defaultImportAllowedPermissions limit the authorizations ImportCredential
+// adopts when import_allowed_permissions is not configured.
+var defaultImportAllowedPermissions = []requiredPermission{
+	{Action: domain.PermissionActionRead, ResourceType: domain.ResourceTypeBuckets},
+	{Action: domain.PermissionActionWrite, ResourceType: domain.ResourceTypeBuckets},
+}
+
+// ImportCredentialRequest identifies an authorization created outside of
+// Vault and how to manage it once adopted.
+type ImportCredentialRequest struct {
+	// AuthorizationID is the ID of the authorization to adopt.
+	AuthorizationID string
+	// RoleName is the role the credential is recorded as issued for.
+	RoleName string
+	// Expiration is when the credential expires, if it does.
+	Expiration time.Time
+	// NonRevocable marks the credential so that DeleteUser, RevokeRole and
+	// EnforceExpiry leave it in place, for shared tokens Vault merely
+	// references.
+	NonRevocable bool
+}
+
+// ImportCredentialResponse describes an adopted credential.
+type ImportCredentialResponse struct {
+	// Username identifies the credential, as given to DeleteUser.
+	Username string
+}
+
+// ImportCredential adopts an authorization created outside of Vault as a
+// credential of the mount, for migrations. The authorization must belong to
+// the configured organization, must not be managed by Vault already, and may
+// only carry import_allowed_permissions. Its description is replaced by the
+// managed one, so that it is listed, expired and revoked like any credential
+// the mount issued, unless it is adopted as non-revocable.
+//
+// A credential is revoked by deleting its user along with every authorization
+// it owns, so only authorizations that are the sole authorization of their
+// user are adopted, and never one owned by the user of the configured token.
+func (i *InfluxdbV2) ImportCredential(ctx context.Context, req ImportCredentialRequest) (_ ImportCredentialResponse, err error) {
+	if req.AuthorizationID == "" {
+		return ImportCredentialResponse{}, fmt.Errorf("authorization ID cannot be empty")
+	}
+
+	i.Lock()
+	defer i.Unlock()
+
+	event := auditEvent{operation: "ImportCredential", role: req.RoleName, authorizationID: req.AuthorizationID, start: time.Now()}
+	defer func() { i.audit(event, err) }()
+
+	if err := i.requireInitialized("ImportCredential"); err != nil {
+		return ImportCredentialResponse{}, err
+	}
+	cli, err := i.getConnection(ctx)
+	if err != nil {
+		return ImportCredentialResponse{}, fmt.Errorf("unable to get connection: %w", err)
+	}
+
+	authorization, err := findAuthorizationByID(ctx, cli, req.AuthorizationID)
+	if err != nil {
+		return ImportCredentialResponse{}, fmt.Errorf("failed to look up authorization %q: %w", req.AuthorizationID, classify(err))
+	}
+	if authorization == nil {
+		return ImportCredentialResponse{}, withKind(ErrCredentialNotFound, fmt.Errorf("no authorization with ID %q exists", req.AuthorizationID))
+	}
+	if _, ok := parseDescription(stringValue(authorization.Description)); ok {
+		return ImportCredentialResponse{}, fmt.Errorf("authorization %q is already managed by Vault", req.AuthorizationID)
+	}
+
+	org, err := i.resolveDefaultOrganization(ctx, cli)
+	if err != nil {
+		return ImportCredentialResponse{}, fmt.Errorf("failed to run query in InfluxDB: %w", err)
+	}
+	event.organization = org.Name
+	if stringValue(authorization.OrgID) != *org.Id {
+		return ImportCredentialResponse{}, fmt.Errorf("authorization %q doesn't belong to organization %q", req.AuthorizationID, org.Name)
+	}
+
+	var permissions []domain.Permission
+	if authorization.Permissions != nil {
+		permissions = *authorization.Permissions
+	}
+	event.permissions = summarizePermissions(permissions)
+	if disallowed := disallowedPermissions(permissions, i.importAllowed); len(disallowed) > 0 {
+		return ImportCredentialResponse{}, fmt.Errorf("authorization %q carries permissions beyond import_allowed_permissions: %s", req.AuthorizationID, strings.Join(disallowed, ", "))
+	}
+
+	username, err := i.importableOwner(ctx, cli, authorization)
+	if err != nil {
+		return ImportCredentialResponse{}, err
+	}
+	event.username = username
+
+	metadata := i.origin(credentialMetadata{
+		Username: username,
+		Role:     req.RoleName,
+		Session:  i.SessionName,
+		Expires:  req.Expiration,
+
+		NonRevocable: req.NonRevocable,
+	})
+	if err := setAuthorizationDescription(ctx, cli, req.AuthorizationID, metadata.description()); err != nil {
+		return ImportCredentialResponse{}, fmt.Errorf("failed to tag authorization %q: %w", req.AuthorizationID, classify(err))
+	}
+	if !req.NonRevocable {
+		i.scheduleExpiry(username, req.Expiration, req.AuthorizationID)
+	}
+	return ImportCredentialResponse{Username: username}, nil
+}
+
+// importableOwner returns the name of the user owning authorization, failing
+// if revoking the adopted credential would delete more than authorization:
+// the user of the configured token, or any other authorization of the user.
+func (i *influxdbConnectionProducer) importableOwner(ctx context.Context, cli influxdb2.Client, authorization *domain.Authorization) (string, error) {
+	id := stringValue(authorization.Id)
+	userID := stringValue(authorization.UserID)
+	if userID == "" {
+		return "", fmt.Errorf("authorization %q isn't owned by a user", id)
+	}
+
+	var owned *[]domain.Authorization
+	err := retry(ctx, func(int) error {
+		var err error
+		owned, err = cli.AuthorizationsAPI().FindAuthorizationsByUserID(ctx, userID)
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to list the authorizations of the owner of %q: %w", id, classify(err))
+	}
+	for _, other := range *owned {
+		if stringValue(other.Token) == i.Token {
+			return "", errors.New("refusing to adopt an authorization owned by the user of the configured token, revoking it would delete that user")
+		}
+		if stringValue(other.Id) != id {
+			return "", fmt.Errorf("refusing to adopt authorization %q, its user owns other authorizations that revoking it would delete", id)
+		}
+	}
+
+	if username := stringValue(authorization.User); username != "" {
+		return username, nil
+	}
+	var user *domain.User
+	err = retry(ctx, func(int) error {
+		var err error
+		user, err = cli.UsersAPI().FindUserByID(ctx, userID)
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to look up the owner of %q: %w", id, classify(err))
+	}
+	return user.Name, nil
+}
+
+// disallowedPermissions describes the permissions that allowed doesn't cover.
+func disallowedPermissions(permissions []domain.Permission, allowed []requiredPermission) []string {
+	var disallowed []string
+	for _, permission := range permissions {
+		p := requiredPermission{Action: permission.Action, ResourceType: permission.Resource.Type}
+		found := false
+		for _, a := range allowed {
+			if a == p {
+				found = true
+				break
+			}
+		}
+		if !found {
+			disallowed = append(disallowed, p.String())
+		}
+	}
+	return disallowed
+}
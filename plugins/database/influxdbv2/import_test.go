@@ -0,0 +1,131 @@
+package influxdbv2
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/database/dbplugin/v5"
+	dbtesting "github.com/hashicorp/vault/sdk/database/dbplugin/v5/testing"
+	"github.com/influxdata/influxdb-client-go/v2/domain"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInfluxdb_ImportCredential(t *testing.T) {
+	const token = "root-token"
+	srv := newFakeInfluxServer(t, token)
+	orgID := srv.orgID("vault")
+	otherOrgID := srv.addOrg("other")
+	readBuckets := permission(domain.PermissionActionRead, domain.ResourceTypeBuckets, orgID)
+	writeUsers := permission(domain.PermissionActionWrite, domain.ResourceTypeUsers, orgID)
+
+	db := new()
+	dbtesting.AssertInitialize(t, db, dbplugin.InitializeRequest{
+		Config: srv.connectionParams(token),
+	})
+	defer dbtesting.AssertClose(t, db)
+
+	importCredential := func(id string) (ImportCredentialResponse, error) {
+		return db.ImportCredential(context.Background(), ImportCredentialRequest{
+			AuthorizationID: id,
+			RoleName:        "migrated",
+			Expiration:      time.Now().Add(time.Hour),
+		})
+	}
+
+	// An authorization created out of band is adopted and then managed like
+	// any other credential.
+	userID := srv.addUser("telegraf-agent")
+	id := srv.addUserAuthorization(orgID, userID, "legacy telegraf token", readBuckets)
+	resp, err := importCredential(id)
+	require.NoError(t, err)
+	require.Equal(t, "telegraf-agent", resp.Username)
+
+	credential, err := db.CredentialPermissions(context.Background(), resp.Username)
+	require.NoError(t, err)
+	require.Len(t, credential, 1)
+	require.Equal(t, id, credential[0].ID)
+	require.Equal(t, "migrated", credential[0].Role)
+	require.Equal(t, []string{id}, db.expiries.entries[resp.Username].authorizationIDs)
+
+	_, err = importCredential(id)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "already managed by Vault")
+
+	dbtesting.AssertDeleteUser(t, db, dbplugin.DeleteUserRequest{Username: resp.Username})
+	_, err = db.CredentialPermissions(context.Background(), id)
+	require.True(t, errors.Is(err, ErrCredentialNotFound), err)
+
+	t.Run("refusals", func(t *testing.T) {
+		_, err := importCredential("0123456789abcdef")
+		require.True(t, errors.Is(err, ErrCredentialNotFound), err)
+
+		userID := srv.addUser("other-org-agent")
+		_, err = importCredential(srv.addUserAuthorization(otherOrgID, userID, "", permission(domain.PermissionActionRead, domain.ResourceTypeBuckets, otherOrgID)))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), `doesn't belong to organization "vault"`)
+
+		userID = srv.addUser("admin-agent")
+		_, err = importCredential(srv.addUserAuthorization(orgID, userID, "", readBuckets, writeUsers))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "beyond import_allowed_permissions: users:write")
+
+		userID = srv.addUser("shared-agent")
+		id := srv.addUserAuthorization(orgID, userID, "", readBuckets)
+		srv.addUserAuthorization(orgID, userID, "", readBuckets)
+		_, err = importCredential(id)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "owns other authorizations")
+
+		userID = srv.addUser("operator")
+		srv.Lock()
+		for idx := range srv.authorizations {
+			if *srv.authorizations[idx].Token == token {
+				srv.authorizations[idx].UserID = &userID
+			}
+		}
+		srv.Unlock()
+		_, err = importCredential(srv.addUserAuthorization(orgID, userID, "", readBuckets))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "owned by the user of the configured token")
+	})
+
+	t.Run("non-revocable", func(t *testing.T) {
+		userID := srv.addUser("shared-dashboard")
+		id := srv.addUserAuthorization(orgID, userID, "shared dashboard token", readBuckets)
+		resp, err := db.ImportCredential(context.Background(), ImportCredentialRequest{
+			AuthorizationID: id,
+			RoleName:        "referenced",
+			Expiration:      time.Now().Add(-time.Hour),
+			NonRevocable:    true,
+		})
+		require.NoError(t, err)
+		require.NotContains(t, db.expiries.entries, resp.Username)
+
+		enforced, err := db.EnforceExpiry(context.Background())
+		require.NoError(t, err)
+		require.NotContains(t, enforced.Revoked, resp.Username)
+
+		dbtesting.AssertDeleteUser(t, db, dbplugin.DeleteUserRequest{Username: resp.Username})
+		credential, err := db.CredentialPermissions(context.Background(), resp.Username)
+		require.NoError(t, err)
+		require.Len(t, credential, 1)
+		require.Equal(t, id, credential[0].ID)
+	})
+
+	t.Run("configured limit", func(t *testing.T) {
+		db := new()
+		dbtesting.AssertInitialize(t, db, dbplugin.InitializeRequest{
+			Config: makeConfig(srv.connectionParams(token), "import_allowed_permissions", "buckets:read,users:write"),
+		})
+		defer dbtesting.AssertClose(t, db)
+
+		userID := srv.addUser("user-manager")
+		resp, err := db.ImportCredential(context.Background(), ImportCredentialRequest{
+			AuthorizationID: srv.addUserAuthorization(orgID, userID, "", readBuckets, writeUsers),
+		})
+		require.NoError(t, err)
+		require.Equal(t, "user-manager", resp.Username)
+	})
+}
@@ -2,12 +2,17 @@ package influxdbv2
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"time"
 
+	log "github.com/hashicorp/go-hclog"
 	"github.com/hashicorp/go-secure-stdlib/strutil"
 	"github.com/hashicorp/vault/sdk/database/dbplugin/v5"
 	"github.com/hashicorp/vault/sdk/helper/template"
 	"github.com/influxdata/influxdb-client-go/v2"
+	ihttp "github.com/influxdata/influxdb-client-go/v2/api/http"
+	"github.com/influxdata/influxdb-client-go/v2/domain"
 )
 
 const (
@@ -36,6 +41,7 @@ func New() (interface{}, error) {
 func new() *InfluxdbV2 {
 	connProducer := &influxdbConnectionProducer{}
 	connProducer.Type = influxdbTypeName
+	connProducer.logger = log.Default()
 
 	return &InfluxdbV2{
 		influxdbConnectionProducer: connProducer,
@@ -47,6 +53,18 @@ func (i *InfluxdbV2) Type() (string, error) {
 	return influxdbTypeName, nil
 }
 
+// requireInitialized fails credential operations made before Initialize has
+// succeeded, such as one racing with the first Initialize, with
+// ErrNotInitialized. It must be called with the lock held, which Initialize
+// holds throughout, so an operation waiting for the lock sees the outcome of
+// the Initialize it raced with.
+func (i *InfluxdbV2) requireInitialized(operation string) error {
+	if !i.Initialized {
+		return withKind(ErrNotInitialized, fmt.Errorf("%s: the connection is not yet initialized, configure it first", operation))
+	}
+	return nil
+}
+
 func (i *InfluxdbV2) getConnection(ctx context.Context) (influxdb2.Client, error) {
 	cli, err := i.Connection(ctx)
 	if err != nil {
@@ -69,12 +87,14 @@ func (i *InfluxdbV2) Initialize(ctx context.Context, req dbplugin.InitializeRequ
 	if err != nil {
 		return dbplugin.InitializeResponse{}, fmt.Errorf("unable to initialize username template: %w", err)
 	}
-	i.usernameProducer = up
-
-	_, err = i.usernameProducer.Generate(dbplugin.UsernameMetadata{})
+	_, err = up.Generate(dbplugin.UsernameMetadata{})
 	if err != nil {
 		return dbplugin.InitializeResponse{}, fmt.Errorf("invalid username template: %w", err)
 	}
+	// NewUser reads the template under the lock.
+	i.Lock()
+	i.usernameProducer = up
+	i.Unlock()
 
 	return i.influxdbConnectionProducer.Initialize(ctx, req)
 }
@@ -84,35 +104,91 @@ func (i *InfluxdbV2) NewUser(ctx context.Context, req dbplugin.NewUserRequest) (
 	i.Lock()
 	defer i.Unlock()
 
+	event := auditEvent{operation: "NewUser", role: req.UsernameConfig.RoleName, start: time.Now()}
+	defer func() { i.audit(event, err, req.Password) }()
+
+	if err := i.requireInitialized("NewUser"); err != nil {
+		return dbplugin.NewUserResponse{}, err
+	}
+	stmt, err := parseCreationStatements(req.Statements)
+	if err != nil {
+		return dbplugin.NewUserResponse{}, err
+	}
 	cli, err := i.getConnection(ctx)
 	if err != nil {
 		return dbplugin.NewUserResponse{}, fmt.Errorf("unable to get connection: %w", err)
 	}
+	if err := i.requireCapabilities(capabilityCreateUsers); err != nil {
+		return dbplugin.NewUserResponse{}, err
+	}
 
 	username, err := i.usernameProducer.Generate(req.UsernameConfig)
 	if err != nil {
 		return dbplugin.NewUserResponse{}, err
 	}
+	event.username = username
 
-	user, err := cli.UsersAPI().CreateUserWithName(ctx, username)
+	var organization *domain.Organization
+	if stmt.Organization != "" {
+		organization, err = i.resolveOrganization(ctx, cli, stmt.Organization)
+	} else {
+		organization, err = i.resolveDefaultOrganization(ctx, cli)
+	}
 	if err != nil {
-		// Attempt rollback only when the response has an error
-		err2 := cli.UsersAPI().DeleteUser(ctx, user)
-		if err2 != nil {
-			return dbplugin.NewUserResponse{}, fmt.Errorf("failed to rollback query in InfluxDB: %w : %s", err, err2)
-		}
 		return dbplugin.NewUserResponse{}, fmt.Errorf("failed to run query in InfluxDB: %w", err)
 	}
-	err = cli.UsersAPI().UpdateUserPassword(ctx, user, req.Password)
+	event.organization = organization.Name
+	bucketOrganization, err := i.resolveBucketOrganization(ctx, cli, organization)
 	if err != nil {
-		// Attempt rollback only when the response has an error
-		err2 := cli.UsersAPI().DeleteUser(ctx, user)
-		if err2 != nil {
-			return dbplugin.NewUserResponse{}, fmt.Errorf("failed to rollback query in InfluxDB: %w : %s", err, err2)
+		return dbplugin.NewUserResponse{}, fmt.Errorf("failed to run query in InfluxDB: %w", err)
+	}
+	permissions, err := i.requestedPermissions(ctx, cli, bucketOrganization, stmt)
+	if err != nil {
+		return dbplugin.NewUserResponse{}, err
+	}
+	if stmt.NonRevocable && len(permissions) == 0 {
+		// The flag is recorded in the description of the authorization.
+		return dbplugin.NewUserResponse{}, fmt.Errorf("invalid creation statement: \"revocable\" requires permissions to grant")
+	}
+	if len(permissions) > 0 {
+		needs := []capability{capabilityCreateTokens}
+		if grantsDBRPWrite(permissions) {
+			needs = append(needs, capabilityCreateDBRPs)
+		}
+		if err := i.requireCapabilities(needs...); err != nil {
+			return dbplugin.NewUserResponse{}, err
+		}
+		err = i.checkOrgAccess(ctx, cli, *organization.Id, organization.Name)
+		if err != nil {
+			return dbplugin.NewUserResponse{}, err
+		}
+		permissions, err = i.resolvePermissions(ctx, cli, *bucketOrganization.Id, permissions)
+		if err != nil {
+			return dbplugin.NewUserResponse{}, fmt.Errorf("failed to run query in InfluxDB: %w", err)
+		}
+		permissions, err = mergePermissions(*organization.Id, permissions)
+		if err != nil {
+			return dbplugin.NewUserResponse{}, fmt.Errorf("invalid creation statement: %w", err)
+		}
+		if err := i.checkPermissionLimits(permissions); err != nil {
+			return dbplugin.NewUserResponse{}, fmt.Errorf("invalid creation statement: %w", err)
+		}
+	}
+	event.permissions = summarizePermissions(permissions)
+
+	user, err := cli.UsersAPI().CreateUserWithName(ctx, username)
+	if err != nil {
+		// The user may have been created even though the response was lost,
+		// so look it up by its unique name before attempting a rollback.
+		if created, err2 := findUserByName(ctx, cli, username); err2 == nil {
+			err2 = cli.UsersAPI().DeleteUser(ctx, created)
+			if err2 != nil {
+				return dbplugin.NewUserResponse{}, fmt.Errorf("failed to rollback query in InfluxDB: %w : %s", err, err2)
+			}
 		}
 		return dbplugin.NewUserResponse{}, fmt.Errorf("failed to run query in InfluxDB: %w", err)
 	}
-	organization, err := cli.OrganizationsAPI().FindOrganizationByName(ctx, i.Organization)
+	err = cli.UsersAPI().UpdateUserPassword(ctx, user, req.Password)
 	if err != nil {
 		// Attempt rollback only when the response has an error
 		err2 := cli.UsersAPI().DeleteUser(ctx, user)
@@ -130,18 +206,164 @@ func (i *InfluxdbV2) NewUser(ctx context.Context, req dbplugin.NewUserRequest) (
 		}
 		return dbplugin.NewUserResponse{}, fmt.Errorf("failed to run query in InfluxDB: %w", err)
 	}
+	if len(permissions) > 0 {
+		metadata := i.origin(credentialMetadata{
+			Username: username,
+			Role:     req.UsernameConfig.RoleName,
+			Session:  i.SessionName,
+			Expires:  req.Expiration,
+
+			NonRevocable: stmt.NonRevocable,
+		})
+		created, err := createAuthorization(ctx, cli, *organization.Id, *user.Id, metadata, permissions)
+		if err != nil {
+			// Attempt rollback only when the response has an error
+			err2 := cli.UsersAPI().DeleteUser(ctx, user)
+			if err2 != nil {
+				return dbplugin.NewUserResponse{}, fmt.Errorf("failed to rollback query in InfluxDB: %w : %s", err, err2)
+			}
+			return dbplugin.NewUserResponse{}, fmt.Errorf("failed to run query in InfluxDB: %w", err)
+		}
+		event.authorizationID = stringValue(created.Id)
+		if i.VerifyCreatedCredential {
+			if err := i.verifyCredential(ctx, cli, created); err != nil {
+				if err2 := purgeUser(ctx, cli, username, i.revocationAttempts()); err2 != nil {
+					return dbplugin.NewUserResponse{}, fmt.Errorf("%w, and revoking it failed: %s", err, err2)
+				}
+				return dbplugin.NewUserResponse{}, err
+			}
+		}
+		if !stmt.NonRevocable {
+			i.scheduleExpiry(username, req.Expiration, stringValue(created.Id))
+		}
+	}
 	resp = dbplugin.NewUserResponse{
 		Username: username,
 	}
 	return resp, nil
 }
 
-func deleteUser(ctx context.Context, cli influxdb2.Client, username string) error {
-	user, err := cli.UsersAPI().FindUserByName(ctx, username)
+// createAuthorization creates an authorization owned by the given user. Any
+// permission that isn't already scoped to an organization is scoped to orgID.
+func createAuthorization(ctx context.Context, cli influxdb2.Client, orgID, userID string, metadata credentialMetadata, permissions []domain.Permission) (*domain.Authorization, error) {
+	scoped := make([]domain.Permission, len(permissions))
+	for idx, permission := range permissions {
+		if permission.Resource.OrgID == nil {
+			permission.Resource.OrgID = &orgID
+		}
+		scoped[idx] = permission
+	}
+
+	status := domain.AuthorizationUpdateRequestStatusActive
+	description := metadata.description()
+	authorization := &domain.Authorization{
+		AuthorizationUpdateRequest: domain.AuthorizationUpdateRequest{
+			Description: &description,
+			Status:      &status,
+		},
+		OrgID:       &orgID,
+		UserID:      &userID,
+		Permissions: &scoped,
+	}
+
+	// The description is unique to the credential, so it doubles as an
+	// idempotency key: a create whose response was lost is found again
+	// rather than repeated.
+	var created *domain.Authorization
+	err := retry(ctx, func(attempt int) error {
+		if attempt > 0 {
+			existing, err := findAuthorizationByDescription(ctx, cli, userID, description)
+			if err != nil {
+				return err
+			}
+			if existing != nil {
+				created = existing
+				return nil
+			}
+		}
+		var err error
+		created, err = cli.AuthorizationsAPI().CreateAuthorization(ctx, authorization)
+		return err
+	})
+	return created, err
+}
+
+// findAuthorizationByDescription returns the authorization of the given user
+// with the given description, or nil if there is none.
+func findAuthorizationByDescription(ctx context.Context, cli influxdb2.Client, userID, description string) (*domain.Authorization, error) {
+	authorizations, err := cli.AuthorizationsAPI().FindAuthorizationsByUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, authorization := range *authorizations {
+		if authorization.Description != nil && *authorization.Description == description {
+			return &authorization, nil
+		}
+	}
+	return nil, nil
+}
+
+// deleteUser deletes the user and its authorizations, making up to attempts
+// attempts at each step. A step answered with a 404 is done already, either
+// by an earlier attempt whose response was lost or by someone else, and so is
+// the whole deletion if the user can't be found. It fails
+// with ErrCredentialNotRevocable, deleting nothing, if any of the
+// authorizations is marked non-revocable.
+func deleteUser(ctx context.Context, cli influxdb2.Client, username string, attempts int) error {
+	return removeUser(ctx, cli, username, attempts, true)
+}
+
+// purgeUser deletes the user and its authorizations like deleteUser, whether
+// or not they are marked non-revocable, to roll back a credential that was
+// never handed out.
+func purgeUser(ctx context.Context, cli influxdb2.Client, username string, attempts int) error {
+	return removeUser(ctx, cli, username, attempts, false)
+}
+
+func removeUser(ctx context.Context, cli influxdb2.Client, username string, attempts int, checkRevocable bool) error {
+	user, err := findUserByName(ctx, cli, username)
+	if errors.Is(err, ErrCredentialNotFound) {
+		return nil
+	}
 	if err != nil {
 		return err
 	}
-	err = cli.UsersAPI().DeleteUser(ctx, user)
+	var authorizations *[]domain.Authorization
+	err = retryN(ctx, attempts, func(int) error {
+		var err error
+		authorizations, err = cli.AuthorizationsAPI().FindAuthorizationsByUserID(ctx, *user.Id)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if checkRevocable {
+		for _, authorization := range *authorizations {
+			if metadata, ok := parseDescription(stringValue(authorization.Description)); ok && metadata.NonRevocable {
+				return withKind(ErrCredentialNotRevocable, fmt.Errorf("authorization %q of %q is marked non-revocable", stringValue(authorization.Id), username))
+			}
+		}
+	}
+	for _, authorization := range *authorizations {
+		authorization := authorization
+		err = retryN(ctx, attempts, func(int) error {
+			err := cli.AuthorizationsAPI().DeleteAuthorization(ctx, &authorization)
+			if isNotFound(err) {
+				return nil
+			}
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	err = retryN(ctx, attempts, func(int) error {
+		err := cli.UsersAPI().DeleteUser(ctx, user)
+		if isNotFound(err) {
+			return nil
+		}
+		return err
+	})
 	if err != nil {
 		return err
 	}
@@ -149,23 +371,135 @@ func deleteUser(ctx context.Context, cli influxdb2.Client, username string) erro
 	return nil
 }
 
-func (i *InfluxdbV2) DeleteUser(ctx context.Context, req dbplugin.DeleteUserRequest) (dbplugin.DeleteUserResponse, error) {
+// findUserByName returns the user named username, failing with
+// ErrCredentialNotFound if there is none. The client's own lookup reports a
+// missing user with a plain error, indistinguishable from others.
+func findUserByName(ctx context.Context, cli influxdb2.Client, username string) (*domain.User, error) {
+	var users *[]domain.User
+	err := retry(ctx, func(int) error {
+		var err error
+		users, err = cli.UsersAPI().GetUsers(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, user := range *users {
+		if user.Name == username {
+			user := user
+			return &user, nil
+		}
+	}
+	return nil, withKind(ErrCredentialNotFound, fmt.Errorf("user '%s' not found", username))
+}
+
+func (i *InfluxdbV2) DeleteUser(ctx context.Context, req dbplugin.DeleteUserRequest) (_ dbplugin.DeleteUserResponse, err error) {
 	i.Lock()
 	defer i.Unlock()
 
+	event := i.auditEventFor("DeleteUser", req.Username)
+	defer func() { i.audit(event, err) }()
+
+	if err := i.requireInitialized("DeleteUser"); err != nil {
+		return dbplugin.DeleteUserResponse{}, err
+	}
+
 	cli, err := i.getConnection(ctx)
 	if err != nil {
 		return dbplugin.DeleteUserResponse{}, fmt.Errorf("unable to get connection: %w", err)
 	}
 
-	err = deleteUser(ctx, cli, req.Username)
+	err = deleteUser(ctx, cli, req.Username, i.revocationAttempts())
+	if errors.Is(err, ErrCredentialNotRevocable) {
+		// Vault is done with the credential, but whoever shares it isn't.
+		i.logger.Info("leaving non-revocable credential in place", "username", req.Username, "reason", err)
+		i.unscheduleExpiry(req.Username)
+		event.retained = true
+		return dbplugin.DeleteUserResponse{}, nil
+	}
 	if err != nil {
-		return dbplugin.DeleteUserResponse{}, fmt.Errorf("failed to delete user cleanly: %w", err)
+		err = fmt.Errorf("failed to delete user cleanly: %w", err)
+		var httpErr *ihttp.Error
+		if errors.As(err, &httpErr) {
+			// The credential may still be live.
+			err = withKind(ErrRevocationFailed, err)
+		}
+		return dbplugin.DeleteUserResponse{}, err
 	}
+	i.unscheduleExpiry(req.Username)
 	return dbplugin.DeleteUserResponse{}, nil
 }
 
-func (i *InfluxdbV2) UpdateUser(ctx context.Context, req dbplugin.UpdateUserRequest) (dbplugin.UpdateUserResponse, error) {
+// RevokeRoleResponse summarizes the outcome of RevokeRole.
+type RevokeRoleResponse struct {
+	// Revoked lists the IDs of the authorizations that were revoked, or that
+	// would have been revoked in a dry run.
+	Revoked []string
+
+	// Failed maps the IDs of authorizations that could not be revoked to the
+	// error encountered.
+	Failed map[string]error
+
+	// Retained lists the IDs of the authorizations left in place because
+	// they are marked non-revocable.
+	Retained []string
+}
+
+// RevokeRole revokes every credential issued for the given role, identified
+// by the role name embedded in the description of its authorization. Each
+// matching credential is deleted the same way DeleteUser deletes it, unless
+// it is marked non-revocable. When
+// dryRun is set the matching authorizations are only reported. Revocation
+// stops early, reporting what was done so far, if ctx is done.
+func (i *InfluxdbV2) RevokeRole(ctx context.Context, roleName string, dryRun bool) (RevokeRoleResponse, error) {
+	if roleName == "" {
+		return RevokeRoleResponse{}, fmt.Errorf("role name cannot be empty")
+	}
+
+	i.Lock()
+	defer i.Unlock()
+
+	if err := i.requireInitialized("RevokeRole"); err != nil {
+		return RevokeRoleResponse{}, err
+	}
+	cli, err := i.getConnection(ctx)
+	if err != nil {
+		return RevokeRoleResponse{}, fmt.Errorf("unable to get connection: %w", err)
+	}
+
+	authorizations, err := listManagedAuthorizations(ctx, cli, i.maxAuthorizationPages())
+	if err != nil {
+		return RevokeRoleResponse{}, fmt.Errorf("failed to list authorizations: %w", err)
+	}
+
+	resp := RevokeRoleResponse{
+		Failed: map[string]error{},
+	}
+	for _, authorization := range authorizations {
+		if authorization.metadata.Role != roleName {
+			continue
+		}
+		if authorization.metadata.NonRevocable {
+			resp.Retained = append(resp.Retained, *authorization.Id)
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return resp, err
+		}
+		if !dryRun {
+			err = deleteUser(ctx, cli, authorization.metadata.Username, i.revocationAttempts())
+			if err != nil {
+				resp.Failed[*authorization.Id] = err
+				continue
+			}
+			i.unscheduleExpiry(authorization.metadata.Username)
+		}
+		resp.Revoked = append(resp.Revoked, *authorization.Id)
+	}
+	return resp, nil
+}
+
+func (i *InfluxdbV2) UpdateUser(ctx context.Context, req dbplugin.UpdateUserRequest) (_ dbplugin.UpdateUserResponse, err error) {
 	if req.Password == nil && req.Expiration == nil {
 		return dbplugin.UpdateUserResponse{}, fmt.Errorf("no changes requested")
 	}
@@ -173,13 +507,36 @@ func (i *InfluxdbV2) UpdateUser(ctx context.Context, req dbplugin.UpdateUserRequ
 	i.Lock()
 	defer i.Unlock()
 
+	event := i.auditEventFor("UpdateUser", req.Username)
+	var password string
+	if req.Password != nil {
+		event.changes = append(event.changes, "password")
+		password = req.Password.NewPassword
+	}
+	if req.Expiration != nil {
+		event.changes = append(event.changes, "expiration")
+	}
+	defer func() { i.audit(event, err, password) }()
+
+	if err := i.requireInitialized("UpdateUser"); err != nil {
+		return dbplugin.UpdateUserResponse{}, err
+	}
 	if req.Password != nil {
 		err := i.changeUserPassword(ctx, req.Username, req.Password)
 		if err != nil {
 			return dbplugin.UpdateUserResponse{}, fmt.Errorf("failed to change %q password: %w", req.Username, err)
 		}
 	}
-	// Expiration is a no-op
+	if req.Expiration != nil {
+		cli, err := i.getConnection(ctx)
+		if err != nil {
+			return dbplugin.UpdateUserResponse{}, fmt.Errorf("unable to get connection: %w", err)
+		}
+		err = i.updateExpiry(ctx, cli, req.Username, req.Expiration.NewExpiration)
+		if err != nil {
+			return dbplugin.UpdateUserResponse{}, fmt.Errorf("failed to change %q expiration: %w", req.Username, err)
+		}
+	}
 	return dbplugin.UpdateUserResponse{}, nil
 }
 
@@ -188,11 +545,13 @@ func (i *InfluxdbV2) changeUserPassword(ctx context.Context, username string, ch
 	if err != nil {
 		return fmt.Errorf("unable to get connection: %w", err)
 	}
-	user, err := cli.UsersAPI().FindUserByName(ctx, username)
+	user, err := findUserByName(ctx, cli, username)
 	if err != nil {
 		return err
 	}
-	err = cli.UsersAPI().UpdateUserPassword(ctx, user, changePassword.NewPassword)
+	err = retry(ctx, func(int) error {
+		return cli.UsersAPI().UpdateUserPassword(ctx, user, changePassword.NewPassword)
+	})
 	if err != nil {
 		return err
 	}
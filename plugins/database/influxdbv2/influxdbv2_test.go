@@ -3,11 +3,13 @@ package influxdbv2
 import (
 	"context"
 	"fmt"
+	"net/http"
 	"net/url"
 	"os"
 	"reflect"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -15,6 +17,7 @@ import (
 	"github.com/hashicorp/vault/sdk/database/dbplugin/v5"
 	dbtesting "github.com/hashicorp/vault/sdk/database/dbplugin/v5/testing"
 	influx "github.com/influxdata/influxdb-client-go/v2"
+	"github.com/influxdata/influxdb-client-go/v2/domain"
 	"github.com/stretchr/testify/require"
 )
 
@@ -416,9 +419,10 @@ func TestInfluxdb_RevokeDeletedUser(t *testing.T) {
 	}
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
+	// A user that is already gone counts as revoked.
 	_, err := db.DeleteUser(ctx, delReq)
-	if err == nil {
-		t.Fatalf("Expected err, got nil")
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
 	}
 }
 
@@ -513,3 +517,300 @@ func testUserHasOrganization(username, organizationName string, c Config, ctx co
 	}
 	return fmt.Errorf("user %s does not belong to organization %s", username, organizationName)
 }
+
+func TestInfluxdb_NewUser_OrganizationOverride(t *testing.T) {
+	const token = "root-token"
+	srv := newFakeInfluxServer(t, token)
+	otherOrgID := srv.addOrg("other")
+	srv.addOrg("denied")
+	vaultOrgID := srv.orgID("vault")
+	srv.setPermissions(token,
+		permission(domain.PermissionActionRead, domain.ResourceTypeUsers, ""),
+		permission(domain.PermissionActionWrite, domain.ResourceTypeUsers, ""),
+		permission(domain.PermissionActionRead, domain.ResourceTypeOrgs, ""),
+		permission(domain.PermissionActionWrite, domain.ResourceTypeOrgs, ""),
+		permission(domain.PermissionActionRead, domain.ResourceTypeAuthorizations, ""),
+		permission(domain.PermissionActionWrite, domain.ResourceTypeAuthorizations, vaultOrgID),
+		permission(domain.PermissionActionWrite, domain.ResourceTypeAuthorizations, otherOrgID),
+	)
+
+	db := new()
+	dbtesting.AssertInitialize(t, db, dbplugin.InitializeRequest{
+		Config:           srv.connectionParams(token),
+		VerifyConnection: true,
+	})
+	defer dbtesting.AssertClose(t, db)
+
+	newUserReq := func(org string) dbplugin.NewUserRequest {
+		return dbplugin.NewUserRequest{
+			UsernameConfig: dbplugin.UsernameMetadata{
+				DisplayName: "test",
+				RoleName:    "test",
+			},
+			Statements: dbplugin.Statements{
+				Commands: []string{fmt.Sprintf(`{"organization": %q, "permissions": [{"action": "read", "resource": {"type": "buckets"}}]}`, org)},
+			},
+			Password:   "nuozxby98523u89bdfnkjl",
+			Expiration: time.Now().Add(1 * time.Minute),
+		}
+	}
+
+	resp := dbtesting.AssertNewUser(t, db, newUserReq("other"))
+	username := resp.Username
+	auths := srv.userAuthorizations(username)
+	require.Len(t, auths, 1)
+	require.Equal(t, otherOrgID, *auths[0].OrgID)
+	require.Equal(t, otherOrgID, *(*auths[0].Permissions)[0].Resource.OrgID)
+
+	// The successful check for "other" is cached.
+	calls := srv.callCount("GET /api/v2/authorizations")
+	dbtesting.AssertNewUser(t, db, newUserReq("other"))
+	require.Equal(t, calls, srv.callCount("GET /api/v2/authorizations"))
+
+	_, err := db.NewUser(context.Background(), newUserReq("denied"))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), `organization "denied"`)
+
+	// Legacy, non-JSON statements still create a plain user.
+	legacyReq := newUserReq("")
+	legacyReq.Statements.Commands = []string{createUserStatements}
+	resp = dbtesting.AssertNewUser(t, db, legacyReq)
+	require.Empty(t, srv.userAuthorizations(resp.Username))
+
+	// Deleting the user also deletes the authorizations it owns.
+	dbtesting.AssertDeleteUser(t, db, dbplugin.DeleteUserRequest{Username: username})
+	srv.Lock()
+	for _, auth := range srv.authorizations {
+		require.False(t, auth.UserID != nil && *auth.UserID == *auths[0].UserID, "authorization %s was not deleted", *auth.Id)
+	}
+	srv.Unlock()
+}
+
+func newTestCredential(t *testing.T, db *InfluxdbV2, roleName string) string {
+	t.Helper()
+	resp := dbtesting.AssertNewUser(t, db, dbplugin.NewUserRequest{
+		UsernameConfig: dbplugin.UsernameMetadata{
+			DisplayName: "token",
+			RoleName:    roleName,
+		},
+		Statements: dbplugin.Statements{
+			Commands: []string{`{"permissions": [{"action": "read", "resource": {"type": "buckets"}}]}`},
+		},
+		Password:   "nuozxby98523u89bdfnkjl",
+		Expiration: time.Now().Add(1 * time.Minute),
+	})
+	return resp.Username
+}
+
+func TestInfluxdb_RevokeRole(t *testing.T) {
+	const token = "root-token"
+	srv := newFakeInfluxServer(t, token)
+
+	db := new()
+	dbtesting.AssertInitialize(t, db, dbplugin.InitializeRequest{
+		Config: srv.connectionParams(token),
+	})
+	defer dbtesting.AssertClose(t, db)
+
+	first := newTestCredential(t, db, "compromised")
+	second := newTestCredential(t, db, "compromised")
+	other := newTestCredential(t, db, "other")
+	expected := []string{
+		*srv.userAuthorizations(first)[0].Id,
+		*srv.userAuthorizations(second)[0].Id,
+	}
+
+	resp, err := db.RevokeRole(context.Background(), "compromised", true)
+	require.NoError(t, err)
+	require.ElementsMatch(t, expected, resp.Revoked)
+	require.Empty(t, resp.Failed)
+	require.Len(t, srv.userAuthorizations(first), 1)
+	require.Len(t, srv.userAuthorizations(second), 1)
+
+	resp, err = db.RevokeRole(context.Background(), "compromised", false)
+	require.NoError(t, err)
+	require.ElementsMatch(t, expected, resp.Revoked)
+	require.Empty(t, resp.Failed)
+	require.Empty(t, srv.userAuthorizations(first))
+	require.Empty(t, srv.userAuthorizations(second))
+	require.Len(t, srv.userAuthorizations(other), 1)
+
+	// Failures are reported per authorization.
+	srv.handle("DELETE /api/v2/authorizations/{id}", func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusInternalServerError, "internal error", "boom")
+	})
+	resp, err = db.RevokeRole(context.Background(), "other", false)
+	require.NoError(t, err)
+	require.Empty(t, resp.Revoked)
+	require.Contains(t, resp.Failed, *srv.userAuthorizations(other)[0].Id)
+
+	_, err = db.RevokeRole(context.Background(), "", true)
+	require.Error(t, err)
+}
+
+func TestInfluxdb_NonRevocable(t *testing.T) {
+	const token = "root-token"
+	srv := newFakeInfluxServer(t, token)
+
+	db := new()
+	dbtesting.AssertInitialize(t, db, dbplugin.InitializeRequest{
+		Config: srv.connectionParams(token),
+	})
+	defer dbtesting.AssertClose(t, db)
+
+	newCredential := func(t *testing.T, commands ...string) (string, error) {
+		t.Helper()
+		resp, err := db.NewUser(context.Background(), dbplugin.NewUserRequest{
+			UsernameConfig: dbplugin.UsernameMetadata{DisplayName: "token", RoleName: "shared"},
+			Statements:     dbplugin.Statements{Commands: commands},
+			Password:       "nuozxby98523u89bdfnkjl",
+			Expiration:     time.Now().Add(time.Minute),
+		})
+		return resp.Username, err
+	}
+
+	shared, err := newCredential(t, `{"permissions": [{"action": "read", "resource": {"type": "buckets"}}], "revocable": false}`)
+	require.NoError(t, err)
+	auths := srv.userAuthorizations(shared)
+	require.Len(t, auths, 1)
+	require.Contains(t, *auths[0].Description, "revocable=false")
+	require.NotContains(t, db.expiries.entries, shared)
+
+	// A later statement overrides an earlier one.
+	revocable, err := newCredential(t,
+		`{"permissions": [{"action": "read", "resource": {"type": "buckets"}}], "revocable": false}`,
+		`{"revocable": true}`,
+	)
+	require.NoError(t, err)
+	revocableAuth := srv.userAuthorizations(revocable)[0]
+	require.NotContains(t, *revocableAuth.Description, "revocable")
+
+	// Revoking a non-revocable credential succeeds and leaves it in place.
+	dbtesting.AssertDeleteUser(t, db, dbplugin.DeleteUserRequest{Username: shared})
+	require.Len(t, srv.userAuthorizations(shared), 1)
+
+	resp, err := db.RevokeRole(context.Background(), "shared", false)
+	require.NoError(t, err)
+	require.Equal(t, []string{*auths[0].Id}, resp.Retained)
+	require.Equal(t, []string{*revocableAuth.Id}, resp.Revoked)
+	require.Len(t, srv.userAuthorizations(shared), 1)
+	require.Empty(t, srv.userAuthorizations(revocable))
+
+	// deleteUser refuses before deleting anything.
+	cli, err := db.getConnection(context.Background())
+	require.NoError(t, err)
+	err = deleteUser(context.Background(), cli, shared, 1)
+	require.ErrorIs(t, err, ErrCredentialNotRevocable)
+	require.Len(t, srv.userAuthorizations(shared), 1)
+
+	_, err = newCredential(t, `{"revocable": false}`)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), `"revocable" requires permissions to grant`)
+}
+
+func TestInfluxdb_BeforeInitialize(t *testing.T) {
+	ctx := context.Background()
+	db := new()
+	expiration := time.Now().Add(time.Minute)
+
+	operations := map[string]func() error{
+		"NewUser": func() error {
+			_, err := db.NewUser(ctx, dbplugin.NewUserRequest{
+				UsernameConfig: dbplugin.UsernameMetadata{DisplayName: "test", RoleName: "test"},
+				Password:       "nuozxby98523u89bdfnkjl",
+			})
+			return err
+		},
+		"UpdateUser": func() error {
+			_, err := db.UpdateUser(ctx, dbplugin.UpdateUserRequest{
+				Username:   "test",
+				Expiration: &dbplugin.ChangeExpiration{NewExpiration: expiration},
+			})
+			return err
+		},
+		"DeleteUser": func() error {
+			_, err := db.DeleteUser(ctx, dbplugin.DeleteUserRequest{Username: "test"})
+			return err
+		},
+		"ImportCredential": func() error {
+			_, err := db.ImportCredential(ctx, ImportCredentialRequest{AuthorizationID: "0123456789abcdef"})
+			return err
+		},
+		"RevokeRole": func() error {
+			_, err := db.RevokeRole(ctx, "test", true)
+			return err
+		},
+		"EnforceExpiry": func() error {
+			_, err := db.EnforceExpiry(ctx)
+			return err
+		},
+		"CredentialPermissions": func() error {
+			_, err := db.CredentialPermissions(ctx, "test")
+			return err
+		},
+		"RotateRootToken": func() error {
+			_, err := db.RotateRootToken(ctx)
+			return err
+		},
+	}
+	for name, operation := range operations {
+		t.Run(name, func(t *testing.T) {
+			err := operation()
+			require.ErrorIs(t, err, ErrNotInitialized)
+			require.EqualError(t, err, name+": the connection is not yet initialized, configure it first")
+		})
+	}
+}
+
+func TestInfluxdb_NewUserDuringInitialize(t *testing.T) {
+	const token = "root-token"
+	srv := newFakeInfluxServer(t, token)
+	pinged := make(chan struct{})
+	release := make(chan struct{})
+	var once sync.Once
+	srv.handle("GET /ping", func(w http.ResponseWriter, r *http.Request) {
+		once.Do(func() { close(pinged) })
+		<-release
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	db := new()
+	initialized := make(chan error, 1)
+	go func() {
+		_, err := db.Initialize(context.Background(), dbplugin.InitializeRequest{
+			Config:           srv.connectionParams(token),
+			VerifyConnection: true,
+		})
+		initialized <- err
+	}()
+	<-pinged
+
+	// NewUser waits for the Initialize holding the lock rather than failing
+	// halfway through it, and then sees it succeeded.
+	created := make(chan error, 1)
+	go func() {
+		_, err := db.NewUser(context.Background(), dbplugin.NewUserRequest{
+			UsernameConfig: dbplugin.UsernameMetadata{DisplayName: "test", RoleName: "test"},
+			Statements:     dbplugin.Statements{Commands: []string{`{"permissions": [{"action": "read", "resource": {"type": "buckets"}}]}`}},
+			Password:       "nuozxby98523u89bdfnkjl",
+			Expiration:     time.Now().Add(time.Minute),
+		})
+		created <- err
+	}()
+	select {
+	case err := <-created:
+		t.Fatalf("NewUser returned during Initialize: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+
+	for _, done := range []chan error{initialized, created} {
+		select {
+		case err := <-done:
+			require.NoError(t, err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("deadlocked")
+		}
+	}
+	dbtesting.AssertClose(t, db)
+}
@@ -0,0 +1,226 @@
+package influxdbv2
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/influxdata/influxdb-client-go/v2"
+	"github.com/influxdata/influxdb-client-go/v2/domain"
+)
+
+// managedDescriptionPrefix marks authorizations created by the plugin.
+const managedDescriptionPrefix = "vault:"
+
+// maxOriginLength bounds origin_cluster and origin_mount.
+const maxOriginLength = 128
+
+// originNode is the host the plugin runs on, or "" if it can't be told.
+var originNode, _ = os.Hostname()
+
+// validateOrigin checks origin_cluster or origin_mount.
+func validateOrigin(name, value string) error {
+	if utf8.RuneCountInString(value) > maxOriginLength {
+		return fmt.Errorf("%s cannot be longer than %d characters", name, maxOriginLength)
+	}
+	for _, r := range value {
+		if unicode.IsControl(r) {
+			return fmt.Errorf("%s cannot contain control characters", name)
+		}
+	}
+	return nil
+}
+
+// origin fills in when and where a credential is being issued.
+func (i *influxdbConnectionProducer) origin(m credentialMetadata) credentialMetadata {
+	m.Created = time.Now()
+	m.Node = originNode
+	m.Cluster = i.OriginCluster
+	m.Mount = i.OriginMount
+	return m
+}
+
+// fromOtherOrigin reports whether a credential was recorded as issued by
+// another cluster or mount than the configured origin_cluster and
+// origin_mount. What either side leaves unset doesn't tell them apart, and a
+// truncated value only differs if the configured one doesn't start with it.
+func (i *influxdbConnectionProducer) fromOtherOrigin(m credentialMetadata) bool {
+	differ := func(recorded, configured string) bool {
+		if recorded == "" || configured == "" {
+			return false
+		}
+		if m.Truncated {
+			return !strings.HasPrefix(configured, recorded)
+		}
+		return recorded != configured
+	}
+	return differ(m.Cluster, i.OriginCluster) || differ(m.Mount, i.OriginMount)
+}
+
+// maxDescriptionLength bounds the descriptions the plugin writes. InfluxDB
+// doesn't document a limit, so descriptions are kept well within what it
+// stores and lists without trouble.
+const maxDescriptionLength = 512
+
+// credentialMetadata is embedded in the description of every authorization
+// the plugin creates, so that credentials can be traced back to the role and
+// user they were issued for, and to when and where they were issued.
+type credentialMetadata struct {
+	Username string
+	Role     string
+	Session  string
+
+	// Expires is the expiration Vault requested for the credential, if any.
+	// InfluxDB tokens don't expire on their own.
+	Expires time.Time
+
+	// NonRevocable marks a credential Vault merely references, such as a
+	// shared token it adopted, and must never delete. It is encoded as
+	// "revocable=false"; descriptions without it are revocable.
+	NonRevocable bool
+
+	// Created is when the plugin created or imported the credential.
+	Created time.Time
+
+	// Node, Cluster and Mount record where the credential was issued: the
+	// host the plugin ran on, and origin_cluster and origin_mount if
+	// configured. They are only informational, so they are the fields cut
+	// short, and Truncated set, when a description would exceed
+	// maxDescriptionLength.
+	Node      string
+	Cluster   string
+	Mount     string
+	Truncated bool
+}
+
+// description encodes the metadata as a managed authorization description,
+// e.g. "vault:created=1690000000&expires=1700000000&mount=database%2F&role=my-role&session=vault-influxdbv2&user=v_token_my_role_...".
+func (m credentialMetadata) description() string {
+	description := m.encode()
+	// Every rune cut saves at least a byte of the encoded description.
+	for _, field := range []*string{&m.Node, &m.Cluster, &m.Mount} {
+		for len(description) > maxDescriptionLength && *field != "" {
+			runes := []rune(*field)
+			cut := len(description) - maxDescriptionLength
+			if cut > len(runes) {
+				cut = len(runes)
+			}
+			*field = string(runes[:len(runes)-cut])
+			m.Truncated = true
+			description = m.encode()
+		}
+	}
+	return description
+}
+
+func (m credentialMetadata) encode() string {
+	values := url.Values{}
+	values.Set("user", m.Username)
+	if !m.Expires.IsZero() {
+		values.Set("expires", strconv.FormatInt(m.Expires.Unix(), 10))
+	}
+	if m.NonRevocable {
+		values.Set("revocable", "false")
+	}
+	if m.Role != "" {
+		values.Set("role", m.Role)
+	}
+	if m.Session != "" {
+		values.Set("session", m.Session)
+	}
+	if !m.Created.IsZero() {
+		values.Set("created", strconv.FormatInt(m.Created.Unix(), 10))
+	}
+	if m.Node != "" {
+		values.Set("node", m.Node)
+	}
+	if m.Cluster != "" {
+		values.Set("cluster", m.Cluster)
+	}
+	if m.Mount != "" {
+		values.Set("mount", m.Mount)
+	}
+	if m.Truncated {
+		values.Set("truncated", "true")
+	}
+	return managedDescriptionPrefix + values.Encode()
+}
+
+// parseDescription decodes the metadata from a managed authorization
+// description. It returns false if the description wasn't written by the
+// plugin.
+func parseDescription(description string) (credentialMetadata, bool) {
+	if !strings.HasPrefix(description, managedDescriptionPrefix) {
+		return credentialMetadata{}, false
+	}
+	values, err := url.ParseQuery(strings.TrimPrefix(description, managedDescriptionPrefix))
+	if err != nil || values.Get("user") == "" {
+		return credentialMetadata{}, false
+	}
+	metadata := credentialMetadata{
+		Username: values.Get("user"),
+		Role:     values.Get("role"),
+		Session:  values.Get("session"),
+		// Anything but an explicit "false" leaves the credential revocable,
+		// as it was before the flag existed.
+		NonRevocable: values.Get("revocable") == "false",
+		Node:         values.Get("node"),
+		Cluster:      values.Get("cluster"),
+		Mount:        values.Get("mount"),
+		Truncated:    values.Get("truncated") == "true",
+	}
+	// A malformed timestamp doesn't make the authorization any less managed.
+	if expires, err := strconv.ParseInt(values.Get("expires"), 10, 64); err == nil {
+		metadata.Expires = time.Unix(expires, 0)
+	}
+	if created, err := strconv.ParseInt(values.Get("created"), 10, 64); err == nil {
+		metadata.Created = time.Unix(created, 0)
+	}
+	return metadata, true
+}
+
+// expiredAt reports whether the credential's expiry has passed at now by more
+// than skew, which absorbs clock differences between the Vault node that
+// created the credential and the one checking it. A credential without an
+// expiry never expires.
+func (m credentialMetadata) expiredAt(now time.Time, skew time.Duration) bool {
+	return !m.Expires.IsZero() && now.After(m.Expires.Add(skew))
+}
+
+// managedAuthorization is an authorization created by the plugin along with
+// the metadata decoded from its description.
+type managedAuthorization struct {
+	domain.Authorization
+	metadata credentialMetadata
+}
+
+// listManagedAuthorizations returns every authorization visible to the client
+// that was created by the plugin, listing at most maxPages pages of
+// authorizations.
+func listManagedAuthorizations(ctx context.Context, cli influxdb2.Client, maxPages int) ([]managedAuthorization, error) {
+	authorizations, err := listAuthorizations(ctx, cli, maxPages)
+	if err != nil {
+		return nil, err
+	}
+	var res []managedAuthorization
+	for _, authorization := range authorizations {
+		if authorization.Description == nil {
+			continue
+		}
+		metadata, ok := parseDescription(*authorization.Description)
+		if !ok {
+			continue
+		}
+		res = append(res, managedAuthorization{
+			Authorization: authorization,
+			metadata:      metadata,
+		})
+	}
+	return res, nil
+}
@@ -0,0 +1,181 @@
+package influxdbv2
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/hashicorp/vault/sdk/database/dbplugin/v5"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCredentialMetadata_RoundTrip(t *testing.T) {
+	tests := map[string]credentialMetadata{
+		"user only":     {Username: "v_token_test_abc"},
+		"user and role": {Username: "v_token_test_abc", Role: "test"},
+		"with session":  {Username: "v_token_test_abc", Role: "test", Session: "vault-influxdbv2"},
+		"special chars": {Username: "a&b=c", Role: "role;with spaces&="},
+		"unicode role":  {Username: "user", Role: "rôle"},
+		"with expiry":   {Username: "user", Role: "test", Expires: time.Unix(1700000000, 0)},
+		"non-revocable": {Username: "user", Role: "test", NonRevocable: true},
+		"with origin": {
+			Username: "user", Role: "test", Session: "vault-influxdbv2", Expires: time.Unix(1700000000, 0),
+			Created: time.Unix(1690000000, 0), Node: "vault-0", Cluster: "vault-cluster-1a2b", Mount: "database/influx & co",
+		},
+	}
+
+	for name, metadata := range tests {
+		t.Run(name, func(t *testing.T) {
+			parsed, ok := parseDescription(metadata.description())
+			require.True(t, ok)
+			require.Equal(t, metadata, parsed)
+		})
+	}
+}
+
+func TestCredentialMetadata_Truncation(t *testing.T) {
+	metadata := credentialMetadata{
+		Username: "v_token_" + strings.Repeat("u", 100),
+		Role:     strings.Repeat("r", 100),
+		Session:  "vault-influxdbv2",
+		Expires:  time.Unix(1700000000, 0),
+		Created:  time.Unix(1690000000, 0),
+		Node:     strings.Repeat("n", 100),
+		Cluster:  strings.Repeat("c", 100),
+		Mount:    strings.Repeat("mount/é ", 40),
+	}
+	description := metadata.description()
+	require.LessOrEqual(t, len(description), maxDescriptionLength)
+
+	parsed, ok := parseDescription(description)
+	require.True(t, ok)
+	require.True(t, parsed.Truncated)
+	// What the plugin relies on is never cut.
+	require.Equal(t, metadata.Username, parsed.Username)
+	require.Equal(t, metadata.Role, parsed.Role)
+	require.Equal(t, metadata.Session, parsed.Session)
+	require.Equal(t, metadata.Expires, parsed.Expires)
+	require.Equal(t, metadata.Created, parsed.Created)
+	// The origin is cut from the node first, and only ever shortened.
+	require.Empty(t, parsed.Node)
+	for _, field := range [][2]string{{metadata.Cluster, parsed.Cluster}, {metadata.Mount, parsed.Mount}} {
+		require.True(t, strings.HasPrefix(field[0], field[1]), field[1])
+		require.True(t, utf8.ValidString(field[1]), field[1])
+	}
+	// Rewriting a parsed description, as renewals do, keeps it as is.
+	require.Equal(t, description, parsed.description())
+
+	// Fitting descriptions are left whole.
+	metadata = credentialMetadata{Username: "user", Node: "vault-0", Mount: "database/"}
+	parsed, ok = parseDescription(metadata.description())
+	require.True(t, ok)
+	require.False(t, parsed.Truncated)
+	require.Equal(t, metadata, parsed)
+}
+
+func TestCredentialMetadata_FromOtherOrigin(t *testing.T) {
+	tests := map[string]struct {
+		cluster, mount string
+		metadata       credentialMetadata
+		expect         bool
+	}{
+		"nothing recorded":      {cluster: "a", mount: "database/", metadata: credentialMetadata{}},
+		"nothing configured":    {metadata: credentialMetadata{Cluster: "a", Mount: "database/"}},
+		"same origin":           {cluster: "a", mount: "database/", metadata: credentialMetadata{Cluster: "a", Mount: "database/"}},
+		"other mount":           {mount: "database/", metadata: credentialMetadata{Mount: "influx/"}, expect: true},
+		"other cluster":         {cluster: "a", metadata: credentialMetadata{Cluster: "b"}, expect: true},
+		"truncated same mount":  {mount: "database/", metadata: credentialMetadata{Mount: "data", Truncated: true}},
+		"truncated other mount": {mount: "database/", metadata: credentialMetadata{Mount: "infl", Truncated: true}, expect: true},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			i := &influxdbConnectionProducer{OriginCluster: test.cluster, OriginMount: test.mount}
+			require.Equal(t, test.expect, i.fromOtherOrigin(test.metadata))
+		})
+	}
+}
+
+func TestParseDescription_Unmanaged(t *testing.T) {
+	for _, description := range []string{
+		"",
+		"telegraf token",
+		"vault:",
+		"vault:role=test",
+		"vault:%zz",
+	} {
+		_, ok := parseDescription(description)
+		require.False(t, ok, description)
+	}
+}
+
+func TestParseDescription_MalformedExpiry(t *testing.T) {
+	metadata, ok := parseDescription("vault:expires=soon&user=v_token_test_abc")
+	require.True(t, ok)
+	require.Equal(t, "v_token_test_abc", metadata.Username)
+	require.True(t, metadata.Expires.IsZero())
+}
+
+func TestCredentialMetadata_ExpiredAt(t *testing.T) {
+	expires := time.Unix(1700000000, 0)
+	skew := 60 * time.Second
+
+	tests := map[string]struct {
+		now    time.Time
+		skew   time.Duration
+		expect bool
+	}{
+		"before expiry":             {now: expires.Add(-time.Second), skew: skew},
+		"at expiry":                 {now: expires, skew: skew},
+		"just inside skew window":   {now: expires.Add(skew - time.Second), skew: skew},
+		"at end of skew window":     {now: expires.Add(skew), skew: skew},
+		"just outside skew window":  {now: expires.Add(skew + time.Second), skew: skew, expect: true},
+		"without skew, at expiry":   {now: expires},
+		"without skew, just after":  {now: expires.Add(time.Second), expect: true},
+		"long after, with big skew": {now: expires.Add(time.Hour), skew: 2 * time.Hour},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			metadata := credentialMetadata{Username: "user", Expires: expires}
+			require.Equal(t, test.expect, metadata.expiredAt(test.now, test.skew))
+		})
+	}
+
+	// A credential without an expiry never expires.
+	require.False(t, credentialMetadata{Username: "user"}.expiredAt(time.Now().Add(100*365*24*time.Hour), 0))
+}
+
+func TestInitialize_ExpirySkew(t *testing.T) {
+	tests := map[string]struct {
+		value     interface{}
+		expect    time.Duration
+		expectErr string
+	}{
+		"default":  {expect: defaultExpirySkew},
+		"seconds":  {value: 30, expect: 30 * time.Second},
+		"duration": {value: "5m", expect: 5 * time.Minute},
+		"zero":     {value: "0s"},
+		"negative": {value: "-1s", expectErr: "expiry_skew cannot be negative"},
+		"invalid":  {value: "soon", expectErr: "invalid expiry_skew"},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			config := map[string]interface{}{"host": "influx.example.com", "token": "token"}
+			if test.value != nil {
+				config["expiry_skew"] = test.value
+			}
+			db := new()
+			_, err := db.Initialize(context.Background(), dbplugin.InitializeRequest{Config: config})
+			if test.expectErr != "" {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), test.expectErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, test.expect, db.expirySkew)
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package influxdbv2
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+	"syscall"
+
+	ihttp "github.com/influxdata/influxdb-client-go/v2/api/http"
+)
+
+// Causes of connection failures, the labels of promConnectionErrors.
+const (
+	causeDNS       = "dns"
+	causeRefused   = "refused"
+	causeTLS       = "tls"
+	causeTimeout   = "timeout"
+	causeAuth      = "auth"
+	causeRateLimit = "rate-limit"
+	causeOther     = "other"
+)
+
+// recordConnectionError counts a failure to establish a connection.
+func recordConnectionError(err error) {
+	promConnectionErrors.WithLabelValues(connectionErrorCause(err)).Inc()
+}
+
+// connectionErrorCause classifies a failure to establish a connection.
+func connectionErrorCause(err error) string {
+	if errors.Is(err, ErrAuthFailed) || errors.Is(err, ErrInsufficientPermissions) {
+		return causeAuth
+	}
+	var httpErr *ihttp.Error
+	if errors.As(err, &httpErr) {
+		switch httpErr.StatusCode {
+		case http.StatusUnauthorized, http.StatusForbidden:
+			return causeAuth
+		case http.StatusTooManyRequests:
+			return causeRateLimit
+		}
+		// The client's Unwrap doesn't preserve the cause.
+		if httpErr.Err != nil {
+			err = httpErr.Err
+		}
+	}
+
+	var dnsErr *net.DNSError
+	var recordErr tls.RecordHeaderError
+	var unknownAuthorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidCertErr x509.CertificateInvalidError
+	var constraintErr *constraintError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &dnsErr):
+		return causeDNS
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return causeRefused
+	case errors.As(err, &recordErr), errors.As(err, &unknownAuthorityErr), errors.As(err, &hostnameErr), errors.As(err, &invalidCertErr),
+		errors.As(err, &constraintErr):
+		return causeTLS
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return causeTimeout
+	}
+	return causeOther
+}
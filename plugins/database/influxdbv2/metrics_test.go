@@ -0,0 +1,98 @@
+package influxdbv2
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/database/dbplugin/v5"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateClient_ConnectionErrorMetrics(t *testing.T) {
+	allCauses := []string{causeDNS, causeRefused, causeTLS, causeTimeout, causeAuth, causeRateLimit, causeOther}
+	counts := func() map[string]float64 {
+		counts := make(map[string]float64, len(allCauses))
+		for _, cause := range allCauses {
+			counts[cause] = testutil.ToFloat64(promConnectionErrors.WithLabelValues(cause))
+		}
+		return counts
+	}
+
+	const token = "root-token"
+	srv := newFakeInfluxServer(t, token)
+	failPing := func(status int) func(w http.ResponseWriter, r *http.Request) {
+		return func(w http.ResponseWriter, r *http.Request) {
+			writeError(w, status, "failed", "ping failed")
+		}
+	}
+	tlsSrv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer tlsSrv.Close()
+
+	tests := map[string]struct {
+		config map[string]interface{}
+		ping   http.HandlerFunc
+		cause  string
+	}{
+		"dns": {
+			config: makeConfig(srv.connectionParams(token), "host", "influx.invalid", "port", "8086", "dns_resolver", unusedEndpoint(t)),
+			cause:  causeDNS,
+		},
+		"refused": {
+			config: makeConfig(srv.connectionParams(token), "host", "", "port", "", "endpoints", unusedEndpoint(t)),
+			cause:  causeRefused,
+		},
+		"tls": {
+			config: map[string]interface{}{"url": tlsSrv.URL, "token": token, "organization": "vault"},
+			cause:  causeTLS,
+		},
+		"timeout": {
+			config: makeConfig(srv.connectionParams(token), "ping_timeout", "20ms"),
+			ping: func(w http.ResponseWriter, r *http.Request) {
+				select {
+				case <-r.Context().Done():
+				case <-time.After(time.Second):
+				}
+			},
+			cause: causeTimeout,
+		},
+		"auth": {
+			config: srv.connectionParams("unknown-token"),
+			cause:  causeAuth,
+		},
+		"rate-limit": {
+			config: srv.connectionParams(token),
+			ping:   failPing(http.StatusTooManyRequests),
+			cause:  causeRateLimit,
+		},
+		"other": {
+			config: srv.connectionParams(token),
+			ping:   failPing(http.StatusNotImplemented),
+			cause:  causeOther,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			if test.ping != nil {
+				srv.handle("GET /ping", test.ping)
+				defer srv.unhandle("GET /ping")
+			}
+			expected := counts()
+			expected[test.cause]++
+
+			_, err := new().Initialize(context.Background(), dbplugin.InitializeRequest{
+				Config:           test.config,
+				VerifyConnection: true,
+			})
+			require.Error(t, err)
+
+			require.Equal(t, expected, counts(), err.Error())
+		})
+	}
+}
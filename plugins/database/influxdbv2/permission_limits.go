@@ -0,0 +1,62 @@
+package influxdbv2
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/influxdata/influxdb-client-go/v2/domain"
+)
+
+// defaultMaxPermissionsSize is the max_permissions_size used when it isn't
+// configured. It leaves room for over a thousand bucket permissions while
+// staying under the 1 MiB request bodies proxies commonly accept by default.
+const defaultMaxPermissionsSize = 512 << 10
+
+// maxPermissionsSize returns the size, in bytes, the permissions of a
+// credential may take in the request creating its authorization.
+func (i *influxdbConnectionProducer) maxPermissionsSize() int {
+	if i.MaxPermissionsSize == 0 {
+		return defaultMaxPermissionsSize
+	}
+	return i.MaxPermissionsSize
+}
+
+// checkPermissionLimits rejects a credential whose permissions exceed the
+// mount's guardrails: more permissions than max_permissions, when set, more
+// bytes than max_permissions_size once encoded, or a permission in
+// forbidden_unscoped_permissions granted on every resource of its type rather
+// than on a single one. Scoping to an organization doesn't count as scoping to
+// a resource. It must be called with the lock held.
+//
+// A credential is a single authorization, so permissions too large for one
+// request can't be split across several: the request is rejected instead.
+func (i *influxdbConnectionProducer) checkPermissionLimits(permissions []domain.Permission) error {
+	if i.MaxPermissions > 0 && len(permissions) > i.MaxPermissions {
+		return fmt.Errorf("the credential would hold %d permissions, more than max_permissions allows (%d)", len(permissions), i.MaxPermissions)
+	}
+	encoded, err := json.Marshal(permissions)
+	if err != nil {
+		return err
+	}
+	if size := len(encoded); size > i.maxPermissionsSize() {
+		return fmt.Errorf("the %d permissions of the credential would take %d bytes, more than max_permissions_size allows (%d): grant the actions on every resource of a type in the organization, such as {\"action\": \"read\", \"resource\": {\"type\": \"buckets\"}}, rather than on each resource", len(permissions), size, i.maxPermissionsSize())
+	}
+	var forbidden []string
+	for _, permission := range permissions {
+		if permission.Resource.Id != nil || permission.Resource.Name != nil {
+			continue
+		}
+		p := requiredPermission{Action: permission.Action, ResourceType: permission.Resource.Type}
+		for _, f := range i.forbiddenUnscoped {
+			if f == p {
+				forbidden = append(forbidden, p.String())
+				break
+			}
+		}
+	}
+	if len(forbidden) > 0 {
+		return fmt.Errorf("the credential would hold %s on every resource of the type, which forbidden_unscoped_permissions doesn't allow: scope them to a resource", strings.Join(forbidden, ", "))
+	}
+	return nil
+}
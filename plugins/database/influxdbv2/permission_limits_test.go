@@ -0,0 +1,102 @@
+package influxdbv2
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/database/dbplugin/v5"
+	dbtesting "github.com/hashicorp/vault/sdk/database/dbplugin/v5/testing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInfluxdb_NewUser_PermissionLimits(t *testing.T) {
+	const token = "root-token"
+	srv := newFakeInfluxServer(t, token)
+	srv.addBucket(srv.orgID("vault"), "telegraf")
+	buckets := make([]string, 50)
+	for idx := range buckets {
+		buckets[idx] = fmt.Sprintf("%q", fmt.Sprintf("bucket-%d", idx))
+		srv.addBucket(srv.orgID("vault"), fmt.Sprintf("bucket-%d", idx))
+	}
+	manyBuckets := `{"preset": "read", "buckets": [` + strings.Join(buckets, ", ") + `]}`
+
+	tests := map[string]struct {
+		config      []interface{}
+		command     string
+		expectedErr string
+	}{
+		"no cap": {
+			command: `{"permissions": [{"action": "read", "resource": {"type": "buckets"}}, {"action": "write", "resource": {"type": "buckets"}}, {"action": "read", "resource": {"type": "orgs"}}]}`,
+		},
+		"within max_permissions": {
+			config:  []interface{}{"max_permissions", 2},
+			command: `{"preset": "read_write", "bucket": "telegraf"}`,
+		},
+		"exceeds max_permissions": {
+			config:      []interface{}{"max_permissions", 2},
+			command:     `{"permissions": [{"action": "read", "resource": {"type": "buckets"}}, {"action": "write", "resource": {"type": "buckets"}}, {"action": "read", "resource": {"type": "orgs"}}]}`,
+			expectedErr: "the credential would hold 3 permissions, more than max_permissions allows (2)",
+		},
+		"within the default max_permissions_size": {
+			command: manyBuckets,
+		},
+		"exceeds max_permissions_size": {
+			config:      []interface{}{"max_permissions_size", 2048},
+			command:     manyBuckets,
+			expectedErr: "the 50 permissions of the credential would take",
+		},
+		"forbidden unscoped grant": {
+			config:      []interface{}{"forbidden_unscoped_permissions", "buckets:write,authorizations:write"},
+			command:     `{"permissions": [{"action": "read", "resource": {"type": "buckets"}}, {"action": "write", "resource": {"type": "buckets"}}]}`,
+			expectedErr: "the credential would hold buckets:write on every resource of the type",
+		},
+		"forbidden grant scoped to a resource": {
+			config:  []interface{}{"forbidden_unscoped_permissions", "buckets:write"},
+			command: `{"preset": "write", "bucket": "telegraf"}`,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			db := new()
+			dbtesting.AssertInitialize(t, db, dbplugin.InitializeRequest{
+				Config: makeConfig(srv.connectionParams(token), test.config...),
+			})
+			defer dbtesting.AssertClose(t, db)
+
+			created := srv.callCount("POST /api/v2/users")
+			_, err := db.NewUser(context.Background(), dbplugin.NewUserRequest{
+				UsernameConfig: dbplugin.UsernameMetadata{
+					DisplayName: "test",
+					RoleName:    "test",
+				},
+				Statements: dbplugin.Statements{
+					Commands: []string{test.command},
+				},
+				Password:   "nuozxby98523u89bdfnkjl",
+				Expiration: time.Now().Add(time.Hour),
+			})
+			if test.expectedErr == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			require.Contains(t, err.Error(), test.expectedErr)
+			// Nothing is created for a rejected request.
+			require.Equal(t, created, srv.callCount("POST /api/v2/users"))
+		})
+	}
+
+	err := ValidateConfig(makeConfig(srv.connectionParams(token), "max_permissions", -1))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "max_permissions cannot be negative")
+	err = ValidateConfig(makeConfig(srv.connectionParams(token), "max_permissions_size", -1))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "max_permissions_size cannot be negative")
+	err = ValidateConfig(makeConfig(srv.connectionParams(token), "forbidden_unscoped_permissions", "buckets"))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "invalid forbidden_unscoped_permissions")
+}
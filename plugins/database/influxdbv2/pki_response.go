@@ -0,0 +1,43 @@
+package influxdbv2
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hashicorp/vault/sdk/helper/certutil"
+)
+
+// parsePKIResponse parses the JSON output of a PKI issue or sign request,
+// either the whole response, as printed by "vault write -format=json", or
+// just its data member. Unlike pem_json, the full ca_chain is kept.
+func parsePKIResponse(raw string) (*certutil.ParsedCertBundle, error) {
+	var response struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := json.Unmarshal([]byte(raw), &response); err != nil {
+		return nil, fmt.Errorf("invalid pki_response: %w", err)
+	}
+	data := response.Data
+	if data == nil {
+		if err := json.Unmarshal([]byte(raw), &data); err != nil {
+			return nil, fmt.Errorf("invalid pki_response: %w", err)
+		}
+	}
+
+	// Only the fields of a certificate bundle are of interest; responses
+	// also carry fields such as expiration that don't decode into one.
+	fields := make(map[string]interface{})
+	for _, key := range []string{"certificate", "private_key", "private_key_type", "issuing_ca", "ca_chain", "serial_number"} {
+		if v, ok := data[key]; ok {
+			fields[key] = v
+		}
+	}
+	bundle, err := certutil.ParsePKIMap(fields)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pki_response: %w", err)
+	}
+	if bundle.Certificate == nil && len(bundle.CAChain) == 0 {
+		return nil, fmt.Errorf("invalid pki_response: no certificate or ca_chain found")
+	}
+	return bundle, nil
+}
@@ -0,0 +1,206 @@
+package influxdbv2
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/database/dbplugin/v5"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInitialize_PKIResponse(t *testing.T) {
+	root := newTestCA(t, "root", nil)
+	intermediate := newTestCA(t, "intermediate", root)
+	leafPEM, keyPEM := intermediate.issue(t, "vault", x509.ExtKeyUsageClientAuth)
+
+	data := map[string]interface{}{
+		"certificate":      leafPEM,
+		"private_key":      keyPEM,
+		"private_key_type": "ec",
+		"issuing_ca":       intermediate.pem,
+		"ca_chain":         []string{intermediate.pem, root.pem},
+		"serial_number":    "01",
+		"expiration":       time.Now().Add(time.Hour).Unix(),
+	}
+	full, err := json.Marshal(map[string]interface{}{
+		"request_id": "7ff1a5e4-0000-0000-0000-000000000000",
+		"lease_id":   "",
+		"renewable":  false,
+		"data":       data,
+	})
+	require.NoError(t, err)
+	dataOnly, err := json.Marshal(data)
+	require.NoError(t, err)
+
+	for name, response := range map[string]string{"full response": string(full), "data only": string(dataOnly)} {
+		t.Run(name, func(t *testing.T) {
+			db := new()
+			_, err := db.Initialize(context.Background(), dbplugin.InitializeRequest{
+				Config: map[string]interface{}{
+					"host":         "influx.example.com",
+					"token":        "token",
+					"pki_response": response,
+				},
+			})
+			require.NoError(t, err)
+			require.True(t, db.TLS)
+			require.Len(t, db.caChain, 2)
+
+			tlsConfig, err := db.tlsConfig()
+			require.NoError(t, err)
+			require.Len(t, tlsConfig.Certificates, 1)
+			// Certificates issued by either CA of the chain are trusted.
+			for _, ca := range []*testCA{root, intermediate} {
+				_, err = ca.cert.Verify(x509.VerifyOptions{Roots: tlsConfig.RootCAs})
+				require.NoError(t, err)
+			}
+			require.NotContains(t, db.rawConfig["pki_response"], "PRIVATE KEY")
+		})
+	}
+
+	errTests := map[string]struct {
+		config    []interface{}
+		expectErr string
+	}{
+		"with pem_json": {
+			config:    []interface{}{"pki_response", string(full), "pem_json", string(dataOnly)},
+			expectErr: "pki_response cannot be combined",
+		},
+		"with cert files": {
+			config:    []interface{}{"pki_response", string(full), "tls_ca_file", "/nonexistent"},
+			expectErr: "cannot be combined",
+		},
+		"no certificates": {
+			config:    []interface{}{"pki_response", `{"data": {"serial_number": "01"}}`},
+			expectErr: "no certificate or ca_chain found",
+		},
+		"not json": {
+			config:    []interface{}{"pki_response", "-----BEGIN CERTIFICATE-----"},
+			expectErr: "invalid pki_response",
+		},
+	}
+	for name, test := range errTests {
+		t.Run(name, func(t *testing.T) {
+			_, err := new().Initialize(context.Background(), dbplugin.InitializeRequest{
+				Config: makeConfig(map[string]interface{}{"host": "influx.example.com", "token": "token"}, test.config...),
+			})
+			require.Error(t, err)
+			require.Contains(t, err.Error(), test.expectErr)
+		})
+	}
+}
+
+func TestTransport_PKIResponseTrustsWholeChain(t *testing.T) {
+	root := newTestCA(t, "root", nil)
+	intermediate := newTestCA(t, "intermediate", root)
+
+	// The server certificate is issued by the last CA of the chain, which
+	// the certificate bundle alone doesn't trust.
+	serverCert, serverKey := root.issue(t, "127.0.0.1", x509.ExtKeyUsageServerAuth)
+	cert, err := tls.X509KeyPair([]byte(serverCert), []byte(serverKey))
+	require.NoError(t, err)
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	srv.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	srv.StartTLS()
+	defer srv.Close()
+
+	response, err := json.Marshal(map[string]interface{}{
+		"data": map[string]interface{}{
+			"ca_chain": []string{intermediate.pem, root.pem},
+		},
+	})
+	require.NoError(t, err)
+
+	db := new()
+	_, err = db.Initialize(context.Background(), dbplugin.InitializeRequest{
+		Config: map[string]interface{}{
+			"host":         "127.0.0.1",
+			"token":        "token",
+			"pki_response": string(response),
+		},
+	})
+	require.NoError(t, err)
+	transport, err := db.newTransport()
+	require.NoError(t, err)
+
+	resp, err := (&http.Client{Transport: transport}).Get(srv.URL + "/ping")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+}
+
+// testCA is a certificate authority for tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  string
+}
+
+// newTestCA creates a CA, self-signed if parent is nil.
+func newTestCA(t *testing.T, name string, parent *testCA) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-1 * time.Hour),
+		NotAfter:              time.Now().Add(1 * time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	issuer, issuerKey := tmpl, key
+	if parent != nil {
+		issuer, issuerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, issuer, &key.PublicKey, issuerKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return &testCA{
+		cert: cert,
+		key:  key,
+		pem:  string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+	}
+}
+
+// issue returns a PEM encoded certificate and key issued by the CA. A name
+// that is an IP address is also added as an IP SAN.
+func (ca *testCA) issue(t *testing.T, name string, usage x509.ExtKeyUsage) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-1 * time.Hour),
+		NotAfter:     time.Now().Add(1 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	if ip := net.ParseIP(name); ip != nil {
+		tmpl.IPAddresses = []net.IP{ip}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+}
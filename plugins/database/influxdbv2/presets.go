@@ -0,0 +1,196 @@
+package influxdbv2
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/influxdata/influxdb-client-go/v2"
+	"github.com/influxdata/influxdb-client-go/v2/domain"
+)
+
+// Presets are shorthands for common sets of bucket permissions.
+const (
+	presetRead      = "read"
+	presetWrite     = "write"
+	presetReadWrite = "read_write"
+
+	// presetReadAllBuckets grants read on every bucket of the organization
+	// that exists when the credential is created.
+	presetReadAllBuckets = "read_all_buckets"
+)
+
+// presetActions maps each preset to the actions it grants on its bucket.
+var presetActions = map[string][]domain.PermissionAction{
+	presetRead:      {domain.PermissionActionRead},
+	presetWrite:     {domain.PermissionActionWrite},
+	presetReadWrite: {domain.PermissionActionRead, domain.PermissionActionWrite},
+}
+
+// presetStatement is a preset requested by a creation statement, along with
+// the buckets the statement names for it, by name or by ID, if any.
+type presetStatement struct {
+	Name      string
+	Buckets   []string
+	BucketIDs []string
+}
+
+func validatePreset(name string) error {
+	if _, ok := presetActions[name]; !ok && name != presetReadAllBuckets {
+		return fmt.Errorf("unknown preset %q, expected %q, %q, %q or %q", name, presetRead, presetWrite, presetReadWrite, presetReadAllBuckets)
+	}
+	return nil
+}
+
+// presetPermissions expands a preset into permissions on the buckets named by
+// the statement, by name or by ID, or else on default_bucket. A preset never
+// falls back to every bucket of the organization, so having neither is an
+// error. The read_all_buckets preset is the exception, see
+// allBucketsPermissions. Names are resolved along with the other permissions;
+// IDs are checked against the organization's buckets here.
+func (i *influxdbConnectionProducer) presetPermissions(ctx context.Context, cli influxdb2.Client, org *domain.Organization, preset presetStatement) ([]domain.Permission, error) {
+	if preset.Name == presetReadAllBuckets {
+		return i.allBucketsPermissions(ctx, cli, org)
+	}
+
+	names := preset.Buckets
+	var defaultBucket *domain.Bucket
+	if len(names) == 0 && len(preset.BucketIDs) == 0 && i.DefaultBucket != "" {
+		if i.DefaultBucketOrganization == "" {
+			names = []string{i.DefaultBucket}
+		} else {
+			// The bucket of default_bucket_organization is granted by ID, since
+			// names are resolved in org.
+			bucket, err := i.resolveDefaultBucket(ctx, cli, org)
+			if err != nil {
+				return nil, fmt.Errorf("unable to resolve default_bucket: %w", err)
+			}
+			defaultBucket = bucket
+		}
+	}
+	if len(names) == 0 && len(preset.BucketIDs) == 0 && defaultBucket == nil {
+		return nil, fmt.Errorf("preset %q requires a bucket: set \"bucket\", \"buckets\" or \"bucket_ids\" in the creation statement or configure default_bucket", preset.Name)
+	}
+	byID, err := i.bucketsByID(ctx, cli, org, preset.BucketIDs)
+	if err != nil {
+		return nil, err
+	}
+	if defaultBucket != nil {
+		byID = append(byID, *defaultBucket)
+	}
+
+	var permissions []domain.Permission
+	for _, action := range presetActions[preset.Name] {
+		for _, name := range names {
+			name := name
+			permissions = append(permissions, domain.Permission{
+				Action: action,
+				Resource: domain.Resource{
+					Type: domain.ResourceTypeBuckets,
+					Name: &name,
+				},
+			})
+		}
+		for _, bucket := range byID {
+			id, name, orgID := *bucket.Id, bucket.Name, stringValue(bucket.OrgID)
+			if orgID == "" {
+				orgID = *org.Id
+			}
+			permissions = append(permissions, domain.Permission{
+				Action: action,
+				Resource: domain.Resource{
+					Type:  domain.ResourceTypeBuckets,
+					Id:    &id,
+					Name:  &name,
+					OrgID: &orgID,
+				},
+			})
+		}
+	}
+	return permissions, nil
+}
+
+// bucketsByID returns the buckets of the organization with the given IDs, in
+// order, failing on the first ID that doesn't match one. They are looked up
+// in the organization's listing, see orgBuckets.
+func (i *influxdbConnectionProducer) bucketsByID(ctx context.Context, cli influxdb2.Client, org *domain.Organization, ids []string) ([]domain.Bucket, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	buckets, cached, err := i.orgBuckets(ctx, cli, *org.Id, false)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]domain.Bucket, 0, len(ids))
+	for _, id := range ids {
+		id = strings.TrimSpace(id)
+		bucket, ok := bucketWithID(buckets, id)
+		if !ok && cached {
+			// The bucket may have been created since the listing.
+			if buckets, _, err = i.orgBuckets(ctx, cli, *org.Id, true); err != nil {
+				return nil, err
+			}
+			cached = false
+			bucket, ok = bucketWithID(buckets, id)
+		}
+		if !ok {
+			return nil, withKind(ErrBucketNotFound, fmt.Errorf("bucket with ID '%s' not found in organization %q", id, org.Name))
+		}
+		res = append(res, bucket)
+	}
+	return res, nil
+}
+
+// bucketWithID returns the bucket of buckets with the given ID.
+func bucketWithID(buckets []domain.Bucket, id string) (domain.Bucket, bool) {
+	for _, bucket := range buckets {
+		if bucket.Id != nil && *bucket.Id == id {
+			return bucket, true
+		}
+	}
+	return domain.Bucket{}, false
+}
+
+// allBucketsPermissions grants read on each bucket the organization has now,
+// by ID. Buckets created later are deliberately not covered: an org-wide
+// bucket permission would silently extend the credential to them. "Now" is as
+// of the organization's listing, which may be up to bucketListingTTL old, see
+// orgBuckets.
+func (i *influxdbConnectionProducer) allBucketsPermissions(ctx context.Context, cli influxdb2.Client, org *domain.Organization) ([]domain.Permission, error) {
+	buckets, _, err := i.orgBuckets(ctx, cli, *org.Id, false)
+	if err != nil {
+		return nil, fmt.Errorf("preset %q could not list the buckets of organization %q: %w", presetReadAllBuckets, org.Name, err)
+	}
+	if len(buckets) == 0 {
+		return nil, withKind(ErrBucketNotFound, fmt.Errorf("preset %q found no buckets in organization %q", presetReadAllBuckets, org.Name))
+	}
+
+	permissions := make([]domain.Permission, len(buckets))
+	for idx, bucket := range buckets {
+		id, name := *bucket.Id, bucket.Name
+		permissions[idx] = domain.Permission{
+			Action: domain.PermissionActionRead,
+			Resource: domain.Resource{
+				Type:  domain.ResourceTypeBuckets,
+				Id:    &id,
+				Name:  &name,
+				OrgID: org.Id,
+			},
+		}
+	}
+	return permissions, nil
+}
+
+// requestedPermissions returns every permission requested by the creation
+// statements, with presets expanded in the given organization.
+func (i *influxdbConnectionProducer) requestedPermissions(ctx context.Context, cli influxdb2.Client, org *domain.Organization, stmt creationStatement) ([]domain.Permission, error) {
+	permissions := append([]domain.Permission(nil), stmt.Permissions...)
+	for _, preset := range stmt.Presets {
+		expanded, err := i.presetPermissions(ctx, cli, org, preset)
+		if err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, expanded...)
+	}
+	return permissions, nil
+}
@@ -0,0 +1,348 @@
+package influxdbv2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/database/dbplugin/v5"
+	dbtesting "github.com/hashicorp/vault/sdk/database/dbplugin/v5/testing"
+	"github.com/influxdata/influxdb-client-go/v2/domain"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInfluxdb_NewUser_PresetBucket(t *testing.T) {
+	const token = "root-token"
+	srv := newFakeInfluxServer(t, token)
+	orgID := srv.orgID("vault")
+	defaultBucketID := srv.addBucket(orgID, "default")
+	telegrafBucketID := srv.addBucket(orgID, "telegraf")
+
+	type testCase struct {
+		defaultBucket string
+		statement     string
+		expectBucket  string
+		expectErr     string
+	}
+
+	tests := map[string]testCase{
+		"default only": {
+			defaultBucket: "default",
+			statement:     `{"preset": "read_write"}`,
+			expectBucket:  defaultBucketID,
+		},
+		"statement only": {
+			statement:    `{"preset": "read_write", "bucket": "telegraf"}`,
+			expectBucket: telegrafBucketID,
+		},
+		"both uses statement": {
+			defaultBucket: "default",
+			statement:     `{"preset": "read_write", "bucket": "telegraf"}`,
+			expectBucket:  telegrafBucketID,
+		},
+		"neither": {
+			statement: `{"preset": "read_write"}`,
+			expectErr: `preset "read_write" requires a bucket`,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			db := new()
+			dbtesting.AssertInitialize(t, db, dbplugin.InitializeRequest{
+				Config: makeConfig(srv.connectionParams(token), "default_bucket", test.defaultBucket),
+			})
+			defer dbtesting.AssertClose(t, db)
+
+			req := dbplugin.NewUserRequest{
+				UsernameConfig: dbplugin.UsernameMetadata{
+					DisplayName: "test",
+					RoleName:    "test",
+				},
+				Statements: dbplugin.Statements{
+					Commands: []string{test.statement},
+				},
+				Password:   "nuozxby98523u89bdfnkjl",
+				Expiration: time.Now().Add(1 * time.Minute),
+			}
+			if test.expectErr != "" {
+				usersBefore := srv.callCount("POST /api/v2/users")
+				_, err := db.NewUser(context.Background(), req)
+				require.Error(t, err)
+				require.Contains(t, err.Error(), test.expectErr)
+				// The error is raised before anything is created.
+				require.Equal(t, usersBefore, srv.callCount("POST /api/v2/users"))
+				return
+			}
+
+			resp := dbtesting.AssertNewUser(t, db, req)
+			auths := srv.userAuthorizations(resp.Username)
+			require.Len(t, auths, 1)
+			permissions := *auths[0].Permissions
+			require.Len(t, permissions, 2)
+			require.Equal(t, domain.PermissionActionRead, permissions[0].Action)
+			require.Equal(t, domain.PermissionActionWrite, permissions[1].Action)
+			for _, p := range permissions {
+				require.Equal(t, domain.ResourceTypeBuckets, p.Resource.Type)
+				require.Equal(t, test.expectBucket, *p.Resource.Id)
+			}
+		})
+	}
+}
+
+func TestInfluxdb_NewUser_PresetReadAllBuckets(t *testing.T) {
+	const token = "root-token"
+	srv := newFakeInfluxServer(t, token)
+	orgID := srv.orgID("vault")
+	telegrafBucketID := srv.addBucket(orgID, "telegraf")
+	otherOrgID := srv.addOrg("other")
+	srv.addBucket(otherOrgID, "elsewhere")
+
+	db := new()
+	dbtesting.AssertInitialize(t, db, dbplugin.InitializeRequest{
+		Config: srv.connectionParams(token),
+	})
+	defer dbtesting.AssertClose(t, db)
+
+	req := dbplugin.NewUserRequest{
+		UsernameConfig: dbplugin.UsernameMetadata{
+			DisplayName: "test",
+			RoleName:    "test",
+		},
+		Statements: dbplugin.Statements{
+			Commands: []string{`{"preset": "read_all_buckets"}`},
+		},
+		Password:   "nuozxby98523u89bdfnkjl",
+		Expiration: time.Now().Add(1 * time.Minute),
+	}
+	resp := dbtesting.AssertNewUser(t, db, req)
+	auths := srv.userAuthorizations(resp.Username)
+	require.Len(t, auths, 1)
+
+	// The default "vault" bucket and "telegraf", but nothing from "other".
+	var bucketIDs []string
+	for _, p := range *auths[0].Permissions {
+		require.Equal(t, domain.PermissionActionRead, p.Action)
+		require.Equal(t, domain.ResourceTypeBuckets, p.Resource.Type)
+		require.Equal(t, orgID, *p.Resource.OrgID)
+		bucketIDs = append(bucketIDs, *p.Resource.Id)
+	}
+	require.Len(t, bucketIDs, 2)
+	require.Contains(t, bucketIDs, telegrafBucketID)
+
+	// Enumeration being denied fails the request before anything is created.
+	// Closing drops the listing the next request would reuse.
+	require.NoError(t, db.Close())
+	srv.handle("GET /api/v2/buckets", func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusForbidden, "forbidden", "insufficient permissions")
+	})
+	usersBefore := srv.callCount("POST /api/v2/users")
+	_, err := db.NewUser(context.Background(), req)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "could not list the buckets")
+	require.True(t, errors.Is(err, ErrInsufficientPermissions))
+	require.Equal(t, usersBefore, srv.callCount("POST /api/v2/users"))
+}
+
+func TestParseCreationStatements_ReadAllBucketsWithBucket(t *testing.T) {
+	_, err := parseCreationStatements(dbplugin.Statements{
+		Commands: []string{`{"preset": "read_all_buckets", "bucket": "telegraf"}`},
+	})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "cannot be combined")
+}
+
+func TestInfluxdb_NewUser_PresetMultipleBuckets(t *testing.T) {
+	const token = "root-token"
+	srv := newFakeInfluxServer(t, token)
+	orgID := srv.orgID("vault")
+	telegrafBucketID := srv.addBucket(orgID, "telegraf")
+	metricsBucketID := srv.addBucket(orgID, "metrics")
+	logsBucketID := srv.addBucket(orgID, "logs")
+	srv.addBucket(orgID, "unrelated")
+	otherBucketID := srv.addBucket(srv.addOrg("other"), "elsewhere")
+
+	type testCase struct {
+		statement     string
+		expectBuckets []string
+		expectErr     string
+	}
+
+	tests := map[string]testCase{
+		"names": {
+			statement:     `{"preset": "write", "buckets": ["telegraf", "metrics"]}`,
+			expectBuckets: []string{telegrafBucketID, metricsBucketID},
+		},
+		"ids": {
+			statement:     `{"preset": "write", "bucket_ids": ["` + logsBucketID + `", "` + metricsBucketID + `"]}`,
+			expectBuckets: []string{logsBucketID, metricsBucketID},
+		},
+		"names and ids, deduplicated": {
+			statement:     `{"preset": "write", "buckets": ["telegraf", "metrics", "telegraf"], "bucket_ids": ["` + metricsBucketID + `", "` + logsBucketID + `"]}`,
+			expectBuckets: []string{telegrafBucketID, metricsBucketID, logsBucketID},
+		},
+		"unknown name": {
+			statement: `{"preset": "write", "buckets": ["telegraf", "missing", "gone"]}`,
+			expectErr: "bucket 'missing' not found",
+		},
+		"unknown id": {
+			statement: `{"preset": "write", "bucket_ids": ["` + metricsBucketID + `", "0123456789abcdef"]}`,
+			expectErr: "bucket with ID '0123456789abcdef' not found",
+		},
+		"id from another organization": {
+			statement: `{"preset": "write", "bucket_ids": ["` + otherBucketID + `"]}`,
+			expectErr: "bucket with ID '" + otherBucketID + "' not found",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			db := new()
+			dbtesting.AssertInitialize(t, db, dbplugin.InitializeRequest{
+				Config: makeConfig(srv.connectionParams(token), "default_bucket", "unrelated"),
+			})
+			defer dbtesting.AssertClose(t, db)
+
+			req := dbplugin.NewUserRequest{
+				UsernameConfig: dbplugin.UsernameMetadata{
+					DisplayName: "test",
+					RoleName:    "test",
+				},
+				Statements: dbplugin.Statements{
+					Commands: []string{test.statement},
+				},
+				Password:   "nuozxby98523u89bdfnkjl",
+				Expiration: time.Now().Add(1 * time.Minute),
+			}
+			if test.expectErr != "" {
+				usersBefore := srv.callCount("POST /api/v2/users")
+				_, err := db.NewUser(context.Background(), req)
+				require.Error(t, err)
+				require.Contains(t, err.Error(), test.expectErr)
+				require.True(t, errors.Is(err, ErrBucketNotFound))
+				require.Equal(t, usersBefore, srv.callCount("POST /api/v2/users"))
+				return
+			}
+
+			resp := dbtesting.AssertNewUser(t, db, req)
+			auths := srv.userAuthorizations(resp.Username)
+			require.Len(t, auths, 1)
+			var bucketIDs []string
+			for _, p := range *auths[0].Permissions {
+				require.Equal(t, domain.PermissionActionWrite, p.Action)
+				require.Equal(t, domain.ResourceTypeBuckets, p.Resource.Type)
+				require.Equal(t, orgID, *p.Resource.OrgID)
+				bucketIDs = append(bucketIDs, *p.Resource.Id)
+			}
+			require.Equal(t, test.expectBuckets, bucketIDs)
+		})
+	}
+}
+
+func TestParseCreationStatements_MultipleBuckets(t *testing.T) {
+	for statement, expectErr := range map[string]string{
+		`{"preset": "write", "bucket": "telegraf", "buckets": ["metrics"]}`:  `"bucket" and "buckets" cannot both be set`,
+		`{"preset": "write", "buckets": ["telegraf", " "]}`:                  "cannot contain an empty entry",
+		`{"preset": "read_all_buckets", "bucket_ids": ["0123456789abcdef"]}`: "cannot be combined",
+		`{"buckets": ["telegraf"]}`:                                          `require a "preset"`,
+	} {
+		_, err := parseCreationStatements(dbplugin.Statements{Commands: []string{statement}})
+		require.Error(t, err, statement)
+		require.Contains(t, err.Error(), expectErr, statement)
+	}
+}
+
+func TestInfluxdb_NewUser_ComposedPermissions(t *testing.T) {
+	const token = "root-token"
+	srv := newFakeInfluxServer(t, token)
+	orgID := srv.orgID("vault")
+	telegrafBucketID := srv.addBucket(orgID, "telegraf")
+	alertsBucketID := srv.addBucket(orgID, "alerts")
+	const telegrafConfigID = "0123456789abcdef"
+
+	db := new()
+	dbtesting.AssertInitialize(t, db, dbplugin.InitializeRequest{
+		Config: srv.connectionParams(token),
+	})
+	defer dbtesting.AssertClose(t, db)
+
+	newUser := func(commands ...string) (dbplugin.NewUserResponse, error) {
+		return db.NewUser(context.Background(), dbplugin.NewUserRequest{
+			UsernameConfig: dbplugin.UsernameMetadata{
+				DisplayName: "test",
+				RoleName:    "test",
+			},
+			Statements: dbplugin.Statements{Commands: commands},
+			Password:   "nuozxby98523u89bdfnkjl",
+			Expiration: time.Now().Add(time.Minute),
+		})
+	}
+
+	// An agent writing metrics and managing its own telegraf config.
+	resp, err := newUser(`{
+		"preset": "write",
+		"bucket": "telegraf",
+		"presets": [
+			{"preset": "read", "bucket": "alerts"},
+			{"preset": "write", "bucket_ids": ["` + telegrafBucketID + `"]}
+		],
+		"permissions": [
+			{"action": "read", "resource": {"type": "telegrafs", "id": "` + telegrafConfigID + `"}},
+			{"action": "write", "resource": {"type": "telegrafs", "id": "` + telegrafConfigID + `"}},
+			{"action": "write", "resource": {"type": "buckets", "name": "telegraf"}}
+		]
+	}`)
+	require.NoError(t, err)
+
+	auths := srv.userAuthorizations(resp.Username)
+	require.Len(t, auths, 1)
+	granted := map[string]bool{}
+	for _, p := range *auths[0].Permissions {
+		granted[fmt.Sprintf("%s %s %s", p.Action, p.Resource.Type, *p.Resource.Id)] = true
+	}
+	require.Equal(t, map[string]bool{
+		"write buckets " + telegrafBucketID:   true,
+		"read buckets " + alertsBucketID:      true,
+		"read telegrafs " + telegrafConfigID:  true,
+		"write telegrafs " + telegrafConfigID: true,
+	}, granted)
+	require.Len(t, *auths[0].Permissions, 4)
+
+	// The same bucket can't be given two names.
+	usersBefore := srv.callCount("POST /api/v2/users")
+	_, err = newUser(`{
+		"preset": "write",
+		"bucket": "telegraf",
+		"permissions": [{"action": "write", "resource": {"type": "buckets", "id": "` + telegrafBucketID + `", "name": "alerts"}}]
+	}`)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "conflicting permissions")
+	require.Equal(t, usersBefore, srv.callCount("POST /api/v2/users"))
+}
+
+func TestParseCreationStatements_Presets(t *testing.T) {
+	stmt, err := parseCreationStatements(dbplugin.Statements{Commands: []string{
+		`{"preset": "read", "presets": [{"preset": "write", "buckets": ["a", "b"]}, {"preset": "read_all_buckets"}]}`,
+	}})
+	require.NoError(t, err)
+	require.Equal(t, []presetStatement{
+		{Name: presetRead},
+		{Name: presetWrite, Buckets: []string{"a", "b"}},
+		{Name: presetReadAllBuckets},
+	}, stmt.Presets)
+
+	for statement, expectErr := range map[string]string{
+		`{"presets": [{"bucket": "telegraf"}]}`:                               `presets[0]: "bucket" requires a "preset"`,
+		`{"presets": [{"preset": "read"}, {}]}`:                               `presets[1]: "presets" entries require a "preset"`,
+		`{"presets": [{"preset": "admin"}]}`:                                  `unknown preset "admin"`,
+		`{"presets": [{"preset": "write", "bucket": "a", "buckets": ["b"]}]}`: `"bucket" and "buckets" cannot both be set`,
+		`{"presets": [{"preset": "write", "organization": "metrics"}]}`:       `unknown field "organization"`,
+	} {
+		_, err := parseCreationStatements(dbplugin.Statements{Commands: []string{statement}})
+		require.Error(t, err, statement)
+		require.Contains(t, err.Error(), expectErr, statement)
+	}
+}
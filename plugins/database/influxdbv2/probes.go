@@ -0,0 +1,147 @@
+package influxdbv2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/influxdata/influxdb-client-go/v2"
+	ihttp "github.com/influxdata/influxdb-client-go/v2/api/http"
+	"github.com/influxdata/influxdb-client-go/v2/domain"
+)
+
+// ProbeCondition classifies the outcome of a probe, so that automation can
+// tell a server that is still starting, and is worth waiting for, from one
+// that is unhealthy.
+type ProbeCondition string
+
+const (
+	ProbeReady     ProbeCondition = "ready"
+	ProbeNotReady  ProbeCondition = "not_ready"
+	ProbeHealthy   ProbeCondition = "healthy"
+	ProbeUnhealthy ProbeCondition = "unhealthy"
+)
+
+// ProbeStatus is the result of a Ready or Health probe. Fields the server
+// didn't report are left empty.
+type ProbeStatus struct {
+	Condition ProbeCondition
+	Status    string
+	Message   string
+	Version   string
+	Started   time.Time
+	Uptime    string
+}
+
+// Ready probes the server's readiness endpoint, which reports whether it has
+// finished starting up.
+func (i *InfluxdbV2) Ready(ctx context.Context) (ProbeStatus, error) {
+	i.Lock()
+	defer i.Unlock()
+
+	cli, release, err := i.probeClient(ctx)
+	if err != nil {
+		return ProbeStatus{}, fmt.Errorf("unable to get connection: %w", err)
+	}
+	defer release()
+	return readyStatus(ctx, cli)
+}
+
+// Health probes the server's health endpoint, which reports whether a
+// started server is working.
+func (i *InfluxdbV2) Health(ctx context.Context) (ProbeStatus, error) {
+	i.Lock()
+	defer i.Unlock()
+
+	cli, release, err := i.probeClient(ctx)
+	if err != nil {
+		return ProbeStatus{}, fmt.Errorf("unable to get connection: %w", err)
+	}
+	defer release()
+	return healthStatus(ctx, cli)
+}
+
+// probeClient returns the client probes are sent to, and a function to call
+// once done with it. Unless verify_endpoint is set, that is the cached
+// connection. Otherwise it is a client of its own for the verify endpoint,
+// closed after the probe, and built without the token: the probe endpoints
+// need none, and the client can't be used for anything else.
+func (i *influxdbConnectionProducer) probeClient(ctx context.Context) (influxdb2.Client, func(), error) {
+	if i.verifyEndpoint == "" {
+		conn, err := i.Connection(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		return conn.(influxdb2.Client), func() {}, nil
+	}
+	if !i.Initialized {
+		return nil, nil, ErrNotInitialized
+	}
+	options, err := i.clientOptions()
+	if err != nil {
+		return nil, nil, err
+	}
+	cli := newInfluxClient(i.scheme()+"://"+i.verifyEndpoint, "", options)
+	release := func() {
+		if err := closeClient(cli); err != nil {
+			i.logger.Warn("failed to close verify_endpoint connection", "error", err)
+		}
+	}
+	return cli, release, nil
+}
+
+func readyStatus(ctx context.Context, cli influxdb2.Client) (ProbeStatus, error) {
+	ready, err := cli.Ready(ctx)
+	if err != nil {
+		// A starting server answers 503 until it is ready.
+		var httpErr *ihttp.Error
+		if errors.As(err, &httpErr) && httpErr.StatusCode == http.StatusServiceUnavailable {
+			return ProbeStatus{
+				Condition: ProbeNotReady,
+				Message:   httpErr.Message,
+			}, nil
+		}
+		return ProbeStatus{}, err
+	}
+
+	status := ProbeStatus{
+		Condition: ProbeNotReady,
+	}
+	if ready.Status != nil {
+		status.Status = string(*ready.Status)
+		if *ready.Status == domain.ReadyStatusReady {
+			status.Condition = ProbeReady
+		}
+	}
+	if ready.Started != nil {
+		status.Started = *ready.Started
+	}
+	if ready.Up != nil {
+		status.Uptime = *ready.Up
+	}
+	return status, nil
+}
+
+func healthStatus(ctx context.Context, cli influxdb2.Client) (ProbeStatus, error) {
+	health, err := cli.Health(ctx)
+	if err != nil {
+		return ProbeStatus{}, err
+	}
+
+	status := ProbeStatus{
+		Condition: ProbeUnhealthy,
+		Status:    string(health.Status),
+	}
+	if health.Status == domain.HealthCheckStatusPass {
+		status.Condition = ProbeHealthy
+	}
+	if health.Message != nil {
+		status.Message = *health.Message
+	}
+	if health.Version != nil {
+		status.Version = *health.Version
+	}
+	return status, nil
+}
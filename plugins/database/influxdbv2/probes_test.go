@@ -0,0 +1,211 @@
+package influxdbv2
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/database/dbplugin/v5"
+	dbtesting "github.com/hashicorp/vault/sdk/database/dbplugin/v5/testing"
+	"github.com/influxdata/influxdb-client-go/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInfluxdb_Probes(t *testing.T) {
+	const token = "root-token"
+	srv := newFakeInfluxServer(t, token)
+
+	db := new()
+	dbtesting.AssertInitialize(t, db, dbplugin.InitializeRequest{
+		Config:           srv.connectionParams(token),
+		VerifyConnection: true,
+	})
+	defer dbtesting.AssertClose(t, db)
+
+	ready, err := db.Ready(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, ProbeReady, ready.Condition)
+	require.Equal(t, "1h0m0s", ready.Uptime)
+	require.False(t, ready.Started.IsZero())
+
+	health, err := db.Health(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, ProbeHealthy, health.Condition)
+	require.Equal(t, "2.1.1", health.Version)
+
+	// A starting server isn't ready yet, which is distinct from unhealthy.
+	srv.handle("GET /ready", func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusServiceUnavailable, "unavailable", "starting")
+	})
+	ready, err = db.Ready(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, ProbeNotReady, ready.Condition)
+
+	srv.handle("GET /health", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]interface{}{"name": "influxdb", "status": "fail", "message": "bolt store unavailable"})
+	})
+	health, err = db.Health(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, ProbeUnhealthy, health.Condition)
+	require.Equal(t, "bolt store unavailable", health.Message)
+
+	// verify_connection checks health.
+	_, err = new().Initialize(context.Background(), dbplugin.InitializeRequest{
+		Config:           srv.connectionParams(token),
+		VerifyConnection: true,
+	})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "server is unhealthy: bolt store unavailable")
+}
+
+func TestInfluxdb_VerifyEndpoint(t *testing.T) {
+	const token = "root-token"
+	primary := newFakeInfluxServer(t, token)
+	primary.addBucket(primary.orgID("vault"), "telegraf")
+	replica := newFakeInfluxServer(t, token)
+
+	var probeAuthorization []string
+	replica.handle("GET /health", func(w http.ResponseWriter, r *http.Request) {
+		probeAuthorization = append(probeAuthorization, r.Header.Get("Authorization"))
+		writeJSON(w, http.StatusOK, map[string]interface{}{"name": "influxdb", "status": "pass", "version": "2.1.1"})
+	})
+
+	config := makeConfig(primary.connectionParams(token), "verify_endpoint", endpointOf(t, replica))
+	db := new()
+	dbtesting.AssertInitialize(t, db, dbplugin.InitializeRequest{
+		Config:           config,
+		VerifyConnection: true,
+	})
+	defer dbtesting.AssertClose(t, db)
+	require.Equal(t, 1, replica.callCount("GET /health"))
+	require.Equal(t, 1, replica.callCount("GET /ping"))
+
+	health, err := db.Health(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, ProbeHealthy, health.Condition)
+	ready, err := db.Ready(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, ProbeReady, ready.Condition)
+	require.Equal(t, 2, replica.callCount("GET /health"))
+	require.Equal(t, 1, replica.callCount("GET /ready"))
+	require.Zero(t, primary.callCount("GET /health"))
+	require.Zero(t, primary.callCount("GET /ready"))
+
+	// Probes never carry the token, and credentials go to the primary.
+	require.Equal(t, []string{"", ""}, probeAuthorization)
+	username := newExpiringUser(t, db, time.Now().Add(time.Hour))
+	require.Len(t, primary.userAuthorizations(username), 1)
+	require.Zero(t, replica.callCount("POST /api/v2/users"))
+	require.Zero(t, replica.callCount("GET /api/v2/authorizations"))
+
+	// The verify endpoint must be reachable and healthy.
+	_, err = new().Initialize(context.Background(), dbplugin.InitializeRequest{
+		Config:           makeConfig(config, "verify_endpoint", unusedEndpoint(t), "connect_timeout", "1s"),
+		VerifyConnection: true,
+	})
+	require.Error(t, err)
+	require.True(t, errors.Is(err, ErrUnreachable), err.Error())
+	require.Contains(t, err.Error(), "verify_endpoint")
+
+	replica.handle("GET /health", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]interface{}{"name": "influxdb", "status": "fail", "message": "replica lagging"})
+	})
+	_, err = new().Initialize(context.Background(), dbplugin.InitializeRequest{
+		Config:           config,
+		VerifyConnection: true,
+	})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "server is unhealthy: replica lagging")
+
+	// Without a port, the port of the endpoints is used.
+	db = new()
+	dbtesting.AssertInitialize(t, db, dbplugin.InitializeRequest{
+		Config: makeConfig(config, "verify_endpoint", "replica.example.com"),
+	})
+	defer dbtesting.AssertClose(t, db)
+	require.Equal(t, "replica.example.com:"+config["port"].(string), db.verifyEndpoint)
+
+	_, err = new().Initialize(context.Background(), dbplugin.InitializeRequest{
+		Config: makeConfig(config, "verify_endpoint", "http://replica.example.com"),
+	})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "invalid verify_endpoint")
+}
+
+func TestInitialize_RequireHealthy(t *testing.T) {
+	const token = "root-token"
+	healthy := newFakeInfluxServer(t, token)
+	degraded := newFakeInfluxServer(t, token)
+	degraded.handle("GET /health", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]interface{}{
+			"name":    "influxdb",
+			"status":  "fail",
+			"message": "compaction backlog",
+			"version": "2.1.1",
+		})
+	})
+
+	tests := map[string]struct {
+		endpoints      []string
+		requireHealthy bool
+		expectedErr    bool
+		unhealthy      bool
+		expectedMsg    string
+		expectedHost   string
+	}{
+		"healthy": {
+			endpoints:      []string{endpointOf(t, healthy)},
+			requireHealthy: true,
+			expectedHost:   endpointOf(t, healthy),
+		},
+		"degraded": {
+			endpoints:      []string{endpointOf(t, degraded)},
+			requireHealthy: true,
+			expectedErr:    true,
+			unhealthy:      true,
+			expectedMsg:    "server is unhealthy: compaction backlog",
+		},
+		"degraded without require_healthy": {
+			endpoints:    []string{endpointOf(t, degraded)},
+			expectedHost: endpointOf(t, degraded),
+		},
+		"degraded then healthy": {
+			endpoints:      []string{endpointOf(t, degraded), endpointOf(t, healthy)},
+			requireHealthy: true,
+			expectedHost:   endpointOf(t, healthy),
+		},
+		"unreachable": {
+			endpoints:      []string{unusedEndpoint(t)},
+			requireHealthy: true,
+			expectedErr:    true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			db := new()
+			dbtesting.AssertInitialize(t, db, dbplugin.InitializeRequest{
+				Config: makeConfig(healthy.connectionParams(token),
+					"host", "",
+					"port", "",
+					"endpoints", test.endpoints,
+					"require_healthy", test.requireHealthy,
+				),
+			})
+			defer dbtesting.AssertClose(t, db)
+
+			conn, err := db.Connection(context.Background())
+			if !test.expectedErr {
+				require.NoError(t, err)
+				require.Contains(t, conn.(influxdb2.Client).ServerURL(), test.expectedHost)
+				return
+			}
+			require.Error(t, err)
+			require.True(t, errors.Is(err, ErrUnreachable), err)
+			require.Equal(t, test.unhealthy, errors.Is(err, ErrUnhealthy), err)
+			require.Contains(t, err.Error(), test.expectedMsg)
+		})
+	}
+}
@@ -0,0 +1,224 @@
+package influxdbv2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// The plugin runs in a process of its own, which Vault's telemetry sinks
+// don't reach, so its metrics are recorded in a Prometheus registry of their
+// own, and served in the Prometheus text format from the plugin process when
+// metrics_listen_addr is set. They are always recorded. Their labels are
+// fixed sets of values: the cause or kind of an error, an operation name, an
+// HTTP method or status code. Nothing configured, let alone a token or
+// password, ever ends up in them.
+//
+// The endpoint belongs to the process, which may serve several connections:
+// connections configuring the same address share its listener, which is
+// closed when the last of them is closed or configures another address.
+
+const metricsNamespace = "vault_influxdbv2"
+
+var (
+	metricsRegistry = prometheus.NewRegistry()
+
+	promConnectionErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "connection_errors_total",
+		Help:      "Failures to establish a connection to InfluxDB, by cause.",
+	}, []string{"cause"})
+	promClientsCreated = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "clients_created_total",
+		Help:      "Clients created to connect to InfluxDB.",
+	})
+	promOperations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "operations_total",
+		Help:      "Credential operations, by operation and outcome.",
+	}, []string{"operation", "outcome"})
+	promOperationErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "operation_errors_total",
+		Help:      "Failed credential operations, by operation and kind of error.",
+	}, []string{"operation", "kind"})
+	promOperationDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "operation_duration_seconds",
+		Help:      "Duration of credential operations, by operation.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation"})
+	promRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "requests_total",
+		Help:      "Requests sent to InfluxDB, by method and status code.",
+	}, []string{"method", "code"})
+	promRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "request_duration_seconds",
+		Help:      "Duration of the requests sent to InfluxDB, until their response headers, by method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method"})
+)
+
+func init() {
+	metricsRegistry.MustRegister(
+		promConnectionErrors,
+		promClientsCreated,
+		promOperations,
+		promOperationErrors,
+		promOperationDuration,
+		promRequests,
+		promRequestDuration,
+	)
+}
+
+// metricsTransport records promRequests and promRequestDuration. Requests
+// that fail without a response are counted with the code "error".
+type metricsTransport struct {
+	wrappedTransport
+}
+
+func (t *metricsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	promRequestDuration.WithLabelValues(req.Method).Observe(time.Since(start).Seconds())
+	code := "error"
+	if err == nil {
+		code = strconv.Itoa(resp.StatusCode)
+	}
+	promRequests.WithLabelValues(req.Method, code).Inc()
+	return resp, err
+}
+
+// recordOperation records the outcome of a credential operation, as audited.
+func recordOperation(event auditEvent, err error) {
+	outcome := auditOutcomeSuccess
+	switch {
+	case err != nil:
+		outcome = auditOutcomeFailure
+		promOperationErrors.WithLabelValues(event.operation, auditErrorKind(err)).Inc()
+	case event.retained:
+		outcome = auditOutcomeRetained
+	}
+	promOperations.WithLabelValues(event.operation, outcome).Inc()
+	if !event.start.IsZero() {
+		promOperationDuration.WithLabelValues(event.operation).Observe(time.Since(event.start).Seconds())
+	}
+}
+
+// validateMetricsListenAddr checks metrics_listen_addr, which must be a
+// loopback address unless allowRemote is set.
+func validateMetricsListenAddr(addr string, allowRemote bool) error {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid metrics_listen_addr: %w", err)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		return fmt.Errorf("invalid metrics_listen_addr: invalid port %q", port)
+	}
+	if allowRemote || host == "localhost" {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+		return fmt.Errorf("metrics_listen_addr %q isn't a loopback address, set metrics_listen_allow_remote to serve metrics beyond localhost", addr)
+	}
+	return nil
+}
+
+// metricsEndpoint is a listener serving metricsRegistry.
+type metricsEndpoint struct {
+	server   *http.Server
+	listener net.Listener
+	refs     int
+}
+
+// metricsEndpoints are the listeners of the process, by configured address.
+var metricsEndpoints = struct {
+	sync.Mutex
+	byAddr map[string]*metricsEndpoint
+}{byAddr: map[string]*metricsEndpoint{}}
+
+// acquireMetricsEndpoint starts serving metrics on addr, unless a connection
+// already does.
+func acquireMetricsEndpoint(addr string) error {
+	metricsEndpoints.Lock()
+	defer metricsEndpoints.Unlock()
+
+	if endpoint, ok := metricsEndpoints.byAddr[addr]; ok {
+		endpoint.refs++
+		return nil
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("unable to serve metrics on metrics_listen_addr: %w", err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{}))
+	endpoint := &metricsEndpoint{
+		server:   &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second},
+		listener: listener,
+		refs:     1,
+	}
+	go func() { _ = endpoint.server.Serve(listener) }()
+	metricsEndpoints.byAddr[addr] = endpoint
+	return nil
+}
+
+// releaseMetricsEndpoint stops serving metrics on addr once no connection
+// configures it anymore.
+func releaseMetricsEndpoint(addr string) {
+	metricsEndpoints.Lock()
+	defer metricsEndpoints.Unlock()
+
+	endpoint, ok := metricsEndpoints.byAddr[addr]
+	if !ok {
+		return
+	}
+	endpoint.refs--
+	if endpoint.refs > 0 {
+		return
+	}
+	delete(metricsEndpoints.byAddr, addr)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := endpoint.server.Shutdown(ctx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		_ = endpoint.server.Close()
+	}
+}
+
+// serveMetrics moves the connection's metrics endpoint to
+// metrics_listen_addr, if it changed. It must be called with the lock held.
+func (i *influxdbConnectionProducer) serveMetrics() error {
+	if i.MetricsListenAddr == i.metricsAddr {
+		return nil
+	}
+	if i.MetricsListenAddr != "" {
+		if err := acquireMetricsEndpoint(i.MetricsListenAddr); err != nil {
+			return err
+		}
+	}
+	if i.metricsAddr != "" {
+		releaseMetricsEndpoint(i.metricsAddr)
+	}
+	i.metricsAddr = i.MetricsListenAddr
+	return nil
+}
+
+// stopServingMetrics releases the connection's metrics endpoint. It must be
+// called with the lock held.
+func (i *influxdbConnectionProducer) stopServingMetrics() {
+	if i.metricsAddr != "" {
+		releaseMetricsEndpoint(i.metricsAddr)
+		i.metricsAddr = ""
+	}
+}
@@ -0,0 +1,139 @@
+package influxdbv2
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/database/dbplugin/v5"
+	dbtesting "github.com/hashicorp/vault/sdk/database/dbplugin/v5/testing"
+	"github.com/stretchr/testify/require"
+)
+
+// metricsListenerAddr returns the address the endpoint configured as addr
+// listens on, or "" if there is none.
+func metricsListenerAddr(addr string) string {
+	metricsEndpoints.Lock()
+	defer metricsEndpoints.Unlock()
+	if endpoint, ok := metricsEndpoints.byAddr[addr]; ok {
+		return endpoint.listener.Addr().String()
+	}
+	return ""
+}
+
+func scrapeMetrics(t *testing.T, addr string) string {
+	t.Helper()
+	resp, err := http.Get("http://" + addr + "/metrics")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return string(body)
+}
+
+func TestInitialize_MetricsListenAddr(t *testing.T) {
+	const token = "root-token"
+	const addr = "127.0.0.1:0"
+	srv := newFakeInfluxServer(t, token)
+
+	db := new()
+	dbtesting.AssertInitialize(t, db, dbplugin.InitializeRequest{
+		Config:           makeConfig(srv.connectionParams(token), "metrics_listen_addr", addr),
+		VerifyConnection: true,
+	})
+	listening := metricsListenerAddr(addr)
+	require.NotEmpty(t, listening)
+
+	password := "nuozxby98523u89bdfnkjl"
+	dbtesting.AssertNewUser(t, db, dbplugin.NewUserRequest{
+		UsernameConfig: dbplugin.UsernameMetadata{DisplayName: "test", RoleName: "test"},
+		Statements:     dbplugin.Statements{Commands: []string{`{"permissions": [{"action": "read", "resource": {"type": "buckets"}}]}`}},
+		Password:       password,
+		Expiration:     time.Now().Add(time.Minute),
+	})
+	_, err := db.NewUser(context.Background(), dbplugin.NewUserRequest{
+		UsernameConfig: dbplugin.UsernameMetadata{DisplayName: "test", RoleName: "test"},
+		Statements:     dbplugin.Statements{Commands: []string{`{"preset": "admin"}`}},
+		Password:       password,
+	})
+	require.Error(t, err)
+	recordConnectionError(errors.New("connection reset"))
+
+	body := scrapeMetrics(t, listening)
+	for _, name := range []string{
+		`vault_influxdbv2_connection_errors_total{cause="other"}`,
+		`vault_influxdbv2_clients_created_total`,
+		`vault_influxdbv2_operations_total{operation="NewUser",outcome="success"}`,
+		`vault_influxdbv2_operations_total{operation="NewUser",outcome="failure"}`,
+		`vault_influxdbv2_operation_errors_total{kind="error",operation="NewUser"}`,
+		`vault_influxdbv2_operation_duration_seconds_bucket{operation="NewUser"`,
+		`vault_influxdbv2_requests_total{code="201",method="POST"}`,
+		`vault_influxdbv2_request_duration_seconds_bucket{method="GET"`,
+	} {
+		require.Contains(t, body, name)
+	}
+	require.NotContains(t, body, token)
+	require.NotContains(t, body, password)
+	require.NotContains(t, body, srv.URL)
+
+	// A second connection on the same address shares the endpoint, which
+	// lives until both are closed.
+	other := new()
+	dbtesting.AssertInitialize(t, other, dbplugin.InitializeRequest{
+		Config: makeConfig(srv.connectionParams(token), "metrics_listen_addr", addr),
+	})
+	require.Equal(t, listening, metricsListenerAddr(addr))
+	dbtesting.AssertClose(t, db)
+	scrapeMetrics(t, listening)
+
+	// Unsetting the address releases it.
+	dbtesting.AssertInitialize(t, other, dbplugin.InitializeRequest{
+		Config: makeConfig(srv.connectionParams(token), "metrics_listen_addr", ""),
+	})
+	require.Empty(t, metricsListenerAddr(addr))
+	_, err = http.Get("http://" + listening + "/metrics")
+	require.Error(t, err)
+	dbtesting.AssertClose(t, other)
+}
+
+func TestInitialize_MetricsListenAddrOffByDefault(t *testing.T) {
+	srv := newFakeInfluxServer(t, "root-token")
+	db := new()
+	dbtesting.AssertInitialize(t, db, dbplugin.InitializeRequest{
+		Config: srv.connectionParams("root-token"),
+	})
+	defer dbtesting.AssertClose(t, db)
+	require.Empty(t, db.metricsAddr)
+}
+
+func TestValidateConfig_MetricsListenAddr(t *testing.T) {
+	base := map[string]interface{}{"host": "influx.example.com", "token": "token"}
+	for name, tc := range map[string]struct {
+		kv  []interface{}
+		err string
+	}{
+		"loopback":              {[]interface{}{"metrics_listen_addr", "127.0.0.1:9273"}, ""},
+		"localhost":             {[]interface{}{"metrics_listen_addr", "localhost:9273"}, ""},
+		"ipv6 loopback":         {[]interface{}{"metrics_listen_addr", "[::1]:9273"}, ""},
+		"every interface":       {[]interface{}{"metrics_listen_addr", ":9273"}, "isn't a loopback address"},
+		"remote":                {[]interface{}{"metrics_listen_addr", "10.0.0.1:9273"}, "set metrics_listen_allow_remote"},
+		"remote allowed":        {[]interface{}{"metrics_listen_addr", "0.0.0.0:9273", "metrics_listen_allow_remote", true}, ""},
+		"missing port":          {[]interface{}{"metrics_listen_addr", "127.0.0.1"}, "invalid metrics_listen_addr"},
+		"invalid port":          {[]interface{}{"metrics_listen_addr", "127.0.0.1:http"}, "invalid port"},
+		"allow without address": {[]interface{}{"metrics_listen_allow_remote", true}, "metrics_listen_allow_remote requires metrics_listen_addr"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			err := ValidateConfig(makeConfig(base, tc.kv...))
+			if tc.err == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			require.Contains(t, err.Error(), tc.err)
+		})
+	}
+}
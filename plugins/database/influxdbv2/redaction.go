@@ -0,0 +1,42 @@
+package influxdbv2
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+const (
+	// redactionMarkerFixed replaces a secret by its name in brackets, such
+	// as "[token]".
+	redactionMarkerFixed = "fixed"
+	// redactionMarkerHash appends a short correlation hint to the name, such
+	// as "[token:1f2e3d4c]", so that different secrets can be told apart.
+	redactionMarkerHash = "hash"
+
+	// redactionHintLength is the number of hex digits of a correlation hint.
+	redactionHintLength = 8
+)
+
+// redactionKey keys the correlation hints. It is generated per process, so a
+// hint can't be matched against the hash of a guessed secret, nor against
+// hints logged by another process.
+var redactionKey = func() []byte {
+	key := make([]byte, sha256.Size)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	return key
+}()
+
+// redactionMarker returns the marker replacing secret, named name, in
+// redacted output.
+func (i *influxdbConnectionProducer) redactionMarker(name, secret string) string {
+	if i.RedactionMarker != redactionMarkerHash || secret == "" {
+		return "[" + name + "]"
+	}
+	mac := hmac.New(sha256.New, redactionKey)
+	mac.Write([]byte(secret))
+	return "[" + name + ":" + hex.EncodeToString(mac.Sum(nil))[:redactionHintLength] + "]"
+}
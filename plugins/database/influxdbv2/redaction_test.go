@@ -0,0 +1,79 @@
+package influxdbv2
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"regexp"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/database/dbplugin/v5"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedactionMarker(t *testing.T) {
+	sanitize := func(t *testing.T, token string, kv ...interface{}) sanitizedConfig {
+		t.Helper()
+		db := new()
+		_, err := db.Initialize(context.Background(), dbplugin.InitializeRequest{
+			Config: makeConfig(map[string]interface{}{
+				"host":           "influx.example.com",
+				"token":          token,
+				"organization":   "vault",
+				"default_bucket": token,
+			}, kv...),
+		})
+		require.NoError(t, err)
+		b, err := db.SanitizedConfig()
+		require.NoError(t, err)
+		require.NotContains(t, string(b), token)
+
+		var config sanitizedConfig
+		require.NoError(t, json.Unmarshal(b, &config))
+		return config
+	}
+
+	const first = "first-secret-token"
+	const second = "second-secret-token"
+
+	// The fixed marker is the default.
+	config := sanitize(t, first)
+	require.Equal(t, redactionMarkerFixed, config.RedactionMarker)
+	require.Equal(t, "[token]", config.DefaultBucket)
+
+	// Hashed markers tell secrets apart and are stable for a secret.
+	firstMarker := sanitize(t, first, "redaction_marker", "hash").DefaultBucket
+	require.Regexp(t, regexp.MustCompile(`^\[token:[0-9a-f]{8}\]$`), firstMarker)
+	require.Equal(t, firstMarker, sanitize(t, first, "redaction_marker", "hash").DefaultBucket)
+	require.NotEqual(t, firstMarker, sanitize(t, second, "redaction_marker", "hash").DefaultBucket)
+
+	// The hint is keyed, so it isn't the hash of the secret.
+	sum := sha256.Sum256([]byte(first))
+	require.NotContains(t, firstMarker, hex.EncodeToString(sum[:])[:redactionHintLength])
+
+	// Errors are redacted with the same marker.
+	db := new()
+	_, err := db.Initialize(context.Background(), dbplugin.InitializeRequest{
+		Config: map[string]interface{}{
+			"host":             "influx.example.com",
+			"token":            first,
+			"redaction_marker": "hash",
+		},
+	})
+	require.NoError(t, err)
+	redacted := redactString("rejected token "+first, db.secretValues())
+	require.Equal(t, "rejected token "+firstMarker, redacted)
+	require.NotContains(t, redacted, first)
+
+	_, err = new().Initialize(context.Background(), dbplugin.InitializeRequest{
+		Config: map[string]interface{}{
+			"host":             "influx.example.com",
+			"token":            first,
+			"redaction_marker": "last4",
+		},
+	})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "invalid redaction_marker")
+	require.NotContains(t, err.Error(), first)
+}
@@ -0,0 +1,77 @@
+package influxdbv2
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Diagnose attaches a remediation hint to every failed step it can classify,
+// for operators unfamiliar with InfluxDB and its permission model. Hints are
+// matched on the kind of the error rather than on its message.
+
+const (
+	hintNotInitialized   = "configure the connection first; Initialize has not succeeded"
+	hintDNS              = "the host name does not resolve: check host or url, and dns_resolver if set"
+	hintRefused          = "nothing accepts connections on the port: check that InfluxDB is running, and that host and port (8086 by default) point at it"
+	hintTimeout          = "the server did not answer in time: check that no firewall blocks the port (8086 by default) between Vault and InfluxDB, and connect_timeout"
+	hintTLS              = "the TLS handshake failed: check that the server certificate is valid for the host and issued by a CA in pem_bundle, pem_json or tls_ca_file, and that tls matches whether the server serves HTTPS"
+	hintUnreachable      = "check that host, port and scheme, or url, point at the InfluxDB HTTP API"
+	hintRateLimit        = "InfluxDB is rate limiting the plugin: lower requests_per_second, or raise the limits of the InfluxDB account"
+	hintAuthFailed       = "the token was rejected: check that token is an InfluxDB v2 API token that has not been deleted or deactivated"
+	hintPermissions      = "the token lacks permissions the plugin needs: " + hintGrant
+	hintNoAuthorizations = "the token cannot read authorizations, so its permissions cannot be checked: grant it authorizations:read, or set skip_access_check"
+	hintInvalidOrgID     = "organization_id must be the 16 hexadecimal character ID shown in the InfluxDB UI under the organization's About page"
+	hintOrgNotFound      = "check organization or organization_id, and that the token can read the organization"
+	hintBucketNotFound   = "check that default_bucket exists in the organization it is looked up in, and that the token can read it"
+	hintUnhealthy        = "InfluxDB reports itself unhealthy: check its logs and free disk space"
+
+	hintGrant = "grant them to the token in the InfluxDB UI (Load Data > API Tokens), or use an operator token"
+)
+
+// remediationHint returns a hint for the failure of a diagnostic step, or ""
+// if the failure isn't one it knows.
+func remediationHint(step string, err error) string {
+	var missing *missingPermissionsError
+	switch {
+	case errors.Is(err, ErrNotInitialized):
+		return hintNotInitialized
+	case errors.As(err, &missing):
+		return fmt.Sprintf("the token lacks %s: %s", missing.names(), hintGrant)
+	case errors.Is(err, ErrAuthorizationsNotVisible):
+		return hintNoAuthorizations
+	case errors.Is(err, ErrInvalidOrganizationID):
+		return hintInvalidOrgID
+	case errors.Is(err, ErrOrganizationNotFound):
+		return hintOrgNotFound
+	case errors.Is(err, ErrBucketNotFound):
+		return hintBucketNotFound
+	case errors.Is(err, ErrUnhealthy):
+		return hintUnhealthy
+	}
+
+	switch connectionErrorCause(err) {
+	case causeAuth:
+		if errors.Is(err, ErrInsufficientPermissions) || statusKind(err) == ErrInsufficientPermissions {
+			return hintPermissions
+		}
+		return hintAuthFailed
+	case causeDNS:
+		return hintDNS
+	case causeRefused:
+		return hintRefused
+	case causeTimeout:
+		return hintTimeout
+	case causeTLS:
+		return hintTLS
+	case causeRateLimit:
+		return hintRateLimit
+	}
+
+	switch {
+	case errors.Is(err, ErrUnreachable):
+		return hintUnreachable
+	case step == DiagnosticHealth:
+		return hintUnhealthy
+	}
+	return ""
+}
@@ -0,0 +1,453 @@
+package influxdbv2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-secure-stdlib/strutil"
+	"github.com/influxdata/influxdb-client-go/v2"
+	"github.com/influxdata/influxdb-client-go/v2/api"
+	ihttp "github.com/influxdata/influxdb-client-go/v2/api/http"
+	"github.com/influxdata/influxdb-client-go/v2/domain"
+)
+
+// listPageSize is the number of organizations or buckets requested per page
+// when enumerating them.
+const listPageSize = 100
+
+// bucketListingTTL is how long the buckets of an organization, once listed,
+// are used to resolve bucket names.
+const bucketListingTTL = 30 * time.Second
+
+// bucketListing holds the buckets of an organization and when they were
+// listed.
+type bucketListing struct {
+	buckets []domain.Bucket
+	listed  time.Time
+}
+
+// organizationIDRegex matches the format of InfluxDB organization IDs.
+var organizationIDRegex = regexp.MustCompile(`^[0-9a-f]{16}$`)
+
+func isOrganizationID(s string) bool {
+	return organizationIDRegex.MatchString(s)
+}
+
+// resolveDefaultOrganization resolves the configured organization, given
+// either by organization_id or by name. If neither is set, the only
+// organization the token can access is used, or with cloud set the
+// organization of the token's own authorization.
+func (i *influxdbConnectionProducer) resolveDefaultOrganization(ctx context.Context, cli influxdb2.Client) (*domain.Organization, error) {
+	if i.OrganizationID == "" && i.Organization == "" {
+		if i.Cloud {
+			return i.tokenOrganization(ctx, cli)
+		}
+		return i.soleOrganization(ctx, cli)
+	}
+	if i.OrganizationID == "" {
+		return i.resolveOrganization(ctx, cli, i.Organization)
+	}
+
+	if i.orgByID != nil {
+		return i.orgByID, nil
+	}
+	var org *domain.Organization
+	err := i.awaitResolution(ctx, func() error {
+		err := retry(ctx, func(int) error {
+			var err error
+			org, err = cli.OrganizationsAPI().FindOrganizationByID(ctx, i.OrganizationID)
+			return err
+		})
+		if isNotFound(err) {
+			return withKind(ErrOrganizationNotFound, err)
+		}
+		return classify(err)
+	})
+	if err != nil {
+		return nil, err
+	}
+	i.orgByID = org
+	return org, nil
+}
+
+// soleOrganization returns the organization the token can access, refusing
+// to guess between several.
+func (i *influxdbConnectionProducer) soleOrganization(ctx context.Context, cli influxdb2.Client) (*domain.Organization, error) {
+	if i.orgByID != nil {
+		return i.orgByID, nil
+	}
+	orgs, err := listOrganizations(ctx, cli)
+	if err != nil {
+		return nil, classify(err)
+	}
+	switch len(orgs) {
+	case 0:
+		return nil, withKind(ErrOrganizationNotFound, fmt.Errorf("no organization is configured and the token cannot access any"))
+	case 1:
+		i.logger.Info("no organization configured, using the only organization the token can access", "organization", orgs[0].Name, "organization_id", stringValue(orgs[0].Id))
+		i.orgByID = &orgs[0]
+		return i.orgByID, nil
+	}
+
+	names := make([]string, len(orgs))
+	for idx, org := range orgs {
+		names[idx] = fmt.Sprintf("%q (%s)", org.Name, stringValue(org.Id))
+	}
+	return nil, fmt.Errorf("no organization is configured and the token can access %d organizations: %s; set organization or organization_id to select one", len(orgs), strings.Join(names, ", "))
+}
+
+// Conditions resolution_retry_on may list.
+const (
+	resolutionRetryNotFound    = "not_found"
+	resolutionRetryServerError = "server_error"
+	resolutionRetryNetwork     = "network"
+)
+
+// parseResolutionRetryOn validates resolution_retry_on. Unset, server and
+// network errors are retried, and on InfluxDB Cloud not-found as well.
+func parseResolutionRetryOn(raw []string, cloud bool) (map[string]bool, error) {
+	if len(raw) == 0 {
+		raw = []string{resolutionRetryServerError, resolutionRetryNetwork}
+		if cloud {
+			raw = append(raw, resolutionRetryNotFound)
+		}
+	}
+	retryOn := make(map[string]bool, len(raw))
+	for _, condition := range strutil.ParseStringSlice(strings.Join(raw, ","), ",") {
+		switch condition {
+		case resolutionRetryNotFound, resolutionRetryServerError, resolutionRetryNetwork:
+			retryOn[condition] = true
+		default:
+			return nil, fmt.Errorf("invalid resolution_retry_on %q, expected %q, %q or %q", condition, resolutionRetryNotFound, resolutionRetryServerError, resolutionRetryNetwork)
+		}
+	}
+	return retryOn, nil
+}
+
+// resolutionRetryable reports whether a failed lookup is looked up again,
+// according to resolution_retry_on: a not-found organization or bucket, a
+// server error or rate limit, or a network error such as a timeout.
+func (i *influxdbConnectionProducer) resolutionRetryable(err error) bool {
+	switch {
+	case errors.Is(err, ErrOrganizationNotFound) || errors.Is(err, ErrBucketNotFound):
+		return i.resolutionRetryOn[resolutionRetryNotFound]
+	case !isTransient(err):
+		return false
+	}
+	var httpErr *ihttp.Error
+	if errors.As(err, &httpErr) && httpErr.StatusCode != 0 {
+		return i.resolutionRetryOn[resolutionRetryServerError]
+	}
+	return i.resolutionRetryOn[resolutionRetryNetwork]
+}
+
+// awaitResolution calls lookup until it finds what it looks for, looking an
+// organization or bucket up again, with a doubling delay, for up to
+// resolution_retry_window while it fails with an error resolution_retry_on
+// lists. On InfluxDB Cloud a newly created organization or bucket may take a
+// moment to become listable, so without this a configuration made right
+// after creating them would fail; elsewhere not-found is definitive and fails
+// right away unless resolution_retry_on says otherwise.
+func (i *influxdbConnectionProducer) awaitResolution(ctx context.Context, lookup func() error) error {
+	return retryWithin(ctx, i.resolutionRetryWindow, i.resolutionRetryable, lookup)
+}
+
+// resolveBucketOrganization returns the organization in which buckets named
+// without an organization are looked up for a credential in org: the
+// resolution_organization if configured, or else org itself.
+func (i *influxdbConnectionProducer) resolveBucketOrganization(ctx context.Context, cli influxdb2.Client, org *domain.Organization) (*domain.Organization, error) {
+	if i.ResolutionOrganization == "" {
+		return org, nil
+	}
+	resolutionOrg, err := i.resolveOrganization(ctx, cli, i.ResolutionOrganization)
+	if err != nil {
+		return nil, fmt.Errorf("unable to resolve resolution_organization: %w", err)
+	}
+	return resolutionOrg, nil
+}
+
+// resolveOrganization looks up an organization by name. Names are matched
+// exactly unless case_insensitive_names is set, in which case every
+// organization is listed and compared on lowercased names. Resolved
+// organizations are cached until the connection is closed or re-initialized.
+func (i *influxdbConnectionProducer) resolveOrganization(ctx context.Context, cli influxdb2.Client, name string) (*domain.Organization, error) {
+	if org, ok := i.orgCache[name]; ok {
+		return org, nil
+	}
+	var org *domain.Organization
+	err := i.awaitResolution(ctx, func() error {
+		var err error
+		org, err = i.lookupOrganization(ctx, cli, name)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if i.orgCache == nil {
+		i.orgCache = make(map[string]*domain.Organization)
+	}
+	i.orgCache[name] = org
+	return org, nil
+}
+
+// lookupOrganization never picks one of several organizations matching the
+// name: that is reported as ambiguous, pointing at organization_id.
+func (i *influxdbConnectionProducer) lookupOrganization(ctx context.Context, cli influxdb2.Client, name string) (*domain.Organization, error) {
+	var candidates []domain.Organization
+	if i.CaseInsensitiveNames {
+		orgs, err := listOrganizations(ctx, cli)
+		if err != nil {
+			return nil, classify(err)
+		}
+		for _, org := range orgs {
+			if strings.EqualFold(org.Name, name) {
+				candidates = append(candidates, org)
+			}
+		}
+	} else {
+		orgs, err := findOrganizationsByName(ctx, cli, name)
+		if err != nil {
+			return nil, classify(err)
+		}
+		for _, org := range orgs {
+			if org.Name == name {
+				candidates = append(candidates, org)
+			}
+		}
+	}
+	switch len(candidates) {
+	case 0:
+		return nil, withKind(ErrOrganizationNotFound, fmt.Errorf("organization '%s' not found", name))
+	case 1:
+		return &candidates[0], nil
+	}
+
+	names := make([]string, len(candidates))
+	for idx, org := range candidates {
+		names[idx] = fmt.Sprintf("%q (%s)", org.Name, *org.Id)
+	}
+	match := "matches"
+	if i.CaseInsensitiveNames {
+		match = "case-insensitively matches"
+	}
+	return nil, fmt.Errorf("organization name %q %s multiple organizations: %s; set organization_id to select one", name, match, strings.Join(names, ", "))
+}
+
+// resolveBucket looks up a bucket by name within the given organization,
+// honoring case_insensitive_names the same way resolveOrganization does.
+func (i *influxdbConnectionProducer) resolveBucket(ctx context.Context, cli influxdb2.Client, orgID, name string) (*domain.Bucket, error) {
+	key := orgID + "/" + name
+	if bucket, ok := i.bucketCache[key]; ok {
+		return bucket, nil
+	}
+	var bucket *domain.Bucket
+	err := i.awaitResolution(ctx, func() error {
+		var err error
+		bucket, err = i.lookupBucket(ctx, cli, orgID, name)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if i.bucketCache == nil {
+		i.bucketCache = make(map[string]*domain.Bucket)
+	}
+	i.bucketCache[key] = bucket
+	return bucket, nil
+}
+
+func (i *influxdbConnectionProducer) lookupBucket(ctx context.Context, cli influxdb2.Client, orgID, name string) (*domain.Bucket, error) {
+	buckets, cached, err := i.orgBuckets(ctx, cli, orgID, false)
+	if err != nil {
+		return nil, err
+	}
+	candidates := i.matchBuckets(buckets, name)
+	if len(candidates) == 0 && cached {
+		// The bucket may have been created since the listing.
+		if buckets, _, err = i.orgBuckets(ctx, cli, orgID, true); err != nil {
+			return nil, err
+		}
+		candidates = i.matchBuckets(buckets, name)
+	}
+	switch len(candidates) {
+	case 0:
+		return nil, withKind(ErrBucketNotFound, fmt.Errorf("bucket '%s' not found", name))
+	case 1:
+		return &candidates[0], nil
+	}
+
+	names := make([]string, len(candidates))
+	for idx, bucket := range candidates {
+		names[idx] = fmt.Sprintf("%q (%s)", bucket.Name, *bucket.Id)
+	}
+	return nil, fmt.Errorf("bucket name %q matches multiple buckets case-insensitively: %s", name, strings.Join(names, ", "))
+}
+
+// orgBuckets returns the buckets of an organization, listing them only if
+// refresh is set or the previous listing is older than bucketListingTTL.
+// cached reports whether the previous listing was used.
+func (i *influxdbConnectionProducer) orgBuckets(ctx context.Context, cli influxdb2.Client, orgID string, refresh bool) (buckets []domain.Bucket, cached bool, err error) {
+	if listing, ok := i.bucketListings[orgID]; ok && !refresh && time.Since(listing.listed) < bucketListingTTL {
+		return listing.buckets, true, nil
+	}
+	buckets, err = listBuckets(ctx, cli, orgID)
+	if err != nil {
+		return nil, false, classify(err)
+	}
+	if i.bucketListings == nil {
+		i.bucketListings = make(map[string]bucketListing)
+	}
+	i.bucketListings[orgID] = bucketListing{buckets: buckets, listed: time.Now()}
+	return buckets, false, nil
+}
+
+// matchBuckets returns the buckets named name, honoring
+// case_insensitive_names.
+func (i *influxdbConnectionProducer) matchBuckets(buckets []domain.Bucket, name string) []domain.Bucket {
+	var candidates []domain.Bucket
+	for _, bucket := range buckets {
+		if bucket.Name == name || (i.CaseInsensitiveNames && strings.EqualFold(bucket.Name, name)) {
+			candidates = append(candidates, bucket)
+		}
+	}
+	return candidates
+}
+
+// resolvePermissions returns a copy of permissions in which every resource
+// scoped to an organization by name only has its organization ID filled in,
+// and every bucket resource given by name only has its ID filled in. Buckets
+// are looked up in the resource's organization, or else bucketOrgID, which
+// the resource is then scoped to. The buckets of an organization are listed
+// once for all the names looked up in it.
+func (i *influxdbConnectionProducer) resolvePermissions(ctx context.Context, cli influxdb2.Client, bucketOrgID string, permissions []domain.Permission) ([]domain.Permission, error) {
+	resolved := make([]domain.Permission, len(permissions))
+	for idx, permission := range permissions {
+		resource := permission.Resource
+		if resource.OrgID == nil && resource.Org != nil {
+			org, err := i.resolveOrganization(ctx, cli, *resource.Org)
+			if err != nil {
+				return nil, err
+			}
+			permission.Resource.OrgID = org.Id
+			permission.Resource.Org = &org.Name
+			resource = permission.Resource
+		}
+		if resource.Type == domain.ResourceTypeBuckets && resource.Id == nil && resource.Name != nil {
+			if resource.OrgID == nil {
+				orgID := bucketOrgID
+				permission.Resource.OrgID = &orgID
+			}
+			bucket, err := i.resolveBucket(ctx, cli, *permission.Resource.OrgID, *resource.Name)
+			if err != nil {
+				return nil, err
+			}
+			permission.Resource.Id = bucket.Id
+			permission.Resource.Name = &bucket.Name
+		}
+		resolved[idx] = permission
+	}
+	return resolved, nil
+}
+
+// mergePermissions combines the permissions requested for a credential into
+// the set granted by its authorization. Repeated permissions, such as a
+// bucket listed both by name and by ID, are kept once. The same resource
+// given two different names is rejected: one of them is a mistake, and
+// granting either could be the wrong one. Permissions not scoped to an
+// organization are compared as scoped to orgID, as createAuthorization
+// scopes them.
+func mergePermissions(orgID string, permissions []domain.Permission) ([]domain.Permission, error) {
+	seen := make(map[string]domain.Permission, len(permissions))
+	res := make([]domain.Permission, 0, len(permissions))
+	for _, permission := range permissions {
+		resource := permission.Resource
+		scope := orgID
+		if resource.OrgID != nil {
+			scope = *resource.OrgID
+		}
+		key := fmt.Sprintf("%s/%s/%s/%s", permission.Action, resource.Type, scope, stringValue(resource.Id))
+		if resource.Id == nil {
+			key += "/" + stringValue(resource.Org) + "/" + stringValue(resource.Name)
+		}
+		if previous, ok := seen[key]; ok {
+			name, previousName := stringValue(resource.Name), stringValue(previous.Resource.Name)
+			if name != "" && previousName != "" && name != previousName {
+				return nil, fmt.Errorf("conflicting permissions: %s %s is named both %q and %q", resource.Type, *resource.Id, previousName, name)
+			}
+			continue
+		}
+		seen[key] = permission
+		res = append(res, permission)
+	}
+	return res, nil
+}
+
+// findOrganizationsByName returns every organization the server matches to
+// the name. Unlike the client's FindOrganizationByName, it doesn't drop all
+// but the first match.
+func findOrganizationsByName(ctx context.Context, cli influxdb2.Client, name string) ([]domain.Organization, error) {
+	var orgs []domain.Organization
+	err := retry(ctx, func(int) error {
+		response, err := domain.NewClientWithResponses(cli.HTTPService()).GetOrgsWithResponse(ctx, &domain.GetOrgsParams{Org: &name})
+		if err != nil {
+			return err
+		}
+		if response.JSONDefault != nil {
+			return domain.ErrorToHTTPError(response.JSONDefault, response.StatusCode())
+		}
+		orgs = nil
+		if response.JSON200 != nil && response.JSON200.Orgs != nil {
+			orgs = *response.JSON200.Orgs
+		}
+		return nil
+	})
+	return orgs, err
+}
+
+func listOrganizations(ctx context.Context, cli influxdb2.Client) ([]domain.Organization, error) {
+	var res []domain.Organization
+	for offset := 0; ; offset += listPageSize {
+		var page *[]domain.Organization
+		err := retry(ctx, func(int) error {
+			var err error
+			page, err = cli.OrganizationsAPI().GetOrganizations(ctx, api.PagingWithLimit(listPageSize), api.PagingWithOffset(offset))
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		if page == nil {
+			return res, nil
+		}
+		res = append(res, *page...)
+		if len(*page) < listPageSize {
+			return res, nil
+		}
+	}
+}
+
+func listBuckets(ctx context.Context, cli influxdb2.Client, orgID string) ([]domain.Bucket, error) {
+	var res []domain.Bucket
+	for offset := 0; ; offset += listPageSize {
+		var page *[]domain.Bucket
+		err := retry(ctx, func(int) error {
+			var err error
+			page, err = cli.BucketsAPI().FindBucketsByOrgID(ctx, orgID, api.PagingWithLimit(listPageSize), api.PagingWithOffset(offset))
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		if page == nil {
+			return res, nil
+		}
+		res = append(res, *page...)
+		if len(*page) < listPageSize {
+			return res, nil
+		}
+	}
+}
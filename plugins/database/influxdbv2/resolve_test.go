@@ -0,0 +1,597 @@
+package influxdbv2
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	log "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/sdk/database/dbplugin/v5"
+	dbtesting "github.com/hashicorp/vault/sdk/database/dbplugin/v5/testing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolve_CaseInsensitiveNames(t *testing.T) {
+	const token = "root-token"
+	srv := newFakeInfluxServer(t, token)
+	orgID := srv.addOrg("Metrics")
+	bucketID := srv.addBucket(orgID, "Telegraf")
+	srv.addOrg("Shared")
+	srv.addOrg("SHARED")
+
+	type testCase struct {
+		caseInsensitive bool
+		org             string
+		bucket          string
+		expectErr       string
+	}
+
+	tests := map[string]testCase{
+		"exact match": {
+			org:    "Metrics",
+			bucket: "Telegraf",
+		},
+		"wrong case rejected by default": {
+			org:       "metrics",
+			expectErr: "not found",
+		},
+		"wrong case bucket rejected by default": {
+			org:       "Metrics",
+			bucket:    "telegraf",
+			expectErr: "not found",
+		},
+		"case-insensitive match": {
+			caseInsensitive: true,
+			org:             "metrics",
+			bucket:          "TELEGRAF",
+		},
+		"case-insensitive not found": {
+			caseInsensitive: true,
+			org:             "missing",
+			expectErr:       "not found",
+		},
+		"case-insensitive ambiguous": {
+			caseInsensitive: true,
+			org:             "shared",
+			expectErr:       `"Shared"`,
+		},
+		"case-insensitive ambiguity lists candidates": {
+			caseInsensitive: true,
+			org:             "shared",
+			expectErr:       `"SHARED"`,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			db := new()
+			defer dbtesting.AssertClose(t, db)
+			dbtesting.AssertInitialize(t, db, dbplugin.InitializeRequest{
+				Config: makeConfig(srv.connectionParams(token), "case_insensitive_names", test.caseInsensitive),
+			})
+			cli, err := db.getConnection(context.Background())
+			require.NoError(t, err)
+
+			org, err := db.resolveOrganization(context.Background(), cli, test.org)
+			if err == nil && test.bucket != "" {
+				bucket, bucketErr := db.resolveBucket(context.Background(), cli, *org.Id, test.bucket)
+				if bucketErr == nil {
+					require.Equal(t, bucketID, *bucket.Id)
+				}
+				err = bucketErr
+			}
+			if test.expectErr != "" {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), test.expectErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, orgID, *org.Id)
+		})
+	}
+}
+
+func TestInitialize_OrganizationNormalization(t *testing.T) {
+	const token = "root-token"
+	srv := newFakeInfluxServer(t, token)
+	orgID := srv.orgID("vault")
+
+	type testCase struct {
+		organization   string
+		organizationID string
+		expectWarning  bool
+		expectErr      string
+	}
+
+	tests := map[string]testCase{
+		"name": {
+			organization: "vault",
+		},
+		"name with whitespace": {
+			organization: "  vault\n",
+		},
+		"id in name field": {
+			organization:  orgID,
+			expectWarning: true,
+		},
+		"id with whitespace in name field": {
+			organization:  " " + orgID + " ",
+			expectWarning: true,
+		},
+		"organization_id": {
+			organizationID: orgID,
+		},
+		"both set": {
+			organization:   "vault",
+			organizationID: orgID,
+			expectErr:      "cannot both be set",
+		},
+		"invalid organization_id": {
+			organizationID: "vault",
+			expectErr:      "invalid organization_id",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			var buf bytes.Buffer
+			db := new()
+			db.logger = log.New(&log.LoggerOptions{Output: &buf})
+			_, err := db.Initialize(context.Background(), dbplugin.InitializeRequest{
+				Config: makeConfig(srv.connectionParams(token),
+					"organization", test.organization,
+					"organization_id", test.organizationID,
+				),
+			})
+			if test.expectErr != "" {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), test.expectErr)
+				return
+			}
+			require.NoError(t, err)
+			defer dbtesting.AssertClose(t, db)
+
+			require.Equal(t, test.expectWarning, strings.Contains(buf.String(), "looks like an organization ID"))
+
+			cli, err := db.getConnection(context.Background())
+			require.NoError(t, err)
+			org, err := db.resolveDefaultOrganization(context.Background(), cli)
+			require.NoError(t, err)
+			require.Equal(t, orgID, *org.Id)
+		})
+	}
+}
+
+func TestResolve_DuplicateOrganizationNames(t *testing.T) {
+	const token = "root-token"
+	srv := newFakeInfluxServer(t, token)
+	firstID := srv.addOrg("metrics")
+	secondID := srv.addOrg("metrics")
+
+	db := new()
+	dbtesting.AssertInitialize(t, db, dbplugin.InitializeRequest{
+		Config: makeConfig(srv.connectionParams(token), "organization", "metrics"),
+	})
+	defer dbtesting.AssertClose(t, db)
+	cli, err := db.getConnection(context.Background())
+	require.NoError(t, err)
+
+	_, err = db.resolveDefaultOrganization(context.Background(), cli)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), firstID)
+	require.Contains(t, err.Error(), secondID)
+	require.Contains(t, err.Error(), "set organization_id")
+
+	// Selecting one by ID resolves the ambiguity.
+	byID := new()
+	dbtesting.AssertInitialize(t, byID, dbplugin.InitializeRequest{
+		Config: makeConfig(srv.connectionParams(token), "organization", "", "organization_id", secondID),
+	})
+	defer dbtesting.AssertClose(t, byID)
+	cli, err = byID.getConnection(context.Background())
+	require.NoError(t, err)
+	org, err := byID.resolveDefaultOrganization(context.Background(), cli)
+	require.NoError(t, err)
+	require.Equal(t, secondID, *org.Id)
+}
+
+func TestInfluxdb_NewUser_ResolutionOrganization(t *testing.T) {
+	const token = "root-token"
+	srv := newFakeInfluxServer(t, token)
+	sharedID := srv.addOrg("shared")
+	bucketID := srv.addBucket(sharedID, "metrics")
+	tenantID := srv.addOrg("tenant")
+
+	req := dbplugin.NewUserRequest{
+		UsernameConfig: dbplugin.UsernameMetadata{
+			DisplayName: "test",
+			RoleName:    "test",
+		},
+		Statements: dbplugin.Statements{
+			Commands: []string{`{"organization": "tenant", "preset": "read", "bucket": "metrics"}`},
+		},
+		Password:   "nuozxby98523u89bdfnkjl",
+		Expiration: time.Now().Add(1 * time.Minute),
+	}
+
+	// Without a resolution organization the bucket is looked up in the
+	// credential's organization.
+	db := new()
+	dbtesting.AssertInitialize(t, db, dbplugin.InitializeRequest{
+		Config: srv.connectionParams(token),
+	})
+	defer dbtesting.AssertClose(t, db)
+	_, err := db.NewUser(context.Background(), req)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "bucket 'metrics' not found")
+
+	db = new()
+	dbtesting.AssertInitialize(t, db, dbplugin.InitializeRequest{
+		Config:           makeConfig(srv.connectionParams(token), "resolution_organization", " shared "),
+		VerifyConnection: true,
+	})
+	defer dbtesting.AssertClose(t, db)
+	resp := dbtesting.AssertNewUser(t, db, req)
+
+	auths := srv.userAuthorizations(resp.Username)
+	require.Len(t, auths, 1)
+	require.Equal(t, tenantID, *auths[0].OrgID)
+	resource := (*auths[0].Permissions)[0].Resource
+	require.Equal(t, bucketID, *resource.Id)
+	require.Equal(t, sharedID, *resource.OrgID)
+
+	// The resolution organization is checked when verifying the connection.
+	_, err = new().Initialize(context.Background(), dbplugin.InitializeRequest{
+		Config:           makeConfig(srv.connectionParams(token), "resolution_organization", "missing"),
+		VerifyConnection: true,
+	})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "invalid resolution_organization")
+}
+
+func TestInitialize_OrganizationIDVerification(t *testing.T) {
+	const token = "root-token"
+	srv := newFakeInfluxServer(t, token)
+	orgID := srv.orgID("vault")
+
+	// A malformed ID is rejected before contacting the server.
+	_, err := new().Initialize(context.Background(), dbplugin.InitializeRequest{
+		Config:           makeConfig(srv.connectionParams(token), "organization", "", "organization_id", "not-an-id"),
+		VerifyConnection: true,
+	})
+	require.Error(t, err)
+	require.True(t, errors.Is(err, ErrInvalidOrganizationID), err.Error())
+	require.False(t, errors.Is(err, ErrOrganizationNotFound))
+	require.Zero(t, srv.callCount("GET /ping"))
+
+	// A well-formed ID must exist on the server.
+	_, err = new().Initialize(context.Background(), dbplugin.InitializeRequest{
+		Config:           makeConfig(srv.connectionParams(token), "organization", "", "organization_id", "0123456789abcdef"),
+		VerifyConnection: true,
+	})
+	require.Error(t, err)
+	require.True(t, errors.Is(err, ErrOrganizationNotFound), err.Error())
+	require.False(t, errors.Is(err, ErrInvalidOrganizationID))
+	require.Contains(t, err.Error(), `no organization with organization_id "0123456789abcdef" exists`)
+
+	// Without verification the ID is only checked when first used.
+	db := new()
+	dbtesting.AssertInitialize(t, db, dbplugin.InitializeRequest{
+		Config: makeConfig(srv.connectionParams(token), "organization", "", "organization_id", "0123456789abcdef"),
+	})
+	dbtesting.AssertClose(t, db)
+
+	db = new()
+	dbtesting.AssertInitialize(t, db, dbplugin.InitializeRequest{
+		Config:           makeConfig(srv.connectionParams(token), "organization", "", "organization_id", orgID),
+		VerifyConnection: true,
+	})
+	dbtesting.AssertClose(t, db)
+}
+
+func TestInitialize_DefaultOrganization(t *testing.T) {
+	const token = "root-token"
+	srv := newFakeInfluxServer(t, token)
+	orgID := srv.orgID("vault")
+	srv.addBucket(orgID, "telegraf")
+	config := makeConfig(srv.connectionParams(token), "organization", "")
+
+	// The only accessible organization is used, and the choice logged.
+	var buf bytes.Buffer
+	db := new()
+	db.logger = log.New(&log.LoggerOptions{Output: &buf})
+	dbtesting.AssertInitialize(t, db, dbplugin.InitializeRequest{
+		Config:           config,
+		VerifyConnection: true,
+	})
+	defer dbtesting.AssertClose(t, db)
+	require.Contains(t, buf.String(), "using the only organization the token can access")
+	require.Contains(t, buf.String(), orgID)
+
+	username := newExpiringUser(t, db, time.Now().Add(time.Hour))
+	auths := srv.userAuthorizations(username)
+	require.Len(t, auths, 1)
+	require.Equal(t, orgID, *auths[0].OrgID)
+
+	// With several, one must be configured.
+	otherID := srv.addOrg("other")
+	_, err := new().Initialize(context.Background(), dbplugin.InitializeRequest{
+		Config:           config,
+		VerifyConnection: true,
+	})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "can access 2 organizations")
+	require.Contains(t, err.Error(), `"vault" (`+orgID+`)`)
+	require.Contains(t, err.Error(), `"other" (`+otherID+`)`)
+	require.Contains(t, err.Error(), "set organization or organization_id")
+
+	// Without verification the error surfaces on first use.
+	lazy := new()
+	dbtesting.AssertInitialize(t, lazy, dbplugin.InitializeRequest{
+		Config: config,
+	})
+	defer dbtesting.AssertClose(t, lazy)
+	_, err = lazy.NewUser(context.Background(), dbplugin.NewUserRequest{
+		UsernameConfig: dbplugin.UsernameMetadata{DisplayName: "test", RoleName: "test"},
+		Statements:     dbplugin.Statements{Commands: []string{`{"preset": "read", "bucket": "telegraf"}`}},
+		Password:       "nuozxby98523u89bdfnkjl",
+	})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "can access 2 organizations")
+}
+
+func TestResolve_EventualConsistency(t *testing.T) {
+	const token = "root-token"
+	srv := newFakeInfluxServer(t, token)
+	orgID := srv.addOrg("fresh")
+	srv.addBucket(orgID, "telegraf")
+
+	// hide makes the next n listings of key come back empty, as if the
+	// organizations and buckets just created weren't listable yet.
+	hide := func(key, field string, n int) {
+		var mu sync.Mutex
+		srv.handle(key, func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			hidden := n > 0
+			n--
+			mu.Unlock()
+			if hidden {
+				writeJSON(w, http.StatusOK, map[string]interface{}{field: []interface{}{}})
+				return
+			}
+			srv.serveDefault(w, r, key, r.URL.Path, strings.Split(strings.TrimPrefix(r.URL.Path, "/api/v2/"), "/"))
+		})
+	}
+	newUser := func(ctx context.Context, window string) (dbplugin.NewUserResponse, error) {
+		db := new()
+		dbtesting.AssertInitialize(t, db, dbplugin.InitializeRequest{
+			Config: makeConfig(srv.connectionParams(token),
+				"organization", "fresh",
+				"resolution_retry_window", window,
+				"resolution_retry_on", "not_found",
+			),
+		})
+		defer dbtesting.AssertClose(t, db)
+		return db.NewUser(ctx, dbplugin.NewUserRequest{
+			UsernameConfig: dbplugin.UsernameMetadata{DisplayName: "token", RoleName: "test"},
+			Statements:     dbplugin.Statements{Commands: []string{`{"preset": "read", "bucket": "telegraf"}`}},
+			Password:       "nuozxby98523u89bdfnkjl",
+			Expiration:     time.Now().Add(time.Minute),
+		})
+	}
+
+	t.Run("retry then succeed", func(t *testing.T) {
+		hide("GET /api/v2/orgs", "orgs", 2)
+		hide("GET /api/v2/buckets", "buckets", 2)
+		resp, err := newUser(context.Background(), "5s")
+		require.NoError(t, err)
+		require.Len(t, srv.userAuthorizations(resp.Username), 1)
+	})
+
+	t.Run("retry then give up", func(t *testing.T) {
+		hide("GET /api/v2/orgs", "orgs", 1000)
+		before := srv.callCount("GET /api/v2/orgs")
+		start := time.Now()
+		_, err := newUser(context.Background(), "500ms")
+		require.Error(t, err)
+		require.True(t, errors.Is(err, ErrOrganizationNotFound), err)
+		require.GreaterOrEqual(t, time.Since(start), 500*time.Millisecond)
+		require.Greater(t, srv.callCount("GET /api/v2/orgs")-before, 2)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		hide("GET /api/v2/orgs", "orgs", 1)
+		before := srv.callCount("GET /api/v2/orgs")
+		_, err := newUser(context.Background(), "0s")
+		require.True(t, errors.Is(err, ErrOrganizationNotFound), err)
+		require.Equal(t, 1, srv.callCount("GET /api/v2/orgs")-before)
+	})
+
+	t.Run("context ends the retries", func(t *testing.T) {
+		hide("GET /api/v2/orgs", "orgs", 1000)
+		ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		_, err := newUser(ctx, "1m")
+		// Either the last lookup or the request running at the deadline
+		// fails the request.
+		require.Error(t, err)
+		require.Less(t, time.Since(start), 5*time.Second)
+	})
+
+	_, err := new().Initialize(context.Background(), dbplugin.InitializeRequest{
+		Config: makeConfig(srv.connectionParams(token), "resolution_retry_window", "-1s"),
+	})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "resolution_retry_window cannot be negative")
+}
+
+func TestResolve_RetryOn(t *testing.T) {
+	const token = "root-token"
+	srv := newFakeInfluxServer(t, token)
+	srv.addBucket(srv.addOrg("fresh"), "telegraf")
+
+	// fail makes the next n listings of organizations fail with a 503.
+	fail := func(n int) {
+		var mu sync.Mutex
+		srv.handle("GET /api/v2/orgs", func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			failed := n > 0
+			n--
+			mu.Unlock()
+			if failed {
+				writeError(w, http.StatusServiceUnavailable, "unavailable", "service unavailable")
+				return
+			}
+			srv.serveDefault(w, r, "GET /api/v2/orgs", r.URL.Path, []string{"orgs"})
+		})
+	}
+	newUser := func(organization string, config ...interface{}) error {
+		db := new()
+		dbtesting.AssertInitialize(t, db, dbplugin.InitializeRequest{
+			Config: makeConfig(srv.connectionParams(token),
+				append([]interface{}{"organization", organization, "resolution_retry_window", "5s"}, config...)...),
+		})
+		defer dbtesting.AssertClose(t, db)
+		_, err := db.NewUser(context.Background(), dbplugin.NewUserRequest{
+			UsernameConfig: dbplugin.UsernameMetadata{DisplayName: "token", RoleName: "test"},
+			Statements:     dbplugin.Statements{Commands: []string{`{"preset": "read", "bucket": "telegraf"}`}},
+			Password:       "nuozxby98523u89bdfnkjl",
+			Expiration:     time.Now().Add(time.Minute),
+		})
+		return err
+	}
+
+	t.Run("not found fails fast", func(t *testing.T) {
+		srv.unhandle("GET /api/v2/orgs")
+		before := srv.callCount("GET /api/v2/orgs")
+		start := time.Now()
+		err := newUser("missing")
+		require.True(t, errors.Is(err, ErrOrganizationNotFound), err)
+		require.Less(t, time.Since(start), time.Second)
+		require.Equal(t, 1, srv.callCount("GET /api/v2/orgs")-before)
+	})
+
+	t.Run("server error retries", func(t *testing.T) {
+		// More failures than the attempts of a single lookup.
+		fail(retryAttempts + 2)
+		before := srv.callCount("GET /api/v2/orgs")
+		require.NoError(t, newUser("fresh"))
+		require.Equal(t, retryAttempts+3, srv.callCount("GET /api/v2/orgs")-before)
+	})
+
+	t.Run("server error not listed", func(t *testing.T) {
+		fail(retryAttempts + 2)
+		before := srv.callCount("GET /api/v2/orgs")
+		require.Error(t, newUser("fresh", "resolution_retry_on", "not_found"))
+		require.Equal(t, retryAttempts, srv.callCount("GET /api/v2/orgs")-before)
+	})
+
+	t.Run("cloud retries not found", func(t *testing.T) {
+		retryOn, err := parseResolutionRetryOn(nil, true)
+		require.NoError(t, err)
+		require.True(t, retryOn[resolutionRetryNotFound])
+	})
+
+	_, err := new().Initialize(context.Background(), dbplugin.InitializeRequest{
+		Config: makeConfig(srv.connectionParams(token), "resolution_retry_on", "not_found,sometimes"),
+	})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), `invalid resolution_retry_on "sometimes"`)
+}
+
+func TestResolve_BucketListing(t *testing.T) {
+	const token = "root-token"
+	srv := newFakeInfluxServer(t, token)
+	orgID := srv.orgID("vault")
+	for _, name := range []string{"telegraf", "metrics", "logs", "traces"} {
+		srv.addBucket(orgID, name)
+	}
+
+	db := new()
+	dbtesting.AssertInitialize(t, db, dbplugin.InitializeRequest{
+		Config: srv.connectionParams(token),
+	})
+	defer dbtesting.AssertClose(t, db)
+
+	newUser := func(buckets string) {
+		t.Helper()
+		dbtesting.AssertNewUser(t, db, dbplugin.NewUserRequest{
+			UsernameConfig: dbplugin.UsernameMetadata{
+				DisplayName: "test",
+				RoleName:    "test",
+			},
+			Statements: dbplugin.Statements{
+				Commands: []string{`{"preset": "read", "buckets": ` + buckets + `}`},
+			},
+			Password:   "nuozxby98523u89bdfnkjl",
+			Expiration: time.Now().Add(time.Hour),
+		})
+	}
+
+	// Every bucket of the request is resolved from a single listing.
+	newUser(`["telegraf", "metrics", "logs"]`)
+	require.Equal(t, 1, srv.callCount("GET /api/v2/buckets"))
+
+	// So are the buckets of later requests while the listing is fresh.
+	newUser(`["traces", "telegraf"]`)
+	require.Equal(t, 1, srv.callCount("GET /api/v2/buckets"))
+
+	// A bucket created since is found by listing again.
+	srv.Lock()
+	srv.addBucket(orgID, "events")
+	srv.Unlock()
+	newUser(`["events"]`)
+	require.Equal(t, 2, srv.callCount("GET /api/v2/buckets"))
+
+	// The listing expires.
+	db.Lock()
+	listing := db.bucketListings[orgID]
+	listing.listed = listing.listed.Add(-bucketListingTTL)
+	db.bucketListings[orgID] = listing
+	db.bucketCache = nil
+	db.Unlock()
+	newUser(`["logs"]`)
+	require.Equal(t, 3, srv.callCount("GET /api/v2/buckets"))
+
+	// And is dropped on Close.
+	require.NoError(t, db.Close())
+	require.Nil(t, db.bucketListings)
+	newUser(`["logs"]`)
+	require.Equal(t, 4, srv.callCount("GET /api/v2/buckets"))
+
+	// Buckets given by ID and every bucket of the organization come from the
+	// same listing as names.
+	require.NoError(t, db.Close())
+	srv.Lock()
+	tracesID := srv.addBucket(orgID, "spans")
+	srv.Unlock()
+	dbtesting.AssertNewUser(t, db, dbplugin.NewUserRequest{
+		UsernameConfig: dbplugin.UsernameMetadata{DisplayName: "test", RoleName: "test"},
+		Statements: dbplugin.Statements{Commands: []string{
+			`{"preset": "write", "bucket_ids": ["` + tracesID + `"]}`,
+			`{"preset": "read_all_buckets"}`,
+			`{"preset": "write", "buckets": ["metrics", "logs"]}`,
+		}},
+		Password:   "nuozxby98523u89bdfnkjl",
+		Expiration: time.Now().Add(time.Hour),
+	})
+	require.Equal(t, 5, srv.callCount("GET /api/v2/buckets"))
+
+	// A bucket ID created since is found by listing again.
+	srv.Lock()
+	newID := srv.addBucket(orgID, "alerts")
+	srv.Unlock()
+	dbtesting.AssertNewUser(t, db, dbplugin.NewUserRequest{
+		UsernameConfig: dbplugin.UsernameMetadata{DisplayName: "test", RoleName: "test"},
+		Statements:     dbplugin.Statements{Commands: []string{`{"preset": "read", "bucket_ids": ["` + newID + `"]}`}},
+		Password:       "nuozxby98523u89bdfnkjl",
+		Expiration:     time.Now().Add(time.Hour),
+	})
+	require.Equal(t, 6, srv.callCount("GET /api/v2/buckets"))
+}
@@ -0,0 +1,123 @@
+package influxdbv2
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	ihttp "github.com/influxdata/influxdb-client-go/v2/api/http"
+)
+
+// Retry policy
+//
+// Only idempotent operations are retried automatically: pings, lookups and
+// listings, password updates, and deletes by ID. A create whose response is
+// lost may still have taken effect, so creates are not blindly retried:
+//
+//   - user creation and organization membership are never retried; a failure
+//     rolls the user back as before
+//   - authorization creation uses its description, which embeds the unique
+//     generated username, as an idempotency key: before creating again, the
+//     user's authorizations are checked for one carrying that description
+const (
+	retryAttempts     = 3
+	retryInitialDelay = 100 * time.Millisecond
+
+	// maxRevocationAttempts bounds revocation_attempts, so that a revocation
+	// against a failing server gives up within a minute or so.
+	maxRevocationAttempts = 10
+)
+
+// retry calls fn until it succeeds, fails with an error that isn't transient,
+// or has been called retryAttempts times, doubling the delay between calls.
+// fn is given the zero-based attempt number. retry must only wrap idempotent
+// operations, see the retry policy above.
+func retry(ctx context.Context, fn func(attempt int) error) error {
+	return retryN(ctx, retryAttempts, fn)
+}
+
+// retryN is retry with a custom number of attempts.
+func retryN(ctx context.Context, attempts int, fn func(attempt int) error) error {
+	var err error
+	delay := retryInitialDelay
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return err
+			case <-time.After(delay):
+			}
+			delay *= 2
+		}
+		err = fn(attempt)
+		if err == nil || !isTransient(err) {
+			return err
+		}
+	}
+	return err
+}
+
+// retryWithin calls fn until it succeeds, fails with an error shouldRetry
+// rejects, or window has passed, doubling the delay between calls from
+// retryInitialDelay. fn is always called at least once, and never again once
+// ctx is done.
+func retryWithin(ctx context.Context, window time.Duration, shouldRetry func(error) bool, fn func() error) error {
+	deadline := time.Now().Add(window)
+	delay := retryInitialDelay
+	for {
+		err := fn()
+		if err == nil || !shouldRetry(err) {
+			return err
+		}
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return err
+		}
+		if delay > remaining {
+			delay = remaining
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// revocationAttempts returns the number of attempts at each step of deleting
+// a credential.
+func (i *influxdbConnectionProducer) revocationAttempts() int {
+	if i.RevocationAttempts > 0 {
+		return i.RevocationAttempts
+	}
+	return retryAttempts
+}
+
+// isTransient reports whether err is a failure that a later attempt of the
+// same request may not hit: a network error, a rate limit, or a server error.
+func isTransient(err error) bool {
+	var httpErr *ihttp.Error
+	if errors.As(err, &httpErr) {
+		if httpErr.StatusCode != 0 {
+			return httpErr.StatusCode == http.StatusTooManyRequests || httpErr.StatusCode >= http.StatusInternalServerError
+		}
+		// The client's Unwrap doesn't preserve the cause.
+		if err = httpErr.Err; err == nil {
+			return false
+		}
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// isNotFound reports whether err is a 404 from the server.
+func isNotFound(err error) bool {
+	var httpErr *ihttp.Error
+	return errors.As(err, &httpErr) && httpErr.StatusCode == http.StatusNotFound
+}
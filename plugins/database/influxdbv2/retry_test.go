@@ -0,0 +1,209 @@
+package influxdbv2
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/database/dbplugin/v5"
+	dbtesting "github.com/hashicorp/vault/sdk/database/dbplugin/v5/testing"
+	ihttp "github.com/influxdata/influxdb-client-go/v2/api/http"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsTransient(t *testing.T) {
+	tests := map[string]struct {
+		err       error
+		transient bool
+	}{
+		"service unavailable": {&ihttp.Error{StatusCode: http.StatusServiceUnavailable}, true},
+		"rate limited":        {&ihttp.Error{StatusCode: http.StatusTooManyRequests}, true},
+		"not found":           {&ihttp.Error{StatusCode: http.StatusNotFound}, false},
+		"unauthorized":        {&ihttp.Error{StatusCode: http.StatusUnauthorized}, false},
+		"network error":       {&net.OpError{Op: "dial", Err: errors.New("connection refused")}, true},
+		"wrapped network":     {ihttp.NewError(&net.OpError{Op: "dial", Err: errors.New("connection refused")}), true},
+		"canceled":            {context.Canceled, false},
+		"other":               {errors.New("user 'x' not found"), false},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, test.transient, isTransient(test.err))
+		})
+	}
+}
+
+func TestRetry(t *testing.T) {
+	calls := 0
+	err := retry(context.Background(), func(int) error {
+		calls++
+		if calls < retryAttempts {
+			return &ihttp.Error{StatusCode: http.StatusServiceUnavailable}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, retryAttempts, calls)
+
+	calls = 0
+	err = retry(context.Background(), func(int) error {
+		calls++
+		return &ihttp.Error{StatusCode: http.StatusBadRequest}
+	})
+	require.Error(t, err)
+	require.Equal(t, 1, calls)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	calls = 0
+	start := time.Now()
+	err = retry(ctx, func(int) error {
+		calls++
+		cancel()
+		return &ihttp.Error{StatusCode: http.StatusServiceUnavailable}
+	})
+	require.Error(t, err)
+	require.Equal(t, 1, calls)
+	require.Less(t, time.Since(start), retryInitialDelay)
+}
+
+func TestInfluxdb_FlakyCreateDoesNotDuplicate(t *testing.T) {
+	const token = "root-token"
+	srv := newFakeInfluxServer(t, token)
+
+	db := new()
+	dbtesting.AssertInitialize(t, db, dbplugin.InitializeRequest{
+		Config:           srv.connectionParams(token),
+		VerifyConnection: true,
+	})
+	defer dbtesting.AssertClose(t, db)
+
+	srv.loseResponses("POST /api/v2/authorizations", 1)
+	username := newTestCredential(t, db, "test")
+	require.Len(t, srv.userAuthorizations(username), 1)
+	require.Equal(t, 1, srv.callCount("POST /api/v2/authorizations"))
+
+	// User creation isn't retried: a lost response fails the request.
+	srv.loseResponses("POST /api/v2/users", 1)
+	_, err := db.NewUser(context.Background(), dbplugin.NewUserRequest{
+		UsernameConfig: dbplugin.UsernameMetadata{
+			DisplayName: "test",
+			RoleName:    "test",
+		},
+		Password:   "nuozxby98523u89bdfnkjl",
+		Expiration: time.Now().Add(1 * time.Minute),
+	})
+	require.Error(t, err)
+	require.Equal(t, 2, srv.callCount("POST /api/v2/users"))
+	// The user created before the response was lost is rolled back.
+	srv.Lock()
+	require.Len(t, srv.users, 1)
+	srv.Unlock()
+}
+
+func TestInfluxdb_FlakyDeleteIsRetried(t *testing.T) {
+	const token = "root-token"
+	srv := newFakeInfluxServer(t, token)
+
+	db := new()
+	dbtesting.AssertInitialize(t, db, dbplugin.InitializeRequest{
+		Config:           srv.connectionParams(token),
+		VerifyConnection: true,
+	})
+	defer dbtesting.AssertClose(t, db)
+
+	username := newTestCredential(t, db, "test")
+	srv.loseResponses("DELETE /api/v2/authorizations/{id}", 1)
+	srv.loseResponses("DELETE /api/v2/users/{id}", 1)
+	dbtesting.AssertDeleteUser(t, db, dbplugin.DeleteUserRequest{Username: username})
+	require.Equal(t, 2, srv.callCount("DELETE /api/v2/authorizations/{id}"))
+	require.Equal(t, 2, srv.callCount("DELETE /api/v2/users/{id}"))
+}
+
+func TestInfluxdb_DeleteUser_UserGone(t *testing.T) {
+	const token = "root-token"
+	srv := newFakeInfluxServer(t, token)
+
+	db := new()
+	dbtesting.AssertInitialize(t, db, dbplugin.InitializeRequest{
+		Config:           srv.connectionParams(token),
+		VerifyConnection: true,
+	})
+	defer dbtesting.AssertClose(t, db)
+
+	// The user is removed out of band, or by a revocation whose response was
+	// lost: it is no longer listed.
+	username := newTestCredential(t, db, "test")
+	require.Contains(t, db.expiries.entries, username)
+	srv.Lock()
+	for idx, user := range srv.users {
+		if user.Name == username {
+			srv.users = append(srv.users[:idx], srv.users[idx+1:]...)
+			break
+		}
+	}
+	srv.Unlock()
+
+	dbtesting.AssertDeleteUser(t, db, dbplugin.DeleteUserRequest{Username: username})
+	require.Zero(t, srv.callCount("DELETE /api/v2/users/{id}"))
+	require.NotContains(t, db.expiries.entries, username)
+}
+
+func TestInfluxdb_DeleteUser_RevocationAttempts(t *testing.T) {
+	const token = "root-token"
+	srv := newFakeInfluxServer(t, token)
+
+	db := new()
+	dbtesting.AssertInitialize(t, db, dbplugin.InitializeRequest{
+		Config:           makeConfig(srv.connectionParams(token), "revocation_attempts", 5),
+		VerifyConnection: true,
+	})
+	defer dbtesting.AssertClose(t, db)
+
+	var failures int32
+	srv.handle("DELETE /api/v2/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&failures, -1) >= 0 {
+			writeError(w, http.StatusServiceUnavailable, "unavailable", "try again")
+			return
+		}
+		pieces := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/v2/"), "/")
+		srv.serveDefault(w, r, "DELETE /api/v2/users/{id}", r.URL.Path, pieces)
+	})
+
+	// A delete failing more often than the default number of attempts still
+	// succeeds within the configured ones.
+	atomic.StoreInt32(&failures, 4)
+	username := newTestCredential(t, db, "test")
+	dbtesting.AssertDeleteUser(t, db, dbplugin.DeleteUserRequest{Username: username})
+	require.Equal(t, 5, srv.callCount("DELETE /api/v2/users/{id}"))
+	require.Empty(t, srv.userAuthorizations(username))
+
+	// A persistent failure gives up after the configured attempts.
+	atomic.StoreInt32(&failures, 1000)
+	username = newTestCredential(t, db, "test")
+	_, err := db.DeleteUser(context.Background(), dbplugin.DeleteUserRequest{Username: username})
+	require.Error(t, err)
+	require.True(t, errors.Is(err, ErrRevocationFailed), err)
+	require.Equal(t, 10, srv.callCount("DELETE /api/v2/users/{id}"))
+
+	// A user deleted by someone else in the meantime counts as revoked.
+	srv.handle("DELETE /api/v2/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotFound, "not found", "user not found")
+	})
+	dbtesting.AssertDeleteUser(t, db, dbplugin.DeleteUserRequest{Username: username})
+
+	// Neither does a user that was never there.
+	dbtesting.AssertDeleteUser(t, db, dbplugin.DeleteUserRequest{Username: "missing"})
+
+	for _, attempts := range []int{-1, maxRevocationAttempts + 1} {
+		_, err = new().Initialize(context.Background(), dbplugin.InitializeRequest{
+			Config: makeConfig(srv.connectionParams(token), "revocation_attempts", attempts),
+		})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "revocation_attempts")
+	}
+}
@@ -0,0 +1,83 @@
+package influxdbv2
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/influxdata/influxdb-client-go/v2/domain"
+)
+
+// Root profiles name the purpose of a mount, each requiring the permissions
+// the configured token needs for it, as checked when a connection is
+// established. The custom profile requires required_permissions instead.
+const (
+	rootProfileTokenMinter = "token-minter"
+	rootProfileUserManager = "user-manager"
+	rootProfileReader      = "reader"
+	rootProfileCustom      = "custom"
+
+	defaultRootProfile = rootProfileTokenMinter
+)
+
+var rootProfiles = map[string][]requiredPermission{
+	// Issues credentials, which is all most mounts do: each is a token
+	// owned by a user of its own, created and added to its organization.
+	rootProfileTokenMinter: {
+		{Action: domain.PermissionActionWrite, ResourceType: domain.ResourceTypeAuthorizations},
+		{Action: domain.PermissionActionWrite, ResourceType: domain.ResourceTypeUsers},
+		{Action: domain.PermissionActionWrite, ResourceType: domain.ResourceTypeOrgs},
+	},
+	// Manages the users credentials belong to, and their memberships.
+	rootProfileUserManager: {
+		{Action: domain.PermissionActionRead, ResourceType: domain.ResourceTypeUsers},
+		{Action: domain.PermissionActionWrite, ResourceType: domain.ResourceTypeUsers},
+		{Action: domain.PermissionActionRead, ResourceType: domain.ResourceTypeOrgs},
+		{Action: domain.PermissionActionWrite, ResourceType: domain.ResourceTypeOrgs},
+	},
+	// Only inspects the server, e.g. for diagnostics.
+	rootProfileReader: {
+		{Action: domain.PermissionActionRead, ResourceType: domain.ResourceTypeOrgs},
+		{Action: domain.PermissionActionRead, ResourceType: domain.ResourceTypeBuckets},
+	},
+}
+
+// profilePermissions returns the effective root profile and the permissions it
+// requires. The custom profile requires custom, which only it may set; setting
+// custom alone selects it.
+func profilePermissions(profile string, custom []string) (string, []requiredPermission, error) {
+	if profile == "" {
+		profile = defaultRootProfile
+		if len(custom) > 0 {
+			profile = rootProfileCustom
+		}
+	}
+	if profile == rootProfileCustom {
+		if len(custom) == 0 {
+			return "", nil, fmt.Errorf("root_profile %q requires required_permissions", rootProfileCustom)
+		}
+		required, err := parseRequiredPermissions(custom)
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid required_permissions: %w", err)
+		}
+		return profile, required, nil
+	}
+	required, ok := rootProfiles[profile]
+	if !ok {
+		return "", nil, fmt.Errorf("invalid root_profile %q, expected one of: %s", profile, strings.Join(rootProfileNames(), ", "))
+	}
+	if len(custom) > 0 {
+		return "", nil, fmt.Errorf("required_permissions can only be set with root_profile %q", rootProfileCustom)
+	}
+	return profile, required, nil
+}
+
+// rootProfileNames lists the valid root profiles, sorted.
+func rootProfileNames() []string {
+	names := []string{rootProfileCustom}
+	for name := range rootProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,123 @@
+package influxdbv2
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/database/dbplugin/v5"
+	"github.com/influxdata/influxdb-client-go/v2/domain"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProfilePermissions(t *testing.T) {
+	profile, required, err := profilePermissions("", nil)
+	require.NoError(t, err)
+	require.Equal(t, rootProfileTokenMinter, profile)
+	require.Equal(t, rootProfiles[rootProfileTokenMinter], required)
+
+	// required_permissions alone selects the custom profile.
+	profile, required, err = profilePermissions("", []string{"users:read"})
+	require.NoError(t, err)
+	require.Equal(t, rootProfileCustom, profile)
+	require.Equal(t, []requiredPermission{{Action: domain.PermissionActionRead, ResourceType: domain.ResourceTypeUsers}}, required)
+
+	for name, test := range map[string]struct {
+		profile string
+		custom  []string
+		err     string
+	}{
+		"unknown profile":             {profile: "admin", err: `invalid root_profile "admin", expected one of: custom, reader, token-minter, user-manager`},
+		"custom without permissions":  {profile: rootProfileCustom, err: "requires required_permissions"},
+		"custom with invalid entries": {profile: rootProfileCustom, custom: []string{"users"}, err: "invalid required_permissions"},
+		"named profile with list":     {profile: rootProfileReader, custom: []string{"users:read"}, err: `can only be set with root_profile "custom"`},
+	} {
+		_, _, err := profilePermissions(test.profile, test.custom)
+		require.Error(t, err, name)
+		require.Contains(t, err.Error(), test.err, name)
+	}
+}
+
+func TestInitialize_RootProfile(t *testing.T) {
+	all := append(operatorPermissions(), permission(domain.PermissionActionRead, domain.ResourceTypeAuthorizations, ""))
+	granted := map[string][]domain.Permission{
+		"minter": {
+			permission(domain.PermissionActionRead, domain.ResourceTypeAuthorizations, ""),
+			permission(domain.PermissionActionWrite, domain.ResourceTypeAuthorizations, ""),
+			permission(domain.PermissionActionWrite, domain.ResourceTypeUsers, ""),
+			permission(domain.PermissionActionWrite, domain.ResourceTypeOrgs, ""),
+		},
+		// Can issue tokens, but not the users credentials belong to.
+		"tokens only": {
+			permission(domain.PermissionActionRead, domain.ResourceTypeAuthorizations, ""),
+			permission(domain.PermissionActionWrite, domain.ResourceTypeAuthorizations, ""),
+		},
+		"manager": {
+			permission(domain.PermissionActionRead, domain.ResourceTypeAuthorizations, ""),
+			permission(domain.PermissionActionRead, domain.ResourceTypeUsers, ""),
+			permission(domain.PermissionActionWrite, domain.ResourceTypeUsers, ""),
+			permission(domain.PermissionActionRead, domain.ResourceTypeOrgs, ""),
+			permission(domain.PermissionActionWrite, domain.ResourceTypeOrgs, ""),
+		},
+		"reader": {
+			permission(domain.PermissionActionRead, domain.ResourceTypeAuthorizations, ""),
+			permission(domain.PermissionActionRead, domain.ResourceTypeOrgs, ""),
+			permission(domain.PermissionActionRead, domain.ResourceTypeBuckets, ""),
+		},
+		"operator": all,
+	}
+
+	tests := map[string]struct {
+		config  []interface{}
+		accepts []string
+		missing map[string]string
+	}{
+		"default": {
+			accepts: []string{"minter", "operator"},
+			missing: map[string]string{"tokens only": "users:write, orgs:write", "manager": "authorizations:write", "reader": "authorizations:write, users:write, orgs:write"},
+		},
+		rootProfileTokenMinter: {
+			config:  []interface{}{"root_profile", rootProfileTokenMinter},
+			accepts: []string{"minter", "operator"},
+			missing: map[string]string{"tokens only": "users:write, orgs:write", "manager": "authorizations:write", "reader": "authorizations:write, users:write, orgs:write"},
+		},
+		rootProfileUserManager: {
+			config:  []interface{}{"root_profile", rootProfileUserManager},
+			accepts: []string{"manager", "operator"},
+			missing: map[string]string{"minter": "users:read, orgs:read", "tokens only": "users:read, users:write, orgs:read, orgs:write", "reader": "users:read, users:write, orgs:write"},
+		},
+		rootProfileReader: {
+			config:  []interface{}{"root_profile", rootProfileReader},
+			accepts: []string{"reader", "operator"},
+			missing: map[string]string{"minter": "orgs:read, buckets:read", "tokens only": "orgs:read, buckets:read", "manager": "buckets:read"},
+		},
+		rootProfileCustom: {
+			config:  []interface{}{"root_profile", rootProfileCustom, "required_permissions", "authorizations:read"},
+			accepts: []string{"minter", "tokens only", "manager", "reader", "operator"},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			for token, permissions := range granted {
+				srv := newFakeInfluxServer(t, token)
+				srv.setPermissions(token, permissions...)
+
+				db := new()
+				_, err := db.Initialize(context.Background(), dbplugin.InitializeRequest{
+					Config:           makeConfig(srv.connectionParams(token), test.config...),
+					VerifyConnection: true,
+				})
+				if missing, ok := test.missing[token]; ok {
+					require.Error(t, err, token)
+					require.True(t, errors.Is(err, ErrInsufficientPermissions), err.Error())
+					require.Contains(t, err.Error(), "missing required permissions in influxdb: "+missing, token)
+					continue
+				}
+				require.NoError(t, err, token)
+				require.Contains(t, test.accepts, token)
+				require.NoError(t, db.Close())
+			}
+		})
+	}
+}
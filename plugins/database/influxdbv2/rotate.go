@@ -0,0 +1,267 @@
+package influxdbv2
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/influxdata/influxdb-client-go/v2"
+	"github.com/influxdata/influxdb-client-go/v2/domain"
+)
+
+// rootTokenDescription is the description of tokens created by
+// RotateRootToken. It deliberately doesn't carry the managed prefix, so that
+// the root token is never mistaken for an issued credential.
+const rootTokenDescription = "vault root token"
+
+// retiredRootTokenPrefix starts the description of a root token retired by a
+// rotation with a grace period, followed by when it is due for revocation.
+// Tagging the authorization itself, rather than only remembering it, makes
+// its revocation survive reconnects and restarts.
+const retiredRootTokenPrefix = "vault retired root token, revoke after "
+
+// rootRetirementTimeout bounds the revocation of retired root tokens when
+// their grace period is over, which runs outside of any request.
+const rootRetirementTimeout = time.Minute
+
+// RotateRootToken replaces the configured token with a new one holding the
+// same permissions and owned by the same user, then revokes the old token. It
+// returns the new token, which the caller must persist in place of the
+// configured one.
+//
+// Concurrent operations never observe a half-rotated state: the new token and
+// its client are validated before being swapped in under the lock, and the old
+// token is only revoked and its client closed afterwards, once no operation
+// can still be using them.
+//
+// With root_rotation_grace set, the old token stays usable for that long
+// after the new one is swapped in, for other systems still using it. It is
+// tagged for revocation instead, and revoked once the grace period is over:
+// by a timer, when a connection is next verified, or by EnforceExpiry.
+func (i *InfluxdbV2) RotateRootToken(ctx context.Context) (string, error) {
+	i.Lock()
+	if err := i.requireInitialized("RotateRootToken"); err != nil {
+		i.Unlock()
+		return "", err
+	}
+	cli, err := i.getConnection(ctx)
+	oldToken := i.Token
+	grace := i.rootRotationGrace
+	maxPages := i.maxAuthorizationPages()
+	i.Unlock()
+	if err != nil {
+		return "", fmt.Errorf("unable to get connection: %w", err)
+	}
+
+	current, err := findAuthorizationByToken(ctx, cli, oldToken, maxPages)
+	if err != nil {
+		return "", fmt.Errorf("unable to find the authorization of the current token: %w", err)
+	}
+
+	// Creates are not retried, see the retry policy.
+	status := domain.AuthorizationUpdateRequestStatusActive
+	description := rootTokenDescription
+	created, err := cli.AuthorizationsAPI().CreateAuthorization(ctx, &domain.Authorization{
+		AuthorizationUpdateRequest: domain.AuthorizationUpdateRequest{
+			Description: &description,
+			Status:      &status,
+		},
+		OrgID:       current.OrgID,
+		UserID:      current.UserID,
+		Permissions: current.Permissions,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to create new token: %w", err)
+	}
+
+	i.Lock()
+	if i.Token != oldToken || i.client != cli {
+		i.Unlock()
+		return "", i.abortRotation(ctx, cli, created, fmt.Errorf("the connection was reconfigured during rotation"))
+	}
+	newCli, err := i.createClient(*created.Token, true)
+	if err != nil {
+		i.Unlock()
+		return "", i.abortRotation(ctx, cli, created, fmt.Errorf("failed to validate new token: %w", err))
+	}
+	i.client = newCli
+	i.clientCreated = time.Now()
+	promClientsCreated.Inc()
+	i.Token = *created.Token
+	i.Unlock()
+
+	if grace > 0 {
+		err := i.retireRootToken(ctx, newCli, current, time.Now().Add(grace))
+		if closeErr := closeClient(cli); closeErr != nil {
+			i.logger.Warn("failed to close the connection using the old token", "error", closeErr)
+		}
+		if err != nil {
+			return *created.Token, fmt.Errorf("token rotated, but failed to schedule the revocation of the old token: %w", err)
+		}
+		return *created.Token, nil
+	}
+
+	// Every operation holds the lock for its whole duration, so none can still
+	// be using the old client.
+	err = retry(ctx, func(attempt int) error {
+		err := newCli.AuthorizationsAPI().DeleteAuthorization(ctx, current)
+		if attempt > 0 && isNotFound(err) {
+			return nil
+		}
+		return err
+	})
+	if closeErr := closeClient(cli); closeErr != nil {
+		i.logger.Warn("failed to close the connection using the old token", "error", closeErr)
+	}
+	if err != nil {
+		return *created.Token, fmt.Errorf("token rotated, but failed to revoke the old token: %w", err)
+	}
+	return *created.Token, nil
+}
+
+// abortRotation revokes a token created by a rotation that can't complete and
+// returns the error that aborted it.
+func (i *InfluxdbV2) abortRotation(ctx context.Context, cli influxdb2.Client, created *domain.Authorization, cause error) error {
+	if err := cli.AuthorizationsAPI().DeleteAuthorization(ctx, created); err != nil {
+		i.logger.Warn("unable to revoke token created by aborted rotation", "id", *created.Id, "error", err)
+	}
+	return cause
+}
+
+// findAuthorizationByToken returns the authorization of the given token,
+// listing at most maxPages pages of authorizations.
+func findAuthorizationByToken(ctx context.Context, cli influxdb2.Client, token string, maxPages int) (*domain.Authorization, error) {
+	authorizations, err := listAuthorizations(ctx, cli, maxPages)
+	if err != nil {
+		return nil, err
+	}
+	for _, authorization := range authorizations {
+		if authorization.Token != nil && *authorization.Token == token {
+			return &authorization, nil
+		}
+	}
+	return nil, fmt.Errorf("no authorization found for the token")
+}
+
+// rootRetirement is the timer revoking retired root tokens when the earliest
+// grace period is over.
+type rootRetirement struct {
+	timer *time.Timer
+	at    time.Time
+}
+
+// stop cancels the timer, if any.
+func (r *rootRetirement) stop() {
+	if r.timer != nil {
+		r.timer.Stop()
+	}
+	*r = rootRetirement{}
+}
+
+// retireRootToken tags the authorization of a rotated root token for
+// revocation at the given time, and schedules it.
+func (i *InfluxdbV2) retireRootToken(ctx context.Context, cli influxdb2.Client, authorization *domain.Authorization, at time.Time) error {
+	if err := setAuthorizationDescription(ctx, cli, *authorization.Id, retiredRootTokenPrefix+at.UTC().Format(time.RFC3339Nano)); err != nil {
+		return classify(err)
+	}
+	i.Lock()
+	defer i.Unlock()
+	i.scheduleRootRetirement(at)
+	return nil
+}
+
+// parseRetiredRootToken returns when the root token with the given
+// authorization description is due for revocation, if it was retired.
+func parseRetiredRootToken(description string) (time.Time, bool) {
+	if !strings.HasPrefix(description, retiredRootTokenPrefix) {
+		return time.Time{}, false
+	}
+	at, err := time.Parse(time.RFC3339Nano, strings.TrimPrefix(description, retiredRootTokenPrefix))
+	if err != nil {
+		return time.Time{}, false
+	}
+	return at, true
+}
+
+// scheduleRootRetirement makes the timer fire at the given time, unless it
+// already fires earlier. It must be called with the lock held.
+func (i *influxdbConnectionProducer) scheduleRootRetirement(at time.Time) {
+	if i.retirement.timer != nil && !i.retirement.at.After(at) {
+		return
+	}
+	i.retirement.stop()
+	i.retirement = rootRetirement{
+		timer: time.AfterFunc(time.Until(at), func() { i.rootRetirementDue(at) }),
+		at:    at,
+	}
+}
+
+// rootRetirementDue revokes the retired root tokens when the timer set for at
+// fires. Failures are left for the next connection verification or
+// EnforceExpiry to retry.
+func (i *influxdbConnectionProducer) rootRetirementDue(at time.Time) {
+	i.Lock()
+	defer i.Unlock()
+
+	// The timer was stopped or replaced while this waited for the lock.
+	if i.retirement.timer == nil || !i.retirement.at.Equal(at) {
+		return
+	}
+	i.retirement = rootRetirement{}
+
+	ctx, cancel := context.WithTimeout(context.Background(), rootRetirementTimeout)
+	defer cancel()
+	conn, err := i.Connection(ctx)
+	if err != nil {
+		i.logger.Warn("unable to get connection to revoke retired root tokens", "error", err)
+		return
+	}
+	if err := i.revokeRetiredRootTokens(ctx, conn.(influxdb2.Client)); err != nil {
+		i.logger.Warn("unable to revoke retired root tokens", "error", err)
+	}
+}
+
+// revokeRetiredRootTokens revokes the root tokens whose grace period is over,
+// and schedules the revocation of the others. The configured token is never
+// revoked, even if tagged. It must be called with the lock held.
+func (i *influxdbConnectionProducer) revokeRetiredRootTokens(ctx context.Context, cli influxdb2.Client) error {
+	authorizations, err := listAuthorizations(ctx, cli, i.maxAuthorizationPages())
+	if err != nil {
+		return classify(err)
+	}
+
+	now := time.Now()
+	var next time.Time
+	var errs *multierror.Error
+	for _, authorization := range authorizations {
+		at, ok := parseRetiredRootToken(stringValue(authorization.Description))
+		if !ok || stringValue(authorization.Token) == i.Token {
+			continue
+		}
+		if at.After(now) {
+			if next.IsZero() || at.Before(next) {
+				next = at
+			}
+			continue
+		}
+		id := stringValue(authorization.Id)
+		err := retryN(ctx, i.revocationAttempts(), func(int) error {
+			err := cli.AuthorizationsAPI().DeleteAuthorization(ctx, &domain.Authorization{Id: &id})
+			if isNotFound(err) {
+				return nil
+			}
+			return err
+		})
+		if err != nil {
+			errs = multierror.Append(errs, fmt.Errorf("authorization %s: %w", id, classify(err)))
+			continue
+		}
+		i.logger.Info("revoked retired root token", "id", id)
+	}
+	if !next.IsZero() {
+		i.scheduleRootRetirement(next)
+	}
+	return errs.ErrorOrNil()
+}
@@ -0,0 +1,180 @@
+package influxdbv2
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/database/dbplugin/v5"
+	dbtesting "github.com/hashicorp/vault/sdk/database/dbplugin/v5/testing"
+	"github.com/influxdata/influxdb-client-go/v2/domain"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInfluxdb_RotateRootToken(t *testing.T) {
+	const token = "root-token"
+	srv := newFakeInfluxServer(t, token)
+
+	db := new()
+	dbtesting.AssertInitialize(t, db, dbplugin.InitializeRequest{
+		Config:           srv.connectionParams(token),
+		VerifyConnection: true,
+	})
+	defer dbtesting.AssertClose(t, db)
+
+	newToken, err := db.RotateRootToken(context.Background())
+	require.NoError(t, err)
+	require.NotEqual(t, token, newToken)
+	require.Equal(t, newToken, db.Token)
+
+	srv.Lock()
+	var tokens []string
+	for _, auth := range srv.authorizations {
+		tokens = append(tokens, *auth.Token)
+	}
+	srv.Unlock()
+	require.Contains(t, tokens, newToken)
+	require.NotContains(t, tokens, token)
+
+	// The new token holds the same permissions and keeps working.
+	newTestCredential(t, db, "test")
+}
+
+func TestInfluxdb_RotateRootToken_Concurrent(t *testing.T) {
+	const token = "root-token"
+	srv := newFakeInfluxServer(t, token)
+
+	db := new()
+	dbtesting.AssertInitialize(t, db, dbplugin.InitializeRequest{
+		Config:           srv.connectionParams(token),
+		VerifyConnection: true,
+	})
+	defer dbtesting.AssertClose(t, db)
+
+	const workers = 4
+	const perWorker = 10
+	var wg sync.WaitGroup
+	errs := make(chan error, workers*perWorker)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < perWorker; n++ {
+				resp, err := db.NewUser(context.Background(), dbplugin.NewUserRequest{
+					UsernameConfig: dbplugin.UsernameMetadata{
+						DisplayName: "token",
+						RoleName:    "test",
+					},
+					Statements: dbplugin.Statements{
+						Commands: []string{`{"permissions": [{"action": "read", "resource": {"type": "buckets"}}]}`},
+					},
+					Password:   "nuozxby98523u89bdfnkjl",
+					Expiration: time.Now().Add(1 * time.Minute),
+				})
+				if err == nil {
+					_, err = db.DeleteUser(context.Background(), dbplugin.DeleteUserRequest{Username: resp.Username})
+				}
+				errs <- err
+			}
+		}()
+	}
+
+	for r := 0; r < 3; r++ {
+		_, err := db.RotateRootToken(context.Background())
+		require.NoError(t, err)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+}
+
+func TestInfluxdb_RotateRootToken_Grace(t *testing.T) {
+	const token = "root-token"
+	const grace = 300 * time.Millisecond
+	srv := newFakeInfluxServer(t, token)
+
+	findToken := func(token string) *domain.Authorization {
+		srv.Lock()
+		defer srv.Unlock()
+		for _, auth := range srv.authorizations {
+			if *auth.Token == token {
+				auth := auth
+				return &auth
+			}
+		}
+		return nil
+	}
+
+	db := new()
+	dbtesting.AssertInitialize(t, db, dbplugin.InitializeRequest{
+		Config:           makeConfig(srv.connectionParams(token), "root_rotation_grace", grace.String()),
+		VerifyConnection: true,
+	})
+	defer dbtesting.AssertClose(t, db)
+
+	rotated := time.Now()
+	newToken, err := db.RotateRootToken(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, newToken, db.Token)
+
+	// The old token is tagged for revocation, but stays until the grace
+	// period is over.
+	old := findToken(token)
+	require.NotNil(t, old)
+	at, ok := parseRetiredRootToken(*old.Description)
+	require.True(t, ok, *old.Description)
+	require.WithinDuration(t, rotated.Add(grace), at, grace)
+	require.Eventually(t, func() bool { return findToken(token) == nil }, 5*time.Second, 10*time.Millisecond)
+	require.GreaterOrEqual(t, time.Since(rotated), grace)
+	require.NotNil(t, findToken(newToken))
+
+	t.Run("survives reconnects", func(t *testing.T) {
+		rotated := time.Now()
+		retired := db.Token
+		current, err := db.RotateRootToken(context.Background())
+		require.NoError(t, err)
+		dbtesting.AssertClose(t, db)
+		require.Nil(t, db.retirement.timer)
+
+		// A new connection picks the tagged token up from the server.
+		other := new()
+		dbtesting.AssertInitialize(t, other, dbplugin.InitializeRequest{
+			Config:           makeConfig(srv.connectionParams(current), "root_rotation_grace", grace.String()),
+			VerifyConnection: true,
+		})
+		defer dbtesting.AssertClose(t, other)
+		require.NotNil(t, findToken(retired))
+		require.Eventually(t, func() bool { return findToken(retired) == nil }, 5*time.Second, 10*time.Millisecond)
+		require.GreaterOrEqual(t, time.Since(rotated), grace)
+		require.NotNil(t, findToken(current))
+	})
+
+	t.Run("EnforceExpiry", func(t *testing.T) {
+		retired := db.Token
+		_, err := db.RotateRootToken(context.Background())
+		require.NoError(t, err)
+		db.Lock()
+		db.retirement.stop()
+		db.Unlock()
+
+		_, err = db.EnforceExpiry(context.Background())
+		require.NoError(t, err)
+		require.NotNil(t, findToken(retired))
+		time.Sleep(grace)
+		_, err = db.EnforceExpiry(context.Background())
+		require.NoError(t, err)
+		require.Nil(t, findToken(retired))
+	})
+}
+
+func TestInfluxdb_RootRotationGraceValidation(t *testing.T) {
+	for _, raw := range []string{"-1s", "25h", "soon"} {
+		err := ValidateConfig(map[string]interface{}{"host": "localhost", "token": "token", "root_rotation_grace": raw})
+		require.Error(t, err, raw)
+		require.Contains(t, err.Error(), "root_rotation_grace", raw)
+	}
+	require.NoError(t, ValidateConfig(map[string]interface{}{"host": "localhost", "token": "token", "root_rotation_grace": "1h"}))
+}
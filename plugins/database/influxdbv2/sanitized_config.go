@@ -0,0 +1,201 @@
+package influxdbv2
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"sort"
+	"strings"
+)
+
+// sanitizedConfig is the effective configuration of a connection, after
+// defaults are applied, with no secret material.
+type sanitizedConfig struct {
+	Scheme         string   `json:"scheme"`
+	URL            string   `json:"url,omitempty"`
+	Host           string   `json:"host,omitempty"`
+	Port           string   `json:"port"`
+	Endpoints      []string `json:"endpoints"`
+	EndpointPolicy string   `json:"endpoint_policy"`
+	VerifyEndpoint string   `json:"verify_endpoint,omitempty"`
+	APIPath        string   `json:"api_path"`
+
+	Organization              string   `json:"organization,omitempty"`
+	OrganizationID            string   `json:"organization_id,omitempty"`
+	ResolutionOrganization    string   `json:"resolution_organization,omitempty"`
+	DefaultBucket             string   `json:"default_bucket,omitempty"`
+	DefaultBucketOrganization string   `json:"default_bucket_organization,omitempty"`
+	SessionName               string   `json:"session_name"`
+	OriginCluster             string   `json:"origin_cluster,omitempty"`
+	OriginMount               string   `json:"origin_mount,omitempty"`
+	ResolutionRetryWindow     string   `json:"resolution_retry_window"`
+	ResolutionRetryOn         []string `json:"resolution_retry_on"`
+	RedactionMarker           string   `json:"redaction_marker"`
+	MetricsListenAddr         string   `json:"metrics_listen_addr,omitempty"`
+	MetricsListenAllowRemote  bool     `json:"metrics_listen_allow_remote,omitempty"`
+
+	TLS TLSPosture `json:"tls"`
+
+	ConnectTimeout        string  `json:"connect_timeout"`
+	RequestTimeout        string  `json:"request_timeout"`
+	PingTimeout           string  `json:"ping_timeout,omitempty"`
+	IdleConnectionTimeout string  `json:"idle_connection_timeout"`
+	ResponseHeaderTimeout string  `json:"response_header_timeout,omitempty"`
+	MaxIdleConnections    int     `json:"max_idle_connections"`
+	MaxConnectionLifetime string  `json:"max_connection_lifetime"`
+	HTTPProxy             string  `json:"http_proxy,omitempty"`
+	SOCKS5Proxy           string  `json:"socks5_proxy,omitempty"`
+	DNSResolver           string  `json:"dns_resolver,omitempty"`
+	DisableHTTP2          bool    `json:"disable_http2"`
+	FollowRedirects       bool    `json:"follow_redirects"`
+	RequestsPerSecond     float64 `json:"requests_per_second"`
+	Burst                 int     `json:"burst,omitempty"`
+	RevocationAttempts    int     `json:"revocation_attempts"`
+	MaxAuthorizationPages int     `json:"max_authorization_pages"`
+	RootRotationGrace     string  `json:"root_rotation_grace"`
+
+	LazyConnect             bool     `json:"lazy_connect"`
+	SkipAccessCheck         bool     `json:"skip_access_check"`
+	RequireHealthy          bool     `json:"require_healthy"`
+	Cloud                   bool     `json:"cloud"`
+	VerifyCreatedCredential bool     `json:"verify_created_credential"`
+	Prewarm                 bool     `json:"prewarm"`
+	WarmConnections         int      `json:"warm_connections"`
+	AutoCreateOrg           bool     `json:"auto_create_org"`
+	CaseInsensitiveNames    bool     `json:"case_insensitive_names"`
+	RootProfile             string   `json:"root_profile"`
+	RequiredPermissions     []string `json:"required_permissions"`
+	ImportAllowed           []string `json:"import_allowed_permissions"`
+	MaxPermissions          int      `json:"max_permissions"`
+	MaxPermissionsSize      int      `json:"max_permissions_size"`
+	ForbiddenUnscoped       []string `json:"forbidden_unscoped_permissions"`
+
+	Capabilities Capabilities `json:"capabilities"`
+}
+
+// SanitizedConfig returns the effective connection configuration as JSON,
+// for inventory and auditing. Values defaulted during Initialize are
+// reported as used rather than as given, and every secret is redacted.
+func (i *influxdbConnectionProducer) SanitizedConfig() ([]byte, error) {
+	i.Lock()
+	defer i.Unlock()
+
+	idleConnectionTimeout := defaultIdleConnectionTimeout
+	if i.idleConnectionTimeout > 0 {
+		idleConnectionTimeout = i.idleConnectionTimeout
+	}
+	var pingTimeout string
+	if i.pingTimeout > 0 {
+		pingTimeout = i.pingTimeout.String()
+	}
+	var responseHeaderTimeout string
+	if i.responseHeaderTimeout > 0 {
+		responseHeaderTimeout = i.responseHeaderTimeout.String()
+	}
+	maxIdleConnections := defaultMaxIdleConnections
+	if i.MaxIdleConnections > 0 {
+		maxIdleConnections = i.MaxIdleConnections
+	}
+	resolutionRetryOn := make([]string, 0, len(i.resolutionRetryOn))
+	for condition := range i.resolutionRetryOn {
+		resolutionRetryOn = append(resolutionRetryOn, condition)
+	}
+	sort.Strings(resolutionRetryOn)
+	var burst int
+	if i.rateLimiter != nil {
+		burst = i.burst()
+	}
+	port := i.Port
+	if len(i.Endpoints) == 0 && len(i.endpoints) == 1 {
+		_, port, _ = net.SplitHostPort(i.endpoints[0])
+	}
+	requiredPermissions := make([]string, len(i.requiredPermissions))
+	for idx, permission := range i.requiredPermissions {
+		requiredPermissions[idx] = permission.String()
+	}
+	importAllowed := make([]string, len(i.importAllowed))
+	for idx, permission := range i.importAllowed {
+		importAllowed[idx] = permission.String()
+	}
+	forbiddenUnscoped := make([]string, len(i.forbiddenUnscoped))
+	for idx, permission := range i.forbiddenUnscoped {
+		forbiddenUnscoped[idx] = permission.String()
+	}
+
+	config := sanitizedConfig{
+		Scheme:         i.scheme(),
+		URL:            i.URL,
+		Host:           i.Host,
+		Port:           port,
+		Endpoints:      i.endpoints,
+		EndpointPolicy: i.EndpointPolicy,
+		VerifyEndpoint: i.verifyEndpoint,
+		APIPath:        i.apiPath,
+
+		Organization:              i.Organization,
+		OrganizationID:            i.OrganizationID,
+		ResolutionOrganization:    i.ResolutionOrganization,
+		DefaultBucket:             i.DefaultBucket,
+		DefaultBucketOrganization: i.DefaultBucketOrganization,
+		SessionName:               i.SessionName,
+		OriginCluster:             i.OriginCluster,
+		OriginMount:               i.OriginMount,
+		ResolutionRetryWindow:     i.resolutionRetryWindow.String(),
+		ResolutionRetryOn:         resolutionRetryOn,
+		RedactionMarker:           i.RedactionMarker,
+		MetricsListenAddr:         i.MetricsListenAddr,
+		MetricsListenAllowRemote:  i.MetricsListenAllowRemote,
+
+		TLS: i.tlsPosture(),
+
+		ConnectTimeout:        i.connectTimeout.String(),
+		RequestTimeout:        i.effectiveRequestTimeout().String(),
+		PingTimeout:           pingTimeout,
+		IdleConnectionTimeout: idleConnectionTimeout.String(),
+		ResponseHeaderTimeout: responseHeaderTimeout,
+		MaxIdleConnections:    maxIdleConnections,
+		MaxConnectionLifetime: i.maxConnectionLifetime.String(),
+		DNSResolver:           i.DNSResolver,
+		DisableHTTP2:          i.DisableHTTP2,
+		FollowRedirects:       i.FollowRedirects,
+		RequestsPerSecond:     i.RequestsPerSecond,
+		Burst:                 burst,
+		RevocationAttempts:    i.revocationAttempts(),
+		MaxAuthorizationPages: i.maxAuthorizationPages(),
+		RootRotationGrace:     i.rootRotationGrace.String(),
+
+		LazyConnect:             i.LazyConnect,
+		SkipAccessCheck:         i.SkipAccessCheck,
+		RequireHealthy:          i.RequireHealthy,
+		Cloud:                   i.Cloud,
+		VerifyCreatedCredential: i.VerifyCreatedCredential,
+		Prewarm:                 i.Prewarm,
+		WarmConnections:         i.WarmConnections,
+		AutoCreateOrg:           i.AutoCreateOrg,
+		CaseInsensitiveNames:    i.CaseInsensitiveNames,
+		RootProfile:             i.rootProfile,
+		RequiredPermissions:     requiredPermissions,
+		ImportAllowed:           importAllowed,
+		MaxPermissions:          i.MaxPermissions,
+		MaxPermissionsSize:      i.maxPermissionsSize(),
+		ForbiddenUnscoped:       forbiddenUnscoped,
+
+		Capabilities: i.capabilities(),
+	}
+	if i.proxyURL != nil {
+		config.HTTPProxy = i.proxyURL.Redacted()
+	}
+	if i.socks5URL != nil {
+		config.SOCKS5Proxy = i.socks5URL.Redacted()
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(config); err != nil {
+		return nil, err
+	}
+	// Nothing above is meant to hold a secret, but a token pasted into the
+	// wrong field must not leak either.
+	return []byte(redactString(strings.TrimSpace(buf.String()), i.secretValues())), nil
+}
@@ -0,0 +1,47 @@
+package influxdbv2
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+)
+
+const (
+	// defaultSessionName is used when session_name is not configured.
+	defaultSessionName = "vault-" + influxdbTypeName
+
+	maxSessionNameLength = 64
+)
+
+var sessionNameRegex = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// validateSessionName checks that name can be sent as a User-Agent product
+// token and embedded in authorization descriptions unchanged.
+func validateSessionName(name string) error {
+	if len(name) > maxSessionNameLength {
+		return fmt.Errorf("session_name cannot be longer than %d characters", maxSessionNameLength)
+	}
+	if !sessionNameRegex.MatchString(name) {
+		return fmt.Errorf("session_name %q may only contain letters, digits, '.', '_' and '-'", name)
+	}
+	return nil
+}
+
+// sessionTransport identifies the mount to the server. The influx client
+// version in use has no application name option, so the session name is
+// appended to the User-Agent the client sets, which InfluxDB records in its
+// HTTP request log.
+type sessionTransport struct {
+	wrappedTransport
+	name string
+}
+
+func (t *sessionTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	userAgent := "vault-session/" + t.name
+	if ua := req.Header.Get("User-Agent"); ua != "" {
+		userAgent = ua + " " + userAgent
+	}
+	req.Header.Set("User-Agent", userAgent)
+	return t.base.RoundTrip(req)
+}
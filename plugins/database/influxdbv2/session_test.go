@@ -0,0 +1,90 @@
+package influxdbv2
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/database/dbplugin/v5"
+	dbtesting "github.com/hashicorp/vault/sdk/database/dbplugin/v5/testing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateSessionName(t *testing.T) {
+	tests := map[string]bool{
+		"vault-influxdbv2":      true,
+		"prod.metrics_mount-1":  true,
+		strings.Repeat("a", 64): true,
+		strings.Repeat("a", 65): false,
+		"has space":             false,
+		"slash/name":            false,
+		"quote\"":               false,
+		"":                      false,
+	}
+
+	for name, valid := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := validateSessionName(name)
+			if valid {
+				require.NoError(t, err)
+			} else {
+				require.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestInfluxdb_SessionName(t *testing.T) {
+	const token = "root-token"
+	srv := newFakeInfluxServer(t, token)
+
+	var mu sync.Mutex
+	var userAgent string
+	srv.handle("GET /ping", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		userAgent = r.Header.Get("User-Agent")
+		mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	db := new()
+	dbtesting.AssertInitialize(t, db, dbplugin.InitializeRequest{
+		Config:           makeConfig(srv.connectionParams(token), "session_name", "metrics-mount"),
+		VerifyConnection: true,
+	})
+	defer dbtesting.AssertClose(t, db)
+
+	mu.Lock()
+	require.Contains(t, userAgent, "influxdb-client-go")
+	require.True(t, strings.HasSuffix(userAgent, " vault-session/metrics-mount"), userAgent)
+	mu.Unlock()
+
+	username := newTestCredential(t, db, "test")
+	auths := srv.userAuthorizations(username)
+	require.Len(t, auths, 1)
+	metadata, ok := parseDescription(*auths[0].Description)
+	require.True(t, ok)
+	require.Equal(t, "metrics-mount", metadata.Session)
+}
+
+func TestInitialize_SessionName(t *testing.T) {
+	db := new()
+	dbtesting.AssertInitialize(t, db, dbplugin.InitializeRequest{
+		Config: map[string]interface{}{
+			"host":  "influx.example.com",
+			"token": "token",
+		},
+	})
+	require.Equal(t, defaultSessionName, db.SessionName)
+
+	_, err := new().Initialize(context.Background(), dbplugin.InitializeRequest{
+		Config: map[string]interface{}{
+			"host":         "influx.example.com",
+			"token":        "token",
+			"session_name": strings.Repeat("a", 65),
+		},
+	})
+	require.Error(t, err)
+}
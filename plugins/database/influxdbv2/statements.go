@@ -0,0 +1,217 @@
+package influxdbv2
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/database/dbplugin/v5"
+	"github.com/influxdata/influxdb-client-go/v2/domain"
+)
+
+// creationStatement is the merged form of the JSON creation statements of a
+// role. Commands that are not JSON objects are ignored, which keeps roles
+// written for earlier versions of the plugin working.
+type creationStatement struct {
+	// Organization overrides the configured organization for this credential.
+	Organization string
+
+	// Permissions and Presets, when set, are granted through an
+	// authorization owned by the new user in the target organization.
+	Permissions []domain.Permission
+	Presets     []presetStatement
+
+	// NonRevocable marks the credential so that DeleteUser leaves it in
+	// place, see credentialMetadata.
+	NonRevocable bool
+}
+
+// statementJSON is the JSON schema of a single creation statement, e.g.
+//
+//	{
+//	  "organization": "metrics",
+//	  "permissions": [
+//	    {"action": "read", "resource": {"type": "buckets", "name": "telegraf"}}
+//	  ]
+//	}
+//
+// or, using a preset on a bucket that defaults to default_bucket:
+//
+//	{"preset": "write", "bucket": "telegraf"}
+//
+// or on several buckets, by name or by ID:
+//
+//	{"preset": "write", "buckets": ["telegraf", "metrics"], "bucket_ids": ["0123456789abcdef"]}
+//
+// A credential Vault must never delete, e.g. one handed to a system that
+// outlives the lease, is marked with "revocable":
+//
+//	{"preset": "read", "bucket": "telegraf", "revocable": false}
+//
+// Several presets, and permissions, combine into the same authorization:
+//
+//	{
+//	  "presets": [{"preset": "write", "bucket": "telegraf"}, {"preset": "read", "bucket": "alerts"}],
+//	  "permissions": [
+//	    {"action": "read", "resource": {"type": "telegrafs", "id": "0123456789abcdef"}},
+//	    {"action": "write", "resource": {"type": "telegrafs", "id": "0123456789abcdef"}}
+//	  ]
+//	}
+type statementJSON struct {
+	Organization string                `json:"organization"`
+	Permissions  []permissionStatement `json:"permissions"`
+	presetJSON
+	Presets   []presetJSON `json:"presets"`
+	Revocable *bool        `json:"revocable"`
+}
+
+// presetJSON is a preset along with the buckets it applies to, given either
+// at the top level of a statement or as an entry of "presets".
+type presetJSON struct {
+	Preset    string   `json:"preset"`
+	Bucket    string   `json:"bucket"`
+	Buckets   []string `json:"buckets"`
+	BucketIDs []string `json:"bucket_ids"`
+}
+
+// parse validates the preset and its buckets. ok is false if nothing is
+// requested.
+func (p presetJSON) parse() (_ presetStatement, ok bool, _ error) {
+	namesBuckets := p.Bucket != "" || len(p.Buckets) > 0 || len(p.BucketIDs) > 0
+	switch {
+	case p.Preset == "" && p.Bucket != "":
+		return presetStatement{}, false, fmt.Errorf("\"bucket\" requires a \"preset\"")
+	case p.Preset == "" && namesBuckets:
+		return presetStatement{}, false, fmt.Errorf("\"buckets\" and \"bucket_ids\" require a \"preset\"")
+	case p.Preset == "":
+		return presetStatement{}, false, nil
+	}
+	if err := validatePreset(p.Preset); err != nil {
+		return presetStatement{}, false, err
+	}
+	if p.Preset == presetReadAllBuckets && namesBuckets {
+		return presetStatement{}, false, fmt.Errorf("preset %q cannot be combined with \"bucket\", \"buckets\" or \"bucket_ids\"", presetReadAllBuckets)
+	}
+	if p.Bucket != "" && len(p.Buckets) > 0 {
+		return presetStatement{}, false, fmt.Errorf("\"bucket\" and \"buckets\" cannot both be set")
+	}
+	for _, bucket := range append(append([]string(nil), p.Buckets...), p.BucketIDs...) {
+		if strings.TrimSpace(bucket) == "" {
+			return presetStatement{}, false, fmt.Errorf("\"buckets\" and \"bucket_ids\" cannot contain an empty entry")
+		}
+	}
+	buckets := p.Buckets
+	if p.Bucket != "" {
+		buckets = []string{p.Bucket}
+	}
+	return presetStatement{Name: p.Preset, Buckets: buckets, BucketIDs: p.BucketIDs}, true, nil
+}
+
+type permissionStatement struct {
+	Action   domain.PermissionAction `json:"action"`
+	Resource resourceStatement       `json:"resource"`
+}
+
+// resourceStatement mirrors the scoping fields InfluxDB supports on a
+// permission's resource. InfluxDB has no notion of measurement or row level
+// permissions: the closest approximation is a bucket holding only the data
+// the credential should see. Measurement and predicate are recognized only so
+// that a role asking for them fails with a precise error instead of silently
+// receiving a broader token.
+type resourceStatement struct {
+	Type  domain.ResourceType `json:"type"`
+	ID    string              `json:"id"`
+	Name  string              `json:"name"`
+	Org   string              `json:"org"`
+	OrgID string              `json:"orgID"`
+
+	Measurement string `json:"measurement"`
+	Predicate   string `json:"predicate"`
+}
+
+// permission converts the statement into the permission sent to InfluxDB.
+func (p permissionStatement) permission() (domain.Permission, error) {
+	switch {
+	case p.Action != domain.PermissionActionRead && p.Action != domain.PermissionActionWrite:
+		return domain.Permission{}, fmt.Errorf("invalid permission action %q, expected read or write", p.Action)
+	case p.Resource.Type == "":
+		return domain.Permission{}, fmt.Errorf("permission resource type cannot be empty")
+	case p.Resource.Measurement != "":
+		return domain.Permission{}, fmt.Errorf("unsupported permission scoping field %q: InfluxDB permissions cannot be scoped to a measurement, scope the credential to a bucket holding only that data instead", "measurement")
+	case p.Resource.Predicate != "":
+		return domain.Permission{}, fmt.Errorf("unsupported permission scoping field %q: InfluxDB permissions cannot be scoped by predicate, scope the credential to a bucket holding only that data instead", "predicate")
+	}
+
+	permission := domain.Permission{
+		Action: p.Action,
+		Resource: domain.Resource{
+			Type: p.Resource.Type,
+		},
+	}
+	if p.Resource.ID != "" {
+		permission.Resource.Id = &p.Resource.ID
+	}
+	if p.Resource.Name != "" {
+		permission.Resource.Name = &p.Resource.Name
+	}
+	if p.Resource.Org != "" {
+		permission.Resource.Org = &p.Resource.Org
+	}
+	if p.Resource.OrgID != "" {
+		permission.Resource.OrgID = &p.Resource.OrgID
+	}
+	return permission, nil
+}
+
+// parseCreationStatements merges every JSON creation statement into a single
+// creationStatement. Later statements override the organization and
+// revocability of earlier ones while permissions accumulate. Unknown fields
+// are rejected rather than ignored so that a typo can't widen the resulting
+// token.
+func parseCreationStatements(statements dbplugin.Statements) (creationStatement, error) {
+	var stmt creationStatement
+	for _, cmd := range statements.Commands {
+		cmd = strings.TrimSpace(cmd)
+		if !strings.HasPrefix(cmd, "{") {
+			continue
+		}
+		var s statementJSON
+		dec := json.NewDecoder(bytes.NewReader([]byte(cmd)))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&s); err != nil {
+			return creationStatement{}, fmt.Errorf("unable to parse creation statement: %w", err)
+		}
+		if s.Organization != "" {
+			stmt.Organization = s.Organization
+		}
+		if s.Revocable != nil {
+			stmt.NonRevocable = !*s.Revocable
+		}
+		preset, ok, err := s.presetJSON.parse()
+		if err != nil {
+			return creationStatement{}, fmt.Errorf("invalid creation statement: %w", err)
+		}
+		if ok {
+			stmt.Presets = append(stmt.Presets, preset)
+		}
+		for idx, p := range s.Presets {
+			preset, ok, err := p.parse()
+			if err == nil && !ok {
+				err = fmt.Errorf("\"presets\" entries require a \"preset\"")
+			}
+			if err != nil {
+				return creationStatement{}, fmt.Errorf("invalid creation statement: presets[%d]: %w", idx, err)
+			}
+			stmt.Presets = append(stmt.Presets, preset)
+		}
+		for _, p := range s.Permissions {
+			permission, err := p.permission()
+			if err != nil {
+				return creationStatement{}, fmt.Errorf("invalid creation statement: %w", err)
+			}
+			stmt.Permissions = append(stmt.Permissions, permission)
+		}
+	}
+	return stmt, nil
+}
@@ -0,0 +1,134 @@
+package influxdbv2
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/database/dbplugin/v5"
+	dbtesting "github.com/hashicorp/vault/sdk/database/dbplugin/v5/testing"
+	"github.com/influxdata/influxdb-client-go/v2/domain"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCreationStatements(t *testing.T) {
+	type testCase struct {
+		commands  []string
+		expectOrg string
+		expectLen int
+		expectErr string
+	}
+
+	tests := map[string]testCase{
+		"legacy statements ignored": {
+			commands: []string{createUserStatements},
+		},
+		"organization overridden by later statement": {
+			commands: []string{
+				`{"organization": "first", "permissions": [{"action": "read", "resource": {"type": "buckets"}}]}`,
+				`{"organization": "second", "permissions": [{"action": "write", "resource": {"type": "buckets"}}]}`,
+			},
+			expectOrg: "second",
+			expectLen: 2,
+		},
+		"scoped resource": {
+			commands:  []string{`{"permissions": [{"action": "read", "resource": {"type": "buckets", "name": "telegraf", "org": "metrics"}}]}`},
+			expectLen: 1,
+		},
+		"measurement rejected": {
+			commands:  []string{`{"permissions": [{"action": "read", "resource": {"type": "buckets", "name": "telegraf", "measurement": "cpu"}}]}`},
+			expectErr: `unsupported permission scoping field "measurement"`,
+		},
+		"predicate rejected": {
+			commands:  []string{`{"permissions": [{"action": "read", "resource": {"type": "buckets", "name": "telegraf", "predicate": "host=\"a\""}}]}`},
+			expectErr: `unsupported permission scoping field "predicate"`,
+		},
+		"unknown field rejected": {
+			commands:  []string{`{"permissions": [{"action": "read", "resource": {"type": "buckets", "tag": "host"}}]}`},
+			expectErr: `unknown field "tag"`,
+		},
+		"presets accumulate": {
+			commands: []string{
+				`{"preset": "read", "bucket": "telegraf"}`,
+				`{"preset": "write"}`,
+			},
+		},
+		"unknown preset": {
+			commands:  []string{`{"preset": "admin"}`},
+			expectErr: `unknown preset "admin"`,
+		},
+		"bucket without preset": {
+			commands:  []string{`{"bucket": "telegraf"}`},
+			expectErr: `"bucket" requires a "preset"`,
+		},
+		"invalid action": {
+			commands:  []string{`{"permissions": [{"action": "admin", "resource": {"type": "buckets"}}]}`},
+			expectErr: "invalid permission action",
+		},
+		"missing type": {
+			commands:  []string{`{"permissions": [{"action": "read", "resource": {"name": "telegraf"}}]}`},
+			expectErr: "resource type cannot be empty",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			stmt, err := parseCreationStatements(dbplugin.Statements{Commands: test.commands})
+			if test.expectErr != "" {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), test.expectErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, test.expectOrg, stmt.Organization)
+			require.Len(t, stmt.Permissions, test.expectLen)
+		})
+	}
+}
+
+func TestInfluxdb_NewUser_ResourceOrganization(t *testing.T) {
+	const token = "root-token"
+	srv := newFakeInfluxServer(t, token)
+	metricsOrgID := srv.addOrg("metrics")
+	bucketID := srv.addBucket(metricsOrgID, "telegraf")
+
+	db := new()
+	dbtesting.AssertInitialize(t, db, dbplugin.InitializeRequest{
+		Config:           srv.connectionParams(token),
+		VerifyConnection: true,
+	})
+	defer dbtesting.AssertClose(t, db)
+
+	resp := dbtesting.AssertNewUser(t, db, dbplugin.NewUserRequest{
+		UsernameConfig: dbplugin.UsernameMetadata{
+			DisplayName: "test",
+			RoleName:    "test",
+		},
+		Statements: dbplugin.Statements{
+			Commands: []string{`{"permissions": [{"action": "read", "resource": {"type": "buckets", "name": "telegraf", "org": "metrics"}}]}`},
+		},
+		Password:   "nuozxby98523u89bdfnkjl",
+		Expiration: time.Now().Add(1 * time.Minute),
+	})
+
+	auths := srv.userAuthorizations(resp.Username)
+	require.Len(t, auths, 1)
+	resource := (*auths[0].Permissions)[0].Resource
+	require.Equal(t, domain.ResourceTypeBuckets, resource.Type)
+	require.Equal(t, metricsOrgID, *resource.OrgID)
+	require.Equal(t, bucketID, *resource.Id)
+
+	_, err := db.NewUser(context.Background(), dbplugin.NewUserRequest{
+		UsernameConfig: dbplugin.UsernameMetadata{
+			DisplayName: "test",
+			RoleName:    "test",
+		},
+		Statements: dbplugin.Statements{
+			Commands: []string{`{"permissions": [{"action": "read", "resource": {"type": "buckets", "name": "telegraf", "measurement": "cpu"}}]}`},
+		},
+		Password:   "nuozxby98523u89bdfnkjl",
+		Expiration: time.Now().Add(1 * time.Minute),
+	})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "measurement")
+}
@@ -0,0 +1,78 @@
+package influxdbv2
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/go-secure-stdlib/parseutil"
+	"github.com/influxdata/influxdb-client-go/v2"
+)
+
+// defaultConnectTimeout bounds dialing the server when connect_timeout is
+// unset or zero.
+const defaultConnectTimeout = 5 * time.Second
+
+// Timeout hierarchy
+//
+// request_timeout bounds every request as a whole, from dialing to reading
+// the last byte of the response. The other timeouts bound a part of a request
+// and so only take effect when shorter than it:
+//
+//   - connect_timeout bounds dialing the server
+//   - response_header_timeout bounds the wait for the response headers
+//   - ping_timeout bounds the reachability check, which includes dialing, so
+//     it must also not be shorter than connect_timeout
+//
+// Combinations breaking these rules are logged, or rejected when
+// strict_timeouts is set.
+//
+// Zero, for any of them, means the same as leaving it unset, see
+// parseTimeout: it never disables a timeout, nor makes it expire at once.
+// Negative timeouts are rejected.
+
+// parseTimeout parses the timeout named name, returning def if it is unset or
+// zero.
+func parseTimeout(name string, raw interface{}, def time.Duration) (time.Duration, error) {
+	if raw == nil {
+		return def, nil
+	}
+	timeout, err := parseutil.ParseDurationSecond(raw)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", name, err)
+	}
+	switch {
+	case timeout < 0:
+		return 0, fmt.Errorf("%s cannot be negative", name)
+	case timeout == 0:
+		return def, nil
+	}
+	return timeout, nil
+}
+
+// effectiveRequestTimeout returns the configured request_timeout, or the
+// client's default.
+func (i *influxdbConnectionProducer) effectiveRequestTimeout() time.Duration {
+	if i.requestTimeout > 0 {
+		return i.requestTimeout
+	}
+	return time.Duration(influxdb2.DefaultOptions().HTTPRequestTimeout()) * time.Second
+}
+
+// timeoutConflicts returns a description of every timeout that can never take
+// effect given the others, see the timeout hierarchy above.
+func (i *influxdbConnectionProducer) timeoutConflicts() []string {
+	requestTimeout := i.effectiveRequestTimeout()
+	var conflicts []string
+	exceeds := func(name string, timeout time.Duration) {
+		if timeout > requestTimeout {
+			conflicts = append(conflicts, fmt.Sprintf("%s (%s) is longer than request_timeout (%s)", name, timeout, requestTimeout))
+		}
+	}
+	exceeds("connect_timeout", i.connectTimeout)
+	exceeds("response_header_timeout", i.responseHeaderTimeout)
+	exceeds("ping_timeout", i.pingTimeout)
+	if i.pingTimeout > 0 && i.pingTimeout < i.connectTimeout {
+		conflicts = append(conflicts, fmt.Sprintf("connect_timeout (%s) is longer than ping_timeout (%s)", i.connectTimeout, i.pingTimeout))
+	}
+	return conflicts
+}
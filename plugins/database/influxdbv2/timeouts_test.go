@@ -0,0 +1,151 @@
+package influxdbv2
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	log "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/sdk/database/dbplugin/v5"
+	dbtesting "github.com/hashicorp/vault/sdk/database/dbplugin/v5/testing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInitialize_TimeoutConsistency(t *testing.T) {
+	type testCase struct {
+		config         []interface{}
+		expectConflict string
+	}
+
+	tests := map[string]testCase{
+		"defaults": {},
+		"consistent": {
+			config: []interface{}{"connect_timeout", "2s", "ping_timeout", "5s", "response_header_timeout", "5s", "request_timeout", "10s"},
+		},
+		"equal": {
+			config: []interface{}{"connect_timeout", "5s", "ping_timeout", "5s", "request_timeout", "5s"},
+		},
+		"connect longer than request": {
+			config:         []interface{}{"connect_timeout", "30s", "request_timeout", "10s"},
+			expectConflict: "connect_timeout (30s) is longer than request_timeout (10s)",
+		},
+		"connect longer than default request": {
+			config:         []interface{}{"connect_timeout", "30s"},
+			expectConflict: "connect_timeout (30s) is longer than request_timeout (20s)",
+		},
+		"ping longer than request": {
+			config:         []interface{}{"ping_timeout", "15s", "request_timeout", "10s"},
+			expectConflict: "ping_timeout (15s) is longer than request_timeout (10s)",
+		},
+		"response header longer than request": {
+			config:         []interface{}{"response_header_timeout", "15s", "request_timeout", "10s"},
+			expectConflict: "response_header_timeout (15s) is longer than request_timeout (10s)",
+		},
+		"connect longer than ping": {
+			config:         []interface{}{"connect_timeout", "5s", "ping_timeout", "1s"},
+			expectConflict: "connect_timeout (5s) is longer than ping_timeout (1s)",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			for _, strict := range []bool{false, true} {
+				var buf bytes.Buffer
+				db := new()
+				db.logger = log.New(&log.LoggerOptions{Output: &buf})
+				config := append([]interface{}{"strict_timeouts", strict, "skip_token_format_check", true}, test.config...)
+				_, err := db.Initialize(context.Background(), dbplugin.InitializeRequest{
+					Config: makeConfig(map[string]interface{}{"host": "influx.example.com", "token": "token"}, config...),
+				})
+
+				switch {
+				case test.expectConflict == "":
+					require.NoError(t, err)
+					require.Empty(t, buf.String())
+				case strict:
+					require.Error(t, err)
+					require.Contains(t, err.Error(), test.expectConflict)
+				default:
+					require.NoError(t, err)
+					require.Contains(t, buf.String(), test.expectConflict)
+				}
+			}
+		})
+	}
+}
+
+func TestInitialize_RequestTimeout(t *testing.T) {
+	const token = "root-token"
+	srv := newFakeInfluxServer(t, token)
+
+	db := new()
+	_, err := db.Initialize(context.Background(), dbplugin.InitializeRequest{
+		Config: makeConfig(srv.connectionParams(token), "request_timeout", "3s", "connect_timeout", "1s"),
+	})
+	require.NoError(t, err)
+	defer dbtesting.AssertClose(t, db)
+
+	cli, err := db.getConnection(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 3*time.Second, cli.Options().HTTPClient().Timeout)
+}
+
+func TestInitialize_InvalidTimeouts(t *testing.T) {
+	tests := map[string][]interface{}{
+		"invalid request timeout":          {"request_timeout", "soon"},
+		"negative request timeout":         {"request_timeout", "-1s"},
+		"invalid ping timeout":             {"ping_timeout", "soon"},
+		"negative ping timeout":            {"ping_timeout", "-1s"},
+		"invalid connect timeout":          {"connect_timeout", "soon"},
+		"negative connect timeout":         {"connect_timeout", "-1s"},
+		"negative idle connection timeout": {"idle_connection_timeout", -5},
+		"negative response header timeout": {"response_header_timeout", "-1ms"},
+	}
+
+	for name, kv := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := new().Initialize(context.Background(), dbplugin.InitializeRequest{
+				Config: makeConfig(map[string]interface{}{"host": "influx.example.com", "token": "token"}, kv...),
+			})
+			require.Error(t, err)
+			require.Contains(t, err.Error(), kv[0].(string))
+		})
+	}
+}
+
+func TestInitialize_ZeroTimeouts(t *testing.T) {
+	srv := newFakeInfluxServer(t, "root-token")
+	zero := []interface{}{
+		"connect_timeout", "0s",
+		"idle_connection_timeout", 0,
+		"response_header_timeout", "0",
+		"request_timeout", "0s",
+		"ping_timeout", "0s",
+	}
+
+	db := new()
+	dbtesting.AssertInitialize(t, db, dbplugin.InitializeRequest{
+		Config:           makeConfig(srv.connectionParams("root-token"), zero...),
+		VerifyConnection: true,
+	})
+	defer dbtesting.AssertClose(t, db)
+
+	// Zero is the same as unset, for every timeout.
+	unset := new()
+	dbtesting.AssertInitialize(t, unset, dbplugin.InitializeRequest{
+		Config: srv.connectionParams("root-token"),
+	})
+	defer dbtesting.AssertClose(t, unset)
+	require.Equal(t, defaultConnectTimeout, db.connectTimeout)
+	require.Equal(t, unset.connectTimeout, db.connectTimeout)
+	require.Equal(t, unset.idleConnectionTimeout, db.idleConnectionTimeout)
+	require.Equal(t, unset.responseHeaderTimeout, db.responseHeaderTimeout)
+	require.Equal(t, unset.effectiveRequestTimeout(), db.effectiveRequestTimeout())
+	require.Equal(t, unset.pingTimeout, db.pingTimeout)
+
+	transport, err := db.newTransport()
+	require.NoError(t, err)
+	require.Equal(t, defaultIdleConnectionTimeout, transport.IdleConnTimeout)
+	require.Zero(t, transport.ResponseHeaderTimeout)
+}
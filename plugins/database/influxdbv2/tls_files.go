@@ -0,0 +1,130 @@
+package influxdbv2
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// certReloadInterval is how long certificates read from files are reused
+// before the files are read again.
+const certReloadInterval = 10 * time.Second
+
+// fileCertificates serves the client certificate and CA pool configured by
+// tls_cert_file, tls_key_file and tls_ca_file, re-reading the files at most
+// once per reloadInterval so that certificates rotated on disk are picked up
+// without a plugin reload.
+//
+// The client certificate is requested on every handshake through
+// tls.Config.GetClientCertificate. A CA pool can't be swapped on a live
+// tls.Config without giving up the standard verification, so instead the
+// connection is rebuilt once the CA file changes, see caChanged.
+type fileCertificates struct {
+	certFile string
+	keyFile  string
+	caFile   string
+
+	reloadInterval time.Duration
+
+	mu         sync.Mutex
+	cert       *tls.Certificate
+	certLoaded time.Time
+	caPEM      []byte
+	caLoaded   time.Time
+}
+
+func newFileCertificates(certFile, keyFile, caFile string) (*fileCertificates, error) {
+	if (certFile == "") != (keyFile == "") {
+		return nil, fmt.Errorf("tls_cert_file and tls_key_file must be set together")
+	}
+	f := &fileCertificates{
+		certFile:       certFile,
+		keyFile:        keyFile,
+		caFile:         caFile,
+		reloadInterval: certReloadInterval,
+	}
+	// Load everything once so that a bad path fails Initialize.
+	if certFile != "" {
+		if _, err := f.clientCertificate(nil); err != nil {
+			return nil, err
+		}
+	}
+	if caFile != "" {
+		if _, err := f.rootCAs(); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// clientCertificate implements tls.Config.GetClientCertificate.
+func (f *fileCertificates) clientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.cert != nil && time.Since(f.certLoaded) < f.reloadInterval {
+		return f.cert, nil
+	}
+	cert, err := tls.LoadX509KeyPair(f.certFile, f.keyFile)
+	if err != nil {
+		if f.cert != nil {
+			// Keep using the last good certificate, e.g. while the files
+			// are being replaced.
+			return f.cert, nil
+		}
+		return nil, fmt.Errorf("failed to load tls_cert_file and tls_key_file: %w", err)
+	}
+	f.cert = &cert
+	f.certLoaded = time.Now()
+	return f.cert, nil
+}
+
+// rootCAs returns the CA pool read from the CA file.
+func (f *fileCertificates) rootCAs() (*x509.CertPool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.loadCA(); err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(f.caPEM) {
+		return nil, fmt.Errorf("no certificates found in tls_ca_file")
+	}
+	return pool, nil
+}
+
+// caChanged reports whether the CA file no longer holds the CA pool last
+// returned by rootCAs.
+func (f *fileCertificates) caChanged() bool {
+	if f.caFile == "" {
+		return false
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if time.Since(f.caLoaded) < f.reloadInterval {
+		return false
+	}
+	caPEM, err := os.ReadFile(f.caFile)
+	if err != nil {
+		return false
+	}
+	f.caLoaded = time.Now()
+	return !bytes.Equal(caPEM, f.caPEM)
+}
+
+// loadCA reads the CA file. It must be called with the lock held.
+func (f *fileCertificates) loadCA() error {
+	caPEM, err := os.ReadFile(f.caFile)
+	if err != nil {
+		return fmt.Errorf("failed to read tls_ca_file: %w", err)
+	}
+	f.caPEM = caPEM
+	f.caLoaded = time.Now()
+	return nil
+}
@@ -0,0 +1,182 @@
+package influxdbv2
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/database/dbplugin/v5"
+	dbtesting "github.com/hashicorp/vault/sdk/database/dbplugin/v5/testing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileCertificates_ClientCertificateReload(t *testing.T) {
+	var mu sync.Mutex
+	var presented []string
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		presented = append(presented, r.TLS.PeerCertificates[0].Subject.CommonName)
+		mu.Unlock()
+	}))
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	srv.StartTLS()
+	defer srv.Close()
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "client.crt")
+	keyFile := filepath.Join(dir, "client.key")
+	writeKeyPair(t, certFile, keyFile, "first")
+
+	db := new()
+	dbtesting.AssertInitialize(t, db, dbplugin.InitializeRequest{
+		Config: map[string]interface{}{
+			"host":          "influx.example.com",
+			"token":         "token",
+			"insecure_tls":  true,
+			"tls_cert_file": certFile,
+			"tls_key_file":  keyFile,
+		},
+	})
+	db.certFiles.reloadInterval = 0
+	transport, err := db.newTransport()
+	require.NoError(t, err)
+	client := &http.Client{Transport: transport}
+
+	get := func() {
+		t.Helper()
+		resp, err := client.Get(srv.URL)
+		require.NoError(t, err)
+		resp.Body.Close()
+		// Force a new handshake for the next request.
+		transport.CloseIdleConnections()
+	}
+
+	get()
+	writeKeyPair(t, certFile, keyFile, "second")
+	get()
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Equal(t, []string{"first", "second"}, presented)
+}
+
+func TestFileCertificates_CAReload(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	serverCA := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}))
+
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.crt")
+	require.NoError(t, os.WriteFile(caFile, []byte(testCACertificate(t)), 0o600))
+
+	db := new()
+	dbtesting.AssertInitialize(t, db, dbplugin.InitializeRequest{
+		Config: map[string]interface{}{
+			"host":        "influx.example.com",
+			"token":       "token",
+			"tls_ca_file": caFile,
+		},
+	})
+	db.certFiles.reloadInterval = 0
+
+	// The server isn't signed by the configured CA.
+	transport, err := db.newTransport()
+	require.NoError(t, err)
+	_, err = (&http.Client{Transport: transport}).Get(srv.URL)
+	require.Error(t, err)
+
+	require.NoError(t, os.WriteFile(caFile, []byte(serverCA), 0o600))
+	require.True(t, db.certFiles.caChanged())
+	transport, err = db.newTransport()
+	require.NoError(t, err)
+	resp, err := (&http.Client{Transport: transport}).Get(srv.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.False(t, db.certFiles.caChanged())
+}
+
+func TestConnection_RebuiltWhenCAFileChanges(t *testing.T) {
+	const token = "root-token"
+	srv := newFakeInfluxServer(t, token)
+
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.crt")
+	require.NoError(t, os.WriteFile(caFile, []byte(testCACertificate(t)), 0o600))
+
+	// The fake server doesn't speak TLS; only the rebuild is under test.
+	db := new()
+	dbtesting.AssertInitialize(t, db, dbplugin.InitializeRequest{
+		Config: makeConfig(srv.connectionParams(token), "tls_ca_file", caFile, "scheme", "http"),
+	})
+	defer dbtesting.AssertClose(t, db)
+	db.certFiles.reloadInterval = 0
+
+	first, err := db.getConnection(context.Background())
+	require.NoError(t, err)
+	same, err := db.getConnection(context.Background())
+	require.NoError(t, err)
+	require.Same(t, first, same)
+
+	require.NoError(t, os.WriteFile(caFile, []byte(testCACertificate(t)), 0o600))
+	rebuilt, err := db.getConnection(context.Background())
+	require.NoError(t, err)
+	require.NotSame(t, first, rebuilt)
+}
+
+func TestInitialize_InvalidCertificateFiles(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "client.crt")
+	keyFile := filepath.Join(dir, "client.key")
+	writeKeyPair(t, certFile, keyFile, "client")
+
+	tests := map[string][]interface{}{
+		"cert without key":  {"tls_cert_file", certFile},
+		"missing cert file": {"tls_cert_file", filepath.Join(dir, "missing"), "tls_key_file", keyFile},
+		"missing CA file":   {"tls_ca_file", filepath.Join(dir, "missing")},
+		"with pem_bundle":   {"tls_ca_file", certFile, "pem_bundle", testCACertificate(t)},
+	}
+
+	for name, kv := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := new().Initialize(context.Background(), dbplugin.InitializeRequest{
+				Config: makeConfig(map[string]interface{}{"host": "influx.example.com", "token": "token"}, kv...),
+			})
+			require.Error(t, err)
+		})
+	}
+}
+
+// writeKeyPair writes a new self-signed certificate with the given common
+// name and its key to the given files.
+func writeKeyPair(t *testing.T, certFile, keyFile, commonName string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-1 * time.Hour),
+		NotAfter:     time.Now().Add(1 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+}
@@ -0,0 +1,135 @@
+package influxdbv2
+
+import (
+	"crypto/tls"
+	"net"
+	"sort"
+)
+
+// TLSPosture is the TLS configuration in effect for a connection, as resolved
+// by Initialize, for security reviews. It is built from the configuration
+// alone, without connecting, and holds no certificate or key material: only
+// whether each is present and which parameter provided it. The sanitized
+// configuration reports the same posture under "tls".
+type TLSPosture struct {
+	// Enabled is set when requests are sent over HTTPS, which Scheme
+	// decides: TLS settings are ignored over HTTP.
+	Enabled bool   `json:"enabled"`
+	Scheme  string `json:"scheme"`
+
+	// MinVersion and MaxVersion bound the negotiated protocol version, the
+	// client's defaults when tls_min_version isn't set.
+	MinVersion string `json:"min_version"`
+	MaxVersion string `json:"max_version"`
+
+	InsecureSkipVerify bool `json:"insecure_skip_verify"`
+	Strict             bool `json:"strict"`
+	Required           bool `json:"required"`
+
+	// ClientCertificate is set when a client certificate is presented,
+	// provided by ClientCertificateSource: pem_bundle, pem_json,
+	// pki_response or tls_cert_file.
+	ClientCertificate       bool   `json:"client_certificate"`
+	ClientCertificateSource string `json:"client_certificate_source,omitempty"`
+
+	// CustomCA is set when the server certificate is verified against CAs
+	// provided by CustomCASource rather than the system's.
+	CustomCA       bool   `json:"custom_ca"`
+	CustomCASource string `json:"custom_ca_source,omitempty"`
+
+	// ServerNames are the names server certificates are verified against.
+	// There is no override: they are the hosts of the endpoints and of
+	// verify_endpoint.
+	ServerNames []string `json:"server_names"`
+
+	RequireServerEKU []string `json:"require_server_eku,omitempty"`
+	MaxChainDepth    int      `json:"max_chain_depth,omitempty"`
+}
+
+// tlsVersionNames are the names tls_min_version takes, by version.
+var tlsVersionNames = map[uint16]string{
+	tls.VersionTLS10: "tls10",
+	tls.VersionTLS11: "tls11",
+	tls.VersionTLS12: "tls12",
+	tls.VersionTLS13: "tls13",
+}
+
+// TLSPosture returns the TLS configuration in effect, without connecting.
+func (i *InfluxdbV2) TLSPosture() (TLSPosture, error) {
+	i.Lock()
+	defer i.Unlock()
+
+	if !i.Initialized {
+		return TLSPosture{}, ErrNotInitialized
+	}
+	return i.tlsPosture(), nil
+}
+
+// tlsPosture describes the resolved TLS configuration. It must be called with
+// the lock held.
+func (i *influxdbConnectionProducer) tlsPosture() TLSPosture {
+	// Go clients default to TLS 1.2 through 1.3.
+	minVersion := tlsVersionNames[tls.VersionTLS12]
+	if i.TLSMinVersion != "" {
+		minVersion = i.TLSMinVersion
+	}
+	posture := TLSPosture{
+		Enabled:            i.scheme() == schemeHTTPS,
+		Scheme:             i.scheme(),
+		MinVersion:         minVersion,
+		MaxVersion:         tlsVersionNames[tls.VersionTLS13],
+		InsecureSkipVerify: i.InsecureTLS,
+		Strict:             i.StrictTLS,
+		Required:           i.RequireTLS,
+		MaxChainDepth:      i.MaxChainDepth,
+	}
+
+	source := i.certificateSource()
+	switch {
+	case len(i.certificate) > 0 && len(i.privateKey) > 0:
+		posture.ClientCertificate, posture.ClientCertificateSource = true, source
+	case i.TLSCertFile != "":
+		posture.ClientCertificate, posture.ClientCertificateSource = true, "tls_cert_file"
+	}
+	switch {
+	case len(i.issuingCA) > 0 || len(i.caChain) > 0 || (len(i.certificate) > 0 && len(i.privateKey) == 0):
+		posture.CustomCA, posture.CustomCASource = true, source
+	case i.TLSCAFile != "":
+		posture.CustomCA, posture.CustomCASource = true, "tls_ca_file"
+	}
+	// insecure_tls skips verification, so no CA is used.
+	if i.InsecureTLS {
+		posture.CustomCA, posture.CustomCASource = false, ""
+	}
+
+	for _, usage := range i.certConstraints.extKeyUsages {
+		posture.RequireServerEKU = append(posture.RequireServerEKU, extKeyUsageName(usage))
+	}
+
+	names := make(map[string]struct{})
+	for _, endpoint := range append(append([]string(nil), i.endpoints...), i.verifyEndpoint) {
+		if host, _, err := net.SplitHostPort(endpoint); err == nil {
+			names[host] = struct{}{}
+		}
+	}
+	posture.ServerNames = make([]string, 0, len(names))
+	for name := range names {
+		posture.ServerNames = append(posture.ServerNames, name)
+	}
+	sort.Strings(posture.ServerNames)
+	return posture
+}
+
+// certificateSource returns the parameter the certificate material in use
+// was read from, or "" if there is none.
+func (i *influxdbConnectionProducer) certificateSource() string {
+	switch {
+	case len(i.PKIResponse) != 0:
+		return "pki_response"
+	case len(i.PemJSON) != 0:
+		return "pem_json"
+	case len(i.PemBundle) != 0:
+		return "pem_bundle"
+	}
+	return ""
+}
@@ -0,0 +1,116 @@
+package influxdbv2
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/database/dbplugin/v5"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInfluxdb_TLSPosture(t *testing.T) {
+	_, err := new().TLSPosture()
+	require.ErrorIs(t, err, ErrNotInitialized)
+
+	ca := newTestCA(t, "influxdb test CA", nil)
+	cert, key := ca.issue(t, "vault", x509.ExtKeyUsageClientAuth)
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeKeyPair(t, certFile, keyFile, "vault")
+
+	base := map[string]interface{}{
+		"host":                    "influx.example.com",
+		"token":                   "token",
+		"skip_token_format_check": true,
+	}
+	tests := map[string]struct {
+		config   map[string]interface{}
+		expected TLSPosture
+	}{
+		"http": {
+			config: base,
+			expected: TLSPosture{
+				Scheme:      schemeHTTP,
+				MinVersion:  "tls12",
+				MaxVersion:  "tls13",
+				ServerNames: []string{"influx.example.com"},
+			},
+		},
+		"pem_bundle": {
+			config: makeConfig(base, "pem_bundle", cert+key, "tls_min_version", "tls13", "require_tls", true,
+				"require_server_eku", "serverAuth", "verify_endpoint", "replica.example.com"),
+			expected: TLSPosture{
+				Enabled:                 true,
+				Scheme:                  schemeHTTPS,
+				MinVersion:              "tls13",
+				MaxVersion:              "tls13",
+				Required:                true,
+				ClientCertificate:       true,
+				ClientCertificateSource: "pem_bundle",
+				ServerNames:             []string{"influx.example.com", "replica.example.com"},
+				RequireServerEKU:        []string{"serverAuth"},
+			},
+		},
+		"pem_bundle CA": {
+			config: makeConfig(base, "pem_bundle", ca.pem),
+			expected: TLSPosture{
+				Enabled:        true,
+				Scheme:         schemeHTTPS,
+				MinVersion:     "tls12",
+				MaxVersion:     "tls13",
+				CustomCA:       true,
+				CustomCASource: "pem_bundle",
+				ServerNames:    []string{"influx.example.com"},
+			},
+		},
+		"files": {
+			config: makeConfig(base, "tls_cert_file", certFile, "tls_key_file", keyFile, "tls_ca_file", certFile),
+			expected: TLSPosture{
+				Enabled:                 true,
+				Scheme:                  schemeHTTPS,
+				MinVersion:              "tls12",
+				MaxVersion:              "tls13",
+				ClientCertificate:       true,
+				ClientCertificateSource: "tls_cert_file",
+				CustomCA:                true,
+				CustomCASource:          "tls_ca_file",
+				ServerNames:             []string{"influx.example.com"},
+			},
+		},
+		"insecure_tls": {
+			config: makeConfig(base, "pem_bundle", ca.pem, "insecure_tls", true),
+			expected: TLSPosture{
+				Enabled:            true,
+				Scheme:             schemeHTTPS,
+				MinVersion:         "tls12",
+				MaxVersion:         "tls13",
+				InsecureSkipVerify: true,
+				ServerNames:        []string{"influx.example.com"},
+			},
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			db := new()
+			_, err := db.Initialize(context.Background(), dbplugin.InitializeRequest{Config: test.config})
+			require.NoError(t, err)
+			defer db.Close()
+
+			posture, err := db.TLSPosture()
+			require.NoError(t, err)
+			require.Equal(t, test.expected, posture)
+
+			b, err := json.Marshal(posture)
+			require.NoError(t, err)
+			for _, material := range []string{key, cert, ca.pem} {
+				body := strings.Split(strings.TrimSpace(material), "\n")[1]
+				require.NotContains(t, string(b), body)
+			}
+			require.NotContains(t, string(b), "PRIVATE KEY")
+		})
+	}
+}
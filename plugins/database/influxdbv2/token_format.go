@@ -0,0 +1,26 @@
+package influxdbv2
+
+import (
+	"regexp"
+)
+
+// minTokenLength is well below the 88 characters of tokens generated by
+// InfluxDB, but still rules out most passwords.
+const minTokenLength = 16
+
+// tokenRegex matches the standard and URL-safe base64 alphabets that
+// InfluxDB tokens are encoded with.
+var tokenRegex = regexp.MustCompile(`^[A-Za-z0-9+/_-]+={0,2}$`)
+
+// tokenFormatProblem returns why token can't be a token generated by
+// InfluxDB v2, or "" if it could be. Tokens chosen at setup time may look
+// different, so callers only warn about it.
+func tokenFormatProblem(token string) string {
+	switch {
+	case len(token) < minTokenLength:
+		return "it is too short"
+	case !tokenRegex.MatchString(token):
+		return "it contains characters that are not valid in a base64 token, such as whitespace or ':'"
+	}
+	return ""
+}
@@ -0,0 +1,67 @@
+package influxdbv2
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	log "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/sdk/database/dbplugin/v5"
+	dbtesting "github.com/hashicorp/vault/sdk/database/dbplugin/v5/testing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenFormatProblem(t *testing.T) {
+	tests := map[string]struct {
+		token      string
+		expectWarn bool
+	}{
+		"generated token": {
+			token: "Zb7ZtMz3jLsG6hJ8CNpS4eFQdxTqhJ3a0v2o9O1mZgkPjXUq2-5wLIY8V_k3lF9Wq7TuRz2c6YHp1nB0xAaLQg==",
+		},
+		"standard base64": {
+			token: "W2t5HxD0/aP+Kq3rT8mZ1nV6cJ4bL7sE",
+		},
+		"too short": {
+			token:      "hunter2",
+			expectWarn: true,
+		},
+		"v1 credentials": {
+			token:      "admin:correct-horse-battery",
+			expectWarn: true,
+		},
+		"trailing newline": {
+			token:      "W2t5HxD0aPKq3rT8mZ1nV6cJ4bL7sE==\n",
+			expectWarn: true,
+		},
+		"inner whitespace": {
+			token:      "correct horse battery staple",
+			expectWarn: true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, test.expectWarn, tokenFormatProblem(test.token) != "")
+		})
+	}
+}
+
+func TestInitialize_TokenFormatWarning(t *testing.T) {
+	const token = "admin:password"
+	srv := newFakeInfluxServer(t, token)
+
+	for _, skip := range []bool{false, true} {
+		var buf bytes.Buffer
+		db := new()
+		db.logger = log.New(&log.LoggerOptions{Output: &buf})
+		dbtesting.AssertInitialize(t, db, dbplugin.InitializeRequest{
+			Config:           makeConfig(srv.connectionParams(token), "skip_token_format_check", skip),
+			VerifyConnection: true,
+		})
+		dbtesting.AssertClose(t, db)
+
+		require.Equal(t, !skip, strings.Contains(buf.String(), "doesn't look like a valid InfluxDB v2 token"))
+		require.NotContains(t, buf.String(), token)
+	}
+}
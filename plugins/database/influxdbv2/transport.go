@@ -0,0 +1,235 @@
+package influxdbv2
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-secure-stdlib/tlsutil"
+	"github.com/hashicorp/vault/sdk/helper/certutil"
+	"golang.org/x/net/proxy"
+)
+
+const (
+	// Transport defaults, matching those the influx client uses when it
+	// builds its own HTTP client.
+	defaultMaxIdleConnections    = 100
+	defaultIdleConnectionTimeout = 90 * time.Second
+	defaultTLSHandshakeTimeout   = 5 * time.Second
+)
+
+// wrappedTransport is embedded by the transports layered over the one
+// newTransport builds, each adding its own RoundTrip. It makes
+// http.Client.CloseIdleConnections reach the transport underneath.
+type wrappedTransport struct {
+	base http.RoundTripper
+}
+
+func (t wrappedTransport) CloseIdleConnections() {
+	if closer, ok := t.base.(interface{ CloseIdleConnections() }); ok {
+		closer.CloseIdleConnections()
+	}
+}
+
+// newTransport builds the single http.Transport used by the influx client.
+// Options are applied in a fixed order so that each one composes with those
+// before it:
+//
+//  1. TLS configuration
+//  2. HTTP proxy, which tunnels through the TLS configuration above
+//  3. DNS resolver
+//  4. dialer timeout, using the resolver above, and SOCKS5 proxy, which
+//     reaches the proxy through that dialer
+//  5. HTTP/2 toggle, which depends on the final TLS configuration
+//  6. idle connection settings
+//  7. response header timeout
+func (i *influxdbConnectionProducer) newTransport() (*http.Transport, error) {
+	transport := &http.Transport{
+		TLSHandshakeTimeout: defaultTLSHandshakeTimeout,
+	}
+
+	if i.TLS {
+		tlsConfig, err := i.tlsConfig()
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	if i.proxyURL != nil {
+		transport.Proxy = http.ProxyURL(i.proxyURL)
+	}
+
+	dialer := &net.Dialer{
+		Timeout: i.connectTimeout,
+	}
+	if i.DNSResolver != "" {
+		resolverAddr := i.DNSResolver
+		dialer.Resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				d := net.Dialer{Timeout: i.connectTimeout}
+				return d.DialContext(ctx, network, resolverAddr)
+			},
+		}
+	}
+	transport.DialContext = dialer.DialContext
+	if i.socks5URL != nil {
+		// The proxy resolves the server's name itself; the resolver above
+		// only applies to the proxy's.
+		socksDialer, err := proxy.FromURL(i.socks5URL, dialer)
+		if err != nil {
+			return nil, fmt.Errorf("invalid socks5_proxy: %w", err)
+		}
+		contextDialer, ok := socksDialer.(proxy.ContextDialer)
+		if !ok {
+			return nil, fmt.Errorf("invalid socks5_proxy: dialer does not support contexts")
+		}
+		transport.DialContext = contextDialer.DialContext
+	}
+
+	if i.DisableHTTP2 {
+		// A non-nil, empty map disables the automatic HTTP/2 upgrade.
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	} else {
+		// Setting a custom TLS config or dialer disables HTTP/2 unless it is
+		// explicitly requested.
+		transport.ForceAttemptHTTP2 = true
+	}
+
+	transport.MaxIdleConns = defaultMaxIdleConnections
+	transport.MaxIdleConnsPerHost = defaultMaxIdleConnections
+	if i.MaxIdleConnections > 0 {
+		transport.MaxIdleConns = i.MaxIdleConnections
+		transport.MaxIdleConnsPerHost = i.MaxIdleConnections
+	}
+	transport.IdleConnTimeout = defaultIdleConnectionTimeout
+	if i.idleConnectionTimeout > 0 {
+		transport.IdleConnTimeout = i.idleConnectionTimeout
+	}
+
+	// Unlike the client's request timeout, this only bounds the wait for the
+	// response headers, so a hung proxy is detected without limiting how
+	// long a large response may take to read.
+	transport.ResponseHeaderTimeout = i.responseHeaderTimeout
+
+	return transport, nil
+}
+
+// rejectRedirect implements http.Client.CheckRedirect unless follow_redirects
+// is set. Following a redirect sends the request, which carries the token or
+// a credential's password, to a server that wasn't configured.
+func rejectRedirect(req *http.Request, via []*http.Request) error {
+	return fmt.Errorf("refusing to follow redirect to %s, set follow_redirects to follow it", req.URL.Redacted())
+}
+
+// parseSOCKS5Proxy parses socks5_proxy, given as "host:port" or as a
+// "socks5://[user:password@]host:port" URL.
+func parseSOCKS5Proxy(raw string) (*url.URL, error) {
+	if !strings.Contains(raw, "://") {
+		raw = "socks5://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "socks5" && u.Scheme != "socks5h" {
+		return nil, fmt.Errorf("unsupported scheme %q, expected socks5", u.Scheme)
+	}
+	if n, err := strconv.Atoi(u.Port()); u.Hostname() == "" || err != nil || n <= 0 || n > 65535 {
+		return nil, fmt.Errorf("expected host:port")
+	}
+	if (u.Path != "" && u.Path != "/") || u.RawQuery != "" {
+		return nil, fmt.Errorf("expected no path or query")
+	}
+	return u, nil
+}
+
+// socks5Password returns the password in socks5_proxy, if any, so that it can
+// be redacted.
+func socks5Password(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.User == nil {
+		return ""
+	}
+	password, _ := u.User.Password()
+	return password
+}
+
+// tlsConfig builds the client TLS configuration from the certificate
+// material parsed during Initialize.
+func (i *influxdbConnectionProducer) tlsConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{}
+	if len(i.certificate) > 0 || len(i.issuingCA) > 0 {
+		if len(i.certificate) > 0 && len(i.privateKey) == 0 {
+			return nil, fmt.Errorf("found certificate for TLS authentication but no private key")
+		}
+
+		certBundle := &certutil.CertBundle{}
+		if len(i.certificate) > 0 {
+			certBundle.Certificate = i.certificate
+			certBundle.PrivateKey = i.privateKey
+		}
+		if len(i.issuingCA) > 0 {
+			certBundle.IssuingCA = i.issuingCA
+		}
+
+		parsedCertBundle, err := certBundle.ToParsedCertBundle()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse certificate bundle: %w", err)
+		}
+
+		tlsConfig, err = parsedCertBundle.GetTLSConfig(certutil.TLSClient)
+		if err != nil || tlsConfig == nil {
+			return nil, fmt.Errorf("failed to get TLS configuration: tlsConfig:%#v err:%w", tlsConfig, err)
+		}
+	}
+
+	// The bundle only trusts the first CA of a chain; trust all of them.
+	if len(i.caChain) > 0 {
+		pool := x509.NewCertPool()
+		for _, cert := range i.caChain {
+			if !pool.AppendCertsFromPEM([]byte(cert)) {
+				return nil, fmt.Errorf("could not append CA certificate")
+			}
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if i.certFiles != nil {
+		if i.certFiles.certFile != "" {
+			tlsConfig.GetClientCertificate = i.certFiles.clientCertificate
+		}
+		if i.certFiles.caFile != "" {
+			pool, err := i.certFiles.rootCAs()
+			if err != nil {
+				return nil, err
+			}
+			tlsConfig.RootCAs = pool
+		}
+	}
+
+	tlsConfig.InsecureSkipVerify = i.InsecureTLS
+	i.certConstraints.apply(tlsConfig)
+
+	if i.TLSMinVersion != "" {
+		var ok bool
+		tlsConfig.MinVersion, ok = tlsutil.TLSLookup[i.TLSMinVersion]
+		if !ok {
+			return nil, fmt.Errorf("invalid 'tls_min_version' in config")
+		}
+	} else {
+		// MinVersion was not being set earlier. Reset it to
+		// zero to gracefully handle upgrades.
+		tlsConfig.MinVersion = 0
+	}
+
+	return tlsConfig, nil
+}
@@ -0,0 +1,380 @@
+package influxdbv2
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	log "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/sdk/database/dbplugin/v5"
+	dbtesting "github.com/hashicorp/vault/sdk/database/dbplugin/v5/testing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewTransport_OptionsCoexist(t *testing.T) {
+	db := new()
+	_, err := db.Initialize(context.Background(), dbplugin.InitializeRequest{
+		Config: map[string]interface{}{
+			"host":                    "influx.example.com",
+			"token":                   "token",
+			"tls":                     true,
+			"tls_min_version":         "tls12",
+			"insecure_tls":            true,
+			"connect_timeout":         "7s",
+			"http_proxy":              "http://proxy.example.com:3128",
+			"dns_resolver":            "10.0.0.53:53",
+			"max_idle_connections":    3,
+			"idle_connection_timeout": "30s",
+			"response_header_timeout": "10s",
+		},
+	})
+	require.NoError(t, err)
+
+	transport, err := db.newTransport()
+	require.NoError(t, err)
+
+	require.NotNil(t, transport.TLSClientConfig)
+	require.True(t, transport.TLSClientConfig.InsecureSkipVerify)
+	require.NotZero(t, transport.TLSClientConfig.MinVersion)
+
+	req, err := http.NewRequest(http.MethodGet, "https://influx.example.com:8086/ping", nil)
+	require.NoError(t, err)
+	proxy, err := transport.Proxy(req)
+	require.NoError(t, err)
+	require.Equal(t, "proxy.example.com:3128", proxy.Host)
+
+	require.NotNil(t, transport.DialContext)
+	require.True(t, transport.ForceAttemptHTTP2)
+	require.Nil(t, transport.TLSNextProto)
+	require.Equal(t, 3, transport.MaxIdleConns)
+	require.Equal(t, 3, transport.MaxIdleConnsPerHost)
+	require.Equal(t, 30*time.Second, transport.IdleConnTimeout)
+	require.Equal(t, 10*time.Second, transport.ResponseHeaderTimeout)
+}
+
+func TestNewTransport_Defaults(t *testing.T) {
+	db := new()
+	_, err := db.Initialize(context.Background(), dbplugin.InitializeRequest{
+		Config: map[string]interface{}{
+			"host":          "influx.example.com",
+			"token":         "token",
+			"disable_http2": true,
+		},
+	})
+	require.NoError(t, err)
+
+	transport, err := db.newTransport()
+	require.NoError(t, err)
+
+	require.Nil(t, transport.TLSClientConfig)
+	require.Nil(t, transport.Proxy)
+	require.False(t, transport.ForceAttemptHTTP2)
+	require.NotNil(t, transport.TLSNextProto)
+	require.Empty(t, transport.TLSNextProto)
+	require.Equal(t, defaultMaxIdleConnections, transport.MaxIdleConns)
+	require.Equal(t, defaultIdleConnectionTimeout, transport.IdleConnTimeout)
+	require.Zero(t, transport.ResponseHeaderTimeout)
+}
+
+func TestInitialize_InvalidTransportOptions(t *testing.T) {
+	tests := map[string][]interface{}{
+		"proxy without host":      {"http_proxy", "proxy.example.com"},
+		"resolver without port":   {"dns_resolver", "10.0.0.53"},
+		"negative idle conns":     {"max_idle_connections", -1},
+		"invalid idle timeout":    {"idle_connection_timeout", "soon"},
+		"invalid header timeout":  {"response_header_timeout", "soon"},
+		"negative header timeout": {"response_header_timeout", "-1s"},
+		"unparseable proxy":       {"http_proxy", "http://[::1"},
+		"socks5 without port":     {"socks5_proxy", "proxy.example.com"},
+		"socks5 wrong scheme":     {"socks5_proxy", "http://proxy.example.com:1080"},
+		"both proxies":            {"socks5_proxy", "proxy.example.com:1080", "http_proxy", "http://proxy.example.com:3128"},
+	}
+
+	for name, kv := range tests {
+		t.Run(name, func(t *testing.T) {
+			db := new()
+			_, err := db.Initialize(context.Background(), dbplugin.InitializeRequest{
+				Config: makeConfig(map[string]interface{}{"host": "influx.example.com", "token": "token"}, kv...),
+			})
+			require.Error(t, err)
+			require.Contains(t, err.Error(), kv[0].(string))
+		})
+	}
+}
+
+// socks5Server is a minimal SOCKS5 proxy requiring username and password
+// authentication, counting the connections it proxies.
+type socks5Server struct {
+	net.Listener
+	username, password string
+
+	mu          sync.Mutex
+	connections int
+}
+
+func newSOCKS5Server(t *testing.T, username, password string) *socks5Server {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := &socks5Server{Listener: l, username: username, password: password}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go srv.serve(conn)
+		}
+	}()
+	return srv
+}
+
+func (s *socks5Server) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	readBytes := func(n int) []byte {
+		buf := make([]byte, n)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil
+		}
+		return buf
+	}
+
+	// Greeting, then username/password authentication (RFC 1929).
+	greeting := readBytes(2)
+	if greeting == nil || readBytes(int(greeting[1])) == nil {
+		return
+	}
+	conn.Write([]byte{5, 2})
+	header := readBytes(2)
+	if header == nil {
+		return
+	}
+	username := readBytes(int(header[1]))
+	passwordLen := readBytes(1)
+	if username == nil || passwordLen == nil {
+		return
+	}
+	password := readBytes(int(passwordLen[0]))
+	if string(username) != s.username || string(password) != s.password {
+		conn.Write([]byte{1, 1})
+		return
+	}
+	conn.Write([]byte{1, 0})
+
+	// CONNECT request.
+	request := readBytes(4)
+	if request == nil {
+		return
+	}
+	var host string
+	switch request[3] {
+	case 1:
+		host = net.IP(readBytes(4)).String()
+	case 3:
+		host = string(readBytes(int(readBytes(1)[0])))
+	default:
+		return
+	}
+	port := readBytes(2)
+	target, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(int(port[0])<<8|int(port[1]))))
+	if err != nil {
+		conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer target.Close()
+	s.mu.Lock()
+	s.connections++
+	s.mu.Unlock()
+	conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+
+	go io.Copy(target, r)
+	io.Copy(conn, target)
+}
+
+func (s *socks5Server) connectionCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.connections
+}
+
+func TestNewTransport_SOCKS5Proxy(t *testing.T) {
+	const token = "root-token"
+	srv := newFakeInfluxServer(t, token)
+	socks := newSOCKS5Server(t, "vault", "s3cret-pass")
+
+	config := makeConfig(srv.connectionParams(token), "socks5_proxy", "socks5://vault:s3cret-pass@"+socks.Addr().String())
+	db := new()
+	dbtesting.AssertInitialize(t, db, dbplugin.InitializeRequest{
+		Config:           config,
+		VerifyConnection: true,
+	})
+	defer dbtesting.AssertClose(t, db)
+	require.NotZero(t, socks.connectionCount())
+
+	sanitized, err := db.SanitizedConfig()
+	require.NoError(t, err)
+	require.Contains(t, string(sanitized), socks.Addr().String())
+	require.NotContains(t, string(sanitized), "s3cret-pass")
+	require.NotContains(t, fmt.Sprint(db.rawConfig), "s3cret-pass")
+
+	// Wrong credentials are rejected by the proxy.
+	_, err = new().Initialize(context.Background(), dbplugin.InitializeRequest{
+		Config:           makeConfig(config, "socks5_proxy", "socks5://vault:wrong@"+socks.Addr().String()),
+		VerifyConnection: true,
+	})
+	require.Error(t, err)
+}
+
+func TestInitialize_InsecureTLSIgnoresCA(t *testing.T) {
+	caPEM := testCACertificate(t)
+	config := map[string]interface{}{
+		"host":                    "influx.example.com",
+		"token":                   "token",
+		"pem_bundle":              caPEM,
+		"skip_token_format_check": true,
+	}
+
+	type testCase struct {
+		insecure  bool
+		strict    bool
+		expectErr bool
+		expectLog bool
+	}
+
+	tests := map[string]testCase{
+		"verified CA":                   {},
+		"insecure with CA warns":        {insecure: true, expectLog: true},
+		"insecure with CA under strict": {insecure: true, strict: true, expectErr: true},
+		"strict without insecure":       {strict: true},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			var buf bytes.Buffer
+			db := new()
+			db.logger = log.New(&log.LoggerOptions{Output: &buf})
+			_, err := db.Initialize(context.Background(), dbplugin.InitializeRequest{
+				Config: makeConfig(config, "insecure_tls", test.insecure, "strict_tls", test.strict),
+			})
+			if test.expectErr {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "strict_tls")
+				require.Contains(t, err.Error(), "CA certificate in pem_bundle")
+				return
+			}
+			require.NoError(t, err)
+			if test.expectLog {
+				require.Contains(t, buf.String(), "CA certificate is ignored")
+				require.Contains(t, buf.String(), "ca_source=pem_bundle")
+			} else {
+				require.Empty(t, buf.String())
+			}
+		})
+	}
+
+	// Without a CA there is nothing to warn about.
+	var buf bytes.Buffer
+	db := new()
+	db.logger = log.New(&log.LoggerOptions{Output: &buf})
+	_, err := db.Initialize(context.Background(), dbplugin.InitializeRequest{
+		Config: map[string]interface{}{"host": "influx.example.com", "token": "token", "insecure_tls": true, "skip_token_format_check": true},
+	})
+	require.NoError(t, err)
+	require.Empty(t, buf.String())
+
+	// The error names the parameter the CA came from.
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeKeyPair(t, certFile, keyFile, "influx.example.com")
+	_, err = new().Initialize(context.Background(), dbplugin.InitializeRequest{
+		Config: map[string]interface{}{"host": "influx.example.com", "token": "token", "tls_ca_file": certFile, "insecure_tls": true, "strict_tls": true, "skip_token_format_check": true},
+	})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "CA certificate in tls_ca_file")
+}
+
+// testCACertificate returns a PEM encoded self-signed CA certificate.
+func testCACertificate(t *testing.T) string {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "influxdb test CA"},
+		NotBefore:             time.Now().Add(-1 * time.Hour),
+		NotAfter:              time.Now().Add(1 * time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
+
+func TestInitialize_FollowRedirects(t *testing.T) {
+	const token = "root-token"
+	srv := newFakeInfluxServer(t, token)
+
+	// A gateway that moves every request to the real server.
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, srv.URL+r.URL.RequestURI(), http.StatusTemporaryRedirect)
+	}))
+	defer gateway.Close()
+	u, err := url.Parse(gateway.URL)
+	require.NoError(t, err)
+	config := makeConfig(srv.connectionParams(token), "port", u.Port())
+
+	// By default the redirect is an error naming its target.
+	_, err = new().Initialize(context.Background(), dbplugin.InitializeRequest{
+		Config:           config,
+		VerifyConnection: true,
+	})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "refusing to follow redirect to "+srv.URL+"/ping")
+	require.Zero(t, srv.callCount("GET /ping"))
+
+	db := new()
+	dbtesting.AssertInitialize(t, db, dbplugin.InitializeRequest{
+		Config:           makeConfig(config, "follow_redirects", true),
+		VerifyConnection: true,
+	})
+	defer dbtesting.AssertClose(t, db)
+	require.NotZero(t, srv.callCount("GET /ping"))
+}
+
+type idleRecorder struct {
+	http.RoundTripper
+	closed bool
+}
+
+func (r *idleRecorder) CloseIdleConnections() { r.closed = true }
+
+func TestWrappedTransport_CloseIdleConnections(t *testing.T) {
+	recorder := &idleRecorder{RoundTripper: http.DefaultTransport}
+	client := &http.Client{Transport: &sessionTransport{
+		wrappedTransport: wrappedTransport{&apiPathTransport{wrappedTransport: wrappedTransport{recorder}, path: "/api/v3"}},
+		name:             defaultSessionName,
+	}}
+	client.CloseIdleConnections()
+	require.True(t, recorder.closed)
+}
@@ -0,0 +1,14 @@
+package influxdbv2
+
+// The version of the server is detected from its health endpoint when the
+// connection is verified, and kept for the life of the connection. No field
+// the plugin sends depends on it: every InfluxDB v2 release accepts them all.
+
+// setServerVersion records the version reported by the server. It must be
+// called with the lock held.
+func (i *influxdbConnectionProducer) setServerVersion(raw string) {
+	if raw != i.serverVersion {
+		i.logger.Info("detected the server version", "version", raw)
+	}
+	i.serverVersion = raw
+}
@@ -0,0 +1,41 @@
+package influxdbv2
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"testing"
+
+	log "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/sdk/database/dbplugin/v5"
+	dbtesting "github.com/hashicorp/vault/sdk/database/dbplugin/v5/testing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInitialize_ServerVersion(t *testing.T) {
+	const token = "root-token"
+	srv := newFakeInfluxServer(t, token)
+	srv.handle("GET /health", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"name": "influxdb", "status": "pass", "version": "v2.7.1"})
+	})
+
+	var buf bytes.Buffer
+	db := new()
+	db.logger = log.New(&log.LoggerOptions{Output: &buf})
+	dbtesting.AssertInitialize(t, db, dbplugin.InitializeRequest{
+		Config: srv.connectionParams(token),
+	})
+	defer dbtesting.AssertClose(t, db)
+	require.Empty(t, db.serverVersion)
+
+	// The version is detected when the connection is verified, and only
+	// logged when it changes.
+	for range []int{0, 1} {
+		dbtesting.AssertInitialize(t, db, dbplugin.InitializeRequest{
+			Config:           srv.connectionParams(token),
+			VerifyConnection: true,
+		})
+	}
+	require.Equal(t, "v2.7.1", db.serverVersion)
+	require.Equal(t, 1, strings.Count(buf.String(), "detected the server version"), buf.String())
+}
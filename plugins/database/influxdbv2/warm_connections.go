@@ -0,0 +1,57 @@
+package influxdbv2
+
+import (
+	"context"
+	"sync"
+
+	"github.com/influxdata/influxdb-client-go/v2"
+)
+
+// warm_connections opens idle connections to the server right after
+// Initialize, so that the first credential operations after a reload don't
+// pay for the TCP and TLS handshakes. The connections are opened by
+// concurrent pings, each of which leaves the connection it used in the
+// transport's idle pool. Over HTTP/2, requests share a single connection, so
+// only one is opened however many are asked for.
+
+// warmConnections pings the server over warm_connections concurrent
+// requests. It is best effort: pings that fail are logged and never fail
+// Initialize. Each ping is bounded by ping_timeout, if set, and all of them
+// by ctx.
+func (i *influxdbConnectionProducer) warmConnections(ctx context.Context) {
+	conn, err := i.connection(false)
+	if err != nil {
+		i.logger.Warn("warm_connections: unable to get connection", "error", err)
+		return
+	}
+	cli := conn.(influxdb2.Client)
+
+	var wg sync.WaitGroup
+	errs := make([]error, i.WarmConnections)
+	for idx := range errs {
+		wg.Add(1)
+		go func(idx int) {
+			defer wg.Done()
+			pingCtx := ctx
+			if i.pingTimeout > 0 {
+				var cancel context.CancelFunc
+				pingCtx, cancel = context.WithTimeout(ctx, i.pingTimeout)
+				defer cancel()
+			}
+			_, errs[idx] = cli.Ping(pingCtx)
+		}(idx)
+	}
+	wg.Wait()
+
+	var failed int
+	var lastErr error
+	for _, err := range errs {
+		if err != nil {
+			failed++
+			lastErr = err
+		}
+	}
+	if failed > 0 {
+		i.logger.Warn("warm_connections: unable to open every connection", "requested", i.WarmConnections, "failed", failed, "error", lastErr)
+	}
+}
@@ -0,0 +1,135 @@
+package influxdbv2
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/database/dbplugin/v5"
+	dbtesting "github.com/hashicorp/vault/sdk/database/dbplugin/v5/testing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInitialize_WarmConnections(t *testing.T) {
+	const token = "root-token"
+
+	t.Run("opens connections", func(t *testing.T) {
+		srv := newFakeInfluxServer(t, token)
+		var lock sync.Mutex
+		remotes := make(map[string]struct{})
+		srv.handle("GET /ping", func(w http.ResponseWriter, r *http.Request) {
+			lock.Lock()
+			remotes[r.RemoteAddr] = struct{}{}
+			lock.Unlock()
+			// Keep the pings in flight together, so that none of them
+			// reuses the connection of another.
+			time.Sleep(50 * time.Millisecond)
+			w.WriteHeader(http.StatusNoContent)
+		})
+
+		db := new()
+		dbtesting.AssertInitialize(t, db, dbplugin.InitializeRequest{
+			Config: makeConfig(srv.connectionParams(token), "warm_connections", 3),
+		})
+		defer dbtesting.AssertClose(t, db)
+		require.Equal(t, 3, srv.callCount("GET /ping"))
+		lock.Lock()
+		defer lock.Unlock()
+		require.Len(t, remotes, 3)
+	})
+
+	t.Run("off by default", func(t *testing.T) {
+		srv := newFakeInfluxServer(t, token)
+		db := new()
+		dbtesting.AssertInitialize(t, db, dbplugin.InitializeRequest{
+			Config: srv.connectionParams(token),
+		})
+		defer dbtesting.AssertClose(t, db)
+		require.Zero(t, srv.callCount("GET /ping"))
+	})
+
+	t.Run("partial failure", func(t *testing.T) {
+		srv := newFakeInfluxServer(t, token)
+		var lock sync.Mutex
+		var pings int
+		srv.handle("GET /ping", func(w http.ResponseWriter, r *http.Request) {
+			lock.Lock()
+			pings++
+			fail := pings%2 == 0
+			lock.Unlock()
+			if fail {
+				writeError(w, http.StatusServiceUnavailable, "unavailable", "overloaded")
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		})
+
+		db := new()
+		dbtesting.AssertInitialize(t, db, dbplugin.InitializeRequest{
+			Config: makeConfig(srv.connectionParams(token), "warm_connections", 4),
+		})
+		defer dbtesting.AssertClose(t, db)
+		require.Equal(t, 4, srv.callCount("GET /ping"))
+	})
+
+	t.Run("bounded by ping_timeout", func(t *testing.T) {
+		srv := newFakeInfluxServer(t, token)
+		release := make(chan struct{})
+		defer close(release)
+		srv.handle("GET /ping", func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+		})
+
+		db := new()
+		start := time.Now()
+		dbtesting.AssertInitialize(t, db, dbplugin.InitializeRequest{
+			Config: makeConfig(srv.connectionParams(token), "warm_connections", 2, "ping_timeout", "100ms"),
+		})
+		defer dbtesting.AssertClose(t, db)
+		require.Less(t, time.Since(start), 5*time.Second)
+	})
+
+	t.Run("bounded by the context", func(t *testing.T) {
+		srv := newFakeInfluxServer(t, token)
+		release := make(chan struct{})
+		defer close(release)
+		srv.handle("GET /ping", func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		db := new()
+		_, err := db.Initialize(ctx, dbplugin.InitializeRequest{
+			Config: makeConfig(srv.connectionParams(token), "warm_connections", 2),
+		})
+		require.NoError(t, err)
+		defer dbtesting.AssertClose(t, db)
+	})
+
+	for name, tc := range map[string]struct {
+		kv  []interface{}
+		err string
+	}{
+		"negative":                {[]interface{}{"warm_connections", -1}, "warm_connections cannot be negative"},
+		"over max_idle":           {[]interface{}{"warm_connections", 5, "max_idle_connections", 2}, "warm_connections cannot exceed max_idle_connections (2)"},
+		"over max_concurrent_ops": {[]interface{}{"warm_connections", 5, "max_concurrent_operations", 2}, "warm_connections cannot exceed max_concurrent_operations (2)"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			srv := newFakeInfluxServer(t, token)
+			_, err := new().Initialize(context.Background(), dbplugin.InitializeRequest{
+				Config: makeConfig(srv.connectionParams(token), tc.kv...),
+			})
+			require.Error(t, err)
+			require.Contains(t, err.Error(), tc.err)
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package influxdbv2
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/hashicorp/go-uuid"
+	"github.com/influxdata/influxdb-client-go/v2"
+	"github.com/influxdata/influxdb-client-go/v2/domain"
+)
+
+// writeCapabilityDescriptionPrefix marks the throwaway authorizations created
+// by verifyWriteCapability.
+const writeCapabilityDescriptionPrefix = "vault write capability check "
+
+// verifyWriteCapability proves that the token can issue write credentials for
+// default_bucket by creating a write-scoped authorization for it and deleting
+// it again. The authorization is cleaned up even if its creation appears to
+// have failed, since the create may have gone through without its response
+// arriving.
+func (i *influxdbConnectionProducer) verifyWriteCapability(ctx context.Context, cli influxdb2.Client) (retErr error) {
+	org, err := i.resolveDefaultOrganization(ctx, cli)
+	if err != nil {
+		return err
+	}
+	bucket, err := i.resolveDefaultBucket(ctx, cli, org)
+	if err != nil {
+		return err
+	}
+
+	id, err := uuid.GenerateUUID()
+	if err != nil {
+		return err
+	}
+	description := writeCapabilityDescriptionPrefix + id
+	status := domain.AuthorizationUpdateRequestStatusActive
+	permissions := []domain.Permission{
+		{
+			Action: domain.PermissionActionWrite,
+			Resource: domain.Resource{
+				Type:  domain.ResourceTypeBuckets,
+				Id:    bucket.Id,
+				OrgID: org.Id,
+			},
+		},
+	}
+
+	created, err := cli.AuthorizationsAPI().CreateAuthorization(ctx, &domain.Authorization{
+		AuthorizationUpdateRequest: domain.AuthorizationUpdateRequest{
+			Description: &description,
+			Status:      &status,
+		},
+		OrgID:       org.Id,
+		Permissions: &permissions,
+	})
+	defer func() {
+		if err := cleanupWriteCapabilityCheck(ctx, cli, created, description, i.maxAuthorizationPages()); err != nil {
+			retErr = multierror.Append(retErr, fmt.Errorf("failed to delete the authorization created to verify write capability: %w", err))
+		}
+	}()
+	if err != nil {
+		return fmt.Errorf("the provided token cannot create write tokens for bucket %q: %w", i.DefaultBucket, classify(err))
+	}
+	return nil
+}
+
+// cleanupWriteCapabilityCheck deletes the authorization created by
+// verifyWriteCapability, looking it up by description if the create didn't
+// return it, in at most maxPages pages of authorizations.
+func cleanupWriteCapabilityCheck(ctx context.Context, cli influxdb2.Client, created *domain.Authorization, description string, maxPages int) error {
+	if created == nil {
+		authorizations, err := listAuthorizations(ctx, cli, maxPages)
+		if err != nil {
+			return err
+		}
+		for _, authorization := range authorizations {
+			if authorization.Description != nil && *authorization.Description == description {
+				created = &authorization
+				break
+			}
+		}
+		if created == nil {
+			return nil
+		}
+	}
+	return retry(ctx, func(attempt int) error {
+		err := cli.AuthorizationsAPI().DeleteAuthorization(ctx, created)
+		if attempt > 0 && isNotFound(err) {
+			return nil
+		}
+		return err
+	})
+}
@@ -0,0 +1,83 @@
+package influxdbv2
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/database/dbplugin/v5"
+	dbtesting "github.com/hashicorp/vault/sdk/database/dbplugin/v5/testing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInitialize_VerifyWriteCapability(t *testing.T) {
+	const token = "root-token"
+
+	type testCase struct {
+		config    []interface{}
+		setup     func(srv *fakeInfluxServer)
+		expectErr string
+	}
+
+	tests := map[string]testCase{
+		"success": {
+			config: []interface{}{"default_bucket", "vault"},
+		},
+		"requires default_bucket": {
+			expectErr: "verify_write_capability requires default_bucket",
+		},
+		"missing bucket": {
+			config:    []interface{}{"default_bucket", "missing"},
+			expectErr: "bucket 'missing' not found",
+		},
+		"forbidden": {
+			config: []interface{}{"default_bucket", "vault"},
+			setup: func(srv *fakeInfluxServer) {
+				srv.handle("POST /api/v2/authorizations", func(w http.ResponseWriter, r *http.Request) {
+					writeError(w, http.StatusForbidden, "forbidden", "insufficient permissions")
+				})
+			},
+			expectErr: `cannot create write tokens for bucket "vault"`,
+		},
+		"lost response": {
+			config: []interface{}{"default_bucket", "vault"},
+			setup: func(srv *fakeInfluxServer) {
+				srv.loseResponses("POST /api/v2/authorizations", 1)
+			},
+			expectErr: `cannot create write tokens for bucket "vault"`,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			srv := newFakeInfluxServer(t, token)
+			if test.setup != nil {
+				test.setup(srv)
+			}
+
+			db := new()
+			config := append([]interface{}{"verify_write_capability", true}, test.config...)
+			_, err := db.Initialize(context.Background(), dbplugin.InitializeRequest{
+				Config:           makeConfig(srv.connectionParams(token), config...),
+				VerifyConnection: true,
+			})
+			if test.expectErr != "" {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), test.expectErr)
+			} else {
+				require.NoError(t, err)
+				defer dbtesting.AssertClose(t, db)
+			}
+
+			// The check never leaves its authorization behind.
+			srv.Lock()
+			defer srv.Unlock()
+			for _, a := range srv.authorizations {
+				if a.Description != nil {
+					require.False(t, strings.HasPrefix(*a.Description, writeCapabilityDescriptionPrefix), "leftover authorization %q", *a.Description)
+				}
+			}
+		})
+	}
+}